@@ -0,0 +1,168 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command s3migrate copies the objects under a prefix from one storage engine to another.
+//
+//	s3migrate -config migrate.json -prefix openim/data/hash/ -checkpoint migrate.checkpoint
+//
+// The config file is JSON with a "src" and a "dst" engine, each naming the engine and
+// holding its configuration under the engine name:
+//
+//	{
+//	  "src": {"engine": "kodo", "kodo": {"endpoint": "...", "bucket": "...", ...}},
+//	  "dst": {"engine": "minio", "minio": {"endpoint": "...", "bucket": "...", ...}}
+//	}
+//
+// With -dry-run nothing is copied and the report lists the missing and different objects.
+// The report is printed as JSON; the exit status is 1 when any object failed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/aws"
+	"github.com/amazing-socrates/next-tools/s3/cos"
+	"github.com/amazing-socrates/next-tools/s3/kodo"
+	"github.com/amazing-socrates/next-tools/s3/local"
+	"github.com/amazing-socrates/next-tools/s3/migrate"
+	"github.com/amazing-socrates/next-tools/s3/minio"
+	"github.com/amazing-socrates/next-tools/s3/oss"
+)
+
+type engineConfig struct {
+	Engine string        `json:"engine"`
+	Minio  *minio.Config `json:"minio"`
+	Aws    *aws.Config   `json:"aws"`
+	Cos    *cos.Config   `json:"cos"`
+	Oss    *oss.Config   `json:"oss"`
+	Kodo   *kodo.Config  `json:"kodo"`
+	Local  *local.Config `json:"local"`
+}
+
+type config struct {
+	Src engineConfig `json:"src"`
+	Dst engineConfig `json:"dst"`
+}
+
+func newEngine(ctx context.Context, conf engineConfig) (s3.Interface, error) {
+	missing := errs.ErrArgs.WrapMsg("engine config missing", "engine", conf.Engine)
+	switch conf.Engine {
+	case "minio":
+		if conf.Minio == nil {
+			return nil, missing
+		}
+		return minio.NewMinio(ctx, nil, *conf.Minio)
+	case "aws":
+		if conf.Aws == nil {
+			return nil, missing
+		}
+		return aws.NewAws(*conf.Aws)
+	case "cos":
+		if conf.Cos == nil {
+			return nil, missing
+		}
+		return cos.NewCos(*conf.Cos)
+	case "oss":
+		if conf.Oss == nil {
+			return nil, missing
+		}
+		return oss.NewOSS(*conf.Oss)
+	case "kodo":
+		if conf.Kodo == nil {
+			return nil, missing
+		}
+		return kodo.NewKodo(*conf.Kodo)
+	case "local":
+		if conf.Local == nil {
+			return nil, missing
+		}
+		return local.NewLocal(*conf.Local)
+	default:
+		return nil, errs.ErrArgs.WrapMsg("unknown engine", "engine", conf.Engine)
+	}
+}
+
+func run() (int, error) {
+	var (
+		configPath  = flag.String("config", "", "JSON file with the src and dst engines")
+		prefix      = flag.String("prefix", "openim/data/hash/", "prefix of the objects to copy")
+		concurrency = flag.Int("concurrency", migrate.DefaultConcurrency, "objects copied at once")
+		retries     = flag.Int("retries", migrate.DefaultRetries, "retries of a failed object")
+		checkpoint  = flag.String("checkpoint", "", "file to save progress to and resume from")
+		pageSize    = flag.Int("page-size", s3.MaxListLimit, "objects listed per request")
+		dryRun      = flag.Bool("dry-run", false, "only report the objects that would be copied")
+	)
+	flag.Parse()
+	if *configPath == "" {
+		flag.Usage()
+		return 2, nil
+	}
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return 1, err
+	}
+	var conf config
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return 1, errs.WrapMsg(err, "invalid config", "path", *configPath)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	src, err := newEngine(ctx, conf.Src)
+	if err != nil {
+		return 1, err
+	}
+	dst, err := newEngine(ctx, conf.Dst)
+	if err != nil {
+		return 1, err
+	}
+	m := migrate.New(src, dst,
+		migrate.WithConcurrency(*concurrency),
+		migrate.WithRetries(*retries, migrate.DefaultRetryDelay),
+		migrate.WithCheckpoint(*checkpoint),
+		migrate.WithPageSize(*pageSize),
+		migrate.WithDryRun(*dryRun),
+	)
+	report, runErr := m.Run(ctx, *prefix)
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	if err := out.Encode(report); err != nil {
+		return 1, err
+	}
+	for _, failure := range report.Failures {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", failure.Key, failure.Err)
+	}
+	if runErr != nil {
+		return 1, runErr
+	}
+	if len(report.Failures) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func main() {
+	code, err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
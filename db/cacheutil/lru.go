@@ -0,0 +1,133 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cacheutil
+
+import (
+	"container/list"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// LRU is a cache bounded to a number of entries that drops the least recently used
+// one when full. Entries may expire. It is safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	lock       sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[K]*list.Element
+	now        func() time.Time
+}
+
+type lruEntry[K comparable, V any] struct {
+	key    K
+	value  V
+	expire time.Time
+}
+
+// NewLRU creates an LRU holding up to maxEntries entries, without bound when maxEntries <= 0.
+func NewLRU[K comparable, V any](maxEntries int) *LRU[K, V] {
+	return &LRU[K, V]{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[K]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Get returns the value of key unless it is missing or expired.
+func (c *LRU[K, V]) Get(key K) (value V, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*lruEntry[K, V])
+	if !entry.expire.IsZero() && !c.now().Before(entry.expire) {
+		c.remove(elem)
+		return value, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+// Set stores value under key for ttl, or until evicted when ttl <= 0.
+func (c *LRU[K, V]) Set(key K, value V, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var expire time.Time
+	if ttl > 0 {
+		expire = c.now().Add(ttl)
+	}
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry[K, V])
+		entry.value, entry.expire = value, expire
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry[K, V]{key: key, value: value, expire: expire})
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Delete removes key and reports whether it was present.
+func (c *LRU[K, V]) Delete(key K) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if ok {
+		c.remove(elem)
+	}
+	return ok
+}
+
+// DeleteFunc removes the entries for which del returns true and returns how many it removed.
+func (c *LRU[K, V]) DeleteFunc(del func(key K, value V) bool) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var n int
+	for elem := c.ll.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*lruEntry[K, V]); del(entry.key, entry.value) {
+			c.remove(elem)
+			n++
+		}
+		elem = next
+	}
+	return n
+}
+
+// Len returns the number of entries, including expired ones not dropped yet.
+func (c *LRU[K, V]) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU[K, V]) remove(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*lruEntry[K, V]).key)
+}
+
+// Jitter moves ttl randomly by up to fraction of it in either direction, so that entries
+// written together do not expire together.
+func Jitter(ttl time.Duration, fraction float64) time.Duration {
+	if ttl <= 0 || fraction <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(float64(ttl)*min(fraction, 1)*(rand.Float64()*2-1))
+}
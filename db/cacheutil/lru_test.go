@@ -0,0 +1,62 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cacheutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewLRU[string, int](2)
+	c.now = func() time.Time { return now }
+	c.Set("a", 1, 0)
+	c.Set("b", 2, time.Minute)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("unexpected a %d %v", v, ok)
+	}
+	// b is now the least recently used.
+	c.Set("c", 3, 0)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used entry kept")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("recently used entry evicted")
+	}
+	c.Set("c", 4, time.Minute)
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("c"); ok || c.Len() != 1 {
+		t.Fatalf("expired entry returned, %d entries", c.Len())
+	}
+	c.Set("b", 2, 0)
+	if n := c.DeleteFunc(func(key string, value int) bool { return value > 1 }); n != 1 || c.Len() != 1 {
+		t.Fatalf("DeleteFunc removed %d, %d left", n, c.Len())
+	}
+	if !c.Delete("a") || c.Delete("a") {
+		t.Fatal("unexpected Delete result")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if ttl := Jitter(time.Second, 0.1); ttl < time.Second*9/10 || ttl > time.Second*11/10 {
+			t.Fatalf("jitter out of range: %s", ttl)
+		}
+	}
+	if ttl := Jitter(time.Second, 0); ttl != time.Second {
+		t.Fatalf("unexpected ttl %s", ttl)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cacheutil
+
+import (
+	"golang.org/x/sync/singleflight"
+)
+
+// Group is a singleflight.Group typed by the value of its calls. The zero value is
+// ready to use.
+type Group[V any] struct {
+	group singleflight.Group
+}
+
+// Do runs fn for key unless a call for key is in flight, in which case it waits for
+// that call. shared reports whether the result was given to more than one caller.
+func (g *Group[V]) Do(key string, fn func() (V, error)) (value V, err error, shared bool) {
+	v, err, shared := g.group.Do(key, func() (any, error) {
+		return fn()
+	})
+	if v != nil {
+		value = v.(V)
+	}
+	return value, err, shared
+}
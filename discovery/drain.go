@@ -0,0 +1,134 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+)
+
+const (
+	// DefaultPropagationDelay is how long Drain lets watchers see the draining instance.
+	DefaultPropagationDelay = 5 * time.Second
+	// DefaultDrainTimeout bounds how long Drain waits for in-flight requests.
+	DefaultDrainTimeout = 30 * time.Second
+)
+
+// Waiter is what Drain waits for before deregistering, such as the in-flight requests
+// counted by mw.Inflight. Wait returns once there is nothing left or ctx is done.
+type Waiter interface {
+	Wait(ctx context.Context) error
+}
+
+type drainOptions struct {
+	propagationDelay time.Duration
+	timeout          time.Duration
+	waiters          []Waiter
+}
+
+// DrainOption configures Drain.
+type DrainOption func(*drainOptions)
+
+// WithPropagationDelay sets how long Drain waits after announcing the drain, so that
+// watchers stop picking the instance; DefaultPropagationDelay by default.
+func WithPropagationDelay(delay time.Duration) DrainOption {
+	return func(o *drainOptions) {
+		o.propagationDelay = delay
+	}
+}
+
+// WithDrainTimeout bounds the wait for the waiters; DefaultDrainTimeout by default.
+func WithDrainTimeout(timeout time.Duration) DrainOption {
+	return func(o *drainOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithWaiters adds what Drain waits for before deregistering, usually the in-flight requests.
+func WithWaiters(waiters ...Waiter) DrainOption {
+	return func(o *drainOptions) {
+		o.waiters = append(o.waiters, waiters...)
+	}
+}
+
+// Drain deregisters the instance of registry without cutting off its callers. A registry
+// storing instance metadata first marks the instance draining, so that resolvers and the
+// gateway ring stop picking it, and keeps it registered while the change propagates and the
+// waiters finish; other registries deregister first and then wait. Drain deregisters in
+// every case: when the waiters time out, and when ctx is done first, which cuts the drain
+// short and returns ctx.Err().
+func Drain(ctx context.Context, registry SvcDiscoveryRegistry, opts ...DrainOption) (err error) {
+	o := &drainOptions{propagationDelay: DefaultPropagationDelay, timeout: DefaultDrainTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	instanceRegistry, ok := registry.(InstanceRegistry)
+	if ok {
+		metadata := maps.Clone(instanceRegistry.Metadata())
+		if metadata == nil {
+			metadata = make(map[string]string, 1)
+		}
+		metadata[MetadataDraining] = "true"
+		if err := instanceRegistry.SetMetadata(ctx, metadata); err != nil {
+			// Deregistering right away is the next best signal.
+			log.ZWarn(ctx, "mark instance draining failed", err, "target", registry.GetSelfConnTarget())
+			ok = false
+		}
+	}
+	if ok {
+		defer func() {
+			if unregisterErr := registry.UnRegister(); unregisterErr != nil {
+				err = errors.Join(err, errs.WrapMsg(unregisterErr, "unregister failed", "target", registry.GetSelfConnTarget()))
+			}
+		}()
+	} else if err := registry.UnRegister(); err != nil {
+		return errs.WrapMsg(err, "unregister failed", "target", registry.GetSelfConnTarget())
+	}
+	if err := sleep(ctx, o.propagationDelay); err != nil {
+		return err
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, o.timeout)
+	for _, waiter := range o.waiters {
+		if err := waiter.Wait(waitCtx); err != nil {
+			if ctx.Err() != nil {
+				cancel()
+				return ctx.Err()
+			}
+			log.ZWarn(ctx, "drain timed out, deregistering anyway", err, "target", registry.GetSelfConnTarget(), "timeout", o.timeout)
+			break
+		}
+	}
+	cancel()
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// drainRegistry records the calls Drain makes.
+type drainRegistry struct {
+	SvcDiscoveryRegistry
+	calls    []string
+	metadata map[string]string
+}
+
+func (r *drainRegistry) GetSelfConnTarget() string { return "127.0.0.1:10001" }
+
+func (r *drainRegistry) UnRegister() error {
+	r.calls = append(r.calls, "unregister")
+	return nil
+}
+
+// instanceRegistry is a drainRegistry that stores metadata.
+type instanceRegistry struct {
+	*drainRegistry
+}
+
+func (r instanceRegistry) RegisterWithMetadata(string, string, int, map[string]string, ...grpc.DialOption) error {
+	return nil
+}
+
+func (r instanceRegistry) SetMetadata(ctx context.Context, metadata map[string]string) error {
+	r.calls = append(r.calls, "draining")
+	r.metadata = metadata
+	return nil
+}
+
+func (r instanceRegistry) Metadata() map[string]string { return r.metadata }
+
+func (r instanceRegistry) GetInstances(context.Context, string) ([]*Instance, error) { return nil, nil }
+
+type waiterFunc func(ctx context.Context) error
+
+func (f waiterFunc) Wait(ctx context.Context) error { return f(ctx) }
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+	registry := &drainRegistry{metadata: map[string]string{MetadataZone: "a"}}
+	waiter := waiterFunc(func(ctx context.Context) error {
+		registry.calls = append(registry.calls, "wait")
+		return nil
+	})
+	if err := Drain(ctx, instanceRegistry{registry}, WithPropagationDelay(time.Millisecond), WithWaiters(waiter)); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(registry.calls, []string{"draining", "wait", "unregister"}) {
+		t.Fatalf("unexpected calls %v", registry.calls)
+	}
+	if want := map[string]string{MetadataZone: "a", MetadataDraining: "true"}; !maps.Equal(registry.metadata, want) {
+		t.Fatalf("unexpected metadata %v", registry.metadata)
+	}
+
+	// Without metadata the instance deregisters first, and a waiter that never finishes
+	// only delays the drain by the timeout.
+	registry = &drainRegistry{}
+	blocked := waiterFunc(func(ctx context.Context) error {
+		registry.calls = append(registry.calls, "wait")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := Drain(ctx, registry, WithPropagationDelay(0), WithDrainTimeout(10*time.Millisecond), WithWaiters(blocked)); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(registry.calls, []string{"unregister", "wait"}) {
+		t.Fatalf("unexpected calls %v", registry.calls)
+	}
+
+	// A done ctx cuts the drain short but the instance still deregisters.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	registry = &drainRegistry{}
+	if err := Drain(cancelled, instanceRegistry{registry}); err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !slices.Equal(registry.calls, []string{"draining", "unregister"}) {
+		t.Fatalf("unexpected calls %v", registry.calls)
+	}
+}
+
+func TestDrainExpiredContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	registry := &drainRegistry{}
+	blocked := waiterFunc(func(ctx context.Context) error {
+		registry.calls = append(registry.calls, "wait")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	err := Drain(ctx, instanceRegistry{registry}, WithPropagationDelay(0), WithWaiters(blocked))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !slices.Equal(registry.calls, []string{"draining", "wait", "unregister"}) {
+		t.Fatalf("unexpected calls %v", registry.calls)
+	}
+}
@@ -16,12 +16,16 @@ package etcd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/amazing-socrates/next-tools/discovery"
+	"github.com/amazing-socrates/next-tools/log"
 	"github.com/pkg/errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/naming/endpoints"
@@ -37,6 +41,8 @@ type ZkOption func(*clientv3.Config)
 
 const maxMsgSize = 100 * 1024 * 1024 // 100 MB
 
+var _ discovery.InstanceRegistry = (*SvcDiscoveryRegistryImpl)(nil)
+
 // SvcDiscoveryRegistryImpl implementation
 type SvcDiscoveryRegistryImpl struct {
 	client            *clientv3.Client
@@ -47,10 +53,19 @@ type SvcDiscoveryRegistryImpl struct {
 	leaseID           clientv3.LeaseID
 	rpcRegisterTarget string
 
+	// metadata is the metadata of the registered endpoint.
+	metadataLock sync.Mutex
+	metadata     map[string]string
+
 	rootDirectory string
 
 	mu      sync.RWMutex
 	connMap map[string][]*grpc.ClientConn
+
+	gatewayLock   sync.Mutex
+	gatewayName   string
+	gatewayRing   *discovery.HashRing
+	gatewayLoaded bool
 }
 
 func createNoOpLogger() *zap.Logger {
@@ -95,9 +110,11 @@ func NewSvcDiscoveryRegistry(rootDirectory string, endpoints []string, options .
 
 	s := &SvcDiscoveryRegistryImpl{
 		client:        client,
-		resolver:      r,
+		resolver:      activeBuilder{Builder: r},
 		rootDirectory: rootDirectory,
 		connMap:       make(map[string][]*grpc.ClientConn),
+		gatewayName:   discovery.DefaultGatewayServiceName,
+		gatewayRing:   discovery.NewHashRing(),
 	}
 
 	go s.watchServiceChanges()
@@ -112,17 +129,24 @@ func (r *SvcDiscoveryRegistryImpl) initializeConnMap() error {
 		return err
 	}
 	r.connMap = make(map[string][]*grpc.ClientConn)
+	services := make(map[string][]*discovery.Instance)
 	for _, kv := range resp.Kvs {
-		prefix, addr := r.splitEndpoint(string(kv.Key))
-		conn, err := grpc.DialContext(context.Background(), addr,
-			append(r.dialOptions,
-				grpc.WithResolvers(r.resolver),
-				grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(maxMsgSize)),
-				grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize)))...)
-		if err != nil {
-			continue
+		prefix, _ := r.splitEndpoint(string(kv.Key))
+		services[prefix] = append(services[prefix], r.parseInstance("", kv.Key, kv.Value))
+	}
+	// Draining instances are left out unless the whole service drains
+	for prefix, instances := range services {
+		for _, instance := range discovery.ActiveInstances(instances) {
+			conn, err := grpc.DialContext(context.Background(), instance.Addr,
+				append(r.dialOptions,
+					grpc.WithResolvers(r.resolver),
+					grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(maxMsgSize)),
+					grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize)))...)
+			if err != nil {
+				continue
+			}
+			r.connMap[prefix] = append(r.connMap[prefix], conn)
 		}
-		r.connMap[prefix] = append(r.connMap[prefix], conn)
 	}
 	return nil
 }
@@ -149,9 +173,55 @@ func WithUsernameAndPassword(username, password string) ZkOption {
 	}
 }
 
-// GetUserIdHashGatewayHost returns the gateway host for a given user ID hash
+// SetGatewayServiceName sets the service GetUserIdHashGatewayHost picks instances of,
+// discovery.DefaultGatewayServiceName by default
+func (r *SvcDiscoveryRegistryImpl) SetGatewayServiceName(serviceName string) {
+	r.gatewayLock.Lock()
+	defer r.gatewayLock.Unlock()
+	r.gatewayName = serviceName
+	r.gatewayRing.Set(nil)
+	r.gatewayLoaded = false
+}
+
+// GetUserIdHashGatewayHost returns the address of the gateway instance the user is pinned to
+// by consistent hashing, so that membership changes move as few users as possible
 func (r *SvcDiscoveryRegistryImpl) GetUserIdHashGatewayHost(ctx context.Context, userId string) (string, error) {
-	return "", nil
+	r.gatewayLock.Lock()
+	loaded := r.gatewayLoaded
+	r.gatewayLock.Unlock()
+	if !loaded {
+		if err := r.refreshGateway(ctx); err != nil {
+			return "", err
+		}
+	}
+	host, ok := r.gatewayRing.Get(userId)
+	if !ok {
+		return "", discovery.ErrNoGateway.WrapMsg("gateway service has no instance", "prefix", r.gatewayPrefix())
+	}
+	return host, nil
+}
+
+// gatewayPrefix returns the key prefix of the gateway service instances
+func (r *SvcDiscoveryRegistryImpl) gatewayPrefix() string {
+	r.gatewayLock.Lock()
+	defer r.gatewayLock.Unlock()
+	return fmt.Sprintf("%s/%s/", r.rootDirectory, r.gatewayName)
+}
+
+// refreshGateway loads the gateway service instances into the hash ring; it holds the lock
+// across the read so that an older listing never replaces a newer one
+func (r *SvcDiscoveryRegistryImpl) refreshGateway(ctx context.Context) error {
+	r.gatewayLock.Lock()
+	defer r.gatewayLock.Unlock()
+	instances, err := r.GetInstances(ctx, r.gatewayName)
+	if err != nil {
+		// The next GetUserIdHashGatewayHost reads again rather than trusting a stale ring.
+		r.gatewayLoaded = false
+		return err
+	}
+	r.gatewayRing.Set(discovery.InstanceWeights(instances))
+	r.gatewayLoaded = true
+	return nil
 }
 
 // GetConns returns gRPC client connections for a given service name
@@ -197,6 +267,11 @@ func (r *SvcDiscoveryRegistryImpl) CloseConn(conn *grpc.ClientConn) {
 
 // Register registers a new service endpoint with etcd
 func (r *SvcDiscoveryRegistryImpl) Register(serviceName, host string, port int, opts ...grpc.DialOption) error {
+	return r.RegisterWithMetadata(serviceName, host, port, nil, opts...)
+}
+
+// RegisterWithMetadata registers a new service endpoint with etcd, storing metadata in the endpoint metadata
+func (r *SvcDiscoveryRegistryImpl) RegisterWithMetadata(serviceName, host string, port int, metadata map[string]string, opts ...grpc.DialOption) error {
 	r.serviceKey = fmt.Sprintf("%s/%s/%s:%d", r.rootDirectory, serviceName, host, port)
 	em, err := endpoints.NewManager(r.client, r.rootDirectory+"/"+serviceName)
 	if err != nil {
@@ -212,16 +287,88 @@ func (r *SvcDiscoveryRegistryImpl) Register(serviceName, host string, port int,
 
 	r.rpcRegisterTarget = fmt.Sprintf("%s:%d", host, port)
 	endpoint := endpoints.Endpoint{Addr: r.rpcRegisterTarget}
+	if len(metadata) > 0 {
+		endpoint.Metadata = metadata
+	}
 
 	err = em.AddEndpoint(context.TODO(), r.serviceKey, endpoint, clientv3.WithLease(leaseResp.ID))
 	if err != nil {
 		return err
 	}
+	r.metadataLock.Lock()
+	r.metadata = maps.Clone(metadata)
+	r.metadataLock.Unlock()
 
 	go r.keepAliveLease(r.leaseID)
 	return nil
 }
 
+// SetMetadata replaces the metadata of the registered endpoint, keeping its lease
+func (r *SvcDiscoveryRegistryImpl) SetMetadata(ctx context.Context, metadata map[string]string) error {
+	if r.endpointMgr == nil {
+		return fmt.Errorf("endpoint manager is not initialized")
+	}
+	r.metadataLock.Lock()
+	defer r.metadataLock.Unlock()
+	endpoint := endpoints.Endpoint{Addr: r.rpcRegisterTarget}
+	if len(metadata) > 0 {
+		endpoint.Metadata = metadata
+	}
+	if err := r.endpointMgr.AddEndpoint(ctx, r.serviceKey, endpoint, clientv3.WithLease(r.leaseID)); err != nil {
+		return err
+	}
+	r.metadata = maps.Clone(metadata)
+	return nil
+}
+
+// Metadata returns the metadata of the registered endpoint
+func (r *SvcDiscoveryRegistryImpl) Metadata() map[string]string {
+	r.metadataLock.Lock()
+	defer r.metadataLock.Unlock()
+	return maps.Clone(r.metadata)
+}
+
+// GetInstances returns the registered endpoints of a service with their metadata
+func (r *SvcDiscoveryRegistryImpl) GetInstances(ctx context.Context, serviceName string) ([]*discovery.Instance, error) {
+	prefix := fmt.Sprintf("%s/%s/", r.rootDirectory, serviceName)
+	resp, err := r.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the service endpoints from etcd")
+	}
+	instances := make([]*discovery.Instance, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		instances = append(instances, r.parseInstance(serviceName, kv.Key, kv.Value))
+	}
+	return instances, nil
+}
+
+// parseInstance decodes an endpoint written by the endpoints manager; values that are not
+// JSON are taken as registered without metadata
+func (r *SvcDiscoveryRegistryImpl) parseInstance(serviceName string, key, value []byte) *discovery.Instance {
+	instance := &discovery.Instance{ServiceName: serviceName}
+	var endpoint struct {
+		Addr     string
+		Metadata map[string]any
+	}
+	if err := json.Unmarshal(value, &endpoint); err == nil {
+		instance.Addr = endpoint.Addr
+		for k, v := range endpoint.Metadata {
+			if instance.Metadata == nil {
+				instance.Metadata = make(map[string]string, len(endpoint.Metadata))
+			}
+			if str, ok := v.(string); ok {
+				instance.Metadata[k] = str
+			} else {
+				instance.Metadata[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	if instance.Addr == "" {
+		_, instance.Addr = r.splitEndpoint(string(key))
+	}
+	return instance
+}
+
 // keepAliveLease maintains the lease alive by sending keep-alive requests
 func (r *SvcDiscoveryRegistryImpl) keepAliveLease(leaseID clientv3.LeaseID) {
 	ch, err := r.client.KeepAlive(context.Background(), leaseID)
@@ -239,10 +386,31 @@ func (r *SvcDiscoveryRegistryImpl) keepAliveLease(leaseID clientv3.LeaseID) {
 // watchServiceChanges watches for changes in the service directory
 func (r *SvcDiscoveryRegistryImpl) watchServiceChanges() {
 	watchChan := r.client.Watch(context.Background(), r.rootDirectory, clientv3.WithPrefix())
-	for range watchChan {
+	for resp := range watchChan {
 		r.mu.Lock()
 		r.initializeConnMap()
 		r.mu.Unlock()
+		r.watchGatewayChanges(resp.Events)
+	}
+}
+
+// watchGatewayChanges refreshes the gateway hash ring when events touch the gateway service,
+// once GetUserIdHashGatewayHost has loaded it
+func (r *SvcDiscoveryRegistryImpl) watchGatewayChanges(events []*clientv3.Event) {
+	r.gatewayLock.Lock()
+	loaded := r.gatewayLoaded
+	r.gatewayLock.Unlock()
+	if !loaded {
+		return
+	}
+	prefix := r.gatewayPrefix()
+	for _, event := range events {
+		if strings.HasPrefix(string(event.Kv.Key), prefix) {
+			if err := r.refreshGateway(context.Background()); err != nil {
+				log.ZWarn(context.Background(), "etcd refresh gateway failed", err, "prefix", prefix)
+			}
+			return
+		}
 	}
 }
 
@@ -291,6 +459,9 @@ func (r *SvcDiscoveryRegistryImpl) UnRegister() error {
 	if err != nil {
 		return err
 	}
+	r.metadataLock.Lock()
+	r.metadata = nil
+	r.metadataLock.Unlock()
 	return nil
 }
 
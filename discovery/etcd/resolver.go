@@ -0,0 +1,64 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"fmt"
+
+	"github.com/amazing-socrates/next-tools/discovery"
+	gresolver "google.golang.org/grpc/resolver"
+)
+
+// activeBuilder wraps the etcd naming resolver so that connections stop picking the
+// endpoints that announced a drain
+type activeBuilder struct {
+	gresolver.Builder
+}
+
+func (b activeBuilder) Build(target gresolver.Target, cc gresolver.ClientConn, opts gresolver.BuildOptions) (gresolver.Resolver, error) {
+	return b.Builder.Build(target, activeClientConn{ClientConn: cc}, opts)
+}
+
+type activeClientConn struct {
+	gresolver.ClientConn
+}
+
+// UpdateState drops the draining addresses, unless every address drains
+func (c activeClientConn) UpdateState(state gresolver.State) error {
+	var active []gresolver.Address
+	for _, addr := range state.Addresses {
+		if !draining(addr.Metadata) {
+			active = append(active, addr)
+		}
+	}
+	if len(active) > 0 {
+		state.Addresses = active
+	}
+	return c.ClientConn.UpdateState(state)
+}
+
+// draining reads the draining flag of endpoint metadata as decoded by the naming resolver
+func draining(metadata any) bool {
+	md, ok := metadata.(map[string]any)
+	if !ok {
+		return false
+	}
+	value, ok := md[discovery.MetadataDraining]
+	if !ok {
+		return false
+	}
+	instance := discovery.Instance{Metadata: map[string]string{discovery.MetadataDraining: fmt.Sprint(value)}}
+	return instance.Draining()
+}
@@ -0,0 +1,184 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+// DefaultGatewayServiceName is the service the registries answer GetUserIdHashGatewayHost from.
+const DefaultGatewayServiceName = "messagegateway"
+
+// DefaultVirtualNodes is the number of ring points of a node of weight 1.
+const DefaultVirtualNodes = 160
+
+// ErrNoGateway is returned by GetUserIdHashGatewayHost when no gateway instance is registered.
+var ErrNoGateway = errs.New("no gateway instance available")
+
+type HashRingOption func(*HashRing)
+
+// WithVirtualNodes sets the number of ring points of a node of weight 1. More points
+// spread keys more evenly at the cost of memory and rebuild time.
+func WithVirtualNodes(n int) HashRingOption {
+	return func(r *HashRing) {
+		r.virtualNodes = max(1, n)
+	}
+}
+
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+// HashRing maps keys to nodes with consistent hashing. Each node owns weight times
+// the virtual node count of points on the ring, and a key belongs to the node of the
+// first point at or after its hash. Adding or removing a node therefore only moves the
+// keys of the points it gains or loses. It is safe for concurrent use.
+type HashRing struct {
+	virtualNodes int
+
+	lock    sync.RWMutex
+	weights map[string]int
+	points  []ringPoint
+}
+
+func NewHashRing(opts ...HashRingOption) *HashRing {
+	r := &HashRing{
+		virtualNodes: DefaultVirtualNodes,
+		weights:      make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Set replaces the nodes of the ring with nodes, a map of node to weight. Nodes with
+// a weight of zero or less are left out. Set does nothing when the membership is unchanged.
+func (r *HashRing) Set(nodes map[string]int) {
+	weights := make(map[string]int, len(nodes))
+	for node, weight := range nodes {
+		if weight > 0 {
+			weights[node] = weight
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if maps.Equal(r.weights, weights) {
+		return
+	}
+	r.weights = weights
+	r.rebuild()
+}
+
+// Add adds node with weight, or changes its weight; a weight of zero or less removes it.
+func (r *HashRing) Add(node string, weight int) {
+	if weight <= 0 {
+		r.Remove(node)
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.weights[node] == weight {
+		return
+	}
+	r.weights[node] = weight
+	r.rebuild()
+}
+
+func (r *HashRing) Remove(node string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.weights[node]; !ok {
+		return
+	}
+	delete(r.weights, node)
+	r.rebuild()
+}
+
+// Get returns the node key belongs to, false when the ring is empty.
+func (r *HashRing) Get(key string) (string, bool) {
+	hash := hashKey(key)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.points) == 0 {
+		return "", false
+	}
+	i, _ := slices.BinarySearchFunc(r.points, hash, func(p ringPoint, hash uint64) int {
+		switch {
+		case p.hash < hash:
+			return -1
+		case p.hash > hash:
+			return 1
+		}
+		return 0
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node, true
+}
+
+// Nodes returns the nodes of the ring with their weights.
+func (r *HashRing) Nodes() map[string]int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return maps.Clone(r.weights)
+}
+
+func (r *HashRing) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return len(r.weights)
+}
+
+// rebuild recomputes the points from r.weights; the points of a node depend only on
+// its name and weight, which keeps the other nodes in place.
+func (r *HashRing) rebuild() {
+	var n int
+	for _, weight := range r.weights {
+		n += weight * r.virtualNodes
+	}
+	points := make([]ringPoint, 0, n)
+	for node, weight := range r.weights {
+		for i := 0; i < weight*r.virtualNodes; i++ {
+			points = append(points, ringPoint{hash: hashKey(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	// Colliding points are ordered by node so that every process builds the same ring.
+	slices.SortFunc(points, func(a, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return strings.Compare(a.node, b.node)
+	})
+	r.points = points
+}
+
+func hashKey(key string) uint64 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
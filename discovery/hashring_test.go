@@ -0,0 +1,93 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"strconv"
+	"testing"
+)
+
+func assign(r *HashRing, n int) map[string]string {
+	owners := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		key := "user" + strconv.Itoa(i)
+		node, ok := r.Get(key)
+		if !ok {
+			panic("empty ring")
+		}
+		owners[key] = node
+	}
+	return owners
+}
+
+func TestHashRing(t *testing.T) {
+	r := NewHashRing()
+	if _, ok := r.Get("user"); ok {
+		t.Fatal("empty ring returned a node")
+	}
+	r.Set(map[string]int{"10.0.0.1:10001": 1, "10.0.0.2:10001": 1, "10.0.0.3:10001": 2, "10.0.0.4:10001": 0})
+	if r.Len() != 3 {
+		t.Fatalf("unexpected nodes %v", r.Nodes())
+	}
+	const keys = 20000
+	before := assign(r, keys)
+	counts := make(map[string]int)
+	for _, node := range before {
+		counts[node]++
+	}
+	// The double weight node takes about half of the keys.
+	if n := counts["10.0.0.3:10001"]; n < keys*4/10 || n > keys*6/10 {
+		t.Fatalf("unexpected distribution %v", counts)
+	}
+
+	// Another process with the same membership agrees on every key.
+	other := NewHashRing()
+	for node, weight := range r.Nodes() {
+		other.Add(node, weight)
+	}
+	for key, node := range assign(other, keys) {
+		if before[key] != node {
+			t.Fatalf("%s: %s != %s", key, node, before[key])
+		}
+	}
+
+	// A new node only takes keys, about a fifth of them, from the others.
+	r.Add("10.0.0.5:10001", 1)
+	var moved int
+	for key, node := range assign(r, keys) {
+		if node == before[key] {
+			continue
+		}
+		if node != "10.0.0.5:10001" {
+			t.Fatalf("%s moved between existing nodes", key)
+		}
+		moved++
+	}
+	if moved < keys/10 || moved > keys*3/10 {
+		t.Fatalf("%d keys moved", moved)
+	}
+
+	// Removing it gives every key back.
+	r.Remove("10.0.0.5:10001")
+	for key, node := range assign(r, keys) {
+		if node != before[key] {
+			t.Fatalf("%s not restored", key)
+		}
+	}
+	r.Set(nil)
+	if _, ok := r.Get("user"); ok || r.Len() != 0 {
+		t.Fatal("ring not cleared")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+)
+
+// Well-known instance metadata keys. Any other key may be published as well.
+const (
+	MetadataVersion  = "version"
+	MetadataZone     = "zone"
+	MetadataWeight   = "weight"
+	MetadataDraining = "draining"
+)
+
+// Instance is one registered instance of a service with the metadata it published.
+type Instance struct {
+	ServiceName string            `json:"serviceName"`
+	Addr        string            `json:"addr"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// Weight returns the weight metadata of the instance, 1 when it is missing or invalid.
+func (i *Instance) Weight() int {
+	weight, err := strconv.Atoi(i.Metadata[MetadataWeight])
+	if err != nil || weight < 0 {
+		return 1
+	}
+	return weight
+}
+
+// Draining reports whether the instance announced that it is about to leave.
+func (i *Instance) Draining() bool {
+	draining, _ := strconv.ParseBool(i.Metadata[MetadataDraining])
+	return draining
+}
+
+// InstanceRegistry is implemented by the registries that store instance metadata.
+type InstanceRegistry interface {
+	// RegisterWithMetadata is Register with metadata attached to the instance.
+	RegisterWithMetadata(serviceName, host string, port int, metadata map[string]string, opts ...grpc.DialOption) error
+	// SetMetadata replaces the metadata of the registered instance.
+	SetMetadata(ctx context.Context, metadata map[string]string) error
+	// Metadata returns the metadata of the registered instance.
+	Metadata() map[string]string
+	// GetInstances returns the registered instances of serviceName.
+	GetInstances(ctx context.Context, serviceName string) ([]*Instance, error)
+}
+
+// FilterInstances returns the instances whose metadata has every key of match with the same value.
+func FilterInstances(instances []*Instance, match map[string]string) []*Instance {
+	var res []*Instance
+	for _, instance := range instances {
+		ok := true
+		for key, value := range match {
+			if v, has := instance.Metadata[key]; !has || v != value {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			res = append(res, instance)
+		}
+	}
+	return res
+}
+
+// ActiveInstances returns the instances that are not draining. When every instance drains
+// they are all returned, so that callers keep reaching the service while it rolls.
+func ActiveInstances(instances []*Instance) []*Instance {
+	var res []*Instance
+	for _, instance := range instances {
+		if !instance.Draining() {
+			res = append(res, instance)
+		}
+	}
+	if len(res) == 0 {
+		return instances
+	}
+	return res
+}
+
+// InstanceWeights returns the HashRing nodes of instances: draining instances are left out.
+func InstanceWeights(instances []*Instance) map[string]int {
+	nodes := make(map[string]int, len(instances))
+	for _, instance := range instances {
+		if !instance.Draining() {
+			nodes[instance.Addr] = instance.Weight()
+		}
+	}
+	return nodes
+}
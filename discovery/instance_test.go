@@ -0,0 +1,49 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestInstances(t *testing.T) {
+	instances := []*Instance{
+		{Addr: "10.0.0.1:10001", Metadata: map[string]string{MetadataZone: "a", MetadataVersion: "v2", MetadataWeight: "3"}},
+		{Addr: "10.0.0.2:10001", Metadata: map[string]string{MetadataZone: "a", MetadataVersion: "v1", MetadataWeight: "bad"}},
+		{Addr: "10.0.0.3:10001", Metadata: map[string]string{MetadataZone: "b", MetadataDraining: "true"}},
+		{Addr: "10.0.0.4:10001"},
+	}
+	if got := FilterInstances(instances, map[string]string{MetadataZone: "a"}); len(got) != 2 {
+		t.Fatalf("unexpected zone a instances %v", got)
+	}
+	if got := FilterInstances(instances, map[string]string{MetadataZone: "a", MetadataVersion: "v1"}); len(got) != 1 || got[0].Addr != "10.0.0.2:10001" {
+		t.Fatalf("unexpected v1 instances %v", got)
+	}
+	if got := FilterInstances(instances, nil); len(got) != len(instances) {
+		t.Fatal("empty match must keep every instance")
+	}
+	want := map[string]int{"10.0.0.1:10001": 3, "10.0.0.2:10001": 1, "10.0.0.4:10001": 1}
+	if got := InstanceWeights(instances); !maps.Equal(got, want) {
+		t.Fatalf("unexpected weights %v", got)
+	}
+	if got := ActiveInstances(instances); len(got) != 3 || slices.ContainsFunc(got, (*Instance).Draining) {
+		t.Fatalf("unexpected active instances %v", got)
+	}
+	if got := ActiveInstances(instances[2:3]); len(got) != 1 {
+		t.Fatal("a service that only drains must keep its instances")
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/amazing-socrates/next-tools/discovery"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultPortName is the endpoint port used when a service name has no port and its
+// EndpointSlices have several.
+const DefaultPortName = "grpc"
+
+// serviceIndex indexes EndpointSlices by the service they belong to.
+const serviceIndex = "service"
+
+// endpoint is one pod address of a service port.
+type endpoint struct {
+	addr        string
+	terminating bool
+	zone        string
+	pod         string
+}
+
+// parseTarget splits a service name into the service and the optional port.
+func parseTarget(serviceName string) (string, string) {
+	service, port, _ := strings.Cut(serviceName, ":")
+	return service, port
+}
+
+func sliceService(obj any) string {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return ""
+	}
+	return slice.Labels[discoveryv1.LabelServiceName]
+}
+
+func sliceServiceIndex(obj any) ([]string, error) {
+	if service := sliceService(obj); service != "" {
+		return []string{service}, nil
+	}
+	return nil, nil
+}
+
+// onSliceChange updates the resolvers, connections and gateway ring of the service of an
+// EndpointSlice that was added, changed or removed.
+func (k *KubernetesConnManager) onSliceChange(obj any) {
+	service := sliceService(obj)
+	if service == "" {
+		return
+	}
+	k.mu.Lock()
+	resolvers := slices.Clone(k.resolvers[service])
+	for serviceName := range k.connMap {
+		if s, _ := parseTarget(serviceName); s == service {
+			k.pruneConns(serviceName, k.endpoints(serviceName))
+		}
+	}
+	k.mu.Unlock()
+	for _, r := range resolvers {
+		r.update()
+	}
+	k.watchGatewayChanges(service)
+}
+
+// endpoints returns the pods of serviceName that take traffic, sorted by address: the ready
+// ones, or the terminating ones that still serve when none is ready.
+func (k *KubernetesConnManager) endpoints(serviceName string) []endpoint {
+	service, port := parseTarget(serviceName)
+	objs, err := k.slices.GetIndexer().ByIndex(serviceIndex, service)
+	if err != nil {
+		return nil
+	}
+	var ready, terminating []endpoint
+	seen := make(map[string]bool)
+	for _, obj := range objs {
+		slice := obj.(*discoveryv1.EndpointSlice)
+		number, ok := slicePort(slice.Ports, port)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			if len(ep.Addresses) == 0 {
+				continue
+			}
+			e := endpoint{addr: net.JoinHostPort(ep.Addresses[0], strconv.Itoa(int(number)))}
+			if seen[e.addr] {
+				// An endpoint moving between slices may briefly be in both.
+				continue
+			}
+			seen[e.addr] = true
+			if ep.Zone != nil {
+				e.zone = *ep.Zone
+			}
+			if ref := ep.TargetRef; ref != nil && ref.Kind == "Pod" {
+				e.pod = ref.Name
+			}
+			conditions := ep.Conditions
+			switch {
+			case conditions.Ready == nil || *conditions.Ready:
+				ready = append(ready, e)
+			case conditions.Terminating != nil && *conditions.Terminating && (conditions.Serving == nil || *conditions.Serving):
+				e.terminating = true
+				terminating = append(terminating, e)
+			}
+		}
+	}
+	res := ready
+	if len(res) == 0 {
+		res = terminating
+	}
+	slices.SortFunc(res, func(a, b endpoint) int {
+		return strings.Compare(a.addr, b.addr)
+	})
+	return res
+}
+
+// slicePort returns the number of port in ports, which may be a port name or number.
+func slicePort(ports []discoveryv1.EndpointPort, port string) (int32, bool) {
+	if port == "" {
+		if len(ports) == 1 && ports[0].Port != nil {
+			return *ports[0].Port, true
+		}
+		for _, p := range ports {
+			if p.Name != nil && *p.Name == DefaultPortName && p.Port != nil {
+				return *p.Port, true
+			}
+		}
+		for _, p := range ports {
+			if p.Port != nil {
+				return *p.Port, true
+			}
+		}
+		return 0, false
+	}
+	for _, p := range ports {
+		if p.Name != nil && *p.Name == port && p.Port != nil {
+			return *p.Port, true
+		}
+	}
+	if number, err := strconv.ParseInt(port, 10, 32); err == nil {
+		return int32(number), true
+	}
+	return 0, false
+}
+
+func containsAddr(endpoints []endpoint, addr string) bool {
+	return slices.ContainsFunc(endpoints, func(e endpoint) bool {
+		return e.addr == addr
+	})
+}
+
+// instance returns e with the metadata annotations of its pod. The zone of the endpoint is
+// used when the pod publishes none, and terminating pods are marked draining.
+func (k *KubernetesConnManager) instance(serviceName string, e endpoint) *discovery.Instance {
+	instance := &discovery.Instance{ServiceName: serviceName, Addr: e.addr}
+	if e.pod != "" && k.pods != nil {
+		if obj, ok, err := k.pods.GetIndexer().GetByKey(k.namespace + "/" + e.pod); err == nil && ok {
+			instance.Metadata = podMetadata(obj.(*corev1.Pod))
+		}
+	}
+	if e.zone != "" && instance.Metadata[discovery.MetadataZone] == "" {
+		setMetadata(instance, discovery.MetadataZone, e.zone)
+	}
+	if e.terminating {
+		setMetadata(instance, discovery.MetadataDraining, "true")
+	}
+	return instance
+}
+
+func setMetadata(instance *discovery.Instance, key, value string) {
+	if instance.Metadata == nil {
+		instance.Metadata = make(map[string]string)
+	}
+	instance.Metadata[key] = value
+}
+
+// podMetadata returns the metadata annotations of pod without their prefix.
+func podMetadata(pod *corev1.Pod) map[string]string {
+	var metadata map[string]string
+	for key, value := range pod.Annotations {
+		if name, ok := strings.CutPrefix(key, AnnotationPrefix); ok {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
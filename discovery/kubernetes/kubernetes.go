@@ -16,25 +16,61 @@ package kubernetes
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"net"
+	"os"
+	"strconv"
 	"sync"
 
+	"github.com/amazing-socrates/next-tools/discovery"
+	"github.com/amazing-socrates/next-tools/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
+// AnnotationPrefix prefixes the pod annotations instance metadata is published as.
+const AnnotationPrefix = "discovery.next-tools.io/"
+
+var _ discovery.InstanceRegistry = (*KubernetesConnManager)(nil)
+
+// KubernetesConnManager discovers the pods of Kubernetes services from EndpointSlice informers.
+// Service names may carry a port, "name:port", where port is the name or number of an
+// endpoint port; without it the only port, the port named "grpc" or the first port is used.
 type KubernetesConnManager struct {
-	clientset   *kubernetes.Clientset
+	clientset   kubernetes.Interface
 	namespace   string
 	dialOptions []grpc.DialOption
 
 	selfTarget string
 
-	mu      sync.RWMutex
-	connMap map[string][]*grpc.ClientConn
+	factory   informers.SharedInformerFactory
+	slices    cache.SharedIndexInformer
+	podsOnce  sync.Once
+	pods      cache.SharedIndexInformer
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	mu sync.RWMutex
+	// connMap holds the per pod connections of GetConns by service name and address.
+	connMap   map[string]map[string]*grpc.ClientConn
+	resolvers map[string][]*serviceResolver
+
+	// metadata is the metadata last published by SetMetadata.
+	metadataLock sync.Mutex
+	metadata     map[string]string
+
+	gatewayLock   sync.Mutex
+	gatewayName   string
+	gatewayRing   *discovery.HashRing
+	gatewayLoaded bool
 }
 
 // NewKubernetesConnManager creates a new connection manager that uses Kubernetes services for service discovery.
@@ -49,74 +85,146 @@ func NewKubernetesConnManager(namespace string, options ...grpc.DialOption) (*Ku
 		return nil, fmt.Errorf("failed to create clientset: %v", err)
 	}
 
-	return &KubernetesConnManager{
+	return NewKubernetesConnManagerWithClientset(clientset, namespace, options...)
+}
+
+// NewKubernetesConnManagerWithClientset creates a connection manager on clientset and starts
+// watching the EndpointSlices of namespace. The service account needs to list and watch
+// EndpointSlices, and pods for GetInstances and GetUserIdHashGatewayHost.
+func NewKubernetesConnManagerWithClientset(clientset kubernetes.Interface, namespace string, options ...grpc.DialOption) (*KubernetesConnManager, error) {
+	k := &KubernetesConnManager{
 		clientset:   clientset,
 		namespace:   namespace,
 		dialOptions: options,
-		connMap:     make(map[string][]*grpc.ClientConn),
-	}, nil
+		factory:     informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace)),
+		stop:        make(chan struct{}),
+		connMap:     make(map[string]map[string]*grpc.ClientConn),
+		resolvers:   make(map[string][]*serviceResolver),
+		gatewayName: discovery.DefaultGatewayServiceName,
+		gatewayRing: discovery.NewHashRing(),
+	}
+	k.slices = k.factory.Discovery().V1().EndpointSlices().Informer()
+	if err := k.slices.AddIndexers(cache.Indexers{serviceIndex: sliceServiceIndex}); err != nil {
+		return nil, fmt.Errorf("failed to index endpoint slices: %v", err)
+	}
+	if _, err := k.slices.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: k.onSliceChange,
+		UpdateFunc: func(oldObj, newObj any) {
+			if sliceService(oldObj) != sliceService(newObj) {
+				k.onSliceChange(oldObj)
+			}
+			k.onSliceChange(newObj)
+		},
+		DeleteFunc: k.onSliceChange,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to watch endpoint slices: %v", err)
+	}
+	k.factory.Start(k.stop)
+	return k, nil
 }
 
-func (k *KubernetesConnManager) initializeConnMap() error {
-	k.mu.Lock()
-	defer k.mu.Unlock()
-
-	services, err := k.clientset.CoreV1().Services(k.namespace).List(context.Background(), metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to list services: %v", err)
-	}
+// podInformer starts the pod informer the first time instance metadata is needed, so that
+// callers of GetConns alone do not need the permission to watch pods.
+func (k *KubernetesConnManager) podInformer() cache.SharedIndexInformer {
+	k.podsOnce.Do(func() {
+		k.pods = k.factory.Core().V1().Pods().Informer()
+		_, _ = k.pods.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(any) { k.watchGatewayChanges(k.gatewayService()) },
+			UpdateFunc: func(any, any) { k.watchGatewayChanges(k.gatewayService()) },
+			DeleteFunc: func(any) { k.watchGatewayChanges(k.gatewayService()) },
+		})
+		k.factory.Start(k.stop)
+	})
+	return k.pods
+}
 
-	for _, service := range services.Items {
-		endpoints, err := k.clientset.CoreV1().Endpoints(k.namespace).Get(context.Background(), service.Name, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to get endpoints for service %s: %v", service.Name, err)
+// waitSynced waits until the informers have listed their objects.
+func (k *KubernetesConnManager) waitSynced(ctx context.Context, informers ...cache.SharedIndexInformer) error {
+	var synced []cache.InformerSynced
+	for _, informer := range informers {
+		if !informer.HasSynced() {
+			synced = append(synced, informer.HasSynced)
 		}
-
-		var conns []*grpc.ClientConn
-		for _, subset := range endpoints.Subsets {
-			for _, address := range subset.Addresses {
-				conn, err := grpc.Dial(address.IP, append(k.dialOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
-				if err != nil {
-					return fmt.Errorf("failed to dial endpoint %s: %v", address.IP, err)
-				}
-				conns = append(conns, conn)
-			}
+	}
+	if len(synced) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-k.stop:
+			cancel()
+		case <-ctx.Done():
 		}
-		k.connMap[service.Name] = conns
+	}()
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync the informers of namespace %s", k.namespace)
 	}
-
 	return nil
 }
 
-// GetConns returns gRPC client connections for a given Kubernetes service name.
+// dialOpts returns the options connections are dialed with; options given later win.
+func (k *KubernetesConnManager) dialOpts(opts []grpc.DialOption) []grpc.DialOption {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	res := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	res = append(res, k.dialOptions...)
+	return append(res, opts...)
+}
+
+// GetConns returns a gRPC client connection to every ready pod of a Kubernetes service.
+// Connections are kept until their pod leaves the service.
 func (k *KubernetesConnManager) GetConns(ctx context.Context, serviceName string, opts ...grpc.DialOption) ([]*grpc.ClientConn, error) {
-	// k.mu.RLock()
-	// defer k.mu.RUnlock()
-	// if len(k.connMap) == 0 {
-	// 	if err := k.initializeConnMap(); err != nil {
-	// 		return nil, err
-	// 	}
-	// }
+	if err := k.waitSynced(ctx, k.slices); err != nil {
+		return nil, err
+	}
+	endpoints := k.endpoints(serviceName)
+	dialOpts := k.dialOpts(opts)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	conns := k.connMap[serviceName]
+	if conns == nil {
+		conns = make(map[string]*grpc.ClientConn)
+		k.connMap[serviceName] = conns
+	}
+	res := make([]*grpc.ClientConn, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		conn, ok := conns[endpoint.addr]
+		if !ok {
+			var err error
+			conn, err = grpc.DialContext(ctx, endpoint.addr, dialOpts...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to dial endpoint %s: %v", endpoint.addr, err)
+			}
+			conns[endpoint.addr] = conn
+		}
+		res = append(res, conn)
+	}
+	k.pruneConns(serviceName, endpoints)
+	return res, nil
+}
 
-	// return k.connMap[serviceName], nil
-	return nil, nil
+// pruneConns closes the connections of serviceName to pods that are no longer in endpoints.
+// The caller holds k.mu.
+func (k *KubernetesConnManager) pruneConns(serviceName string, endpoints []endpoint) {
+	conns := k.connMap[serviceName]
+	for addr, conn := range conns {
+		if !containsAddr(endpoints, addr) {
+			_ = conn.Close()
+			delete(conns, addr)
+		}
+	}
 }
 
-// GetConn returns a single gRPC client connection for a given Kubernetes service name.
+// GetConn returns a single gRPC client connection for a given Kubernetes service name, balanced
+// round robin over its ready pods by a resolver that follows the EndpointSlices.
 func (k *KubernetesConnManager) GetConn(ctx context.Context, serviceName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	// k.mu.RLock()
-	// if len(k.connMap) == 0 {
-	// 	k.mu.RUnlock()
-	// 	if err := k.initializeConnMap(); err != nil {
-	// 		return nil, err
-	// 	}
-	// 	k.mu.RLock()
-	// }
-
-	// defer k.mu.RUnlock()
-
-	// return k.connMap[serviceName][0], nil
-	return grpc.DialContext(ctx, serviceName, append(k.dialOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithResolvers(k),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy": "round_robin"}`),
+	}, k.dialOpts(opts)...)
+	return grpc.DialContext(ctx, fmt.Sprintf("%s:///%s", Scheme, serviceName), dialOpts...)
 }
 
 // GetSelfConnTarget returns the connection target for the current service.
@@ -133,11 +241,25 @@ func (k *KubernetesConnManager) AddOption(opts ...grpc.DialOption) {
 
 // CloseConn closes a given gRPC client connection.
 func (k *KubernetesConnManager) CloseConn(conn *grpc.ClientConn) {
+	k.mu.Lock()
+	for _, conns := range k.connMap {
+		for addr, c := range conns {
+			if c == conn {
+				delete(conns, addr)
+			}
+		}
+	}
+	k.mu.Unlock()
 	conn.Close()
 }
 
-// Close closes all gRPC connections managed by KubernetesConnManager.
+// Close stops the informers and closes all gRPC connections managed by KubernetesConnManager.
 func (k *KubernetesConnManager) Close() {
+	k.closeOnce.Do(func() {
+		close(k.stop)
+		k.factory.Shutdown()
+	})
+
 	k.mu.Lock()
 	defer k.mu.Unlock()
 	for _, conns := range k.connMap {
@@ -145,16 +267,152 @@ func (k *KubernetesConnManager) Close() {
 			_ = conn.Close()
 		}
 	}
-	k.connMap = make(map[string][]*grpc.ClientConn)
+	k.connMap = make(map[string]map[string]*grpc.ClientConn)
 }
 
 func (k *KubernetesConnManager) Register(serviceName, host string, port int, opts ...grpc.DialOption) error {
+	return k.RegisterWithMetadata(serviceName, host, port, nil, opts...)
+}
+
+// RegisterWithMetadata publishes metadata as annotations of the pod of the process, found by the
+// POD_NAME environment variable or the hostname. Kubernetes tracks membership itself, so
+// nothing else is registered; with metadata the service account needs to patch pods.
+func (k *KubernetesConnManager) RegisterWithMetadata(serviceName, host string, port int, metadata map[string]string, opts ...grpc.DialOption) error {
+	k.selfTarget = net.JoinHostPort(host, strconv.Itoa(port))
+	if len(metadata) == 0 {
+		return nil
+	}
+	return k.SetMetadata(context.Background(), metadata)
+}
+
+// SetMetadata replaces the metadata annotations of the pod of the process.
+func (k *KubernetesConnManager) SetMetadata(ctx context.Context, metadata map[string]string) error {
+	name, err := podName()
+	if err != nil {
+		return err
+	}
+	k.metadataLock.Lock()
+	defer k.metadataLock.Unlock()
+	annotations := make(map[string]*string)
+	for key := range k.metadata {
+		annotations[AnnotationPrefix+key] = nil
+	}
+	for key, value := range metadata {
+		annotations[AnnotationPrefix+key] = &value
+	}
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": annotations}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal pod patch: %v", err)
+	}
+	if _, err := k.clientset.CoreV1().Pods(k.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to patch annotations of pod %s: %v", name, err)
+	}
+	k.metadata = maps.Clone(metadata)
 	return nil
 }
+
+// Metadata returns the metadata last published by SetMetadata.
+func (k *KubernetesConnManager) Metadata() map[string]string {
+	k.metadataLock.Lock()
+	defer k.metadataLock.Unlock()
+	return maps.Clone(k.metadata)
+}
+
+// GetInstances returns the ready pods of a Kubernetes service with their metadata annotations.
+// When no pod is ready the terminating pods that still serve are returned, marked draining.
+func (k *KubernetesConnManager) GetInstances(ctx context.Context, serviceName string) ([]*discovery.Instance, error) {
+	pods := k.podInformer()
+	if err := k.waitSynced(ctx, k.slices, pods); err != nil {
+		return nil, err
+	}
+	endpoints := k.endpoints(serviceName)
+	instances := make([]*discovery.Instance, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		instances = append(instances, k.instance(serviceName, endpoint))
+	}
+	return instances, nil
+}
+
 func (k *KubernetesConnManager) UnRegister() error {
 	return nil
 }
 
+// SetGatewayServiceName sets the service GetUserIdHashGatewayHost picks pods of,
+// discovery.DefaultGatewayServiceName by default.
+func (k *KubernetesConnManager) SetGatewayServiceName(serviceName string) {
+	k.gatewayLock.Lock()
+	defer k.gatewayLock.Unlock()
+	k.gatewayName = serviceName
+	k.gatewayRing.Set(nil)
+	k.gatewayLoaded = false
+}
+
+// GetUserIdHashGatewayHost returns the address of the gateway pod the user is pinned to by
+// consistent hashing, so that scaling the gateway moves as few users as possible.
 func (k *KubernetesConnManager) GetUserIdHashGatewayHost(ctx context.Context, userId string) (string, error) {
-	return "", nil
+	k.gatewayLock.Lock()
+	loaded := k.gatewayLoaded
+	k.gatewayLock.Unlock()
+	if !loaded {
+		if err := k.refreshGateway(ctx); err != nil {
+			return "", err
+		}
+	}
+	host, ok := k.gatewayRing.Get(userId)
+	if !ok {
+		return "", discovery.ErrNoGateway.WrapMsg("gateway service has no ready endpoint", "namespace", k.namespace, "serviceName", k.getGatewayName())
+	}
+	return host, nil
+}
+
+func (k *KubernetesConnManager) getGatewayName() string {
+	k.gatewayLock.Lock()
+	defer k.gatewayLock.Unlock()
+	return k.gatewayName
+}
+
+// gatewayService returns the gateway service name without its port.
+func (k *KubernetesConnManager) gatewayService() string {
+	service, _ := parseTarget(k.getGatewayName())
+	return service
+}
+
+// refreshGateway loads the gateway pods into the hash ring.
+func (k *KubernetesConnManager) refreshGateway(ctx context.Context) error {
+	k.gatewayLock.Lock()
+	defer k.gatewayLock.Unlock()
+	instances, err := k.GetInstances(ctx, k.gatewayName)
+	if err != nil {
+		k.gatewayLoaded = false
+		return err
+	}
+	k.gatewayRing.Set(discovery.InstanceWeights(instances))
+	k.gatewayLoaded = true
+	return nil
+}
+
+// watchGatewayChanges refreshes the gateway hash ring when serviceName is the gateway
+// service, once GetUserIdHashGatewayHost has loaded it.
+func (k *KubernetesConnManager) watchGatewayChanges(serviceName string) {
+	k.gatewayLock.Lock()
+	gateway, _ := parseTarget(k.gatewayName)
+	refresh := k.gatewayLoaded && serviceName == gateway
+	k.gatewayLock.Unlock()
+	if !refresh {
+		return
+	}
+	if err := k.refreshGateway(context.Background()); err != nil {
+		log.ZWarn(context.Background(), "kubernetes refresh gateway failed", err, "namespace", k.namespace, "serviceName", serviceName)
+	}
+}
+
+func podName() (string, error) {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name, nil
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the pod name: %v", err)
+	}
+	return name, nil
 }
@@ -0,0 +1,225 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/discovery"
+	"google.golang.org/grpc/resolver"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const namespace = "im"
+
+type slicePod struct {
+	ip          string
+	ready       bool
+	terminating bool
+}
+
+func newSlice(name, service string, pods ...slicePod) *discoveryv1.EndpointSlice {
+	grpcName, httpName := "grpc", "http"
+	grpcPort, httpPort := int32(10001), int32(8080)
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &httpName, Port: &httpPort}, {Name: &grpcName, Port: &grpcPort}},
+	}
+	for _, pod := range pods {
+		ready, serving, terminating := pod.ready, pod.ready || pod.terminating, pod.terminating
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{pod.ip},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready, Serving: &serving, Terminating: &terminating},
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: "pod-" + pod.ip, Namespace: namespace},
+		})
+	}
+	return slice
+}
+
+// newManager returns a manager on a fake clientset once its EndpointSlice watch is started,
+// since the fake clientset drops the changes made between a list and its watch.
+func newManager(t *testing.T, objects ...runtime.Object) (*KubernetesConnManager, *fake.Clientset) {
+	client := fake.NewClientset(objects...)
+	watching := make(chan struct{}, 1)
+	client.PrependWatchReactor("endpointslices", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		select {
+		case watching <- struct{}{}:
+		default:
+		}
+		return true, w, err
+	})
+	k, err := NewKubernetesConnManagerWithClientset(client, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(k.Close)
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("endpoint slices not watched")
+	}
+	return k, client
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func conns(t *testing.T, k *KubernetesConnManager, serviceName string) []string {
+	t.Helper()
+	res, err := k.GetConns(context.Background(), serviceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := make([]string, len(res))
+	for i, conn := range res {
+		targets[i] = conn.Target()
+	}
+	return targets
+}
+
+func TestGetConns(t *testing.T) {
+	ctx := context.Background()
+	k, client := newManager(t, newSlice("rpc-a", "rpc", slicePod{ip: "10.0.0.2", ready: true}, slicePod{ip: "10.0.0.3"}),
+		newSlice("rpc-b", "rpc", slicePod{ip: "10.0.0.1", ready: true}, slicePod{ip: "10.0.0.4", terminating: true}))
+	if got := conns(t, k, "rpc"); !slices.Equal(got, []string{"10.0.0.1:10001", "10.0.0.2:10001"}) {
+		t.Fatalf("unexpected conns %v", got)
+	}
+	if got := conns(t, k, "rpc:http"); !slices.Equal(got, []string{"10.0.0.1:8080", "10.0.0.2:8080"}) {
+		t.Fatalf("unexpected named port conns %v", got)
+	}
+	if got := conns(t, k, "missing"); len(got) != 0 {
+		t.Fatalf("unexpected conns %v", got)
+	}
+
+	// The pods of rpc-b terminate; only the terminating pods that still serve are left once
+	// rpc-a is gone too.
+	if _, err := client.DiscoveryV1().EndpointSlices(namespace).Update(ctx, newSlice("rpc-b", "rpc", slicePod{ip: "10.0.0.4", terminating: true}), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		return slices.Equal(conns(t, k, "rpc"), []string{"10.0.0.2:10001"})
+	})
+	if err := client.DiscoveryV1().EndpointSlices(namespace).Delete(ctx, "rpc-a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		return slices.Equal(conns(t, k, "rpc"), []string{"10.0.0.4:10001"})
+	})
+}
+
+type testClientConn struct {
+	resolver.ClientConn
+	states chan []string
+}
+
+func (c *testClientConn) UpdateState(state resolver.State) error {
+	addrs := make([]string, len(state.Addresses))
+	for i, addr := range state.Addresses {
+		addrs[i] = addr.Addr
+	}
+	c.states <- addrs
+	return nil
+}
+
+func (c *testClientConn) ReportError(error) {
+	c.states <- nil
+}
+
+// wait waits for the resolver to send want, nil for an error; states sent twice are skipped.
+func (c *testClientConn) wait(t *testing.T, want []string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case addrs := <-c.states:
+			if slices.Equal(addrs, want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("resolver did not send %v", want)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	k, client := newManager(t, newSlice("rpc-a", "rpc", slicePod{ip: "10.0.0.1", ready: true}))
+	cc := &testClientConn{states: make(chan []string, 10)}
+	r, err := k.Build(resolver.Target{URL: url.URL{Scheme: Scheme, Path: "/rpc:http"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cc.wait(t, []string{"10.0.0.1:8080"})
+	if _, err := client.DiscoveryV1().EndpointSlices(namespace).Create(ctx, newSlice("rpc-b", "rpc", slicePod{ip: "10.0.0.2", ready: true}), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cc.wait(t, []string{"10.0.0.1:8080", "10.0.0.2:8080"})
+	for _, name := range []string{"rpc-a", "rpc-b"} {
+		if err := client.DiscoveryV1().EndpointSlices(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cc.wait(t, nil)
+}
+
+func TestGetInstances(t *testing.T) {
+	ctx := context.Background()
+	pod := func(ip string, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-" + ip, Namespace: namespace, Annotations: annotations}}
+	}
+	k, _ := newManager(t,
+		newSlice("gateway-a", "gateway", slicePod{ip: "10.0.0.1", ready: true}, slicePod{ip: "10.0.0.2", ready: true}),
+		pod("10.0.0.1", map[string]string{AnnotationPrefix + discovery.MetadataZone: "a", "other": "x"}),
+		pod("10.0.0.2", map[string]string{AnnotationPrefix + discovery.MetadataWeight: "0"}),
+	)
+	instances, err := k.GetInstances(ctx, "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 || instances[0].Metadata[discovery.MetadataZone] != "a" || len(instances[0].Metadata) != 1 {
+		t.Fatalf("unexpected instances %+v", instances)
+	}
+	k.SetGatewayServiceName("gateway")
+	for _, user := range []string{"u1", "u2", "u3"} {
+		// The instance of weight 0 takes no user.
+		if host, err := k.GetUserIdHashGatewayHost(ctx, user); err != nil || host != "10.0.0.1:10001" {
+			t.Fatalf("unexpected gateway %s: %v", host, err)
+		}
+	}
+}
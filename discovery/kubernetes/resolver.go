@@ -0,0 +1,108 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/amazing-socrates/next-tools/log"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the target scheme of the connections of GetConn, "kubernetes:///name[:port]".
+const Scheme = "kubernetes"
+
+// serviceResolver feeds a gRPC connection with the pods of a service as its EndpointSlices change.
+type serviceResolver struct {
+	manager     *KubernetesConnManager
+	serviceName string
+	cc          resolver.ClientConn
+
+	lock   sync.Mutex
+	closed bool
+}
+
+// Build implements resolver.Builder for the connections of GetConn.
+func (k *KubernetesConnManager) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	serviceName := strings.TrimLeft(target.URL.Path, "/")
+	if serviceName == "" {
+		return nil, fmt.Errorf("kubernetes target %s has no service name", target.URL.String())
+	}
+	r := &serviceResolver{manager: k, serviceName: serviceName, cc: cc}
+	service, _ := parseTarget(serviceName)
+	k.mu.Lock()
+	k.resolvers[service] = append(k.resolvers[service], r)
+	k.mu.Unlock()
+	go func() {
+		// Until the informer has synced an empty service is not an error.
+		if err := k.waitSynced(context.Background(), k.slices); err == nil {
+			r.update()
+		}
+	}()
+	return r, nil
+}
+
+// Scheme implements resolver.Builder.
+func (k *KubernetesConnManager) Scheme() string {
+	return Scheme
+}
+
+// update sends the current pods of the service to the connection.
+func (r *serviceResolver) update() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed || !r.manager.slices.HasSynced() {
+		return
+	}
+	endpoints := r.manager.endpoints(r.serviceName)
+	if len(endpoints) == 0 {
+		r.cc.ReportError(fmt.Errorf("kubernetes service %s has no ready endpoint in namespace %s", r.serviceName, r.manager.namespace))
+		return
+	}
+	addrs := make([]resolver.Address, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addrs = append(addrs, resolver.Address{Addr: endpoint.addr})
+	}
+	if err := r.cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		log.ZDebug(context.Background(), "kubernetes resolver update state", "serviceName", r.serviceName, "err", err)
+	}
+}
+
+// ResolveNow implements resolver.Resolver; the informer keeps the state current, so it
+// only sends it again.
+func (r *serviceResolver) ResolveNow(resolver.ResolveNowOptions) {
+	go r.update()
+}
+
+// Close implements resolver.Resolver.
+func (r *serviceResolver) Close() {
+	r.lock.Lock()
+	r.closed = true
+	r.lock.Unlock()
+	service, _ := parseTarget(r.serviceName)
+	k := r.manager
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.resolvers[service] = slices.DeleteFunc(k.resolvers[service], func(other *serviceResolver) bool {
+		return other == r
+	})
+	if len(k.resolvers[service]) == 0 {
+		delete(k.resolvers, service)
+	}
+}
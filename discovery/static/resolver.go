@@ -0,0 +1,100 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package static
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the target scheme of the connections of GetConn, "static:///name".
+const Scheme = "static"
+
+// serviceResolver feeds a gRPC connection with the endpoints of a service as they change.
+type serviceResolver struct {
+	registry    *Registry
+	serviceName string
+	cc          resolver.ClientConn
+
+	lock   sync.Mutex
+	closed bool
+}
+
+// Build implements resolver.Builder for the connections of GetConn.
+func (r *Registry) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	serviceName := strings.TrimLeft(target.URL.Path, "/")
+	if serviceName == "" {
+		return nil, errs.ErrArgs.WrapMsg("static target has no service name", "target", target.URL.String())
+	}
+	res := &serviceResolver{registry: r, serviceName: serviceName, cc: cc}
+	r.lock.Lock()
+	r.resolvers[serviceName] = append(r.resolvers[serviceName], res)
+	r.lock.Unlock()
+	res.update()
+	return res, nil
+}
+
+// Scheme implements resolver.Builder.
+func (r *Registry) Scheme() string {
+	return Scheme
+}
+
+// update sends the current endpoints of the service to the connection.
+func (s *serviceResolver) update() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	endpoints := s.registry.activeEndpoints(s.serviceName)
+	if len(endpoints) == 0 {
+		s.cc.ReportError(errs.New("static service has no endpoint", "serviceName", s.serviceName))
+		return
+	}
+	addrs := make([]resolver.Address, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addrs = append(addrs, resolver.Address{Addr: endpoint.Addr})
+	}
+	if err := s.cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		log.ZDebug(context.Background(), "static resolver update state", "serviceName", s.serviceName, "err", err)
+	}
+}
+
+// ResolveNow implements resolver.Resolver; updates are pushed, so it only sends the state again.
+func (s *serviceResolver) ResolveNow(resolver.ResolveNowOptions) {
+	go s.update()
+}
+
+// Close implements resolver.Resolver.
+func (s *serviceResolver) Close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	r := s.registry
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.resolvers[s.serviceName] = slices.DeleteFunc(r.resolvers[s.serviceName], func(other *serviceResolver) bool {
+		return other == s
+	})
+	if len(r.resolvers[s.serviceName]) == 0 {
+		delete(r.resolvers, s.serviceName)
+	}
+}
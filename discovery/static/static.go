@@ -0,0 +1,445 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package static is a discovery.SvcDiscoveryRegistry without an external registry, for local
+// development and CI. Services are given in code or in a YAML or JSON file that is watched
+// for changes, and connections resolve them through the same gRPC resolver path as the
+// other registries:
+//
+//	rpc-user:
+//	  - 127.0.0.1:10110
+//	  - addr: 127.0.0.1:10111
+//	    metadata:
+//	      zone: a
+//
+// Instances registered in the process are added to the services of the file.
+package static
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/discovery"
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultWatchInterval is how often NewFromFile checks the file for changes.
+const DefaultWatchInterval = time.Second
+
+var _ discovery.SvcDiscoveryRegistry = (*Registry)(nil)
+var _ discovery.InstanceRegistry = (*Registry)(nil)
+
+// Endpoint is one address of a service. In a file it is either the address alone or an
+// object with addr and metadata.
+type Endpoint struct {
+	Addr     string            `yaml:"addr" json:"addr"`
+	Metadata map[string]string `yaml:"metadata" json:"metadata,omitempty"`
+}
+
+func (e *Endpoint) UnmarshalYAML(unmarshal func(any) error) error {
+	if err := unmarshal(&e.Addr); err == nil {
+		return nil
+	}
+	type endpoint Endpoint
+	return unmarshal((*endpoint)(e))
+}
+
+// ParseServices parses a YAML or JSON map of service name to endpoints.
+func ParseServices(data []byte) (map[string][]Endpoint, error) {
+	var services map[string][]Endpoint
+	if err := yaml.Unmarshal(data, &services); err != nil {
+		return nil, errs.WrapMsg(err, "parse static services failed")
+	}
+	for name, endpoints := range services {
+		for _, endpoint := range endpoints {
+			if endpoint.Addr == "" {
+				return nil, errs.ErrArgs.WrapMsg("static endpoint without addr", "serviceName", name)
+			}
+		}
+	}
+	return services, nil
+}
+
+type Option func(*Registry)
+
+// WithDialOptions sets the gRPC dial options of every connection.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(r *Registry) {
+		r.dialOptions = opts
+	}
+}
+
+// WithWatchInterval sets how often NewFromFile checks the file for changes; 0 disables watching.
+func WithWatchInterval(interval time.Duration) Option {
+	return func(r *Registry) {
+		r.watchInterval = interval
+	}
+}
+
+// WithGatewayServiceName sets the service GetUserIdHashGatewayHost picks instances of,
+// discovery.DefaultGatewayServiceName by default.
+func WithGatewayServiceName(serviceName string) Option {
+	return func(r *Registry) {
+		r.gatewayName = serviceName
+	}
+}
+
+// Registry serves services from a static map.
+type Registry struct {
+	dialOptions   []grpc.DialOption
+	watchInterval time.Duration
+	gatewayName   string
+	gatewayRing   *discovery.HashRing
+	cancel        context.CancelFunc
+
+	lock sync.RWMutex
+	// services are the configured endpoints, registered those of Register.
+	services   map[string][]Endpoint
+	registered map[string][]Endpoint
+	conns      map[string]map[string]*grpc.ClientConn
+	resolvers  map[string][]*serviceResolver
+
+	selfService  string
+	selfTarget   string
+	selfMetadata map[string]string
+}
+
+// New returns a Registry serving services, a map of service name to endpoints.
+func New(services map[string][]Endpoint, opts ...Option) *Registry {
+	r := &Registry{
+		watchInterval: DefaultWatchInterval,
+		gatewayName:   discovery.DefaultGatewayServiceName,
+		gatewayRing:   discovery.NewHashRing(),
+		cancel:        func() {},
+		registered:    make(map[string][]Endpoint),
+		conns:         make(map[string]map[string]*grpc.ClientConn),
+		resolvers:     make(map[string][]*serviceResolver),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.Update(services)
+	return r
+}
+
+// NewFromFile returns a Registry serving the services of a YAML or JSON file, reloaded
+// when it changes. A file that fails to parse is logged and the previous services are kept.
+func NewFromFile(path string, opts ...Option) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errs.WrapMsg(err, "read static services failed", "path", path)
+	}
+	services, err := ParseServices(data)
+	if err != nil {
+		return nil, errs.WrapMsg(err, "invalid static services file", "path", path)
+	}
+	r := New(services, opts...)
+	if r.watchInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.cancel = cancel
+		go r.watchFile(ctx, path, data)
+	}
+	return r, nil
+}
+
+func (r *Registry) watchFile(ctx context.Context, path string, data []byte) {
+	ticker := time.NewTicker(r.watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// The content is compared rather than the modification time, which atomic
+		// replacements such as mounted ConfigMaps do not always change.
+		current, err := os.ReadFile(path)
+		if err != nil {
+			log.ZWarn(ctx, "read static services failed", err, "path", path)
+			continue
+		}
+		if bytes.Equal(current, data) {
+			continue
+		}
+		data = current
+		services, err := ParseServices(data)
+		if err != nil {
+			log.ZWarn(ctx, "invalid static services file, keeping the previous services", err, "path", path)
+			continue
+		}
+		log.ZInfo(ctx, "static services reloaded", "path", path, "services", len(services))
+		r.Update(services)
+	}
+}
+
+// Update replaces the configured services and updates the connections to them.
+func (r *Registry) Update(services map[string][]Endpoint) {
+	services = maps.Clone(services)
+	r.lock.Lock()
+	var changed []string
+	for name := range r.services {
+		changed = append(changed, name)
+	}
+	for name := range services {
+		if _, ok := r.services[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	r.services = services
+	r.lock.Unlock()
+	r.changed(changed...)
+}
+
+// endpoints returns the configured and registered endpoints of serviceName, the first
+// endpoint of an address winning.
+func (r *Registry) endpoints(serviceName string) []Endpoint {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var res []Endpoint
+	for _, endpoint := range slices.Concat(r.services[serviceName], r.registered[serviceName]) {
+		if !slices.ContainsFunc(res, func(e Endpoint) bool { return e.Addr == endpoint.Addr }) {
+			res = append(res, endpoint)
+		}
+	}
+	return res
+}
+
+// activeEndpoints returns the endpoints of serviceName connections pick: draining endpoints
+// are left out unless the whole service drains.
+func (r *Registry) activeEndpoints(serviceName string) []Endpoint {
+	endpoints := r.endpoints(serviceName)
+	instances := make([]*discovery.Instance, len(endpoints))
+	for i, endpoint := range endpoints {
+		instances[i] = &discovery.Instance{Addr: endpoint.Addr, Metadata: endpoint.Metadata}
+	}
+	active := discovery.ActiveInstances(instances)
+	return slices.DeleteFunc(endpoints, func(e Endpoint) bool {
+		return !slices.ContainsFunc(active, func(instance *discovery.Instance) bool { return instance.Addr == e.Addr })
+	})
+}
+
+// changed updates the resolvers, connections and gateway ring of services.
+func (r *Registry) changed(services ...string) {
+	for _, name := range services {
+		endpoints := r.endpoints(name)
+		r.lock.Lock()
+		resolvers := slices.Clone(r.resolvers[name])
+		for addr, conn := range r.conns[name] {
+			if !slices.ContainsFunc(endpoints, func(e Endpoint) bool { return e.Addr == addr }) {
+				_ = conn.Close()
+				delete(r.conns[name], addr)
+			}
+		}
+		gateway := name == r.gatewayName
+		r.lock.Unlock()
+		for _, res := range resolvers {
+			res.update()
+		}
+		if gateway {
+			r.refreshGateway()
+		}
+	}
+}
+
+func (r *Registry) dialOpts(opts []grpc.DialOption) []grpc.DialOption {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	res := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	res = append(res, r.dialOptions...)
+	return append(res, opts...)
+}
+
+// GetConns returns a connection to every endpoint of serviceName that is not draining.
+func (r *Registry) GetConns(ctx context.Context, serviceName string, opts ...grpc.DialOption) ([]*grpc.ClientConn, error) {
+	endpoints := r.activeEndpoints(serviceName)
+	dialOpts := r.dialOpts(opts)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	conns := r.conns[serviceName]
+	if conns == nil {
+		conns = make(map[string]*grpc.ClientConn)
+		r.conns[serviceName] = conns
+	}
+	res := make([]*grpc.ClientConn, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		conn, ok := conns[endpoint.Addr]
+		if !ok {
+			var err error
+			conn, err = grpc.DialContext(ctx, endpoint.Addr, dialOpts...)
+			if err != nil {
+				return nil, errs.WrapMsg(err, "DialContext failed", "addr", endpoint.Addr)
+			}
+			conns[endpoint.Addr] = conn
+		}
+		res = append(res, conn)
+	}
+	return res, nil
+}
+
+// GetConn returns a connection balanced round robin over the endpoints of serviceName.
+func (r *Registry) GetConn(ctx context.Context, serviceName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy": "round_robin"}`),
+	}, r.dialOpts(opts)...)
+	return grpc.DialContext(ctx, fmt.Sprintf("%s:///%s", Scheme, serviceName), dialOpts...)
+}
+
+func (r *Registry) GetSelfConnTarget() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.selfTarget
+}
+
+func (r *Registry) AddOption(opts ...grpc.DialOption) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.dialOptions = append(r.dialOptions, opts...)
+}
+
+func (r *Registry) CloseConn(conn *grpc.ClientConn) {
+	r.lock.Lock()
+	for _, conns := range r.conns {
+		for addr, c := range conns {
+			if c == conn {
+				delete(conns, addr)
+			}
+		}
+	}
+	r.lock.Unlock()
+	conn.Close()
+}
+
+// Register adds the instance to serviceName for the connections of this process.
+func (r *Registry) Register(serviceName, host string, port int, opts ...grpc.DialOption) error {
+	return r.RegisterWithMetadata(serviceName, host, port, nil, opts...)
+}
+
+func (r *Registry) RegisterWithMetadata(serviceName, host string, port int, metadata map[string]string, opts ...grpc.DialOption) error {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	r.lock.Lock()
+	if r.selfService != "" {
+		r.lock.Unlock()
+		return errs.ErrArgs.WrapMsg("static registry already registered", "serviceName", r.selfService, "addr", r.selfTarget)
+	}
+	r.selfService, r.selfTarget, r.selfMetadata = serviceName, addr, maps.Clone(metadata)
+	r.registered[serviceName] = append(r.registered[serviceName], Endpoint{Addr: addr, Metadata: r.selfMetadata})
+	r.lock.Unlock()
+	r.changed(serviceName)
+	return nil
+}
+
+// SetMetadata replaces the metadata of the registered instance.
+func (r *Registry) SetMetadata(ctx context.Context, metadata map[string]string) error {
+	r.lock.Lock()
+	service := r.selfService
+	if service == "" {
+		r.lock.Unlock()
+		return errs.ErrArgs.WrapMsg("static registry not registered")
+	}
+	r.selfMetadata = maps.Clone(metadata)
+	for i, endpoint := range r.registered[service] {
+		if endpoint.Addr == r.selfTarget {
+			r.registered[service][i].Metadata = r.selfMetadata
+		}
+	}
+	r.lock.Unlock()
+	r.changed(service)
+	return nil
+}
+
+// Metadata returns the metadata of the registered instance.
+func (r *Registry) Metadata() map[string]string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return maps.Clone(r.selfMetadata)
+}
+
+func (r *Registry) UnRegister() error {
+	r.lock.Lock()
+	service := r.selfService
+	r.registered[service] = slices.DeleteFunc(r.registered[service], func(e Endpoint) bool {
+		return e.Addr == r.selfTarget
+	})
+	if len(r.registered[service]) == 0 {
+		delete(r.registered, service)
+	}
+	r.selfService, r.selfTarget, r.selfMetadata = "", "", nil
+	r.lock.Unlock()
+	if service != "" {
+		r.changed(service)
+	}
+	return nil
+}
+
+// GetInstances returns the endpoints of serviceName with their metadata.
+func (r *Registry) GetInstances(ctx context.Context, serviceName string) ([]*discovery.Instance, error) {
+	endpoints := r.endpoints(serviceName)
+	instances := make([]*discovery.Instance, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		instances = append(instances, &discovery.Instance{ServiceName: serviceName, Addr: endpoint.Addr, Metadata: maps.Clone(endpoint.Metadata)})
+	}
+	return instances, nil
+}
+
+// SetGatewayServiceName sets the service GetUserIdHashGatewayHost picks instances of.
+func (r *Registry) SetGatewayServiceName(serviceName string) {
+	r.lock.Lock()
+	r.gatewayName = serviceName
+	r.lock.Unlock()
+	r.refreshGateway()
+}
+
+func (r *Registry) refreshGateway() {
+	r.lock.RLock()
+	name := r.gatewayName
+	r.lock.RUnlock()
+	instances, _ := r.GetInstances(context.Background(), name)
+	r.gatewayRing.Set(discovery.InstanceWeights(instances))
+}
+
+// GetUserIdHashGatewayHost returns the address of the gateway instance the user is pinned to
+// by consistent hashing.
+func (r *Registry) GetUserIdHashGatewayHost(ctx context.Context, userId string) (string, error) {
+	host, ok := r.gatewayRing.Get(userId)
+	if !ok {
+		return "", discovery.ErrNoGateway.WrapMsg("gateway service has no instance")
+	}
+	return host, nil
+}
+
+// Close stops watching the file and closes the connections of GetConns.
+func (r *Registry) Close() {
+	r.cancel()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, conns := range r.conns {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}
+	r.conns = make(map[string]map[string]*grpc.ClientConn)
+}
@@ -0,0 +1,183 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package static
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/discovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestParseServices(t *testing.T) {
+	yamlServices, err := ParseServices([]byte("rpc-user:\n  - 127.0.0.1:10110\n  - addr: 127.0.0.1:10111\n    metadata:\n      zone: a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonServices, err := ParseServices([]byte(`{"rpc-user": ["127.0.0.1:10110", {"addr": "127.0.0.1:10111", "metadata": {"zone": "a"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, services := range []map[string][]Endpoint{yamlServices, jsonServices} {
+		endpoints := services["rpc-user"]
+		if len(endpoints) != 2 || endpoints[0].Addr != "127.0.0.1:10110" || endpoints[1].Metadata["zone"] != "a" {
+			t.Fatalf("unexpected services %+v", services)
+		}
+	}
+	if _, err := ParseServices([]byte("rpc-user:\n  - metadata: {zone: a}\n")); err == nil {
+		t.Fatal("endpoint without addr accepted")
+	}
+}
+
+// serve starts a gRPC health server and returns its address.
+func serve(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func writeServices(t *testing.T, path string, addrs ...string) {
+	data := "rpc-user:\n"
+	for _, addr := range addrs {
+		data += fmt.Sprintf("  - %s\n", addr)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewFromFile(t *testing.T) {
+	ctx := context.Background()
+	a, b := serve(t), serve(t)
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	writeServices(t, path, a)
+	r, err := NewFromFile(path, WithWatchInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	conn, err := r.GetConn(ctx, "rpc-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	check := func() error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		_, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		return err
+	}
+	if err := check(); err != nil {
+		t.Fatal(err)
+	}
+
+	targets := func() []string {
+		conns, err := r.GetConns(ctx, "rpc-user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := make([]string, len(conns))
+		for i, conn := range conns {
+			res[i] = conn.Target()
+		}
+		return res
+	}
+	if got := targets(); !slices.Equal(got, []string{a}) {
+		t.Fatalf("unexpected conns %v", got)
+	}
+
+	// The file moves the service to b; a broken file in between is ignored.
+	if err := os.WriteFile(path, []byte("rpc-user: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := targets(); !slices.Equal(got, []string{a}) {
+		t.Fatalf("broken file applied: %v", got)
+	}
+	writeServices(t, path, b)
+	eventually(t, func() bool {
+		return slices.Equal(targets(), []string{b})
+	})
+	if err := check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	r := New(map[string][]Endpoint{discovery.DefaultGatewayServiceName: {{Addr: "127.0.0.1:10001"}}})
+	defer r.Close()
+	if host, err := r.GetUserIdHashGatewayHost(ctx, "user"); err != nil || host != "127.0.0.1:10001" {
+		t.Fatalf("unexpected gateway %s: %v", host, err)
+	}
+	if err := r.RegisterWithMetadata(discovery.DefaultGatewayServiceName, "127.0.0.1", 10002, map[string]string{discovery.MetadataWeight: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if r.GetSelfConnTarget() != "127.0.0.1:10002" {
+		t.Fatalf("unexpected self target %s", r.GetSelfConnTarget())
+	}
+	instances, err := r.GetInstances(ctx, discovery.DefaultGatewayServiceName)
+	if err != nil || len(instances) != 2 || instances[1].Weight() != 2 {
+		t.Fatalf("unexpected instances %+v: %v", instances, err)
+	}
+	// Once the registered instance drains, every user goes to the configured one.
+	if err := r.SetMetadata(ctx, map[string]string{discovery.MetadataDraining: "true"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"u1", "u2", "u3", "u4"} {
+		if host, err := r.GetUserIdHashGatewayHost(ctx, user); err != nil || host != "127.0.0.1:10001" {
+			t.Fatalf("draining instance picked: %s %v", host, err)
+		}
+	}
+	conns, err := r.GetConns(ctx, discovery.DefaultGatewayServiceName)
+	if err != nil || len(conns) != 1 || conns[0].Target() != "127.0.0.1:10001" {
+		t.Fatalf("draining instance connected: %v", err)
+	}
+	if md := r.Metadata(); md[discovery.MetadataDraining] != "true" {
+		t.Fatalf("unexpected metadata %v", md)
+	}
+	if err := r.UnRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if instances, _ := r.GetInstances(ctx, discovery.DefaultGatewayServiceName); len(instances) != 1 {
+		t.Fatalf("instance not unregistered: %+v", instances)
+	}
+}
@@ -16,9 +16,11 @@ package zookeeper
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/amazing-socrates/next-tools/discovery"
 	"github.com/amazing-socrates/next-tools/errs"
 	"github.com/go-zookeeper/zk"
 	"google.golang.org/grpc"
@@ -42,15 +44,18 @@ func (s *ZkClient) watch(ctx context.Context) {
 			case zk.EventSession:
 				switch event.State {
 				case zk.StateHasSession:
+					// The lock keeps SetMetadata from writing to the node while it is replaced.
+					s.lock.Lock()
 					if s.isRegistered && !s.isStateDisconnected {
 						s.logger.Debug(ctx, "zk session event stateHasSession, client prepare to create new temp node", "event", event)
-						node, err := s.CreateTempNode(s.rpcRegisterName, s.rpcRegisterAddr)
+						node, err := s.createTempNode(s.rpcRegisterName, s.rpcRegisterAddr, s.rpcRegisterMetadata)
 						if err != nil {
 							s.logger.Error(ctx, "zk session event stateHasSession, create temp node error", err, "event", event)
 						} else {
 							s.node = node
 						}
 					}
+					s.lock.Unlock()
 				case zk.StateDisconnected:
 					s.isStateDisconnected = true
 				case zk.StateConnected:
@@ -66,9 +71,20 @@ func (s *ZkClient) watch(ctx context.Context) {
 					s.lock.Lock()
 					s.flushResolverAndDeleteLocal(serviceName)
 					s.lock.Unlock()
+					s.watchGatewayChanges(ctx, serviceName)
 				}
 				s.logger.Debug(ctx, "zk event handle success", "path", event.Path)
 			case zk.EventNodeDataChanged:
+				// The metadata of an instance changed; its parent is the service node.
+				s.logger.Debug(ctx, "zk event", "event", event)
+				l := strings.Split(event.Path, "/")
+				if len(l) > 2 {
+					serviceName := l[len(l)-2]
+					s.lock.Lock()
+					s.flushResolverAndDeleteLocal(serviceName)
+					s.lock.Unlock()
+					s.watchGatewayChanges(ctx, serviceName)
+				}
 			case zk.EventNodeCreated:
 				s.logger.Debug(ctx, "zk node create event", "event", event)
 			case zk.EventNodeDeleted:
@@ -79,36 +95,107 @@ func (s *ZkClient) watch(ctx context.Context) {
 }
 
 func (s *ZkClient) GetConnsRemote(ctx context.Context, serviceName string) (conns []resolver.Address, err error) {
-	err = s.ensureName(serviceName)
+	instances, err := s.getInstancesRemote(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	// Draining instances are left out unless the whole service drains.
+	for _, instance := range discovery.ActiveInstances(instances) {
+		conns = append(conns, resolver.Address{Addr: instance.Addr, ServerName: serviceName})
+	}
+	return conns, nil
+}
+
+// GetInstances returns the registered instances of serviceName with their metadata.
+func (s *ZkClient) GetInstances(ctx context.Context, serviceName string) ([]*discovery.Instance, error) {
+	return s.getInstancesRemote(ctx, serviceName)
+}
+
+// getInstancesRemote reads the instances of serviceName, watching its children and their
+// data so that both membership and metadata changes flush the resolver.
+func (s *ZkClient) getInstancesRemote(ctx context.Context, serviceName string) ([]*discovery.Instance, error) {
+	err := s.ensureName(serviceName)
 	if err != nil {
 		return nil, err
 	}
 
 	path := s.getPath(serviceName)
-	_, _, _, err = s.conn.ChildrenW(path)
+	childNodes, _, _, err := s.conn.ChildrenW(path)
 	if err != nil {
 		return nil, errs.WrapMsg(err, "children watch error", "path", path)
 	}
-	childNodes, _, err := s.conn.Children(path)
-	if err != nil {
-		return nil, errs.WrapMsg(err, "get children error", "path", path)
-	} else {
-		for _, child := range childNodes {
-			fullPath := path + "/" + child
-			data, _, err := s.conn.Get(fullPath)
-			if err != nil {
-				return nil, errs.WrapMsg(err, "get children error", "fullPath", fullPath)
-			}
-			s.logger.Debug(ctx, "get addr from remote", "conn", string(data))
-			conns = append(conns, resolver.Address{Addr: string(data), ServerName: serviceName})
+	instances := make([]*discovery.Instance, 0, len(childNodes))
+	for _, child := range childNodes {
+		fullPath := path + "/" + child
+		data, _, _, err := s.conn.GetW(fullPath)
+		if errors.Is(err, zk.ErrNoNode) {
+			// Removed since the listing; the children watch reports it.
+			continue
+		}
+		if err != nil {
+			return nil, errs.WrapMsg(err, "get children error", "fullPath", fullPath)
 		}
+		s.logger.Debug(ctx, "get addr from remote", "conn", string(data))
+		instance := decodeNodeData(data)
+		instance.ServiceName = serviceName
+		instances = append(instances, instance)
 	}
-	return conns, nil
+	return instances, nil
 }
 
+// GetUserIdHashGatewayHost returns the address of the gateway instance the user is pinned to
+// by consistent hashing, so that membership changes move as few users as possible.
 func (s *ZkClient) GetUserIdHashGatewayHost(ctx context.Context, userId string) (string, error) {
-	s.logger.Warn(ctx, "not implement", errs.New("zkclinet not implement GetUserIdHashGatewayHost method"))
-	return "", nil
+	s.gatewayLock.Lock()
+	loaded := s.gatewayLoaded
+	s.gatewayLock.Unlock()
+	if !loaded {
+		if err := s.refreshGateway(ctx); err != nil {
+			return "", err
+		}
+	}
+	host, ok := s.gatewayRing.Get(userId)
+	if !ok {
+		return "", discovery.ErrNoGateway.WrapMsg("gateway service has no instance", "serviceName", s.getGatewayName())
+	}
+	return host, nil
+}
+
+func (s *ZkClient) getGatewayName() string {
+	s.gatewayLock.Lock()
+	defer s.gatewayLock.Unlock()
+	return s.gatewayName
+}
+
+// refreshGateway loads the gateway service instances into the hash ring and watches its
+// children again. It holds the lock across the read so that an older listing never
+// replaces a newer one.
+func (s *ZkClient) refreshGateway(ctx context.Context) error {
+	s.gatewayLock.Lock()
+	defer s.gatewayLock.Unlock()
+	instances, err := s.getInstancesRemote(ctx, s.gatewayName)
+	if err != nil {
+		// The next GetUserIdHashGatewayHost reads again rather than trusting a stale ring.
+		s.gatewayLoaded = false
+		return err
+	}
+	s.gatewayRing.Set(discovery.InstanceWeights(instances))
+	s.gatewayLoaded = true
+	return nil
+}
+
+// watchGatewayChanges refreshes the gateway hash ring when serviceName is the gateway
+// service, once GetUserIdHashGatewayHost has loaded it.
+func (s *ZkClient) watchGatewayChanges(ctx context.Context, serviceName string) {
+	s.gatewayLock.Lock()
+	refresh := s.gatewayLoaded && serviceName == s.gatewayName
+	s.gatewayLock.Unlock()
+	if !refresh {
+		return
+	}
+	if err := s.refreshGateway(ctx); err != nil {
+		s.logger.Error(ctx, "zk refresh gateway error", err, "serviceName", serviceName)
+	}
 }
 
 func (s *ZkClient) GetConns(ctx context.Context, serviceName string, opts ...grpc.DialOption) ([]*grpc.ClientConn, error) {
@@ -146,6 +233,8 @@ func (s *ZkClient) GetConn(ctx context.Context, serviceName string, opts ...grpc
 }
 
 func (s *ZkClient) GetSelfConnTarget() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return s.rpcRegisterAddr
 }
 
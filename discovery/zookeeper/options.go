@@ -54,6 +54,14 @@ func WithTimeout(timeout int) ZkOption {
 	}
 }
 
+// WithGatewayServiceName sets the service GetUserIdHashGatewayHost picks instances of,
+// discovery.DefaultGatewayServiceName by default.
+func WithGatewayServiceName(serviceName string) ZkOption {
+	return func(client *ZkClient) {
+		client.gatewayName = serviceName
+	}
+}
+
 func WithLogger(logger log.Logger) ZkOption {
 	return func(client *ZkClient) {
 		client.logger = logger
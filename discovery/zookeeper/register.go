@@ -15,13 +15,20 @@
 package zookeeper
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
 	"time"
 
+	"github.com/amazing-socrates/next-tools/discovery"
 	"github.com/amazing-socrates/next-tools/errs"
 	"github.com/go-zookeeper/zk"
 	"google.golang.org/grpc"
 )
 
+var _ discovery.InstanceRegistry = (*ZkClient)(nil)
+
 func (s *ZkClient) CreateRpcRootNodes(serviceNames []string) error {
 	for _, serviceName := range serviceNames {
 		if err := s.ensureName(serviceName); err != nil && err != zk.ErrNodeExists {
@@ -32,9 +39,17 @@ func (s *ZkClient) CreateRpcRootNodes(serviceNames []string) error {
 }
 
 func (s *ZkClient) CreateTempNode(rpcRegisterName, addr string) (node string, err error) {
+	return s.createTempNode(rpcRegisterName, addr, nil)
+}
+
+func (s *ZkClient) createTempNode(rpcRegisterName, addr string, metadata map[string]string) (node string, err error) {
+	data, err := encodeNodeData(addr, metadata)
+	if err != nil {
+		return "", err
+	}
 	node, err = s.conn.CreateProtectedEphemeralSequential(
 		s.getPath(rpcRegisterName)+"/"+addr+"_",
-		[]byte(addr),
+		data,
 		zk.WorldACL(zk.PermAll),
 	)
 	if err != nil {
@@ -44,6 +59,11 @@ func (s *ZkClient) CreateTempNode(rpcRegisterName, addr string) (node string, er
 }
 
 func (s *ZkClient) Register(rpcRegisterName, host string, port int, opts ...grpc.DialOption) error {
+	return s.RegisterWithMetadata(rpcRegisterName, host, port, nil, opts...)
+}
+
+// RegisterWithMetadata registers the instance with metadata stored in its node data.
+func (s *ZkClient) RegisterWithMetadata(rpcRegisterName, host string, port int, metadata map[string]string, opts ...grpc.DialOption) error {
 	if err := s.ensureName(rpcRegisterName); err != nil {
 		return err
 	}
@@ -52,17 +72,75 @@ func (s *ZkClient) Register(rpcRegisterName, host string, port int, opts ...grpc
 	if err != nil {
 		return errs.WrapMsg(err, "grpc dial error", "addr", addr)
 	}
-	node, err := s.CreateTempNode(rpcRegisterName, addr)
+	metadata = maps.Clone(metadata)
+	node, err := s.createTempNode(rpcRegisterName, addr, metadata)
 	if err != nil {
 		return err
 	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.rpcRegisterName = rpcRegisterName
 	s.rpcRegisterAddr = addr
+	s.rpcRegisterMetadata = metadata
 	s.node = node
 	s.isRegistered = true
 	return nil
 }
 
+// SetMetadata replaces the metadata of the registered instance; it is also used when the
+// node is created again after a session loss.
+func (s *ZkClient) SetMetadata(ctx context.Context, metadata map[string]string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.isRegistered {
+		return errs.New("zk client is not registered")
+	}
+	data, err := encodeNodeData(s.rpcRegisterAddr, metadata)
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.Set(s.node, data, -1); err != nil {
+		return errs.WrapMsg(err, "set node data error", "node", s.node)
+	}
+	s.rpcRegisterMetadata = maps.Clone(metadata)
+	return nil
+}
+
+// Metadata returns the metadata of the registered instance.
+func (s *ZkClient) Metadata() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return maps.Clone(s.rpcRegisterMetadata)
+}
+
+// nodeData is the data of an instance node registered with metadata; nodes without
+// metadata hold the bare address, which is what older clients read.
+type nodeData struct {
+	Addr     string            `json:"addr"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func encodeNodeData(addr string, metadata map[string]string) ([]byte, error) {
+	if len(metadata) == 0 {
+		return []byte(addr), nil
+	}
+	data, err := json.Marshal(nodeData{Addr: addr, Metadata: metadata})
+	if err != nil {
+		return nil, errs.WrapMsg(err, "marshal node data failed", "addr", addr)
+	}
+	return data, nil
+}
+
+func decodeNodeData(data []byte) *discovery.Instance {
+	if bytes.HasPrefix(data, []byte("{")) {
+		var d nodeData
+		if err := json.Unmarshal(data, &d); err == nil && d.Addr != "" {
+			return &discovery.Instance{Addr: d.Addr, Metadata: d.Metadata}
+		}
+	}
+	return &discovery.Instance{Addr: string(data)}
+}
+
 func (s *ZkClient) UnRegister() error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -74,6 +152,7 @@ func (s *ZkClient) UnRegister() error {
 	s.node = ""
 	s.rpcRegisterName = ""
 	s.rpcRegisterAddr = ""
+	s.rpcRegisterMetadata = nil
 	s.isRegistered = false
 	s.localConns = make(map[string][]*grpc.ClientConn)
 	s.resolvers = make(map[string]*Resolver)
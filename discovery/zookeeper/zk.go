@@ -21,6 +21,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/amazing-socrates/next-tools/discovery"
 	"github.com/amazing-socrates/next-tools/errs"
 	"github.com/amazing-socrates/next-tools/log"
 	"github.com/go-zookeeper/zk"
@@ -43,6 +44,8 @@ type ZkClient struct {
 	rpcRegisterAddr string
 	isRegistered    bool
 	scheme          string
+	// rpcRegisterMetadata is written with the address to the node of the registered instance.
+	rpcRegisterMetadata map[string]string
 
 	timeout   int
 	conn      *zk.Conn
@@ -59,6 +62,11 @@ type ZkClient struct {
 	isStateDisconnected bool
 	balancerName        string
 
+	gatewayLock   sync.Mutex
+	gatewayName   string
+	gatewayRing   *discovery.HashRing
+	gatewayLoaded bool
+
 	logger log.Logger
 }
 
@@ -73,6 +81,9 @@ func NewZkClient(ZkServers []string, scheme string, options ...ZkOption) (*ZkCli
 		resolvers:  make(map[string]*Resolver),
 		lock:       &sync.Mutex{},
 		logger:     nilLog{},
+
+		gatewayName: discovery.DefaultGatewayServiceName,
+		gatewayRing: discovery.NewHashRing(),
 	}
 	for _, option := range options {
 		option(client)
@@ -146,6 +157,7 @@ func (s *ZkClient) refresh(ctx context.Context) {
 			delete(s.localConns, rpcName)
 		}
 		s.lock.Unlock()
+		s.watchGatewayChanges(ctx, s.getGatewayName())
 		s.logger.Debug(ctx, "zk refresh local conns success")
 	}
 }
@@ -172,6 +184,8 @@ func (s *ZkClient) GetRootPath() string {
 }
 
 func (s *ZkClient) GetNode() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return s.node
 }
 
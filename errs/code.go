@@ -29,4 +29,9 @@ const (
 	TokenUnknownError     = 1505
 	TokenKickedError      = 1506
 	TokenNotExistError    = 1507
+
+	// Object storage error codes.
+	UploadIDInvalidError     = 1601 // Upload ID is malformed or its signature does not verify
+	UploadIDExpiredError     = 1602 // Upload ID is past its expiry
+	UploadQuotaExceededError = 1603 // Upload would exceed a size, storage or rate limit
 )
@@ -27,4 +27,8 @@ var (
 	ErrTokenUnknown     = NewCodeError(TokenUnknownError, "TokenUnknownError")
 	ErrTokenKicked      = NewCodeError(TokenKickedError, "TokenKickedError")
 	ErrTokenNotExist    = NewCodeError(TokenNotExistError, "TokenNotExistError")
+
+	ErrUploadIDInvalid     = NewCodeError(UploadIDInvalidError, "UploadIDInvalidError")
+	ErrUploadIDExpired     = NewCodeError(UploadIDExpiredError, "UploadIDExpiredError")
+	ErrUploadQuotaExceeded = NewCodeError(UploadQuotaExceededError, "UploadQuotaExceededError")
 )
@@ -0,0 +1,94 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mw
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// Inflight counts the RPCs a server is handling, so that a draining instance can wait
+// for them before it deregisters. Install its interceptors on the server and pass it to
+// discovery.Drain with discovery.WithWaiters.
+type Inflight struct {
+	lock  sync.Mutex
+	count int
+	idle  chan struct{}
+}
+
+// NewInflight returns an Inflight with no RPC in flight.
+func NewInflight() *Inflight {
+	idle := make(chan struct{})
+	close(idle)
+	return &Inflight{idle: idle}
+}
+
+func (i *Inflight) start() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.count == 0 {
+		i.idle = make(chan struct{})
+	}
+	i.count++
+}
+
+func (i *Inflight) done() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.count--
+	if i.count == 0 {
+		close(i.idle)
+	}
+}
+
+// Count returns the number of RPCs in flight.
+func (i *Inflight) Count() int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.count
+}
+
+// Wait returns once no RPC is in flight, or with ctx.Err() when ctx is done first.
+func (i *Inflight) Wait(ctx context.Context) error {
+	i.lock.Lock()
+	idle := i.idle
+	i.lock.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UnaryServerInterceptor counts the unary RPCs.
+func (i *Inflight) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		i.start()
+		defer i.done()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor counts the streams until their handler returns.
+func (i *Inflight) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		i.start()
+		defer i.done()
+		return handler(srv, ss)
+	}
+}
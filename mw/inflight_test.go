@@ -0,0 +1,59 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestInflight(t *testing.T) {
+	ctx := context.Background()
+	inflight := NewInflight()
+	if err := inflight.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	interceptor := inflight.UnaryServerInterceptor()
+	go interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	if inflight.Count() != 1 {
+		t.Fatalf("unexpected count %d", inflight.Count())
+	}
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := inflight.Wait(timeout); err == nil {
+		t.Fatal("wait returned with an RPC in flight")
+	}
+
+	close(release)
+	timeout, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := inflight.Wait(timeout); err != nil {
+		t.Fatal(err)
+	}
+	if inflight.Count() != 0 {
+		t.Fatalf("unexpected count %d", inflight.Count())
+	}
+}
@@ -32,9 +32,14 @@ type Config struct {
 	SecretAccessKey string
 	SessionToken    string
 	UrlPrefix       string
+	// Encryption enables server-side encryption of uploaded objects.
+	Encryption *s3.Encryption
 }
 
 func NewAws(conf Config) (*Aws, error) {
+	if err := conf.Encryption.Check(); err != nil {
+		return nil, err
+	}
 	cfg := aws.Config{
 		Region:      conf.Region,
 		Credentials: credentials.NewStaticCredentialsProvider(conf.AccessKeyID, conf.SecretAccessKey, conf.SessionToken),
@@ -45,6 +50,7 @@ func NewAws(conf Config) (*Aws, error) {
 		bucket:    conf.Bucket,
 		client:    client,
 		presign:   aws3.NewPresignClient(client),
+		sse:       conf.Encryption,
 	}, nil
 }
 
@@ -53,6 +59,7 @@ type Aws struct {
 	bucket    string
 	client    *aws3.Client
 	presign   *aws3.PresignClient
+	sse       *s3.Encryption
 }
 
 func (a *Aws) Engine() string {
@@ -100,24 +107,40 @@ func (a *Aws) IsNotFound(err error) bool {
 }
 
 func (a *Aws) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
-	res, err := a.presign.PresignPutObject(ctx, &aws3.PutObjectInput{Bucket: aws.String(a.bucket), Key: aws.String(name)}, aws3.WithPresignExpires(expire), withDisableHTTPPresignerHeaderV4(nil))
+	res, err := a.PresignedPutObjectHeader(ctx, name, expire)
 	if err != nil {
 		return "", err
 	}
 	return res.URL, nil
 }
 
+// PresignedPutObjectHeader also returns the signed headers, which include those of the encryption.
+func (a *Aws) PresignedPutObjectHeader(ctx context.Context, name string, expire time.Duration) (*s3.PresignedPutObjectResult, error) {
+	params := &aws3.PutObjectInput{Bucket: aws.String(a.bucket), Key: aws.String(name)}
+	params.ServerSideEncryption, params.SSEKMSKeyId = a.serverSide()
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = a.customerKey()
+	res, err := a.presign.PresignPutObject(ctx, params, aws3.WithPresignExpires(expire), withDisableHTTPPresignerHeaderV4(nil))
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PresignedPutObjectResult{URL: res.URL, Header: res.SignedHeader}, nil
+}
+
 func (a *Aws) DeleteObject(ctx context.Context, name string) error {
 	_, err := a.client.DeleteObject(ctx, &aws3.DeleteObjectInput{Bucket: aws.String(a.bucket), Key: aws.String(name)})
 	return err
 }
 
 func (a *Aws) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyObjectInfo, error) {
-	res, err := a.client.CopyObject(ctx, &aws3.CopyObjectInput{
+	params := &aws3.CopyObjectInput{
 		Bucket:     aws.String(a.bucket),
 		CopySource: aws.String(a.bucket + "/" + src),
 		Key:        aws.String(dst),
-	})
+	}
+	params.ServerSideEncryption, params.SSEKMSKeyId = a.serverSide()
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = a.customerKey()
+	params.CopySourceSSECustomerAlgorithm, params.CopySourceSSECustomerKey, params.CopySourceSSECustomerKeyMD5 = a.customerKey()
+	res, err := a.client.CopyObject(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -131,7 +154,9 @@ func (a *Aws) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyO
 }
 
 func (a *Aws) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, error) {
-	res, err := a.client.HeadObject(ctx, &aws3.HeadObjectInput{Bucket: aws.String(a.bucket), Key: aws.String(name)})
+	params := &aws3.HeadObjectInput{Bucket: aws.String(a.bucket), Key: aws.String(name)}
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = a.customerKey()
+	res, err := a.client.HeadObject(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -142,9 +167,11 @@ func (a *Aws) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, erro
 		return nil, errors.New("GetObjectAttributes object size is nil")
 	}
 	info := &s3.ObjectInfo{
-		ETag: a.formatETag(*res.ETag),
-		Key:  name,
-		Size: *res.ContentLength,
+		ETag:        a.formatETag(*res.ETag),
+		Key:         name,
+		Size:        *res.ContentLength,
+		ContentType: aws.ToString(res.ContentType),
+		Metadata:    s3.LowerMetadata(res.Metadata),
 	}
 	if res.LastModified == nil {
 		info.LastModified = time.Unix(0, 0)
@@ -155,7 +182,10 @@ func (a *Aws) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, erro
 }
 
 func (a *Aws) InitiateMultipartUpload(ctx context.Context, name string) (*s3.InitiateMultipartUploadResult, error) {
-	res, err := a.client.CreateMultipartUpload(ctx, &aws3.CreateMultipartUploadInput{Bucket: aws.String(a.bucket), Key: aws.String(name)})
+	params := &aws3.CreateMultipartUploadInput{Bucket: aws.String(a.bucket), Key: aws.String(name)}
+	params.ServerSideEncryption, params.SSEKMSKeyId = a.serverSide()
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = a.customerKey()
+	res, err := a.client.CreateMultipartUpload(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -249,6 +279,38 @@ func (a *Aws) ListUploadedParts(ctx context.Context, uploadID string, name strin
 	return info, nil
 }
 
+func (a *Aws) ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*s3.ListMultipartUploadsResult, error) {
+	params := &aws3.ListMultipartUploadsInput{
+		Bucket:     aws.String(a.bucket),
+		Prefix:     aws.String(prefix),
+		MaxUploads: aws.Int32(int32(s3.ListLimit(limit))),
+	}
+	if keyMarker != "" {
+		params.KeyMarker = aws.String(keyMarker)
+	}
+	if uploadIDMarker != "" {
+		params.UploadIdMarker = aws.String(uploadIDMarker)
+	}
+	result, err := a.client.ListMultipartUploads(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	res := &s3.ListMultipartUploadsResult{
+		NextKeyMarker:      aws.ToString(result.NextKeyMarker),
+		NextUploadIDMarker: aws.ToString(result.NextUploadIdMarker),
+		IsTruncated:        aws.ToBool(result.IsTruncated),
+		Uploads:            make([]s3.MultipartUpload, len(result.Uploads)),
+	}
+	for i, upload := range result.Uploads {
+		res.Uploads[i] = s3.MultipartUpload{
+			Key:       aws.ToString(upload.Key),
+			UploadID:  aws.ToString(upload.UploadId),
+			Initiated: aws.ToTime(upload.Initiated),
+		}
+	}
+	return res, nil
+}
+
 func (a *Aws) AuthSign(ctx context.Context, uploadID string, name string, expire time.Duration, partNumbers []int) (*s3.AuthSignResult, error) {
 	res := &s3.AuthSignResult{
 		Parts: make([]s3.SignPart, 0, len(partNumbers)),
@@ -258,6 +320,7 @@ func (a *Aws) AuthSign(ctx context.Context, uploadID string, name string, expire
 		Key:      aws.String(name),
 		UploadId: aws.String(uploadID),
 	}
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = a.customerKey()
 	opt := aws3.WithPresignExpires(expire)
 	for _, number := range partNumbers {
 		params.PartNumber = aws.Int32(int32(number))
@@ -340,6 +403,32 @@ func (a *Aws) FormData(ctx context.Context, name string, size int64, contentType
 	return nil, errors.New("aws does not currently support form data file uploads")
 }
 
+// serverSide returns the encryption fields of requests creating an object.
+func (a *Aws) serverSide() (types.ServerSideEncryption, *string) {
+	if a.sse == nil {
+		return "", nil
+	}
+	switch a.sse.Type {
+	case s3.EncryptionSSES3:
+		return types.ServerSideEncryptionAes256, nil
+	case s3.EncryptionSSEKMS:
+		if a.sse.KMSKeyID == "" {
+			return types.ServerSideEncryptionAwsKms, nil
+		}
+		return types.ServerSideEncryptionAwsKms, aws.String(a.sse.KMSKeyID)
+	default:
+		return "", nil
+	}
+}
+
+// customerKey returns the SSE-C fields every request touching the object data needs, nil otherwise.
+func (a *Aws) customerKey() (algorithm *string, key *string, keyMD5 *string) {
+	if !a.sse.IsCustomerKey() {
+		return nil, nil, nil
+	}
+	return aws.String("AES256"), aws.String(a.sse.CustomerKeyBase64()), aws.String(a.sse.CustomerKeyMD5())
+}
+
 func withDisableHTTPPresignerHeaderV4(opt *s3.AccessURLOption) func(options *aws3.PresignOptions) {
 	return func(options *aws3.PresignOptions) {
 		options.Presigner = &disableHTTPPresignerHeaderV4{
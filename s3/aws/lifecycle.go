@@ -0,0 +1,143 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	aws3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var _ s3.Lifecycle = (*Aws)(nil)
+
+func (a *Aws) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	res, err := a.client.GetBucketLifecycleConfiguration(ctx, &aws3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(a.bucket)})
+	if err != nil {
+		// NoSuchLifecycleConfiguration
+		if a.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	rules := make([]s3.LifecycleRule, 0, len(res.Rules))
+	for _, r := range res.Rules {
+		rule := s3.LifecycleRule{ID: aws.ToString(r.ID), Prefix: aws.ToString(r.Prefix), Disabled: r.Status != types.ExpirationStatusEnabled}
+		if prefix, ok := r.Filter.(*types.LifecycleRuleFilterMemberPrefix); ok {
+			rule.Prefix = prefix.Value
+		}
+		if r.Expiration != nil {
+			rule.ExpireDays = int(aws.ToInt32(r.Expiration.Days))
+		}
+		for _, t := range r.Transitions {
+			rule.Transitions = append(rule.Transitions, s3.LifecycleTransition{
+				Days:         int(aws.ToInt32(t.Days)),
+				StorageClass: s3.AmzStorageClasses.Class(string(t.StorageClass)),
+			})
+		}
+		if !mappable(r) {
+			rule.Vendor = r
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// mappable reports whether s3.LifecycleRule expresses everything r does.
+func mappable(r types.LifecycleRule) bool {
+	if r.Filter != nil {
+		if _, ok := r.Filter.(*types.LifecycleRuleFilterMemberPrefix); !ok {
+			return false
+		}
+	}
+	if r.Expiration != nil && (r.Expiration.Date != nil || aws.ToBool(r.Expiration.ExpiredObjectDeleteMarker)) {
+		return false
+	}
+	for _, t := range r.Transitions {
+		if t.Date != nil {
+			return false
+		}
+	}
+	return r.AbortIncompleteMultipartUpload == nil && r.NoncurrentVersionExpiration == nil && len(r.NoncurrentVersionTransitions) == 0 &&
+		(r.Status == types.ExpirationStatusEnabled || r.Status == types.ExpirationStatusDisabled)
+}
+
+func (a *Aws) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	if len(rules) == 0 {
+		_, err := a.client.DeleteBucketLifecycle(ctx, &aws3.DeleteBucketLifecycleInput{Bucket: aws.String(a.bucket)})
+		return err
+	}
+	config := &types.BucketLifecycleConfiguration{Rules: make([]types.LifecycleRule, 0, len(rules))}
+	for _, rule := range rules {
+		if rule.Vendor != nil {
+			r, ok := rule.Vendor.(types.LifecycleRule)
+			if !ok {
+				return errs.ErrArgs.WrapMsg("not an aws lifecycle rule", "id", rule.ID)
+			}
+			config.Rules = append(config.Rules, r)
+			continue
+		}
+		r := types.LifecycleRule{
+			ID:     aws.String(rule.ID),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilterMemberPrefix{Value: rule.Prefix},
+		}
+		if rule.Disabled {
+			r.Status = types.ExpirationStatusDisabled
+		}
+		if rule.ExpireDays > 0 {
+			r.Expiration = &types.LifecycleExpiration{Days: aws.Int32(int32(rule.ExpireDays))}
+		}
+		for _, t := range rule.Transitions {
+			r.Transitions = append(r.Transitions, types.Transition{
+				Days:         aws.Int32(int32(t.Days)),
+				StorageClass: types.TransitionStorageClass(s3.AmzStorageClasses.Vendor(t.StorageClass)),
+			})
+		}
+		config.Rules = append(config.Rules, r)
+	}
+	_, err := a.client.PutBucketLifecycleConfiguration(ctx, &aws3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(a.bucket),
+		LifecycleConfiguration: config,
+	})
+	return err
+}
+
+func (a *Aws) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	status := types.ObjectLockLegalHoldStatusOff
+	if hold {
+		status = types.ObjectLockLegalHoldStatusOn
+	}
+	_, err := a.client.PutObjectLegalHold(ctx, &aws3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(a.bucket),
+		Key:       aws.String(name),
+		LegalHold: &types.ObjectLockLegalHold{Status: status},
+	})
+	return err
+}
+
+func (a *Aws) LegalHold(ctx context.Context, name string) (bool, error) {
+	res, err := a.client.GetObjectLegalHold(ctx, &aws3.GetObjectLegalHoldInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.LegalHold != nil && res.LegalHold.Status == types.ObjectLockLegalHoldStatusOn, nil
+}
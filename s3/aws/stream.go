@@ -0,0 +1,169 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	aws3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func (a *Aws) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	return s3.PutStream(ctx, a, objectWriter{a}, name, reader, size, opt)
+}
+
+func (a *Aws) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	params := &aws3.GetObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(name),
+	}
+	if rangeHeader := opt.RangeHeader(); rangeHeader != "" {
+		params.Range = aws.String(rangeHeader)
+	}
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = a.customerKey()
+	res, err := a.client.GetObject(ctx, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &s3.ObjectInfo{
+		ETag:         a.formatETag(aws.ToString(res.ETag)),
+		Key:          name,
+		Size:         s3.ObjectSize(aws.ToInt64(res.ContentLength), aws.ToString(res.ContentRange)),
+		LastModified: aws.ToTime(res.LastModified),
+		ContentType:  aws.ToString(res.ContentType),
+		Metadata:     s3.LowerMetadata(res.Metadata),
+	}
+	return res.Body, info, nil
+}
+
+func (a *Aws) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	params := &aws3.ListObjectsV2Input{
+		Bucket:  aws.String(a.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(s3.ListLimit(limit))),
+	}
+	if marker != "" {
+		params.StartAfter = aws.String(marker)
+	}
+	res, err := a.client.ListObjectsV2(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*s3.ObjectInfo, 0, len(res.Contents))
+	for _, object := range res.Contents {
+		objects = append(objects, &s3.ObjectInfo{
+			ETag:         a.formatETag(aws.ToString(object.ETag)),
+			Key:          aws.ToString(object.Key),
+			Size:         aws.ToInt64(object.Size),
+			LastModified: aws.ToTime(object.LastModified),
+		})
+	}
+	return s3.ListResult(objects, aws.ToBool(res.IsTruncated)), nil
+}
+
+type objectWriter struct {
+	a *Aws
+}
+
+func (w objectWriter) PutObject(ctx context.Context, name string, data []byte, opt *s3.PutObjectOption) (string, error) {
+	params := &aws3.PutObjectInput{
+		Bucket:        aws.String(w.a.bucket),
+		Key:           aws.String(name),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	}
+	params.ServerSideEncryption, params.SSEKMSKeyId = w.a.serverSide()
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = w.a.customerKey()
+	if opt != nil {
+		if opt.ContentType != "" {
+			params.ContentType = aws.String(opt.ContentType)
+		}
+		params.Metadata = opt.Metadata
+	}
+	res, err := w.a.client.PutObject(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	if res.ETag == nil || *res.ETag == "" {
+		return "", errors.New("PutObject etag is nil")
+	}
+	return w.a.formatETag(*res.ETag), nil
+}
+
+func (w objectWriter) InitiateMultipart(ctx context.Context, name string, opt *s3.PutObjectOption) (s3.MultipartWriter, error) {
+	params := &aws3.CreateMultipartUploadInput{
+		Bucket: aws.String(w.a.bucket),
+		Key:    aws.String(name),
+	}
+	params.ServerSideEncryption, params.SSEKMSKeyId = w.a.serverSide()
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = w.a.customerKey()
+	if opt != nil {
+		if opt.ContentType != "" {
+			params.ContentType = aws.String(opt.ContentType)
+		}
+		params.Metadata = opt.Metadata
+	}
+	res, err := w.a.client.CreateMultipartUpload(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if res.UploadId == nil || *res.UploadId == "" {
+		return nil, errors.New("CreateMultipartUpload upload id is nil")
+	}
+	return &multipartWriter{a: w.a, name: name, uploadID: *res.UploadId}, nil
+}
+
+type multipartWriter struct {
+	a        *Aws
+	name     string
+	uploadID string
+}
+
+func (w *multipartWriter) UploadPart(ctx context.Context, partNumber int, data []byte) (string, error) {
+	params := &aws3.UploadPartInput{
+		Bucket:        aws.String(w.a.bucket),
+		Key:           aws.String(w.name),
+		UploadId:      aws.String(w.uploadID),
+		PartNumber:    aws.Int32(int32(partNumber)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	}
+	params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = w.a.customerKey()
+	res, err := w.a.client.UploadPart(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	if res.ETag == nil || *res.ETag == "" {
+		return "", errors.New("UploadPart etag is nil")
+	}
+	return *res.ETag, nil
+}
+
+func (w *multipartWriter) Complete(ctx context.Context, parts []s3.Part) (string, error) {
+	res, err := w.a.CompleteMultipartUpload(ctx, w.uploadID, w.name, parts)
+	if err != nil {
+		return "", err
+	}
+	return res.ETag, nil
+}
+
+func (w *multipartWriter) Abort(ctx context.Context) error {
+	return w.a.AbortMultipartUpload(ctx, w.uploadID, w.name)
+}
@@ -0,0 +1,757 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package composite spreads one s3.Interface over several backends, such as a primary
+// MinIO and a cloud bucket in another region, so that a backend going down does not
+// fail uploads and downloads.
+//
+// Backends are checked periodically with their Check function and passed over while
+// unhealthy. Objects may end up on different backends, so reads look for them in turn
+// and every URL handed to clients, presigned or not, is signed by the backend that
+// holds the object or will receive the upload. Multipart upload IDs carry the name of
+// their backend.
+package composite
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+const engineName = "composite"
+
+// Strategy selects how a Composite spreads operations over its backends.
+type Strategy string
+
+const (
+	// StrategyFailover writes to the first healthy backend in configuration order and
+	// reads from the backends in the same order.
+	StrategyFailover Strategy = "failover"
+	// StrategyWriteAll writes PutObject and CopyObject to every healthy backend, succeeding
+	// when one of them does. Client uploads through presigned URLs, forms and multipart
+	// uploads reach a single backend, like with StrategyFailover.
+	StrategyWriteAll Strategy = "write-all"
+	// StrategyNearest writes like StrategyFailover and reads from the healthy backend
+	// with the lowest check latency first.
+	StrategyNearest Strategy = "nearest"
+)
+
+// Defaults of the options of New.
+const (
+	DefaultCheckInterval = time.Second * 10
+	DefaultCheckTimeout  = time.Second * 5
+)
+
+// uploadIDSeparator joins the backend name to the upload ID of the backend.
+const uploadIDSeparator = ":"
+
+var (
+	_ s3.Interface                  = (*Composite)(nil)
+	_ s3.PresignedPutObjectHeaderer = (*Composite)(nil)
+	_ s3.Lifecycle                  = (*Composite)(nil)
+)
+
+// Backend is one engine of a Composite.
+type Backend struct {
+	// Name identifies the backend in upload IDs and logs. It must be unique and must not contain ':'.
+	Name string
+	Impl s3.Interface
+	// Check reports whether the backend is usable, such as minio.Check with the backend
+	// config. When nil, the backend is healthy as long as StatObject answers.
+	Check func(ctx context.Context) error
+}
+
+type Option func(*Composite)
+
+// WithStrategy sets the strategy, StrategyFailover by default.
+func WithStrategy(strategy Strategy) Option {
+	return func(c *Composite) {
+		c.strategy = strategy
+	}
+}
+
+// WithCheckInterval sets how often backends are checked; zero or less disables the
+// periodic checks, leaving CheckHealth to the caller.
+func WithCheckInterval(interval time.Duration) Option {
+	return func(c *Composite) {
+		c.checkInterval = interval
+	}
+}
+
+// WithCheckTimeout bounds each check of a backend.
+func WithCheckTimeout(timeout time.Duration) Option {
+	return func(c *Composite) {
+		c.checkTimeout = timeout
+	}
+}
+
+// Composite is an s3.Interface backed by several engines.
+type Composite struct {
+	strategy      Strategy
+	checkInterval time.Duration
+	checkTimeout  time.Duration
+	backends      []*backend
+	index         map[string]int
+}
+
+// New checks the backends once and, unless disabled, keeps checking them until ctx is done.
+func New(ctx context.Context, backends []Backend, opts ...Option) (*Composite, error) {
+	c := &Composite{
+		strategy:      StrategyFailover,
+		checkInterval: DefaultCheckInterval,
+		checkTimeout:  DefaultCheckTimeout,
+		index:         make(map[string]int, len(backends)),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	switch c.strategy {
+	case StrategyFailover, StrategyWriteAll, StrategyNearest:
+	default:
+		return nil, errs.ErrArgs.WrapMsg("unknown composite strategy", "strategy", c.strategy)
+	}
+	if len(backends) == 0 {
+		return nil, errs.ErrArgs.WrapMsg("composite needs at least one backend")
+	}
+	for i, b := range backends {
+		if b.Name == "" || strings.Contains(b.Name, uploadIDSeparator) || b.Impl == nil {
+			return nil, errs.ErrArgs.WrapMsg("invalid composite backend", "index", i, "name", b.Name)
+		}
+		if _, ok := c.index[b.Name]; ok {
+			return nil, errs.ErrArgs.WrapMsg("duplicate composite backend", "name", b.Name)
+		}
+		c.index[b.Name] = i
+		c.backends = append(c.backends, &backend{Backend: b, status: BackendStatus{Name: b.Name, Healthy: true}})
+	}
+	c.CheckHealth(ctx)
+	if c.checkInterval > 0 {
+		go c.checkLoop(ctx)
+	}
+	return c, nil
+}
+
+// ordered returns the healthy backends followed by the unhealthy ones, which are only
+// tried as a last resort. Healthy backends are sorted by latency when nearest is set.
+func (c *Composite) ordered(nearest bool) []*backend {
+	healthy := make([]*backend, 0, len(c.backends))
+	var unhealthy []*backend
+	for _, b := range c.backends {
+		if b.healthy() {
+			healthy = append(healthy, b)
+		} else {
+			unhealthy = append(unhealthy, b)
+		}
+	}
+	if nearest {
+		slices.SortStableFunc(healthy, func(a, b *backend) int {
+			return cmp.Compare(a.latency(), b.latency())
+		})
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (c *Composite) readOrder() []*backend {
+	return c.ordered(c.strategy == StrategyNearest)
+}
+
+// writer returns the backend that receives single-backend writes.
+func (c *Composite) writer() *backend {
+	return c.ordered(false)[0]
+}
+
+// available returns the healthy backends in configuration order, or all of them when
+// none is healthy.
+func (c *Composite) available() []*backend {
+	healthy := make([]*backend, 0, len(c.backends))
+	for _, b := range c.backends {
+		if b.healthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		return c.backends
+	}
+	return healthy
+}
+
+// read calls fn on the backends in read order until one succeeds. When none does, the
+// first error other than not found is returned, since the object may be on that backend.
+func (c *Composite) read(ctx context.Context, fn func(b *backend) error) error {
+	var notFound, failed error
+	for _, b := range c.readOrder() {
+		err := fn(b)
+		b.observe(ctx, err)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if b.Impl.IsNotFound(err) {
+			if notFound == nil {
+				notFound = err
+			}
+		} else if failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	return notFound
+}
+
+// locate returns the backend holding name.
+func (c *Composite) locate(ctx context.Context, name string) (*backend, *s3.ObjectInfo, error) {
+	var (
+		holder *backend
+		info   *s3.ObjectInfo
+	)
+	err := c.read(ctx, func(b *backend) error {
+		var err error
+		info, err = b.Impl.StatObject(ctx, name)
+		holder = b
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return holder, info, nil
+}
+
+// each calls fn on every backend of bs concurrently and returns the errors in the same order.
+func each(bs []*backend, fn func(i int, b *backend) error) []error {
+	errList := make([]error, len(bs))
+	var wg sync.WaitGroup
+	for i, b := range bs {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			errList[i] = fn(i, b)
+		}(i, b)
+	}
+	wg.Wait()
+	return errList
+}
+
+func (b *backend) uploadID(id string) string {
+	return b.Name + uploadIDSeparator + id
+}
+
+// upload returns the backend index and backend upload ID of a composite upload ID.
+func (c *Composite) upload(uploadID string) (int, string, error) {
+	name, id, ok := strings.Cut(uploadID, uploadIDSeparator)
+	if ok {
+		if i, ok := c.index[name]; ok {
+			return i, id, nil
+		}
+	}
+	return 0, "", errs.ErrArgs.WrapMsg("invalid composite upload id", "uploadID", uploadID)
+}
+
+func (c *Composite) Engine() string {
+	return engineName
+}
+
+func (c *Composite) PartLimit() *s3.PartLimit {
+	return c.writer().Impl.PartLimit()
+}
+
+func (c *Composite) PartSize(ctx context.Context, size int64) (int64, error) {
+	return c.writer().Impl.PartSize(ctx, size)
+}
+
+func (c *Composite) InitiateMultipartUpload(ctx context.Context, name string) (*s3.InitiateMultipartUploadResult, error) {
+	b := c.writer()
+	res, err := b.Impl.InitiateMultipartUpload(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	res.UploadID = b.uploadID(res.UploadID)
+	return res, nil
+}
+
+func (c *Composite) CompleteMultipartUpload(ctx context.Context, uploadID string, name string, parts []s3.Part) (*s3.CompleteMultipartUploadResult, error) {
+	i, id, err := c.upload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return c.backends[i].Impl.CompleteMultipartUpload(ctx, id, name, parts)
+}
+
+func (c *Composite) AuthSign(ctx context.Context, uploadID string, name string, expire time.Duration, partNumbers []int) (*s3.AuthSignResult, error) {
+	i, id, err := c.upload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return c.backends[i].Impl.AuthSign(ctx, id, name, expire, partNumbers)
+}
+
+func (c *Composite) AbortMultipartUpload(ctx context.Context, uploadID string, name string) error {
+	i, id, err := c.upload(uploadID)
+	if err != nil {
+		return err
+	}
+	return c.backends[i].Impl.AbortMultipartUpload(ctx, id, name)
+}
+
+func (c *Composite) ListUploadedParts(ctx context.Context, uploadID string, name string, partNumberMarker int, maxParts int) (*s3.ListUploadedPartsResult, error) {
+	i, id, err := c.upload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.backends[i].Impl.ListUploadedParts(ctx, id, name, partNumberMarker, maxParts)
+	if err != nil {
+		return nil, err
+	}
+	res.UploadID = uploadID
+	return res, nil
+}
+
+// ListMultipartUploads lists the uploads of one backend after the other, in configuration
+// order and skipping unhealthy backends, so uploads are only ordered within a backend.
+// Moving to the next backend is marked by an empty key marker and an upload ID marker
+// naming that backend.
+func (c *Composite) ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*s3.ListMultipartUploadsResult, error) {
+	limit = s3.ListLimit(limit)
+	var start int
+	if uploadIDMarker != "" {
+		var err error
+		if start, uploadIDMarker, err = c.upload(uploadIDMarker); err != nil {
+			return nil, err
+		}
+	}
+	available := c.available()
+	res := &s3.ListMultipartUploadsResult{Uploads: make([]s3.MultipartUpload, 0)}
+	for i := start; i < len(c.backends); i++ {
+		b := c.backends[i]
+		if !slices.Contains(available, b) {
+			keyMarker, uploadIDMarker = "", ""
+			continue
+		}
+		page, err := b.Impl.ListMultipartUploads(ctx, prefix, keyMarker, uploadIDMarker, limit-len(res.Uploads))
+		if err != nil {
+			return nil, err
+		}
+		keyMarker, uploadIDMarker = "", ""
+		for _, upload := range page.Uploads {
+			upload.UploadID = b.uploadID(upload.UploadID)
+			res.Uploads = append(res.Uploads, upload)
+		}
+		if page.IsTruncated {
+			res.IsTruncated = true
+			res.NextKeyMarker = page.NextKeyMarker
+			res.NextUploadIDMarker = b.uploadID(page.NextUploadIDMarker)
+			return res, nil
+		}
+		if len(res.Uploads) >= limit && i+1 < len(c.backends) {
+			res.IsTruncated = true
+			res.NextUploadIDMarker = c.backends[i+1].uploadID("")
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+// PresignedPutObject signs the upload on the backend that receives writes, where reads find it.
+func (c *Composite) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
+	return c.writer().Impl.PresignedPutObject(ctx, name, expire)
+}
+
+func (c *Composite) PresignedPutObjectHeader(ctx context.Context, name string, expire time.Duration) (*s3.PresignedPutObjectResult, error) {
+	return s3.PresignPut(ctx, c.writer().Impl, name, expire)
+}
+
+func (c *Composite) FormData(ctx context.Context, name string, size int64, contentType string, duration time.Duration) (*s3.FormData, error) {
+	return c.writer().Impl.FormData(ctx, name, size, contentType, duration)
+}
+
+// DeleteObject deletes name from every backend, since it may have been written to several.
+// Failures of unhealthy backends are only logged.
+func (c *Composite) DeleteObject(ctx context.Context, name string) error {
+	errList := each(c.backends, func(i int, b *backend) error {
+		return b.Impl.DeleteObject(ctx, name)
+	})
+	for i, err := range errList {
+		b := c.backends[i]
+		if err == nil || b.Impl.IsNotFound(err) {
+			continue
+		}
+		if !b.healthy() {
+			log.ZWarn(ctx, "s3 composite delete object on unhealthy backend failed", err, "backend", b.Name, "key", name)
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+// CopyObject copies on the backend holding src, or with StrategyWriteAll on every
+// healthy backend holding it.
+func (c *Composite) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyObjectInfo, error) {
+	if c.strategy != StrategyWriteAll {
+		var info *s3.CopyObjectInfo
+		err := c.read(ctx, func(b *backend) error {
+			var err error
+			info, err = b.Impl.CopyObject(ctx, src, dst)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+	targets := c.available()
+	infos := make([]*s3.CopyObjectInfo, len(targets))
+	errList := each(targets, func(i int, b *backend) error {
+		var err error
+		infos[i], err = b.Impl.CopyObject(ctx, src, dst)
+		b.observe(ctx, err)
+		return err
+	})
+	i, err := succeeded(ctx, "copy object", dst, targets, errList)
+	if err != nil {
+		return nil, err
+	}
+	return infos[i], nil
+}
+
+func (c *Composite) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, error) {
+	_, info, err := c.locate(ctx, name)
+	return info, err
+}
+
+// PutObject writes to the first healthy backend, or with StrategyWriteAll to all of them
+// at the pace of the slowest. A failed write only moves on to the next backend when
+// reader is an io.Seeker.
+func (c *Composite) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	if c.strategy == StrategyWriteAll {
+		if targets := c.available(); len(targets) > 1 {
+			return putAll(ctx, targets, name, reader, size, opt)
+		}
+	}
+	seeker, _ := reader.(io.Seeker)
+	var offset int64
+	if seeker != nil {
+		var err error
+		if offset, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+	var firstErr error
+	for i, b := range c.ordered(false) {
+		if i > 0 {
+			if seeker == nil {
+				break
+			}
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				break
+			}
+		}
+		info, err := b.Impl.PutObject(ctx, name, reader, size, opt)
+		b.observe(ctx, err)
+		if err == nil {
+			return info, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		log.ZWarn(ctx, "s3 composite put object failed", err, "backend", b.Name, "key", name)
+	}
+	return nil, firstErr
+}
+
+// errWriteDone stops feeding a backend whose PutObject returned.
+var errWriteDone = errors.New("composite backend write done")
+
+func putAll(ctx context.Context, targets []*backend, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	infos := make([]*s3.ObjectInfo, len(targets))
+	writers := make([]*io.PipeWriter, len(targets))
+	readers := make([]*io.PipeReader, len(targets))
+	for i := range targets {
+		readers[i], writers[i] = io.Pipe()
+	}
+	var errList []error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errList = each(targets, func(i int, b *backend) error {
+			var err error
+			infos[i], err = b.Impl.PutObject(ctx, name, readers[i], size, opt)
+			readers[i].CloseWithError(errWriteDone)
+			b.observe(ctx, err)
+			return err
+		})
+	}()
+	fanOut(reader, writers)
+	<-done
+	i, err := succeeded(ctx, "put object", name, targets, errList)
+	if err != nil {
+		return nil, err
+	}
+	return infos[i], nil
+}
+
+// fanOut copies reader to every writer, dropping the writers that fail, and closes them
+// with the error of reader.
+func fanOut(reader io.Reader, writers []*io.PipeWriter) {
+	buf := make([]byte, 32*1024)
+	active := slices.Clone(writers)
+	for len(active) > 0 {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			writing := active[:0]
+			for _, w := range active {
+				if _, err := w.Write(buf[:n]); err == nil {
+					writing = append(writing, w)
+				}
+			}
+			active = writing
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			for _, w := range writers {
+				w.CloseWithError(err)
+			}
+			return
+		}
+	}
+	for _, w := range writers {
+		w.Close()
+	}
+}
+
+// succeeded returns the index of the first backend that succeeded, logging the failures
+// of the others, or the first error other than not found when all of them failed.
+func succeeded(ctx context.Context, op string, key string, targets []*backend, errList []error) (int, error) {
+	first := -1
+	for i, err := range errList {
+		if err == nil {
+			if first < 0 {
+				first = i
+			}
+			continue
+		}
+		if !targets[i].Impl.IsNotFound(err) {
+			log.ZWarn(ctx, "s3 composite "+op+" failed", err, "backend", targets[i].Name, "key", key)
+		}
+	}
+	if first >= 0 {
+		return first, nil
+	}
+	for i, err := range errList {
+		if !targets[i].Impl.IsNotFound(err) {
+			return 0, err
+		}
+	}
+	return 0, errList[0]
+}
+
+// ListObjects merges the listings of the healthy backends. An object on several
+// backends is listed once, as the first backend in configuration order has it.
+func (c *Composite) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	limit = s3.ListLimit(limit)
+	targets := c.available()
+	pages := make([]*s3.ListObjectsResult, len(targets))
+	errList := each(targets, func(i int, b *backend) error {
+		var err error
+		pages[i], err = b.Impl.ListObjects(ctx, prefix, marker, limit)
+		b.observe(ctx, err)
+		return err
+	})
+	for _, err := range errList {
+		if err != nil {
+			return nil, err
+		}
+	}
+	// A truncated backend may have keys past its page, so the merge stops at the
+	// lowest last key of the truncated pages.
+	var (
+		end       string
+		truncated bool
+	)
+	for _, page := range pages {
+		if page.IsTruncated && len(page.Objects) > 0 {
+			if last := page.Objects[len(page.Objects)-1].Key; !truncated || last < end {
+				end = last
+			}
+			truncated = true
+		}
+	}
+	seen := make(map[string]struct{})
+	objects := make([]*s3.ObjectInfo, 0)
+	for _, page := range pages {
+		for _, obj := range page.Objects {
+			if truncated && obj.Key > end {
+				break
+			}
+			if _, ok := seen[obj.Key]; ok {
+				continue
+			}
+			seen[obj.Key] = struct{}{}
+			objects = append(objects, obj)
+		}
+	}
+	slices.SortFunc(objects, func(a, b *s3.ObjectInfo) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	res := &s3.ListObjectsResult{IsTruncated: truncated}
+	if len(objects) > limit {
+		objects = objects[:limit]
+		res.IsTruncated = true
+	}
+	res.Objects = objects
+	if res.IsTruncated {
+		res.NextMarker = objects[len(objects)-1].Key
+	}
+	return res, nil
+}
+
+func (c *Composite) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	var (
+		reader io.ReadCloser
+		info   *s3.ObjectInfo
+	)
+	err := c.read(ctx, func(b *backend) error {
+		var err error
+		reader, info, err = b.Impl.GetObject(ctx, name, opt)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, info, nil
+}
+
+func (c *Composite) IsNotFound(err error) bool {
+	for _, b := range c.backends {
+		if b.Impl.IsNotFound(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessURL signs the URL on the backend holding name.
+func (c *Composite) AccessURL(ctx context.Context, name string, expire time.Duration, opt *s3.AccessURLOption) (string, error) {
+	b := c.backends[0]
+	if len(c.backends) > 1 {
+		var err error
+		if b, _, err = c.locate(ctx, name); err != nil {
+			return "", err
+		}
+	}
+	return b.Impl.AccessURL(ctx, name, expire, opt)
+}
+
+// GetLifecycle returns the rules of the backend that receives writes. Vendor rules are
+// left out: they belong to one engine, and SetLifecycle keeps those of each backend.
+func (c *Composite) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	lc, err := s3.LifecycleOf(c.writer().Impl)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := lc.GetLifecycle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(rules, func(rule s3.LifecycleRule) bool {
+		return rule.Vendor != nil
+	}), nil
+}
+
+// SetLifecycle sets rules on every backend, together with the vendor rules each backend
+// already has. rules may not carry Vendor, nor replace the vendor rule of a backend.
+func (c *Composite) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	ids := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if rule.Vendor != nil {
+			return errs.ErrArgs.WrapMsg("vendor lifecycle rules cannot be set on a composite", "id", rule.ID)
+		}
+		ids[rule.ID] = struct{}{}
+	}
+	// Every backend is read before any is written, so a conflict leaves all unchanged.
+	merged := make([][]s3.LifecycleRule, len(c.backends))
+	for i, b := range c.backends {
+		lc, err := s3.LifecycleOf(b.Impl)
+		if err != nil {
+			return err
+		}
+		current, err := lc.GetLifecycle(ctx)
+		if err != nil {
+			return errs.WrapMsg(err, "get composite backend lifecycle failed", "backend", b.Name)
+		}
+		merged[i] = slices.Clone(rules)
+		for _, rule := range current {
+			if rule.Vendor == nil {
+				continue
+			}
+			if _, ok := ids[rule.ID]; ok {
+				return errs.ErrArgs.WrapMsg("lifecycle rule replaces a vendor rule of a backend", "id", rule.ID, "backend", b.Name)
+			}
+			merged[i] = append(merged[i], rule)
+		}
+	}
+	for i, b := range c.backends {
+		lc, err := s3.LifecycleOf(b.Impl)
+		if err != nil {
+			return err
+		}
+		if err := lc.SetLifecycle(ctx, merged[i]); err != nil {
+			return errs.WrapMsg(err, "set composite backend lifecycle failed", "backend", b.Name)
+		}
+	}
+	return nil
+}
+
+// SetLegalHold sets the hold on every backend holding name.
+func (c *Composite) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	targets := c.available()
+	errList := each(targets, func(i int, b *backend) error {
+		lc, err := s3.LifecycleOf(b.Impl)
+		if err != nil {
+			return err
+		}
+		return lc.SetLegalHold(ctx, name, hold)
+	})
+	_, err := succeeded(ctx, "set legal hold", name, targets, errList)
+	return err
+}
+
+// LegalHold reports the hold of name on the backend holding it.
+func (c *Composite) LegalHold(ctx context.Context, name string) (bool, error) {
+	b, _, err := c.locate(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	lc, err := s3.LifecycleOf(b.Impl)
+	if err != nil {
+		return false, err
+	}
+	return lc.LegalHold(ctx, name)
+}
@@ -0,0 +1,329 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package composite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+var errDown = errors.New("backend down")
+
+// testBackend is an s3test engine whose check fails while down is set.
+type testBackend struct {
+	*s3test.Engine
+	down  atomic.Bool
+	delay time.Duration
+}
+
+func (b *testBackend) check(ctx context.Context) error {
+	time.Sleep(b.delay)
+	if b.down.Load() {
+		return errDown
+	}
+	return nil
+}
+
+func newComposite(t *testing.T, strategy Strategy, n int) (*Composite, []*testBackend) {
+	engines := make([]*testBackend, n)
+	backends := make([]Backend, n)
+	for i := range engines {
+		engines[i] = &testBackend{Engine: s3test.NewEngine()}
+		backends[i] = Backend{Name: fmt.Sprintf("b%d", i), Impl: engines[i].Engine, Check: engines[i].check}
+	}
+	c, err := New(context.Background(), backends, WithStrategy(strategy), WithCheckInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, engines
+}
+
+func TestConformance(t *testing.T) {
+	for _, strategy := range []Strategy{StrategyFailover, StrategyWriteAll, StrategyNearest} {
+		t.Run(string(strategy), func(t *testing.T) {
+			s3test.RunConformance(t, func(t *testing.T) *s3test.Harness {
+				c, _ := newComposite(t, strategy, 2)
+				return &s3test.Harness{
+					Impl: c,
+					Put: func(ctx context.Context, name string, data []byte) error {
+						c.writer().Impl.(*s3test.Engine).SetObject(name, data)
+						return nil
+					},
+					UploadPart: func(ctx context.Context, uploadID string, name string, partNumber int, data []byte) (string, error) {
+						i, id, err := c.upload(uploadID)
+						if err != nil {
+							return "", err
+						}
+						return c.backends[i].Impl.(*s3test.Engine).UploadPart(id, name, partNumber, data)
+					},
+				}
+			})
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	c, engines := newComposite(t, StrategyFailover, 2)
+	primary, secondary := engines[0], engines[1]
+
+	primary.down.Store(true)
+	c.CheckHealth(ctx)
+	if status := c.Status(); status[0].Healthy || status[0].Err != errDown.Error() || !status[1].Healthy {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	// Not seekable, so the write cannot be retried and must go to the healthy backend first.
+	reader := io.MultiReader(strings.NewReader("written during failover"))
+	if _, err := c.PutObject(ctx, "a", reader, -1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secondary.Object("a"); !ok || primary.CallCount(s3test.OpPutObject) != 0 {
+		t.Fatal("write did not fail over")
+	}
+	upload, err := c.InitiateMultipartUpload(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(upload.UploadID, "b1:") {
+		t.Fatalf("upload started on the wrong backend: %s", upload.UploadID)
+	}
+
+	primary.down.Store(false)
+	c.CheckHealth(ctx)
+	if _, err := c.PutObject(ctx, "c", strings.NewReader("c"), 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := primary.Object("c"); !ok {
+		t.Fatal("write did not return to the primary")
+	}
+	// Objects written during the failover stay readable, with URLs of their backend.
+	reader2, info, err := c.GetObject(ctx, "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader2)
+	reader2.Close()
+	if string(data) != "written during failover" || info.Size != int64(len(data)) {
+		t.Fatalf("unexpected object %q %+v", data, info)
+	}
+	primary.ResetCalls()
+	secondary.ResetCalls()
+	if _, err := c.AccessURL(ctx, "a", time.Minute, nil); err != nil {
+		t.Fatal(err)
+	}
+	if primary.CallCount(s3test.OpAccessURL) != 0 || secondary.CallCount(s3test.OpAccessURL) != 1 {
+		t.Fatal("access url not signed by the backend holding the object")
+	}
+	if err := c.AbortMultipartUpload(ctx, upload.UploadID, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if n := secondary.CallCount(s3test.OpAbortMultipartUpload); n != 1 {
+		t.Fatalf("abort routed %d times to the backend of the upload", n)
+	}
+	if _, err := c.StatObject(ctx, "missing"); !c.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestPutObjectRetry(t *testing.T) {
+	ctx := context.Background()
+	c, engines := newComposite(t, StrategyFailover, 2)
+	injected := errors.New("injected")
+	engines[0].SetFault(s3test.OpPutObject, s3test.Fault{Err: injected})
+	if _, err := c.PutObject(ctx, "a", bytes.NewReader([]byte("retried")), 7, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := engines[1].Object("a"); !ok || string(data) != "retried" {
+		t.Fatal("seekable write not retried on the next backend")
+	}
+	if _, err := c.PutObject(ctx, "b", io.MultiReader(strings.NewReader("b")), 1, nil); !errors.Is(err, injected) {
+		t.Fatalf("expected the primary error, got %v", err)
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	ctx := context.Background()
+	c, engines := newComposite(t, StrategyWriteAll, 3)
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	injected := errors.New("injected")
+	engines[2].SetFault(s3test.OpPutObject, s3test.Fault{Err: injected, Times: 1})
+	if _, err := c.PutObject(ctx, "a", io.MultiReader(bytes.NewReader(data)), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range engines[:2] {
+		if got, ok := e.Object("a"); !ok || !bytes.Equal(got, data) {
+			t.Fatalf("backend %d not written", i)
+		}
+	}
+	if _, ok := engines[2].Object("a"); ok {
+		t.Fatal("failed backend written")
+	}
+	if _, err := c.CopyObject(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := engines[0].Keys(); !slices.Equal(keys, []string{"a", "b"}) || !slices.Equal(engines[1].Keys(), keys) {
+		t.Fatalf("copy not written to every holder: %v %v", keys, engines[1].Keys())
+	}
+	if err := c.DeleteObject(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range engines {
+		if _, ok := e.Object("a"); ok {
+			t.Fatalf("backend %d still has the object", i)
+		}
+	}
+
+	for _, e := range engines {
+		e.SetFault(s3test.OpPutObject, s3test.Fault{Err: injected, Times: 1})
+	}
+	if _, err := c.PutObject(ctx, "c", strings.NewReader("c"), 1, nil); !errors.Is(err, injected) {
+		t.Fatalf("expected an error when every backend fails, got %v", err)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	ctx := context.Background()
+	c, engines := newComposite(t, StrategyNearest, 2)
+	engines[0].delay = time.Millisecond * 20
+	c.CheckHealth(ctx)
+	if _, err := c.PutObject(ctx, "a", strings.NewReader("a"), 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := engines[0].Object("a"); !ok {
+		t.Fatal("write did not go to the primary")
+	}
+	engines[1].SetObject("a", []byte("a"))
+	engines[0].ResetCalls()
+	if _, err := c.StatObject(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if engines[0].CallCount(s3test.OpStatObject) != 0 || engines[1].CallCount(s3test.OpStatObject) != 1 {
+		t.Fatal("read not served by the nearest backend")
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	ctx := context.Background()
+	c, engines := newComposite(t, StrategyFailover, 2)
+	for i := 0; i < 10; i++ {
+		engines[i%2].SetObject(fmt.Sprintf("k%d", i), []byte{byte(i)})
+	}
+	engines[1].SetObject("k4", []byte("shadowed"))
+	var keys []string
+	it := s3.NewObjectIterator(c, "k", 3)
+	for it.Next(ctx) {
+		obj := it.Object()
+		if obj.Key == "k4" && obj.Size != 1 {
+			t.Fatal("duplicate not taken from the first backend")
+		}
+		keys = append(keys, obj.Key)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8", "k9"}; !slices.Equal(keys, want) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestListMultipartUploads(t *testing.T) {
+	ctx := context.Background()
+	c, engines := newComposite(t, StrategyFailover, 2)
+	want := make(map[string]bool)
+	for i, key := range []string{"u/a", "u/b", "u/c"} {
+		engines[0].down.Store(i == 2)
+		c.CheckHealth(ctx)
+		upload, err := c.InitiateMultipartUpload(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[upload.UploadID] = true
+	}
+	engines[0].down.Store(false)
+	c.CheckHealth(ctx)
+	var keyMarker, uploadIDMarker string
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("listing does not end")
+		}
+		res, err := c.ListMultipartUploads(ctx, "u/", keyMarker, uploadIDMarker, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, upload := range res.Uploads {
+			if !want[upload.UploadID] {
+				t.Fatalf("unexpected upload %+v", upload)
+			}
+			delete(want, upload.UploadID)
+		}
+		if !res.IsTruncated {
+			break
+		}
+		keyMarker, uploadIDMarker = res.NextKeyMarker, res.NextUploadIDMarker
+	}
+	if len(want) != 0 {
+		t.Fatalf("uploads not listed: %v", want)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	c, engines := newComposite(t, StrategyWriteAll, 2)
+	vendor := []s3.LifecycleRule{{ID: "tags", Vendor: "b0 rule"}, {ID: "tags", Vendor: "b1 rule"}}
+	for i, e := range engines {
+		if err := e.SetLifecycle(ctx, []s3.LifecycleRule{vendor[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rules, err := c.GetLifecycle(ctx); err != nil || len(rules) != 0 {
+		t.Fatalf("unexpected rules %+v: %v", rules, err)
+	}
+	desired := []s3.LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpireDays: 1}}
+	changes, err := s3.ReconcileLifecycle(ctx, c, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changes.Added, []string{"tmp"}) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	// Each backend keeps its own vendor rule instead of receiving the writer's.
+	for i, e := range engines {
+		rules, err := e.GetLifecycle(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 2 || rules[0].ID != "tmp" || rules[1].Vendor != vendor[i].Vendor {
+			t.Fatalf("backend %d: unexpected rules %+v", i, rules)
+		}
+	}
+	if err := c.SetLifecycle(ctx, vendor[:1]); !errs.ErrArgs.Is(err) {
+		t.Fatalf("expected ErrArgs for a vendor rule, got %v", err)
+	}
+	if err := c.SetLifecycle(ctx, []s3.LifecycleRule{{ID: "tags", ExpireDays: 1}}); !errs.ErrArgs.Is(err) {
+		t.Fatalf("expected ErrArgs for replacing a vendor rule, got %v", err)
+	}
+}
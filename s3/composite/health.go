@@ -0,0 +1,152 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package composite
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/log"
+)
+
+// healthCheckKey is stated by the default check; a not found answer proves the backend is reachable.
+const healthCheckKey = "composite/health-check"
+
+// BackendStatus is the health of one backend as last seen by the Composite.
+type BackendStatus struct {
+	Name    string        `json:"name"`
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency"`
+	// Err is the reason the backend was marked unhealthy.
+	Err       string    `json:"err,omitempty"`
+	CheckTime time.Time `json:"checkTime"`
+}
+
+type backend struct {
+	Backend
+	lock   sync.RWMutex
+	status BackendStatus
+}
+
+func (b *backend) healthy() bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.status.Healthy
+}
+
+func (b *backend) latency() time.Duration {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.status.Latency
+}
+
+// setHealthy records the health of b and logs transitions.
+func (b *backend) setHealthy(ctx context.Context, err error) {
+	b.lock.Lock()
+	was := b.status.Healthy
+	b.status.Healthy = err == nil
+	if err == nil {
+		b.status.Err = ""
+	} else {
+		b.status.Err = err.Error()
+	}
+	b.lock.Unlock()
+	switch {
+	case was && err != nil:
+		log.ZWarn(ctx, "s3 composite backend unhealthy", err, "backend", b.Name)
+	case !was && err == nil:
+		log.ZInfo(ctx, "s3 composite backend healthy", "backend", b.Name)
+	}
+}
+
+// observe marks b from the outcome of an operation, so that a backend that stopped
+// answering is passed over before the next check notices it. Only network errors mark
+// a backend unhealthy; other errors concern the request.
+func (b *backend) observe(ctx context.Context, err error) {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		if !b.healthy() {
+			b.setHealthy(ctx, nil)
+		}
+	case ctx.Err() == nil && errors.As(err, &netErr):
+		b.setHealthy(ctx, err)
+	}
+}
+
+func (b *backend) check(ctx context.Context) error {
+	if b.Check != nil {
+		return b.Check(ctx)
+	}
+	if _, err := b.Impl.StatObject(ctx, healthCheckKey); err != nil && !b.Impl.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// CheckHealth checks every backend once, concurrently, and records the results
+// that route the following operations.
+func (c *Composite) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, b := range c.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.checkTimeout)
+			defer cancel()
+			start := time.Now()
+			err := b.check(checkCtx)
+			latency := time.Since(start)
+			if ctx.Err() != nil {
+				return
+			}
+			b.setHealthy(ctx, err)
+			b.lock.Lock()
+			b.status.CheckTime = start
+			if err == nil {
+				b.status.Latency = latency
+			}
+			b.lock.Unlock()
+		}(b)
+	}
+	wg.Wait()
+}
+
+// Status returns the health of the backends in configuration order.
+func (c *Composite) Status() []BackendStatus {
+	status := make([]BackendStatus, len(c.backends))
+	for i, b := range c.backends {
+		b.lock.RLock()
+		status[i] = b.status
+		b.lock.RUnlock()
+	}
+	return status
+}
+
+func (c *Composite) checkLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.CheckHealth(ctx)
+		}
+	}
+}
@@ -2,7 +2,12 @@ package cont
 
 import (
 	"context"
+	"maps"
+	"sync"
+	"time"
 
+	"github.com/amazing-socrates/next-tools/db/cacheutil"
+	"github.com/amazing-socrates/next-tools/errs"
 	"github.com/amazing-socrates/next-tools/s3"
 )
 
@@ -10,3 +15,154 @@ type S3Cache interface {
 	GetKey(ctx context.Context, engine string, key string) (*s3.ObjectInfo, error)
 	DelS3Key(ctx context.Context, engine string, keys ...string) error
 }
+
+// Defaults of the CacheOption settings.
+const (
+	DefaultCacheTTL         = time.Hour
+	DefaultCacheNotFoundTTL = time.Second * 30
+	DefaultCacheJitter      = 0.1
+)
+
+type CacheOption func(*cacheConfig)
+
+// WithCacheTTL sets how long the info of an object is cached.
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.ttl = ttl
+	}
+}
+
+// WithCacheNotFoundTTL sets how long a missing key is remembered; zero disables negative
+// caching. The Controller calls DelS3Key for the objects it writes, objects written by
+// other means may stay missing for up to ttl.
+func WithCacheNotFoundTTL(ttl time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.notFoundTTL = ttl
+	}
+}
+
+// WithCacheJitter moves every TTL randomly by up to fraction of it, so that entries
+// cached together do not expire together.
+func WithCacheJitter(fraction float64) CacheOption {
+	return func(c *cacheConfig) {
+		c.jitter = fraction
+	}
+}
+
+// WithCacheKeyPrefix sets the prefix of the Redis keys, "S3:" by default.
+func WithCacheKeyPrefix(prefix string) CacheOption {
+	return func(c *cacheConfig) {
+		c.keyPrefix = prefix
+	}
+}
+
+type cacheConfig struct {
+	ttl         time.Duration
+	notFoundTTL time.Duration
+	jitter      float64
+	keyPrefix   string
+}
+
+func newCacheConfig(opts []CacheOption) cacheConfig {
+	conf := cacheConfig{
+		ttl:         DefaultCacheTTL,
+		notFoundTTL: DefaultCacheNotFoundTTL,
+		jitter:      DefaultCacheJitter,
+		keyPrefix:   defaultS3CacheKeyPrefix,
+	}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	return conf
+}
+
+// s3CacheEntry is a cached StatObject result; NotFound entries are negative.
+type s3CacheEntry struct {
+	Info     *s3.ObjectInfo `json:"info,omitempty"`
+	NotFound bool           `json:"notFound,omitempty"`
+}
+
+// load stats key on impl. A missing key yields a negative entry along with the error of impl.
+func (c *cacheConfig) load(ctx context.Context, impl s3.Interface, key string) (*s3CacheEntry, time.Duration, error) {
+	info, err := impl.StatObject(ctx, key)
+	if err == nil {
+		return &s3CacheEntry{Info: info}, cacheutil.Jitter(c.ttl, c.jitter), nil
+	}
+	if impl.IsNotFound(err) && c.notFoundTTL > 0 {
+		return &s3CacheEntry{NotFound: true}, cacheutil.Jitter(c.notFoundTTL, c.jitter), err
+	}
+	return nil, 0, err
+}
+
+// result returns a copy of the cached info, or errs.ErrRecordNotFound for a negative
+// entry, which Controller.IsNotFound recognizes.
+func (e *s3CacheEntry) result(engine string, key string) (*s3.ObjectInfo, error) {
+	if e.NotFound || e.Info == nil {
+		return nil, errs.ErrRecordNotFound.WrapMsg("s3 object not found", "engine", engine, "key", key)
+	}
+	info := *e.Info
+	info.Metadata = maps.Clone(e.Info.Metadata)
+	return &info, nil
+}
+
+func s3CacheKey(engine string, key string) string {
+	return engine + ":" + key
+}
+
+// NewMemoryS3Cache caches the StatObject results of impl, the engine of the Controller,
+// in process memory. Up to maxEntries keys are kept, the least recently used are dropped
+// first. Concurrent misses of a key share one StatObject.
+func NewMemoryS3Cache(impl s3.Interface, maxEntries int, opts ...CacheOption) S3Cache {
+	return &memoryS3Cache{
+		impl:    impl,
+		conf:    newCacheConfig(opts),
+		entries: cacheutil.NewLRU[string, *s3CacheEntry](maxEntries),
+	}
+}
+
+type memoryS3Cache struct {
+	impl    s3.Interface
+	conf    cacheConfig
+	entries *cacheutil.LRU[string, *s3CacheEntry]
+	group   cacheutil.Group[*s3CacheEntry]
+	// lock orders stores against DelS3Key, which bumps epoch so that a StatObject
+	// started before the deletion does not cache what it saw.
+	lock  sync.Mutex
+	epoch uint64
+}
+
+func (m *memoryS3Cache) GetKey(ctx context.Context, engine string, key string) (*s3.ObjectInfo, error) {
+	cacheKey := s3CacheKey(engine, key)
+	entry, ok := m.entries.Get(cacheKey)
+	if !ok {
+		var err error
+		entry, err, _ = m.group.Do(cacheKey, func() (*s3CacheEntry, error) {
+			m.lock.Lock()
+			epoch := m.epoch
+			m.lock.Unlock()
+			entry, ttl, err := m.conf.load(ctx, m.impl, key)
+			if entry != nil {
+				m.lock.Lock()
+				if m.epoch == epoch {
+					m.entries.Set(cacheKey, entry, ttl)
+				}
+				m.lock.Unlock()
+			}
+			return entry, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entry.result(engine, key)
+}
+
+func (m *memoryS3Cache) DelS3Key(ctx context.Context, engine string, keys ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.epoch++
+	for _, key := range keys {
+		m.entries.Delete(s3CacheKey(engine, key))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/amazing-socrates/next-tools/db/cacheutil"
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultS3CacheKeyPrefix = "S3:"
+
+// NewRedisS3Cache caches the StatObject results of impl, the engine of the Controller,
+// as JSON strings. Concurrent misses of a key within the process share one StatObject.
+// When Redis fails, lookups go to impl so that the cache never takes the service down.
+func NewRedisS3Cache(rdb redis.UniversalClient, impl s3.Interface, opts ...CacheOption) S3Cache {
+	return &redisS3Cache{rdb: rdb, impl: impl, conf: newCacheConfig(opts)}
+}
+
+type redisS3Cache struct {
+	rdb   redis.UniversalClient
+	impl  s3.Interface
+	conf  cacheConfig
+	group cacheutil.Group[*s3CacheEntry]
+}
+
+func (r *redisS3Cache) key(engine string, key string) string {
+	return r.conf.keyPrefix + s3CacheKey(engine, key)
+}
+
+func (r *redisS3Cache) GetKey(ctx context.Context, engine string, key string) (*s3.ObjectInfo, error) {
+	redisKey := r.key(engine, key)
+	data, err := r.rdb.Get(ctx, redisKey).Bytes()
+	if err == nil {
+		var entry s3CacheEntry
+		if err := json.Unmarshal(data, &entry); err == nil {
+			return entry.result(engine, key)
+		}
+		log.ZWarn(ctx, "decode s3 cache entry failed", err, "key", redisKey)
+	} else if !errors.Is(err, redis.Nil) {
+		log.ZWarn(ctx, "redis get s3 cache failed", err, "key", redisKey)
+	}
+	entry, err, _ := r.group.Do(redisKey, func() (*s3CacheEntry, error) {
+		entry, ttl, err := r.conf.load(ctx, r.impl, key)
+		if entry != nil && ttl > 0 {
+			if data, err := json.Marshal(entry); err == nil {
+				if err := r.rdb.Set(ctx, redisKey, data, ttl).Err(); err != nil {
+					log.ZWarn(ctx, "redis set s3 cache failed", err, "key", redisKey)
+				}
+			}
+		}
+		return entry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.result(engine, key)
+}
+
+// DelS3Key deletes the keys one by one in a pipeline, so that they need not share a cluster slot.
+func (r *redisS3Cache) DelS3Key(ctx context.Context, engine string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pipe := r.rdb.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, r.key(engine, key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errs.WrapMsg(err, "redis delete s3 cache failed", "engine", engine, "keys", keys)
+	}
+	return nil
+}
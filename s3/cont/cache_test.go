@@ -0,0 +1,118 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+func TestMemoryS3Cache(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	engine.SetObject("a", []byte("a"))
+	cache := NewMemoryS3Cache(engine, 16)
+	for i := 0; i < 2; i++ {
+		info, err := cache.GetKey(ctx, engine.Engine(), "a")
+		if err != nil || info.Key != "a" {
+			t.Fatalf("unexpected info %+v: %v", info, err)
+		}
+		info.Metadata = map[string]string{"mutated": "true"}
+	}
+	if n := engine.CallCount(s3test.OpStatObject); n != 1 {
+		t.Fatalf("cached key stated %d times", n)
+	}
+	if info, _ := cache.GetKey(ctx, engine.Engine(), "a"); info.Metadata["mutated"] != "" {
+		t.Fatal("cached info shared with callers")
+	}
+
+	engine.ResetCalls()
+	if _, err := cache.GetKey(ctx, engine.Engine(), "b"); !engine.IsNotFound(err) {
+		t.Fatalf("expected the engine not found error, got %v", err)
+	}
+	if _, err := cache.GetKey(ctx, engine.Engine(), "b"); !errs.ErrRecordNotFound.Is(err) {
+		t.Fatalf("expected a cached not found, got %v", err)
+	}
+	if n := engine.CallCount(s3test.OpStatObject); n != 1 {
+		t.Fatalf("missing key stated %d times", n)
+	}
+	engine.SetObject("b", []byte("b"))
+	if err := cache.DelS3Key(ctx, engine.Engine(), "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetKey(ctx, engine.Engine(), "b"); err != nil {
+		t.Fatalf("negative entry survived the deletion: %v", err)
+	}
+
+	cache = NewMemoryS3Cache(engine, 16, WithCacheNotFoundTTL(0), WithCacheTTL(time.Millisecond))
+	engine.ResetCalls()
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetKey(ctx, engine.Engine(), "c"); !engine.IsNotFound(err) {
+			t.Fatalf("expected the engine not found error, got %v", err)
+		}
+	}
+	if n := engine.CallCount(s3test.OpStatObject); n != 2 {
+		t.Fatalf("missing key cached without negative caching: %d stats", n)
+	}
+}
+
+func TestMemoryS3CacheSingleflight(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	engine.SetObject("a", []byte("a"))
+	engine.SetFault(s3test.OpStatObject, s3test.Fault{Latency: time.Millisecond * 50})
+	cache := NewMemoryS3Cache(engine, 16)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetKey(ctx, engine.Engine(), "a"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := engine.CallCount(s3test.OpStatObject); n != 1 {
+		t.Fatalf("concurrent misses stated %d times", n)
+	}
+}
+
+func TestControllerMemoryS3Cache(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	c := newController(NewMemoryS3Cache(engine, 16), engine)
+	data := []byte("cached upload")
+	hash := md5Hex([]byte(md5Hex(data)))
+	// The lookup before the upload caches a negative entry the upload must invalidate.
+	if _, err := c.GetHashObject(ctx, hash); !c.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := c.GetHashObject(ctx, hash); !c.IsNotFound(err) {
+		t.Fatalf("expected a cached not found, got %v", err)
+	}
+	if _, err := c.UploadObject(ctx, hash, int64(len(data)), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := c.GetHashObject(ctx, hash); err != nil || info.Size != int64(len(data)) {
+		t.Fatalf("uploaded object not found: %+v %v", info, err)
+	}
+}
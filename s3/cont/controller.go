@@ -20,34 +20,147 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/amazing-socrates/next-tools/errs"
 	"github.com/amazing-socrates/next-tools/log"
+	"github.com/amazing-socrates/next-tools/mcontext"
 	"github.com/amazing-socrates/next-tools/s3"
 	"github.com/google/uuid"
 )
 
-func New(cache S3Cache, impl s3.Interface) *Controller {
-	return &Controller{
-		cache: cache,
-		impl:  impl,
+type Option func(*Controller)
+
+// WithHashAlgorithm sets the algorithm used when InitiateUpload is not given one, MD5 by default.
+func WithHashAlgorithm(alg HashAlgorithm) Option {
+	return func(c *Controller) {
+		c.hash = alg
+	}
+}
+
+// WithUploadIDKeys signs upload IDs with HMAC-SHA256. The first key signs new IDs and
+// every key verifies, so a rotation adds the new key in front and drops the old one
+// once its IDs have expired. Every instance serving the same clients must share the
+// keys. New fails for a key without ID or secret.
+func WithUploadIDKeys(keys ...UploadIDKey) Option {
+	return func(c *Controller) {
+		c.uploadIDKeys = keys
+	}
+}
+
+// WithUnsignedUploadIDs accepts upload IDs without a signature, such as those issued
+// before WithUploadIDKeys existed, and issues them when no key is configured. Clients can
+// forge such IDs, so it is meant for rolling out the keys while the uploads initiated by
+// the previous version complete.
+func WithUnsignedUploadIDs() Option {
+	return func(c *Controller) {
+		c.unsignedUploadIDs = true
+	}
+}
+
+// WithUploadIDTTL sets how long an upload ID stays valid, 24 hours by default.
+// A TTL of zero or less issues IDs that never expire.
+func WithUploadIDTTL(ttl time.Duration) Option {
+	return func(c *Controller) {
+		c.uploadIDTTL = ttl
+	}
+}
+
+// WithSessionStore records every initiated upload so that ResumeUpload can find it again.
+func WithSessionStore(store SessionStore) Option {
+	return func(c *Controller) {
+		c.sessions = store
+	}
+}
+
+// WithUploadPolicy consults policy for the operator of every InitiateUpload and CompleteUpload.
+func WithUploadPolicy(policy UploadPolicy) Option {
+	return func(c *Controller) {
+		c.policy = policy
+	}
+}
+
+type UploadOption func(*uploadOption)
+
+type uploadOption struct {
+	hashAlgorithm string
+	contentType   string
+}
+
+// WithUploadHashAlgorithm selects a registered HashAlgorithm by name for one upload.
+func WithUploadHashAlgorithm(name string) UploadOption {
+	return func(o *uploadOption) {
+		o.hashAlgorithm = name
+	}
+}
+
+// WithUploadContentType declares the content type of the upload to the UploadPolicy.
+func WithUploadContentType(contentType string) UploadOption {
+	return func(o *uploadOption) {
+		o.contentType = contentType
+	}
+}
+
+// New returns a Controller storing content in impl. It fails when no upload ID key is
+// configured, unless unsigned upload IDs are explicitly allowed with WithUnsignedUploadIDs.
+func New(cache S3Cache, impl s3.Interface, opts ...Option) (*Controller, error) {
+	c := &Controller{
+		cache:       cache,
+		impl:        impl,
+		hash:        MD5,
+		uploadIDTTL: defaultUploadIDTTL,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, key := range c.uploadIDKeys {
+		if key.ID == "" || len(key.Secret) == 0 {
+			return nil, errs.ErrArgs.WrapMsg("invalid upload id key", "id", key.ID)
+		}
+	}
+	if len(c.uploadIDKeys) == 0 {
+		if !c.unsignedUploadIDs {
+			return nil, errs.ErrArgs.WrapMsg("no upload id keys, configure WithUploadIDKeys or allow unsigned ids with WithUnsignedUploadIDs")
+		}
+		log.ZWarn(context.Background(), "s3 controller issues unsigned upload ids, which clients can forge", nil)
 	}
+	return c, nil
 }
 
 type Controller struct {
 	cache S3Cache
 	impl  s3.Interface
+	hash  HashAlgorithm
+
+	uploadIDKeys      []UploadIDKey
+	unsignedUploadIDs bool
+	uploadIDTTL       time.Duration
+	now               func() time.Time
+	sessions          SessionStore
+	policy            UploadPolicy
+	sinks             []EventSink
 }
 
 func (c *Controller) Engine() string {
 	return c.impl.Engine()
 }
 
-func (c *Controller) HashPath(md5 string) string {
-	return path.Join(hashPath, md5)
+// HashPath returns the key of content hashed with the default algorithm.
+func (c *Controller) HashPath(hash string) string {
+	return c.HashPathFor(c.hash, hash)
+}
+
+// HashPathFor returns the key of content hashed with alg. MD5 keeps the original
+// unprefixed layout, other algorithms live under a directory named after them.
+func (c *Controller) HashPathFor(alg HashAlgorithm, hash string) string {
+	if isMD5(alg) {
+		return path.Join(hashPath, hash)
+	}
+	return path.Join(hashPath, alg.Name(), hash)
 }
 
 func (c *Controller) NowPath() string {
@@ -79,19 +192,71 @@ func (c *Controller) StatObject(ctx context.Context, name string) (*s3.ObjectInf
 	return c.cache.GetKey(ctx, c.impl.Engine(), name)
 }
 
+// GetHashObject looks hash up with the default algorithm. A hash with the length of
+// an MD5 digest falls back to the MD5 layout so objects stored before the default
+// changed still resolve.
 func (c *Controller) GetHashObject(ctx context.Context, hash string) (*s3.ObjectInfo, error) {
-	return c.StatObject(ctx, c.HashPath(hash))
+	if !isMD5(c.hash) && len(hash) == hex.EncodedLen(MD5.Size()) && len(hash) != hex.EncodedLen(c.hash.Size()) {
+		return c.GetHashObjectFor(ctx, MD5, hash)
+	}
+	return c.GetHashObjectFor(ctx, c.hash, hash)
+}
+
+func (c *Controller) GetHashObjectFor(ctx context.Context, alg HashAlgorithm, hash string) (*s3.ObjectInfo, error) {
+	return c.StatObject(ctx, c.HashPathFor(alg, hash))
 }
 
-func (c *Controller) InitiateUpload(ctx context.Context, hash string, size int64, expire time.Duration, maxParts int) (*InitiateUploadResult, error) {
+func (c *Controller) uploadHashAlgorithm(name string) (HashAlgorithm, error) {
+	if name == "" {
+		return c.hash, nil
+	}
+	alg, ok := GetHashAlgorithm(name)
+	if !ok {
+		return nil, errs.ErrArgs.WrapMsg("unsupported hash algorithm", "name", name)
+	}
+	return alg, nil
+}
+
+func (c *Controller) InitiateUpload(ctx context.Context, hash string, size int64, expire time.Duration, maxParts int, opts ...UploadOption) (*InitiateUploadResult, error) {
 	defer log.ZDebug(ctx, "return")
+	res, err := c.initiateUpload(ctx, hash, size, expire, maxParts, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.saveSession(ctx, hash, size, res)
+	return res, nil
+}
+
+func (c *Controller) initiateUpload(ctx context.Context, hash string, size int64, expire time.Duration, maxParts int, opts ...UploadOption) (*InitiateUploadResult, error) {
+	var opt uploadOption
+	for _, o := range opts {
+		o(&opt)
+	}
+	alg, err := c.uploadHashAlgorithm(opt.hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
 	if size < 0 {
 		return nil, errors.New("invalid size")
 	}
-	if hashBytes, err := hex.DecodeString(hash); err != nil {
+	if err := checkHash(alg, hash); err != nil {
 		return nil, err
-	} else if len(hashBytes) != md5.Size {
-		return nil, errors.New("invalid md5")
+	}
+	if c.policy != nil {
+		req := &UploadRequest{
+			UserID:      mcontext.GetOpUserID(ctx),
+			Hash:        hash,
+			Size:        size,
+			ContentType: opt.contentType,
+		}
+		if err := c.policy.CheckInitiate(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	// An empty algorithm keeps MD5 upload IDs readable by servers that predate it.
+	var algName string
+	if !isMD5(alg) {
+		algName = alg.Name()
 	}
 	partSize, err := c.impl.PartSize(ctx, size)
 	if err != nil {
@@ -104,39 +269,47 @@ func (c *Controller) InitiateUpload(ctx context.Context, hash string, size int64
 	if maxParts > 0 && partNumber > 0 && partNumber < maxParts {
 		return nil, fmt.Errorf("too many parts: %d", partNumber)
 	}
-	if info, err := c.StatObject(ctx, c.HashPath(hash)); err == nil {
+	if info, err := c.StatObject(ctx, c.HashPathFor(alg, hash)); err == nil {
 		return nil, &HashAlreadyExistsError{Object: info}
-	} else if !c.impl.IsNotFound(err) {
+	} else if !c.IsNotFound(err) {
 		return nil, err
 	}
 	if size <= partSize {
 		// Pre-signed upload
 		key := path.Join(tempPath, c.NowPath(), fmt.Sprintf("%s_%d_%s.presigned", hash, size, c.UUID()))
-		rawURL, err := c.impl.PresignedPutObject(ctx, key, expire)
+		presigned, err := s3.PresignPut(ctx, c.impl, key, expire)
 		if err != nil {
 			return nil, err
 		}
 		return &InitiateUploadResult{
-			UploadID: newMultipartUploadID(multipartUploadID{
-				Type: UploadTypePresigned,
-				ID:   "",
-				Key:  key,
-				Size: size,
-				Hash: hash,
+			UploadID: c.newMultipartUploadID(multipartUploadID{
+				Type:        UploadTypePresigned,
+				ID:          "",
+				Key:         key,
+				Size:        size,
+				Hash:        hash,
+				Algorithm:   algName,
+				ContentType: opt.contentType,
 			}),
 			PartSize: partSize,
 			Sign: &s3.AuthSignResult{
 				Parts: []s3.SignPart{
 					{
 						PartNumber: 1,
-						URL:        rawURL,
+						URL:        presigned.URL,
+						Header:     presigned.Header,
 					},
 				},
 			},
 		}, nil
 	} else {
-		// Fragment upload
-		upload, err := c.impl.InitiateMultipartUpload(ctx, c.HashPath(hash))
+		// Fragment upload. Only MD5 parts can be checked against ETags, other algorithms
+		// are assembled under the temp path and verified before reaching the hash path.
+		key := c.HashPathFor(alg, hash)
+		if !isMD5(alg) {
+			key = path.Join(tempPath, c.NowPath(), fmt.Sprintf("%s_%d_%s.multipart", hash, size, c.UUID()))
+		}
+		upload, err := c.impl.InitiateMultipartUpload(ctx, key)
 		if err != nil {
 			return nil, err
 		}
@@ -155,12 +328,14 @@ func (c *Controller) InitiateUpload(ctx context.Context, hash string, size int64
 			}
 		}
 		return &InitiateUploadResult{
-			UploadID: newMultipartUploadID(multipartUploadID{
-				Type: UploadTypeMultipart,
-				ID:   upload.UploadID,
-				Key:  upload.Key,
-				Size: size,
-				Hash: hash,
+			UploadID: c.newMultipartUploadID(multipartUploadID{
+				Type:        UploadTypeMultipart,
+				ID:          upload.UploadID,
+				Key:         upload.Key,
+				Size:        size,
+				Hash:        hash,
+				Algorithm:   algName,
+				ContentType: opt.contentType,
 			}),
 			PartSize: partSize,
 			Sign:     authSign,
@@ -170,14 +345,29 @@ func (c *Controller) InitiateUpload(ctx context.Context, hash string, size int64
 
 func (c *Controller) CompleteUpload(ctx context.Context, uploadID string, partHashs []string) (*UploadResult, error) {
 	defer log.ZDebug(ctx, "return")
-	upload, err := parseMultipartUploadID(uploadID)
+	upload, err := c.parseMultipartUploadID(uploadID)
 	if err != nil {
 		return nil, err
 	}
-	if md5Sum := md5.Sum([]byte(strings.Join(partHashs, partSeparator))); hex.EncodeToString(md5Sum[:]) != upload.Hash {
-		return nil, errors.New("md5 mismatching")
+	alg, ok := GetHashAlgorithm(upload.Algorithm)
+	if !ok {
+		return nil, errs.ErrArgs.WrapMsg("unsupported hash algorithm", "name", upload.Algorithm)
 	}
-	if info, err := c.StatObject(ctx, c.HashPath(upload.Hash)); err == nil {
+	if hashHex(alg, []byte(strings.Join(partHashs, partSeparator))) != upload.Hash {
+		return nil, fmt.Errorf("%s mismatching", alg.Name())
+	}
+	hashKey := c.HashPathFor(alg, upload.Hash)
+	if info, err := c.StatObject(ctx, hashKey); err == nil {
+		c.deleteSession(ctx, upload.Hash)
+		c.emit(ctx, &Event{
+			Type:          EventUploadCompleted,
+			Key:           info.Key,
+			Hash:          upload.Hash,
+			HashAlgorithm: alg.Name(),
+			Size:          info.Size,
+			ContentType:   upload.ContentType,
+			Existed:       true,
+		})
 		return &UploadResult{
 			Key:  info.Key,
 			Size: info.Size,
@@ -186,6 +376,18 @@ func (c *Controller) CompleteUpload(ctx context.Context, uploadID string, partHa
 	} else if !c.IsNotFound(err) {
 		return nil, err
 	}
+	var policyReq *UploadRequest
+	if c.policy != nil {
+		policyReq = &UploadRequest{
+			UserID:      mcontext.GetOpUserID(ctx),
+			Hash:        upload.Hash,
+			Size:        upload.Size,
+			ContentType: upload.ContentType,
+		}
+		if err := c.policy.CheckComplete(ctx, policyReq); err != nil {
+			return nil, err
+		}
+	}
 	cleanObject := make(map[string]struct{})
 	defer func() {
 		for key := range cleanObject {
@@ -195,6 +397,13 @@ func (c *Controller) CompleteUpload(ctx context.Context, uploadID string, partHa
 	var targetKey string
 	switch upload.Type {
 	case UploadTypeMultipart:
+		if !isMD5(alg) {
+			targetKey, err = c.completeVerifiedMultipart(ctx, alg, upload, partHashs, hashKey, cleanObject)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		parts := make([]s3.Part, len(partHashs))
 		for i, part := range partHashs {
 			parts[i] = s3.Part{
@@ -209,6 +418,9 @@ func (c *Controller) CompleteUpload(ctx context.Context, uploadID string, partHa
 		}
 		targetKey = result.Key
 	case UploadTypePresigned:
+		if len(partHashs) != 1 {
+			return nil, errs.ErrArgs.WrapMsg("presigned upload takes one part hash", "parts", len(partHashs))
+		}
 		uploadInfo, err := c.StatObject(ctx, upload.Key)
 		if err != nil {
 			return nil, err
@@ -217,9 +429,11 @@ func (c *Controller) CompleteUpload(ctx context.Context, uploadID string, partHa
 		if uploadInfo.Size != upload.Size {
 			return nil, errors.New("upload size mismatching")
 		}
-		md5Sum := md5.Sum([]byte(strings.Join([]string{uploadInfo.ETag}, partSeparator)))
-		if md5val := hex.EncodeToString(md5Sum[:]); md5val != upload.Hash {
-			return nil, errs.ErrArgs.WrapMsg(fmt.Sprintf("md5 mismatching %s != %s", md5val, upload.Hash))
+		if isMD5(alg) {
+			md5Sum := md5.Sum([]byte(strings.Join([]string{uploadInfo.ETag}, partSeparator)))
+			if md5val := hex.EncodeToString(md5Sum[:]); md5val != upload.Hash {
+				return nil, errs.ErrArgs.WrapMsg(fmt.Sprintf("md5 mismatching %s != %s", md5val, upload.Hash))
+			}
 		}
 		// Prevents concurrent operations at this time that cause files to be overwritten
 		copyInfo, err := c.impl.CopyObject(ctx, uploadInfo.Key, upload.Key+"."+c.UUID())
@@ -230,7 +444,13 @@ func (c *Controller) CompleteUpload(ctx context.Context, uploadID string, partHa
 		if copyInfo.ETag != uploadInfo.ETag {
 			return nil, errors.New("[concurrency]copy md5 mismatching")
 		}
-		hashCopyInfo, err := c.impl.CopyObject(ctx, copyInfo.Key, c.HashPath(upload.Hash))
+		if !isMD5(alg) {
+			// The copy cannot be overwritten through the presigned URL, so it is the one to hash.
+			if err := c.verifyParts(ctx, alg, copyInfo.Key, []int64{upload.Size}, partHashs); err != nil {
+				return nil, err
+			}
+		}
+		hashCopyInfo, err := c.impl.CopyObject(ctx, copyInfo.Key, hashKey)
 		if err != nil {
 			return nil, err
 		}
@@ -242,6 +462,20 @@ func (c *Controller) CompleteUpload(ctx context.Context, uploadID string, partHa
 	if err := c.cache.DelS3Key(ctx, c.impl.Engine(), targetKey); err != nil {
 		return nil, err
 	}
+	c.deleteSession(ctx, upload.Hash)
+	if policyReq != nil {
+		if err := c.policy.Completed(ctx, policyReq); err != nil {
+			log.ZWarn(ctx, "upload policy accounting failed", err, "key", targetKey)
+		}
+	}
+	c.emit(ctx, &Event{
+		Type:          EventUploadCompleted,
+		Key:           targetKey,
+		Hash:          upload.Hash,
+		HashAlgorithm: alg.Name(),
+		Size:          upload.Size,
+		ContentType:   upload.ContentType,
+	})
 	return &UploadResult{
 		Key:  targetKey,
 		Size: upload.Size,
@@ -249,8 +483,197 @@ func (c *Controller) CompleteUpload(ctx context.Context, uploadID string, partHa
 	}, nil
 }
 
+// completeVerifiedMultipart completes an upload staged under the temp path, hashes every
+// part of the assembled object with alg and copies it to hashKey.
+func (c *Controller) completeVerifiedMultipart(ctx context.Context, alg HashAlgorithm, upload *multipartUploadID, partHashs []string, hashKey string, cleanObject map[string]struct{}) (string, error) {
+	uploaded, err := c.listUploadedParts(ctx, upload.ID, upload.Key)
+	if err != nil {
+		return "", err
+	}
+	if len(uploaded) != len(partHashs) {
+		return "", errs.ErrArgs.WrapMsg(fmt.Sprintf("part count mismatching %d != %d", len(uploaded), len(partHashs)))
+	}
+	var size int64
+	parts := make([]s3.Part, len(uploaded))
+	partSizes := make([]int64, len(uploaded))
+	for i, part := range uploaded {
+		if part.PartNumber != i+1 {
+			return "", errs.ErrArgs.WrapMsg(fmt.Sprintf("missing part %d", i+1))
+		}
+		parts[i] = s3.Part{PartNumber: part.PartNumber, ETag: part.ETag}
+		partSizes[i] = part.Size
+		size += part.Size
+	}
+	if size != upload.Size {
+		return "", errors.New("upload size mismatching")
+	}
+	result, err := c.impl.CompleteMultipartUpload(ctx, upload.ID, upload.Key, parts)
+	if err != nil {
+		return "", err
+	}
+	cleanObject[result.Key] = struct{}{}
+	if err := c.verifyParts(ctx, alg, result.Key, partSizes, partHashs); err != nil {
+		return "", err
+	}
+	hashCopyInfo, err := c.impl.CopyObject(ctx, result.Key, hashKey)
+	if err != nil {
+		return "", err
+	}
+	return hashCopyInfo.Key, nil
+}
+
+func (c *Controller) listUploadedParts(ctx context.Context, uploadID string, name string) ([]s3.UploadedPart, error) {
+	const pageSize = 1000
+	var (
+		parts  []s3.UploadedPart
+		marker int
+	)
+	for {
+		res, err := c.impl.ListUploadedParts(ctx, uploadID, name, marker, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, res.UploadedParts...)
+		if len(res.UploadedParts) < pageSize || res.NextPartNumberMarker <= marker {
+			return parts, nil
+		}
+		marker = res.NextPartNumberMarker
+	}
+}
+
+// verifyParts reads key back and checks each consecutive chunk of partSizes against partHashs.
+func (c *Controller) verifyParts(ctx context.Context, alg HashAlgorithm, key string, partSizes []int64, partHashs []string) error {
+	if len(partHashs) != len(partSizes) {
+		return errs.ErrArgs.WrapMsg("part hash count mismatching", "parts", len(partSizes), "hashes", len(partHashs))
+	}
+	reader, info, err := c.impl.GetObject(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	var size int64
+	for _, partSize := range partSizes {
+		size += partSize
+	}
+	if info.Size != size {
+		return errors.New("upload size mismatching")
+	}
+	for i, partSize := range partSizes {
+		h := alg.New()
+		if _, err := io.CopyN(h, reader, partSize); err != nil {
+			return errs.WrapMsg(err, "read uploaded part failed", "key", key, "partNumber", i+1)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != partHashs[i] {
+			return errs.ErrArgs.WrapMsg(fmt.Sprintf("part %d %s mismatching %s != %s", i+1, alg.Name(), sum, partHashs[i]))
+		}
+	}
+	return nil
+}
+
+// UploadObject stores size bytes of reader under the hash path through the server, for
+// storages clients cannot upload to directly such as encrypted.Storage. The content is
+// hashed in parts of PartSize as it streams, so hash is the one a client would send to
+// InitiateUpload, and content that already exists is not uploaded again.
+func (c *Controller) UploadObject(ctx context.Context, hash string, size int64, reader io.Reader, opts ...UploadOption) (*UploadResult, error) {
+	defer log.ZDebug(ctx, "return")
+	var opt uploadOption
+	for _, o := range opts {
+		o(&opt)
+	}
+	alg, err := c.uploadHashAlgorithm(opt.hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, errors.New("invalid size")
+	}
+	if err := checkHash(alg, hash); err != nil {
+		return nil, err
+	}
+	var policyReq *UploadRequest
+	if c.policy != nil {
+		policyReq = &UploadRequest{
+			UserID:      mcontext.GetOpUserID(ctx),
+			Hash:        hash,
+			Size:        size,
+			ContentType: opt.contentType,
+		}
+		if err := c.policy.CheckInitiate(ctx, policyReq); err != nil {
+			return nil, err
+		}
+	}
+	hashKey := c.HashPathFor(alg, hash)
+	if info, err := c.StatObject(ctx, hashKey); err == nil {
+		c.emit(ctx, &Event{
+			Type:          EventUploadCompleted,
+			Key:           info.Key,
+			Hash:          hash,
+			HashAlgorithm: alg.Name(),
+			Size:          info.Size,
+			ContentType:   opt.contentType,
+			Existed:       true,
+		})
+		return &UploadResult{
+			Key:  info.Key,
+			Size: info.Size,
+			Hash: hash,
+		}, nil
+	} else if !c.IsNotFound(err) {
+		return nil, err
+	}
+	partSize, err := c.impl.PartSize(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	hasher := &partHasher{alg: alg, partSize: partSize}
+	counter := &countReader{r: io.TeeReader(io.LimitReader(reader, size), hasher)}
+	key := path.Join(tempPath, c.NowPath(), fmt.Sprintf("%s_%d_%s.upload", hash, size, c.UUID()))
+	if _, err := c.impl.PutObject(ctx, key, counter, size, &s3.PutObjectOption{ContentType: opt.contentType}); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = c.impl.DeleteObject(ctx, key)
+	}()
+	if counter.n != size {
+		return nil, errs.ErrArgs.WrapMsg("upload size mismatching", "size", size, "read", counter.n)
+	}
+	if sum := hashHex(alg, []byte(strings.Join(hasher.Sums(), partSeparator))); sum != hash {
+		return nil, errs.ErrArgs.WrapMsg(fmt.Sprintf("%s mismatching %s != %s", alg.Name(), sum, hash))
+	}
+	if policyReq != nil {
+		if err := c.policy.CheckComplete(ctx, policyReq); err != nil {
+			return nil, err
+		}
+	}
+	info, err := c.impl.CopyObject(ctx, key, hashKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cache.DelS3Key(ctx, c.impl.Engine(), info.Key); err != nil {
+		return nil, err
+	}
+	if policyReq != nil {
+		if err := c.policy.Completed(ctx, policyReq); err != nil {
+			log.ZWarn(ctx, "upload policy accounting failed", err, "key", info.Key)
+		}
+	}
+	c.emit(ctx, &Event{
+		Type:          EventUploadCompleted,
+		Key:           info.Key,
+		Hash:          hash,
+		HashAlgorithm: alg.Name(),
+		Size:          size,
+		ContentType:   opt.contentType,
+	})
+	return &UploadResult{
+		Key:  info.Key,
+		Size: size,
+		Hash: hash,
+	}, nil
+}
+
 func (c *Controller) AuthSign(ctx context.Context, uploadID string, partNumbers []int) (*s3.AuthSignResult, error) {
-	upload, err := parseMultipartUploadID(uploadID)
+	upload, err := c.parseMultipartUploadID(uploadID)
 	if err != nil {
 		return nil, err
 	}
@@ -280,6 +703,35 @@ func (c *Controller) FormData(ctx context.Context, name string, size int64, cont
 	return c.impl.FormData(ctx, name, size, contentType, duration)
 }
 
+// DeleteObject deletes name and tells the UploadPolicy, so that it releases the stored bytes.
 func (c *Controller) DeleteObject(ctx context.Context, name string) error {
-	return c.impl.DeleteObject(ctx, name)
+	var policyReq *UploadRequest
+	if c.policy != nil {
+		info, err := c.StatObject(ctx, name)
+		if err == nil {
+			policyReq = &UploadRequest{
+				UserID:      mcontext.GetOpUserID(ctx),
+				Size:        info.Size,
+				ContentType: info.ContentType,
+			}
+			if strings.HasPrefix(name, hashPath+"/") {
+				policyReq.Hash = path.Base(name)
+			}
+		} else if !c.IsNotFound(err) {
+			return err
+		}
+	}
+	if err := c.impl.DeleteObject(ctx, name); err != nil {
+		return err
+	}
+	if err := c.cache.DelS3Key(ctx, c.impl.Engine(), name); err != nil {
+		return err
+	}
+	if policyReq != nil {
+		if err := c.policy.Deleted(ctx, policyReq); err != nil {
+			log.ZWarn(ctx, "upload policy accounting failed", err, "key", name)
+		}
+	}
+	c.emit(ctx, &Event{Type: EventObjectDeleted, Key: name})
+	return nil
 }
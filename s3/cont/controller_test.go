@@ -0,0 +1,492 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/encrypted"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+// passCache is an S3Cache that always goes to the engine.
+type passCache struct {
+	impl s3.Interface
+}
+
+func (p passCache) GetKey(ctx context.Context, engine string, key string) (*s3.ObjectInfo, error) {
+	return p.impl.StatObject(ctx, key)
+}
+
+func (p passCache) DelS3Key(ctx context.Context, engine string, keys ...string) error {
+	return nil
+}
+
+// testUploadIDKey signs the upload IDs of the controllers built by newController.
+var testUploadIDKey = UploadIDKey{ID: "test", Secret: []byte("test secret")}
+
+// newController is New with testUploadIDKey in front of opts.
+func newController(cache S3Cache, impl s3.Interface, opts ...Option) *Controller {
+	c, err := New(cache, impl, append([]Option{WithUploadIDKeys(testUploadIDKey)}, opts...)...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func newTestController() (*Controller, *s3test.Engine) {
+	engine := s3test.NewEngine()
+	return newController(passCache{impl: engine}, engine), engine
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// presignedHash is the hash a client sends for a single-part upload of data.
+func presignedHash(data []byte) string {
+	return md5Hex([]byte(md5Hex(data)))
+}
+
+func initiatePresigned(t *testing.T, c *Controller, hash string, size int64) (*InitiateUploadResult, *multipartUploadID) {
+	t.Helper()
+	res, err := c.InitiateUpload(context.Background(), hash, size, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := c.parseMultipartUploadID(res.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Type != UploadTypePresigned {
+		t.Fatalf("expected presigned upload, got type %d", upload.Type)
+	}
+	return res, upload
+}
+
+func TestPresignedUpload(t *testing.T) {
+	ctx := context.Background()
+	c, engine := newTestController()
+	data := []byte("presigned upload")
+	hash := presignedHash(data)
+	res, upload := initiatePresigned(t, c, hash, int64(len(data)))
+	if res.Sign == nil || len(res.Sign.Parts) != 1 || res.Sign.Parts[0].URL == "" {
+		t.Fatalf("unexpected sign %+v", res.Sign)
+	}
+	engine.SetObject(upload.Key, data)
+	result, err := c.CompleteUpload(ctx, res.UploadID, []string{md5Hex(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Key != c.HashPath(hash) || result.Size != int64(len(data)) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if stored, ok := engine.Object(result.Key); !ok || !bytes.Equal(stored, data) {
+		t.Fatal("hash object not stored")
+	}
+	if keys := engine.Keys(); len(keys) != 1 {
+		t.Fatalf("temporary objects left behind: %v", keys)
+	}
+	if n := engine.CallCount(s3test.OpInitiateMultipartUpload); n != 0 {
+		t.Fatalf("presigned upload initiated %d multipart uploads", n)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	c, engine := newTestController()
+	partSize := c.PartLimit().MinPartSize
+	chunks := [][]byte{bytes.Repeat([]byte{'a'}, int(partSize)), []byte("tail")}
+	partHashs := []string{md5Hex(chunks[0]), md5Hex(chunks[1])}
+	hash := md5Hex([]byte(strings.Join(partHashs, partSeparator)))
+	res, err := c.InitiateUpload(ctx, hash, partSize+int64(len(chunks[1])), time.Minute, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PartSize != partSize || res.Sign == nil || len(res.Sign.Parts) != 2 {
+		t.Fatalf("unexpected initiate result %+v", res)
+	}
+	upload, err := c.parseMultipartUploadID(res.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Type != UploadTypeMultipart || upload.Key != c.HashPath(hash) {
+		t.Fatalf("unexpected upload id %+v", upload)
+	}
+	for i, chunk := range chunks {
+		if _, err := engine.UploadPart(upload.ID, upload.Key, i+1, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := c.CompleteUpload(ctx, res.UploadID, partHashs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Key != c.HashPath(hash) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if n := engine.CallCount(s3test.OpCompleteMultipartUpload); n != 1 {
+		t.Fatalf("expected one complete call, got %d", n)
+	}
+}
+
+func TestInitiateHashExists(t *testing.T) {
+	c, engine := newTestController()
+	data := []byte("exists")
+	hash := presignedHash(data)
+	engine.SetObject(c.HashPath(hash), data)
+	_, err := c.InitiateUpload(context.Background(), hash, int64(len(data)), time.Minute, 0)
+	var exists *HashAlreadyExistsError
+	if !errors.As(err, &exists) || exists.Object.Key != c.HashPath(hash) {
+		t.Fatalf("expected HashAlreadyExistsError, got %v", err)
+	}
+	if n := engine.CallCount(s3test.OpPresignedPutObject); n != 0 {
+		t.Fatalf("presigned url issued for an existing hash")
+	}
+}
+
+func TestInitiateEngineError(t *testing.T) {
+	c, engine := newTestController()
+	injected := errors.New("stat unavailable")
+	engine.SetFault(s3test.OpStatObject, s3test.Fault{Err: injected})
+	if _, err := c.InitiateUpload(context.Background(), presignedHash([]byte("x")), 1, time.Minute, 0); !errors.Is(err, injected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+}
+
+func TestCompleteSizeMismatch(t *testing.T) {
+	c, engine := newTestController()
+	data := []byte("size mismatch")
+	res, upload := initiatePresigned(t, c, presignedHash(data), int64(len(data))+1)
+	engine.SetObject(upload.Key, data)
+	_, err := c.CompleteUpload(context.Background(), res.UploadID, []string{md5Hex(data)})
+	if err == nil || !strings.Contains(err.Error(), "size mismatching") {
+		t.Fatalf("expected size mismatch, got %v", err)
+	}
+	if keys := engine.Keys(); len(keys) != 0 {
+		t.Fatalf("temporary object not cleaned: %v", keys)
+	}
+}
+
+func TestCompleteHashMismatch(t *testing.T) {
+	c, engine := newTestController()
+	data := []byte("hash mismatch")
+	res, upload := initiatePresigned(t, c, presignedHash(data), int64(len(data)))
+	engine.SetObject(upload.Key, data)
+	if _, err := c.CompleteUpload(context.Background(), res.UploadID, []string{md5Hex([]byte("other"))}); err == nil {
+		t.Fatal("expected md5 mismatch")
+	}
+	if n := engine.CallCount(s3test.OpCopyObject); n != 0 {
+		t.Fatalf("copied %d objects after a hash mismatch", n)
+	}
+}
+
+func TestCompleteCopyETagDrift(t *testing.T) {
+	c, engine := newTestController()
+	data := []byte("concurrent overwrite")
+	hash := presignedHash(data)
+	res, upload := initiatePresigned(t, c, hash, int64(len(data)))
+	engine.SetObject(upload.Key, data)
+	engine.SetFault(s3test.OpCopyObject, s3test.Fault{ETagDrift: true, Times: 1})
+	_, err := c.CompleteUpload(context.Background(), res.UploadID, []string{md5Hex(data)})
+	if err == nil || !strings.Contains(err.Error(), "[concurrency]") {
+		t.Fatalf("expected concurrency error, got %v", err)
+	}
+	if _, ok := engine.Object(c.HashPath(hash)); ok {
+		t.Fatal("hash object written despite etag drift")
+	}
+	if keys := engine.Keys(); len(keys) != 0 {
+		t.Fatalf("temporary objects not cleaned: %v", keys)
+	}
+}
+
+func TestSHA256PresignedUpload(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	c := newController(passCache{impl: engine}, engine, WithHashAlgorithm(SHA256))
+	data := []byte("sha256 presigned upload")
+	hash := sha256Hex([]byte(sha256Hex(data)))
+	if _, err := c.InitiateUpload(ctx, presignedHash(data), int64(len(data)), time.Minute, 0); err == nil {
+		t.Fatal("md5 sized hash accepted for sha256")
+	}
+	res, upload := initiatePresigned(t, c, hash, int64(len(data)))
+	if upload.Algorithm != HashSHA256 {
+		t.Fatalf("algorithm not recorded in upload id: %+v", upload)
+	}
+	engine.SetObject(upload.Key, data)
+	result, err := c.CompleteUpload(ctx, res.UploadID, []string{sha256Hex(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hashPath + HashSHA256 + "/" + hash; result.Key != want || c.HashPath(hash) != want {
+		t.Fatalf("unexpected key %s", result.Key)
+	}
+	if keys := engine.Keys(); len(keys) != 1 {
+		t.Fatalf("temporary objects left behind: %v", keys)
+	}
+}
+
+func TestSHA256PresignedPartCount(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	c := newController(passCache{impl: engine}, engine, WithHashAlgorithm(SHA256))
+	// The hash of no part hashes at all passes the whole-hash check.
+	hash := sha256Hex(nil)
+	res, upload := initiatePresigned(t, c, hash, 4)
+	engine.SetObject(upload.Key, []byte("data"))
+	if _, err := c.CompleteUpload(ctx, res.UploadID, nil); !errors.Is(err, errs.ErrArgs) {
+		t.Fatalf("upload completed without part hash: %v", err)
+	}
+	if _, err := c.InitiateUpload(ctx, strings.ToUpper(hash), 4, time.Minute, 0); err == nil {
+		t.Fatal("upper-case hash accepted")
+	}
+}
+
+func TestSHA256MultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	c, engine := newTestController()
+	partSize := c.PartLimit().MinPartSize
+	chunks := [][]byte{bytes.Repeat([]byte{'b'}, int(partSize)), []byte("tail")}
+	partHashs := []string{sha256Hex(chunks[0]), sha256Hex(chunks[1])}
+	hash := sha256Hex([]byte(strings.Join(partHashs, partSeparator)))
+	res, err := c.InitiateUpload(ctx, hash, partSize+int64(len(chunks[1])), time.Minute, -1, WithUploadHashAlgorithm(HashSHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := c.parseMultipartUploadID(res.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Type != UploadTypeMultipart || !strings.HasPrefix(upload.Key, tempPath) {
+		t.Fatalf("sha256 multipart upload not staged: %+v", upload)
+	}
+	for i, chunk := range chunks {
+		if _, err := engine.UploadPart(upload.ID, upload.Key, i+1, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := c.CompleteUpload(ctx, res.UploadID, partHashs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Key != c.HashPathFor(SHA256, hash) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	stored, ok := engine.Object(result.Key)
+	if !ok || !bytes.Equal(stored, bytes.Join(chunks, nil)) {
+		t.Fatal("hash object not stored")
+	}
+	if keys := engine.Keys(); len(keys) != 1 {
+		t.Fatalf("temporary objects left behind: %v", keys)
+	}
+}
+
+func TestSHA256PartMismatch(t *testing.T) {
+	ctx := context.Background()
+	c, engine := newTestController()
+	partSize := c.PartLimit().MinPartSize
+	chunks := [][]byte{bytes.Repeat([]byte{'c'}, int(partSize)), []byte("tail")}
+	// The client claims hashes of content it did not upload.
+	partHashs := []string{sha256Hex(chunks[0]), sha256Hex([]byte("liat"))}
+	hash := sha256Hex([]byte(strings.Join(partHashs, partSeparator)))
+	res, err := c.InitiateUpload(ctx, hash, partSize+int64(len(chunks[1])), time.Minute, -1, WithUploadHashAlgorithm(HashSHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := c.parseMultipartUploadID(res.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, chunk := range chunks {
+		if _, err := engine.UploadPart(upload.ID, upload.Key, i+1, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = c.CompleteUpload(ctx, res.UploadID, partHashs)
+	if err == nil || !strings.Contains(err.Error(), "part 2 sha256 mismatching") {
+		t.Fatalf("expected part mismatch, got %v", err)
+	}
+	if keys := engine.Keys(); len(keys) != 0 {
+		t.Fatalf("unverified objects left behind: %v", keys)
+	}
+}
+
+func TestLegacyMD5HashObject(t *testing.T) {
+	engine := s3test.NewEngine()
+	c := newController(passCache{impl: engine}, engine, WithHashAlgorithm(SHA256))
+	data := []byte("stored before sha256")
+	hash := presignedHash(data)
+	engine.SetObject(hashPath+hash, data)
+	info, err := c.GetHashObject(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != c.HashPathFor(MD5, hash) {
+		t.Fatalf("unexpected key %s", info.Key)
+	}
+	_, err = c.InitiateUpload(context.Background(), hash, int64(len(data)), time.Minute, 0, WithUploadHashAlgorithm(HashMD5))
+	var exists *HashAlreadyExistsError
+	if !errors.As(err, &exists) {
+		t.Fatalf("expected HashAlreadyExistsError, got %v", err)
+	}
+	if _, err := c.InitiateUpload(context.Background(), hash, 1, time.Minute, 0, WithUploadHashAlgorithm("crc32")); err == nil {
+		t.Fatal("unregistered algorithm accepted")
+	}
+}
+
+func TestSignedUploadID(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	oldKey := UploadIDKey{ID: "k1", Secret: []byte("first secret")}
+	newKey := UploadIDKey{ID: "k2", Secret: []byte("second secret")}
+	c := newController(passCache{impl: engine}, engine, WithUploadIDKeys(oldKey))
+	data := []byte("signed upload id")
+	res, upload := initiatePresigned(t, c, presignedHash(data), int64(len(data)))
+	if upload.KeyID != oldKey.ID || upload.Expire == 0 {
+		t.Fatalf("upload id not signed: %+v", upload)
+	}
+
+	payload, sign, _ := strings.Cut(res.UploadID, uploadIDSignSeparator)
+	forge := func(edit func(id *multipartUploadID)) string {
+		forged := *upload
+		edit(&forged)
+		forgedData, err := json.Marshal(forged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(forgedData)
+	}
+	for name, id := range map[string]string{
+		"forged":    forge(func(id *multipartUploadID) { id.Key = "openim/data/hash/victim" }) + uploadIDSignSeparator + sign,
+		"unsigned":  payload,
+		"no expiry": forge(func(id *multipartUploadID) { id.Expire = 0 }) + uploadIDSignSeparator + sign,
+		"garbage":   "!" + res.UploadID,
+	} {
+		if _, err := c.CompleteUpload(ctx, id, []string{md5Hex(data)}); !errs.ErrUploadIDInvalid.Is(err) {
+			t.Fatalf("%s: expected ErrUploadIDInvalid, got %v", name, err)
+		}
+	}
+
+	rotated := newController(passCache{impl: engine}, engine, WithUploadIDKeys(newKey, oldKey))
+	if _, err := rotated.parseMultipartUploadID(res.UploadID); err != nil {
+		t.Fatalf("rotated key set rejected an id signed by the old key: %v", err)
+	}
+	retired := newController(passCache{impl: engine}, engine, WithUploadIDKeys(newKey))
+	if _, err := retired.parseMultipartUploadID(res.UploadID); !errs.ErrUploadIDInvalid.Is(err) {
+		t.Fatalf("expected ErrUploadIDInvalid for a retired key, got %v", err)
+	}
+
+	// Without keys New fails, unless unsigned IDs are explicitly allowed.
+	for _, opts := range [][]Option{nil, {WithUploadIDKeys(UploadIDKey{ID: "empty"})}} {
+		if _, err := New(passCache{impl: engine}, engine, opts...); !errs.ErrArgs.Is(err) {
+			t.Fatalf("expected ErrArgs, got %v", err)
+		}
+	}
+	unsigned, err := New(passCache{impl: engine}, engine, WithUnsignedUploadIDs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsignedRes, _ := initiatePresigned(t, unsigned, presignedHash(data), int64(len(data)))
+	if strings.Contains(unsignedRes.UploadID, uploadIDSignSeparator) {
+		t.Fatalf("unexpected signature %s", unsignedRes.UploadID)
+	}
+	if _, err := c.parseMultipartUploadID(unsignedRes.UploadID); !errs.ErrUploadIDInvalid.Is(err) {
+		t.Fatalf("expected ErrUploadIDInvalid, got %v", err)
+	}
+	// During a key rollout the unsigned IDs issued before still complete.
+	rollout := newController(passCache{impl: engine}, engine, WithUploadIDKeys(newKey), WithUnsignedUploadIDs())
+	if _, err := rollout.parseMultipartUploadID(unsignedRes.UploadID); err != nil {
+		t.Fatalf("unsigned id rejected during rollout: %v", err)
+	}
+	if _, err := rollout.parseMultipartUploadID(res.UploadID); !errs.ErrUploadIDInvalid.Is(err) {
+		t.Fatalf("expected ErrUploadIDInvalid for an unknown key, got %v", err)
+	}
+
+	c.now = func() time.Time { return time.Now().Add(defaultUploadIDTTL + time.Minute) }
+	if _, err := c.CompleteUpload(ctx, res.UploadID, []string{md5Hex(data)}); !errs.ErrUploadIDExpired.Is(err) {
+		t.Fatalf("expected ErrUploadIDExpired, got %v", err)
+	}
+}
+
+func TestUploadObjectEncrypted(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	master, err := encrypted.NewAESKey("master", bytes.Repeat([]byte{'k'}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := encrypted.New(engine, master)
+	c := newController(passCache{impl: impl}, impl)
+	partSize := c.PartLimit().MinPartSize
+	data := append(bytes.Repeat([]byte{'e'}, int(partSize)), []byte("encrypted tail")...)
+	hash := md5Hex([]byte(md5Hex(data[:partSize]) + partSeparator + md5Hex(data[partSize:])))
+
+	if _, err := c.UploadObject(ctx, presignedHash(data), int64(len(data)), bytes.NewReader(data)); !errs.ErrArgs.Is(err) {
+		t.Fatalf("expected hash mismatch, got %v", err)
+	}
+	result, err := c.UploadObject(ctx, hash, int64(len(data)), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Key != c.HashPath(hash) || result.Size != int64(len(data)) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if keys := engine.Keys(); len(keys) != 1 {
+		t.Fatalf("temporary objects left behind: %v", keys)
+	}
+	stored, _ := engine.Object(result.Key)
+	if bytes.Contains(stored, data[partSize:]) {
+		t.Fatal("plaintext stored")
+	}
+	reader, info, err := impl.GetObject(ctx, result.Key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if got, err := io.ReadAll(reader); err != nil || !bytes.Equal(got, data) || info.Size != int64(len(data)) {
+		t.Fatalf("content mismatch: %v", err)
+	}
+
+	engine.ResetCalls()
+	again, err := c.UploadObject(ctx, hash, int64(len(data)), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Key != result.Key || again.Size != result.Size {
+		t.Fatalf("unexpected dedup result %+v", again)
+	}
+	if n := engine.CallCount(s3test.OpPutObject); n != 0 {
+		t.Fatalf("existing content uploaded %d times", n)
+	}
+}
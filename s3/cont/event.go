@@ -0,0 +1,174 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"github.com/amazing-socrates/next-tools/mcontext"
+	"github.com/amazing-socrates/next-tools/mq/memamq"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// EventType names what happened to an object.
+type EventType string
+
+const (
+	// EventUploadCompleted follows a successful CompleteUpload or UploadObject.
+	EventUploadCompleted EventType = "upload.completed"
+	// EventObjectDeleted follows a successful DeleteObject.
+	EventObjectDeleted EventType = "object.deleted"
+)
+
+// Event describes an operation of the Controller after it succeeded.
+type Event struct {
+	Type   EventType `json:"type"`
+	Engine string    `json:"engine"`
+	Key    string    `json:"key"`
+	// Hash, HashAlgorithm, Size and ContentType are only set for uploads.
+	Hash          string `json:"hash,omitempty"`
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+	Size          int64  `json:"size,omitempty"`
+	ContentType   string `json:"contentType,omitempty"`
+	// Existed is set when the upload completed because the content was already stored.
+	Existed bool `json:"existed,omitempty"`
+	// Operator is the op user ID of the request, see mcontext.GetOpUserID.
+	Operator    string    `json:"operator,omitempty"`
+	OperationID string    `json:"operationID,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// EventSink receives the events of a Controller. Emit is called before the operation
+// returns; its error is logged and does not fail the operation. Sinks that reach the
+// network should be wrapped by NewMemoryQueueEventSink to keep them off the request.
+type EventSink interface {
+	Emit(ctx context.Context, event *Event) error
+}
+
+// EventSinkFunc adapts a function to an EventSink.
+type EventSinkFunc func(ctx context.Context, event *Event) error
+
+func (f EventSinkFunc) Emit(ctx context.Context, event *Event) error {
+	return f(ctx, event)
+}
+
+// WithEventSinks sends the events of the Controller to every sink, in order.
+func WithEventSinks(sinks ...EventSink) Option {
+	return func(c *Controller) {
+		c.sinks = append(c.sinks, sinks...)
+	}
+}
+
+// emit completes event from ctx and hands it to the sinks.
+func (c *Controller) emit(ctx context.Context, event *Event) {
+	if len(c.sinks) == 0 {
+		return
+	}
+	event.Engine = c.impl.Engine()
+	event.Operator = mcontext.GetOpUserID(ctx)
+	event.OperationID = mcontext.GetOperationID(ctx)
+	event.Time = c.now()
+	for _, sink := range c.sinks {
+		if err := sink.Emit(ctx, event); err != nil {
+			log.ZWarn(ctx, "s3 event sink failed", err, "type", event.Type, "key", event.Key)
+		}
+	}
+}
+
+// NewMemoryQueueEventSink hands every event to sink on a worker of queue, so that slow
+// sinks do not delay the operations. Emit fails without waiting when the queue is full
+// or stopped. sink sees the context of the operation without its cancellation.
+func NewMemoryQueueEventSink(queue *memamq.MemoryQueue, sink EventSink) EventSink {
+	return &memoryQueueEventSink{queue: queue, sink: sink}
+}
+
+type memoryQueueEventSink struct {
+	queue *memamq.MemoryQueue
+	sink  EventSink
+}
+
+func (m *memoryQueueEventSink) Emit(ctx context.Context, event *Event) error {
+	ctx = context.WithoutCancel(ctx)
+	copied := *event
+	err := m.queue.NotWaitPush(func() {
+		if err := m.sink.Emit(ctx, &copied); err != nil {
+			log.ZWarn(ctx, "s3 queued event sink failed", err, "type", copied.Type, "key", copied.Key)
+		}
+	})
+	if err != nil {
+		return errs.WrapMsg(err, "queue s3 event failed", "type", event.Type, "key", event.Key)
+	}
+	return nil
+}
+
+// KafkaProducer sends protobuf messages; *kafka.Producer of mq/kafka implements it.
+type KafkaProducer interface {
+	SendMessage(ctx context.Context, key string, msg proto.Message) (int32, int64, error)
+}
+
+// NewKafkaEventSink sends every event to the topic of producer as a structpb.Struct holding
+// the JSON fields of the Event, keyed by the object key so that the events of an object
+// stay in order.
+func NewKafkaEventSink(producer KafkaProducer) EventSink {
+	return &kafkaEventSink{producer: producer}
+}
+
+type kafkaEventSink struct {
+	producer KafkaProducer
+}
+
+func (k *kafkaEventSink) Emit(ctx context.Context, event *Event) error {
+	msg, err := EventStruct(event)
+	if err != nil {
+		return err
+	}
+	_, _, err = k.producer.SendMessage(ctx, event.Key, msg)
+	return err
+}
+
+// EventStruct converts event to the message NewKafkaEventSink sends.
+func EventStruct(event *Event) (*structpb.Struct, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	msg, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, errs.WrapMsg(err, "convert s3 event failed", "type", event.Type, "key", event.Key)
+	}
+	return msg, nil
+}
+
+// ParseEventStruct is the inverse of EventStruct, for the consumers of NewKafkaEventSink.
+func ParseEventStruct(msg *structpb.Struct) (*Event, error) {
+	data, err := json.Marshal(msg.AsMap())
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, errs.WrapMsg(err, "decode s3 event failed")
+	}
+	return &event, nil
+}
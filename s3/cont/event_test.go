@@ -0,0 +1,152 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/mcontext"
+	"github.com/amazing-socrates/next-tools/mq/kafka"
+	"github.com/amazing-socrates/next-tools/mq/memamq"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var _ KafkaProducer = (*kafka.Producer)(nil)
+
+type recordSink struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func (r *recordSink) Emit(ctx context.Context, event *Event) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *recordSink) Events() []Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+func TestControllerEvents(t *testing.T) {
+	ctx := mcontext.WithOpUserIDContext(mcontext.NewCtx("op"), "user")
+	engine := s3test.NewEngine()
+	sink := &recordSink{}
+	failing := EventSinkFunc(func(ctx context.Context, event *Event) error {
+		return errors.New("sink down")
+	})
+	c := newController(passCache{impl: engine}, engine, WithEventSinks(failing, sink))
+	data := []byte("evented")
+	hash := md5Hex([]byte(md5Hex(data)))
+	for i := 0; i < 2; i++ {
+		if _, err := c.UploadObject(ctx, hash, int64(len(data)), bytes.NewReader(data), WithUploadContentType("text/plain")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.DeleteObject(ctx, c.HashPath(hash)); err != nil {
+		t.Fatal(err)
+	}
+	events := sink.Events()
+	if len(events) != 3 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	want := Event{
+		Type:          EventUploadCompleted,
+		Engine:        engine.Engine(),
+		Key:           c.HashPath(hash),
+		Hash:          hash,
+		HashAlgorithm: MD5.Name(),
+		Size:          int64(len(data)),
+		ContentType:   "text/plain",
+		Operator:      "user",
+		OperationID:   "op",
+	}
+	for i, event := range events[:2] {
+		want.Existed = i == 1
+		want.Time = event.Time
+		if event != want || event.Time.IsZero() {
+			t.Fatalf("unexpected event %d %+v", i, event)
+		}
+	}
+	if deleted := events[2]; deleted.Type != EventObjectDeleted || deleted.Key != want.Key || deleted.Operator != "user" {
+		t.Fatalf("unexpected delete event %+v", deleted)
+	}
+}
+
+type fakeProducer struct {
+	key string
+	msg proto.Message
+}
+
+func (f *fakeProducer) SendMessage(ctx context.Context, key string, msg proto.Message) (int32, int64, error) {
+	f.key, f.msg = key, msg
+	return 0, 0, nil
+}
+
+func TestKafkaEventSink(t *testing.T) {
+	producer := &fakeProducer{}
+	event := &Event{Type: EventUploadCompleted, Engine: "minio", Key: "openim/data/hash/x", Hash: "x", Size: 1 << 40, Time: time.Unix(1700000000, 0).UTC()}
+	if err := NewKafkaEventSink(producer).Emit(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if producer.key != event.Key {
+		t.Fatalf("unexpected message key %s", producer.key)
+	}
+	parsed, err := ParseEventStruct(producer.msg.(*structpb.Struct))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *event {
+		t.Fatalf("event changed in transit: %+v", parsed)
+	}
+}
+
+func TestMemoryQueueEventSink(t *testing.T) {
+	queue := memamq.NewMemoryQueue(1, 1)
+	defer queue.Stop()
+	ctx, cancel := context.WithCancel(mcontext.NewCtx("queued"))
+	done := make(chan *Event, 1)
+	sink := NewMemoryQueueEventSink(queue, EventSinkFunc(func(ctx context.Context, event *Event) error {
+		if ctx.Err() != nil || mcontext.GetOperationID(ctx) != "queued" {
+			t.Error("queued sink lost the context values or got its cancellation")
+		}
+		done <- event
+		return nil
+	}))
+	event := &Event{Type: EventObjectDeleted, Key: "a"}
+	if err := sink.Emit(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	event.Key = "mutated"
+	select {
+	case got := <-done:
+		if got.Key != "a" {
+			t.Fatalf("queued event shared with the caller: %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+}
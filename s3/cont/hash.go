@@ -0,0 +1,176 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	HashMD5    = "md5"
+	HashSHA256 = "sha256"
+)
+
+// HashAlgorithm identifies uploaded content. Name ends up in upload IDs and in the
+// hash path of every algorithm except MD5, so it must be stable and path safe.
+type HashAlgorithm interface {
+	Name() string
+	// Size is the digest length in bytes.
+	Size() int
+	New() hash.Hash
+}
+
+type hashAlgorithm struct {
+	name string
+	size int
+	new  func() hash.Hash
+}
+
+func (h hashAlgorithm) Name() string {
+	return h.name
+}
+
+func (h hashAlgorithm) Size() int {
+	return h.size
+}
+
+func (h hashAlgorithm) New() hash.Hash {
+	return h.new()
+}
+
+// NewHashAlgorithm adapts a hash constructor such as sha512.New or a BLAKE3 implementation.
+func NewHashAlgorithm(name string, size int, fn func() hash.Hash) HashAlgorithm {
+	return hashAlgorithm{name: name, size: size, new: fn}
+}
+
+var (
+	MD5    = NewHashAlgorithm(HashMD5, md5.Size, md5.New)
+	SHA256 = NewHashAlgorithm(HashSHA256, sha256.Size, sha256.New)
+)
+
+var hashAlgorithms = struct {
+	lock sync.RWMutex
+	m    map[string]HashAlgorithm
+}{
+	m: map[string]HashAlgorithm{
+		HashMD5:    MD5,
+		HashSHA256: SHA256,
+	},
+}
+
+// RegisterHashAlgorithm makes alg available to upload IDs by name, replacing any previous registration.
+func RegisterHashAlgorithm(alg HashAlgorithm) {
+	name := alg.Name()
+	if name == "" || strings.ContainsAny(name, `/\.`) {
+		panic(fmt.Sprintf("invalid hash algorithm name %q", name))
+	}
+	hashAlgorithms.lock.Lock()
+	defer hashAlgorithms.lock.Unlock()
+	hashAlgorithms.m[name] = alg
+}
+
+// GetHashAlgorithm looks up a registered algorithm; an empty name is MD5, as in upload IDs issued before algorithms were pluggable.
+func GetHashAlgorithm(name string) (HashAlgorithm, bool) {
+	if name == "" {
+		return MD5, true
+	}
+	hashAlgorithms.lock.RLock()
+	defer hashAlgorithms.lock.RUnlock()
+	alg, ok := hashAlgorithms.m[name]
+	return alg, ok
+}
+
+func isMD5(alg HashAlgorithm) bool {
+	return alg.Name() == HashMD5
+}
+
+func hashHex(alg HashAlgorithm, data []byte) string {
+	h := alg.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkHash validates that hash is the lower-case hex digest length of alg.
+func checkHash(alg HashAlgorithm, hash string) error {
+	if hash != strings.ToLower(hash) {
+		return fmt.Errorf("invalid %s, not lower-case hex", alg.Name())
+	}
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil {
+		return err
+	}
+	if len(hashBytes) != alg.Size() {
+		return fmt.Errorf("invalid %s", alg.Name())
+	}
+	return nil
+}
+
+// partHasher hashes what is written to it in consecutive parts of partSize bytes.
+type partHasher struct {
+	alg      HashAlgorithm
+	partSize int64
+	current  hash.Hash
+	written  int64
+	sums     []string
+}
+
+func (h *partHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if h.current == nil {
+			h.current = h.alg.New()
+			h.written = 0
+		}
+		chunk := p[:min(int64(len(p)), h.partSize-h.written)]
+		h.current.Write(chunk)
+		h.written += int64(len(chunk))
+		p = p[len(chunk):]
+		if h.written == h.partSize {
+			h.sums = append(h.sums, hex.EncodeToString(h.current.Sum(nil)))
+			h.current = nil
+		}
+	}
+	return n, nil
+}
+
+// Sums returns the hex digest of every part; empty content is a single empty part.
+func (h *partHasher) Sums() []string {
+	if h.current != nil || len(h.sums) == 0 {
+		if h.current == nil {
+			h.current = h.alg.New()
+		}
+		h.sums = append(h.sums, hex.EncodeToString(h.current.Sum(nil)))
+		h.current = nil
+	}
+	return h.sums
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
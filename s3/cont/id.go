@@ -15,9 +15,26 @@
 package cont
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+// UploadIDKey is one HMAC key of the set that signs upload IDs. ID is embedded in every
+// signed upload ID so that keys can be rotated without invalidating uploads in flight.
+type UploadIDKey struct {
+	ID     string
+	Secret []byte
+}
+
+const (
+	uploadIDSignSeparator = "."
+	defaultUploadIDTTL    = time.Hour * 24
 )
 
 type multipartUploadID struct {
@@ -26,24 +43,85 @@ type multipartUploadID struct {
 	Key  string `json:"c,omitempty"`
 	Size int64  `json:"d,omitempty"`
 	Hash string `json:"e,omitempty"`
+	// Algorithm is the HashAlgorithm name, empty for MD5 as in IDs issued before it existed.
+	Algorithm string `json:"f,omitempty"`
+	// Expire is the unix time in seconds after which the ID is rejected.
+	Expire int64 `json:"g,omitempty"`
+	// KeyID names the UploadIDKey that signed the ID.
+	KeyID string `json:"h,omitempty"`
+	// ContentType is the type declared with WithUploadContentType.
+	ContentType string `json:"i,omitempty"`
 }
 
-func newMultipartUploadID(id multipartUploadID) string {
+func signUploadID(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newMultipartUploadID encodes id as base64 JSON signed by the first upload ID key, the
+// signature appended after a dot. Without keys the ID is left unsigned.
+func (c *Controller) newMultipartUploadID(id multipartUploadID) string {
+	if c.uploadIDTTL > 0 {
+		id.Expire = c.now().Add(c.uploadIDTTL).Unix()
+	}
+	if len(c.uploadIDKeys) > 0 {
+		id.KeyID = c.uploadIDKeys[0].ID
+	}
 	data, err := json.Marshal(id)
 	if err != nil {
 		panic(err)
 	}
-	return base64.StdEncoding.EncodeToString(data)
+	payload := base64.StdEncoding.EncodeToString(data)
+	if len(c.uploadIDKeys) == 0 {
+		return payload
+	}
+	return payload + uploadIDSignSeparator + signUploadID(c.uploadIDKeys[0].Secret, payload)
 }
 
-func parseMultipartUploadID(id string) (*multipartUploadID, error) {
-	data, err := base64.StdEncoding.DecodeString(id)
+// parseMultipartUploadID decodes an ID issued by newMultipartUploadID. IDs signed by an
+// unknown key are rejected as forged, and so are unsigned IDs unless WithUnsignedUploadIDs
+// allows them.
+func (c *Controller) parseMultipartUploadID(id string) (*multipartUploadID, error) {
+	payload, sign, signed := strings.Cut(id, uploadIDSignSeparator)
+	data, err := base64.StdEncoding.DecodeString(payload)
 	if err != nil {
-		return nil, fmt.Errorf("invalid multipart upload id: %w", err)
+		return nil, errs.ErrUploadIDInvalid.WrapMsg("invalid multipart upload id: " + err.Error())
 	}
 	var upload multipartUploadID
 	if err := json.Unmarshal(data, &upload); err != nil {
-		return nil, fmt.Errorf("invalid multipart upload id: %w", err)
+		return nil, errs.ErrUploadIDInvalid.WrapMsg("invalid multipart upload id: " + err.Error())
+	}
+	if !signed {
+		if !c.unsignedUploadIDs {
+			return nil, errs.ErrUploadIDInvalid.WrapMsg("unsigned multipart upload id")
+		}
+		if upload.Expire > 0 && c.now().Unix() > upload.Expire {
+			return nil, errs.ErrUploadIDExpired.WrapMsg("multipart upload id expired", "expire", upload.Expire)
+		}
+		return &upload, nil
+	}
+	key, ok := c.uploadIDKey(upload.KeyID)
+	if !ok {
+		return nil, errs.ErrUploadIDInvalid.WrapMsg("unknown multipart upload id key", "keyID", upload.KeyID)
+	}
+	if !hmac.Equal([]byte(sign), []byte(signUploadID(key.Secret, payload))) {
+		return nil, errs.ErrUploadIDInvalid.WrapMsg("multipart upload id signature mismatch", "keyID", upload.KeyID)
+	}
+	if upload.Expire == 0 && c.uploadIDTTL > 0 {
+		return nil, errs.ErrUploadIDInvalid.WrapMsg("multipart upload id without expiry")
+	}
+	if upload.Expire > 0 && c.now().Unix() > upload.Expire {
+		return nil, errs.ErrUploadIDExpired.WrapMsg("multipart upload id expired", "expire", upload.Expire)
 	}
 	return &upload, nil
 }
+
+func (c *Controller) uploadIDKey(id string) (UploadIDKey, bool) {
+	for _, key := range c.uploadIDKeys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return UploadIDKey{}, false
+}
@@ -0,0 +1,194 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"context"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/log"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+const defaultJanitorTTL = time.Hour * 24
+
+type JanitorOption func(*Janitor)
+
+// WithJanitorTTL sets how old a temp object or multipart upload must be before it is removed.
+func WithJanitorTTL(ttl time.Duration) JanitorOption {
+	return func(j *Janitor) {
+		j.ttl = ttl
+	}
+}
+
+// WithJanitorDryRun reports what would be removed without deleting or aborting anything.
+func WithJanitorDryRun(dryRun bool) JanitorOption {
+	return func(j *Janitor) {
+		j.dryRun = dryRun
+	}
+}
+
+// WithJanitorObjectPrefixes replaces the prefixes scanned for abandoned objects, tempPath by default.
+func WithJanitorObjectPrefixes(prefixes ...string) JanitorOption {
+	return func(j *Janitor) {
+		j.objectPrefixes = prefixes
+	}
+}
+
+// WithJanitorUploadPrefixes replaces the prefixes scanned for stale multipart uploads,
+// hashPath and tempPath by default.
+func WithJanitorUploadPrefixes(prefixes ...string) JanitorOption {
+	return func(j *Janitor) {
+		j.uploadPrefixes = prefixes
+	}
+}
+
+// WithJanitorPageSize sets how many entries are requested per listing call.
+func WithJanitorPageSize(size int) JanitorOption {
+	return func(j *Janitor) {
+		j.pageSize = size
+	}
+}
+
+// WithJanitorClock replaces time.Now as the reference for ages.
+func WithJanitorClock(now func() time.Time) JanitorOption {
+	return func(j *Janitor) {
+		j.now = now
+	}
+}
+
+// Janitor removes what clients leave behind when they never call CompleteUpload:
+// presigned objects under the temp path and multipart upload sessions.
+type Janitor struct {
+	impl           s3.Interface
+	ttl            time.Duration
+	dryRun         bool
+	objectPrefixes []string
+	uploadPrefixes []string
+	pageSize       int
+	now            func() time.Time
+}
+
+func NewJanitor(impl s3.Interface, opts ...JanitorOption) *Janitor {
+	j := &Janitor{
+		impl:           impl,
+		ttl:            defaultJanitorTTL,
+		objectPrefixes: []string{tempPath},
+		uploadPrefixes: []string{hashPath, tempPath},
+		now:            time.Now,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// JanitorFailure is one object or upload the janitor could not remove.
+type JanitorFailure struct {
+	Key      string `json:"key"`
+	UploadID string `json:"uploadID,omitempty"`
+	Err      error  `json:"-"`
+}
+
+// JanitorReport describes one Run. In dry-run mode DeletedObjects and AbortedUploads
+// list what would have been removed.
+type JanitorReport struct {
+	DryRun         bool                 `json:"dryRun"`
+	StartTime      time.Time            `json:"startTime"`
+	EndTime        time.Time            `json:"endTime"`
+	Deadline       time.Time            `json:"deadline"`
+	ScannedObjects int                  `json:"scannedObjects"`
+	DeletedObjects []string             `json:"deletedObjects"`
+	DeletedBytes   int64                `json:"deletedBytes"`
+	ScannedUploads int                  `json:"scannedUploads"`
+	AbortedUploads []s3.MultipartUpload `json:"abortedUploads"`
+	Failures       []JanitorFailure     `json:"failures"`
+}
+
+// Run performs one sweep. Failures to delete single entries are recorded in the
+// report; a listing error stops the sweep and is returned with the partial report.
+func (j *Janitor) Run(ctx context.Context) (*JanitorReport, error) {
+	report := &JanitorReport{
+		DryRun:    j.dryRun,
+		StartTime: j.now(),
+	}
+	report.Deadline = report.StartTime.Add(-j.ttl)
+	defer func() {
+		report.EndTime = j.now()
+		log.ZInfo(ctx, "s3 janitor run", "dryRun", report.DryRun, "scannedObjects", report.ScannedObjects,
+			"deletedObjects", len(report.DeletedObjects), "scannedUploads", report.ScannedUploads,
+			"abortedUploads", len(report.AbortedUploads), "failures", len(report.Failures))
+	}()
+	for _, prefix := range j.objectPrefixes {
+		if err := j.sweepObjects(ctx, prefix, report); err != nil {
+			return report, err
+		}
+	}
+	for _, prefix := range j.uploadPrefixes {
+		if err := j.sweepUploads(ctx, prefix, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func (j *Janitor) sweepObjects(ctx context.Context, prefix string, report *JanitorReport) error {
+	it := s3.NewObjectIterator(j.impl, prefix, j.pageSize)
+	for it.Next(ctx) {
+		info := it.Object()
+		report.ScannedObjects++
+		if !info.LastModified.Before(report.Deadline) {
+			continue
+		}
+		if !j.dryRun {
+			if err := j.impl.DeleteObject(ctx, info.Key); err != nil && !j.impl.IsNotFound(err) {
+				log.ZWarn(ctx, "s3 janitor delete object failed", err, "key", info.Key)
+				report.Failures = append(report.Failures, JanitorFailure{Key: info.Key, Err: err})
+				continue
+			}
+		}
+		report.DeletedObjects = append(report.DeletedObjects, info.Key)
+		report.DeletedBytes += info.Size
+	}
+	return it.Err()
+}
+
+func (j *Janitor) sweepUploads(ctx context.Context, prefix string, report *JanitorReport) error {
+	var keyMarker, uploadIDMarker string
+	for {
+		res, err := j.impl.ListMultipartUploads(ctx, prefix, keyMarker, uploadIDMarker, j.pageSize)
+		if err != nil {
+			return err
+		}
+		for _, upload := range res.Uploads {
+			report.ScannedUploads++
+			if upload.Initiated.IsZero() || !upload.Initiated.Before(report.Deadline) {
+				continue
+			}
+			if !j.dryRun {
+				if err := j.impl.AbortMultipartUpload(ctx, upload.UploadID, upload.Key); err != nil && !j.impl.IsNotFound(err) {
+					log.ZWarn(ctx, "s3 janitor abort upload failed", err, "key", upload.Key, "uploadID", upload.UploadID)
+					report.Failures = append(report.Failures, JanitorFailure{Key: upload.Key, UploadID: upload.UploadID, Err: err})
+					continue
+				}
+			}
+			report.AbortedUploads = append(report.AbortedUploads, upload)
+		}
+		if !res.IsTruncated || (res.NextKeyMarker == "" && res.NextUploadIDMarker == "") {
+			return nil
+		}
+		keyMarker, uploadIDMarker = res.NextKeyMarker, res.NextUploadIDMarker
+	}
+}
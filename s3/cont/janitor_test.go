@@ -0,0 +1,105 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+func newStaleEngine(t *testing.T) (*s3test.Engine, time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	engine.SetClock(func() time.Time { return now.Add(-time.Hour * 48) })
+	engine.SetObject(tempPath+"2024/05/30/old.presigned", []byte("old"))
+	if _, err := engine.InitiateMultipartUpload(ctx, hashPath+"old"); err != nil {
+		t.Fatal(err)
+	}
+	engine.SetClock(func() time.Time { return now.Add(-time.Minute) })
+	engine.SetObject(tempPath+"2024/06/01/new.presigned", []byte("new"))
+	engine.SetObject(hashPath+"kept", []byte("kept"))
+	if _, err := engine.InitiateMultipartUpload(ctx, hashPath+"new"); err != nil {
+		t.Fatal(err)
+	}
+	return engine, now
+}
+
+func TestJanitorRun(t *testing.T) {
+	engine, now := newStaleEngine(t)
+	janitor := NewJanitor(engine, WithJanitorTTL(time.Hour*24), WithJanitorPageSize(1), WithJanitorClock(func() time.Time { return now }))
+	report, err := janitor.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ScannedObjects != 2 || len(report.DeletedObjects) != 1 || report.DeletedObjects[0] != tempPath+"2024/05/30/old.presigned" || report.DeletedBytes != 3 {
+		t.Fatalf("unexpected object report %+v", report)
+	}
+	if report.ScannedUploads != 2 || len(report.AbortedUploads) != 1 || report.AbortedUploads[0].Key != hashPath+"old" {
+		t.Fatalf("unexpected upload report %+v", report)
+	}
+	if keys := engine.Keys(); len(keys) != 2 {
+		t.Fatalf("unexpected remaining objects %v", keys)
+	}
+	res, err := engine.ListMultipartUploads(context.Background(), "", "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Uploads) != 1 || res.Uploads[0].Key != hashPath+"new" {
+		t.Fatalf("unexpected remaining uploads %+v", res.Uploads)
+	}
+}
+
+func TestJanitorDryRun(t *testing.T) {
+	engine, now := newStaleEngine(t)
+	janitor := NewJanitor(engine, WithJanitorDryRun(true), WithJanitorClock(func() time.Time { return now }))
+	report, err := janitor.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || len(report.DeletedObjects) != 1 || len(report.AbortedUploads) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if n := engine.CallCount(s3test.OpDeleteObject) + engine.CallCount(s3test.OpAbortMultipartUpload); n != 0 {
+		t.Fatalf("dry run removed %d entries", n)
+	}
+}
+
+func TestJanitorFailures(t *testing.T) {
+	engine, now := newStaleEngine(t)
+	injected := errors.New("delete denied")
+	engine.SetFault(s3test.OpDeleteObject, s3test.Fault{Err: injected})
+	janitor := NewJanitor(engine, WithJanitorClock(func() time.Time { return now }))
+	report, err := janitor.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failures) != 1 || !errors.Is(report.Failures[0].Err, injected) || len(report.DeletedObjects) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.AbortedUploads) != 1 {
+		t.Fatal("a delete failure stopped the upload sweep")
+	}
+	listErr := errors.New("list denied")
+	engine.SetFault(s3test.OpListMultipartUploads, s3test.Fault{Err: listErr})
+	if _, err := janitor.Run(context.Background()); !errors.Is(err, listErr) {
+		t.Fatalf("expected list error, got %v", err)
+	}
+}
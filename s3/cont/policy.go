@@ -0,0 +1,303 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+// UploadRequest describes the upload an UploadPolicy is asked about.
+// UserID is the operator taken from mcontext.GetOpUserID.
+type UploadRequest struct {
+	UserID      string
+	Hash        string
+	Size        int64
+	ContentType string
+}
+
+// UploadPolicy decides whether a user may upload. CheckInitiate runs before InitiateUpload
+// touches the storage and CheckComplete before CompleteUpload stores new content;
+// Completed is told about every upload that did store new content, and Deleted about
+// every object DeleteObject removed, with the operator of the delete as UserID.
+type UploadPolicy interface {
+	CheckInitiate(ctx context.Context, req *UploadRequest) error
+	CheckComplete(ctx context.Context, req *UploadRequest) error
+	Completed(ctx context.Context, req *UploadRequest) error
+	Deleted(ctx context.Context, req *UploadRequest) error
+}
+
+// UsageStore keeps the counters QuotaPolicy enforces limits with, so that they can live
+// in Mongo, Redis or memory. A subject is a user or tenant key built by QuotaPolicy.
+type UsageStore interface {
+	// StoredBytes returns the bytes accounted to subject.
+	StoredBytes(ctx context.Context, subject string) (int64, error)
+	// AddStoredBytes adjusts the bytes accounted to subject; delta may be negative.
+	AddStoredBytes(ctx context.Context, subject string, delta int64) error
+	// Uploads returns the uploads counted in the window starting at windowStart.
+	Uploads(ctx context.Context, subject string, windowStart time.Time) (int64, error)
+	// IncrUploads counts one upload in the window starting at windowStart and returns
+	// the window total. Windows that have ended may be dropped.
+	IncrUploads(ctx context.Context, subject string, windowStart time.Time, window time.Duration) (int64, error)
+}
+
+// UploadLimit is a set of limits; a zero field is unlimited.
+type UploadLimit struct {
+	MaxFileSize    int64
+	MaxStoredBytes int64
+	// ContentTypes lists allowed content types, "image/*" matches a whole family.
+	ContentTypes []string
+	// MaxUploads is the number of InitiateUpload calls allowed per RateWindow.
+	MaxUploads int64
+	RateWindow time.Duration
+}
+
+type QuotaOption func(*QuotaPolicy)
+
+// WithUserLimit applies limit to every user.
+func WithUserLimit(limit UploadLimit) QuotaOption {
+	return func(p *QuotaPolicy) {
+		p.userLimit = &limit
+	}
+}
+
+// WithTenantLimit applies limit to the tenant tenantOf returns for a user,
+// shared by all of its users. Users without a tenant are not limited by it.
+func WithTenantLimit(tenantOf func(ctx context.Context, userID string) string, limit UploadLimit) QuotaOption {
+	return func(p *QuotaPolicy) {
+		p.tenantOf = tenantOf
+		p.tenantLimit = &limit
+	}
+}
+
+// WithQuotaClock replaces time.Now as the reference for rate windows.
+func WithQuotaClock(now func() time.Time) QuotaOption {
+	return func(p *QuotaPolicy) {
+		p.now = now
+	}
+}
+
+// QuotaPolicy is the built-in UploadPolicy enforcing per-user and per-tenant UploadLimits.
+type QuotaPolicy struct {
+	usage       UsageStore
+	userLimit   *UploadLimit
+	tenantLimit *UploadLimit
+	tenantOf    func(ctx context.Context, userID string) string
+	now         func() time.Time
+}
+
+func NewQuotaPolicy(usage UsageStore, opts ...QuotaOption) *QuotaPolicy {
+	p := &QuotaPolicy{
+		usage: usage,
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+type quotaSubject struct {
+	key   string
+	limit *UploadLimit
+}
+
+func (p *QuotaPolicy) subjects(ctx context.Context, userID string) []quotaSubject {
+	var subjects []quotaSubject
+	if p.userLimit != nil {
+		subjects = append(subjects, quotaSubject{key: "user:" + userID, limit: p.userLimit})
+	}
+	if p.tenantLimit != nil {
+		if tenant := p.tenantOf(ctx, userID); tenant != "" {
+			subjects = append(subjects, quotaSubject{key: "tenant:" + tenant, limit: p.tenantLimit})
+		}
+	}
+	return subjects
+}
+
+func (p *QuotaPolicy) check(ctx context.Context, subject quotaSubject, req *UploadRequest) error {
+	limit := subject.limit
+	if limit.MaxFileSize > 0 && req.Size > limit.MaxFileSize {
+		return errs.ErrUploadQuotaExceeded.WrapMsg("file too large", "subject", subject.key, "size", req.Size, "maxFileSize", limit.MaxFileSize)
+	}
+	if len(limit.ContentTypes) > 0 && !matchContentType(limit.ContentTypes, req.ContentType) {
+		return errs.ErrNoPermission.WrapMsg("content type not allowed", "subject", subject.key, "contentType", req.ContentType)
+	}
+	if limit.MaxStoredBytes > 0 {
+		stored, err := p.usage.StoredBytes(ctx, subject.key)
+		if err != nil {
+			return err
+		}
+		if stored+req.Size > limit.MaxStoredBytes {
+			return errs.ErrUploadQuotaExceeded.WrapMsg("storage quota exceeded", "subject", subject.key, "stored", stored, "size", req.Size, "maxStoredBytes", limit.MaxStoredBytes)
+		}
+	}
+	return nil
+}
+
+func (p *QuotaPolicy) CheckInitiate(ctx context.Context, req *UploadRequest) error {
+	subjects := p.subjects(ctx, req.UserID)
+	for _, subject := range subjects {
+		if err := p.check(ctx, subject, req); err != nil {
+			return err
+		}
+	}
+	// Rate is counted last, and only once every subject has room, so that rejected
+	// requests do not use it up.
+	var rated []quotaSubject
+	for _, subject := range subjects {
+		limit := subject.limit
+		if limit.MaxUploads <= 0 || limit.RateWindow <= 0 {
+			continue
+		}
+		count, err := p.usage.Uploads(ctx, subject.key, p.now().Truncate(limit.RateWindow))
+		if err != nil {
+			return err
+		}
+		if count >= limit.MaxUploads {
+			return errs.ErrUploadQuotaExceeded.WrapMsg("upload rate exceeded", "subject", subject.key, "count", count, "maxUploads", limit.MaxUploads, "window", limit.RateWindow)
+		}
+		rated = append(rated, subject)
+	}
+	for _, subject := range rated {
+		limit := subject.limit
+		count, err := p.usage.IncrUploads(ctx, subject.key, p.now().Truncate(limit.RateWindow), limit.RateWindow)
+		if err != nil {
+			return err
+		}
+		// Concurrent requests may have taken the room in the meantime.
+		if count > limit.MaxUploads {
+			return errs.ErrUploadQuotaExceeded.WrapMsg("upload rate exceeded", "subject", subject.key, "count", count, "maxUploads", limit.MaxUploads, "window", limit.RateWindow)
+		}
+	}
+	return nil
+}
+
+// CheckComplete repeats the size, type and storage checks, since other uploads of
+// the same subject may have completed since InitiateUpload.
+func (p *QuotaPolicy) CheckComplete(ctx context.Context, req *UploadRequest) error {
+	for _, subject := range p.subjects(ctx, req.UserID) {
+		if err := p.check(ctx, subject, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *QuotaPolicy) Completed(ctx context.Context, req *UploadRequest) error {
+	for _, subject := range p.subjects(ctx, req.UserID) {
+		if subject.limit.MaxStoredBytes <= 0 {
+			continue
+		}
+		if err := p.usage.AddStoredBytes(ctx, subject.key, req.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deleted releases the stored bytes of the deleted object, never below zero since the
+// object may have been accounted to another user.
+func (p *QuotaPolicy) Deleted(ctx context.Context, req *UploadRequest) error {
+	for _, subject := range p.subjects(ctx, req.UserID) {
+		if subject.limit.MaxStoredBytes <= 0 {
+			continue
+		}
+		stored, err := p.usage.StoredBytes(ctx, subject.key)
+		if err != nil {
+			return err
+		}
+		if released := min(stored, req.Size); released > 0 {
+			if err := p.usage.AddStoredBytes(ctx, subject.key, -released); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func matchContentType(allowed []string, contentType string) bool {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if family, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(contentType, family+"/") {
+				return true
+			}
+		} else if pattern == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// NewMemoryUsageStore keeps usage in process memory.
+func NewMemoryUsageStore() UsageStore {
+	return &memoryUsageStore{
+		stored:  make(map[string]int64),
+		uploads: make(map[string]*uploadWindow),
+	}
+}
+
+type uploadWindow struct {
+	start time.Time
+	count int64
+}
+
+type memoryUsageStore struct {
+	lock    sync.Mutex
+	stored  map[string]int64
+	uploads map[string]*uploadWindow
+}
+
+func (m *memoryUsageStore) StoredBytes(ctx context.Context, subject string) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stored[subject], nil
+}
+
+func (m *memoryUsageStore) AddStoredBytes(ctx context.Context, subject string, delta int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stored[subject] += delta
+	return nil
+}
+
+func (m *memoryUsageStore) Uploads(ctx context.Context, subject string, windowStart time.Time) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	w, ok := m.uploads[subject]
+	if !ok || !w.start.Equal(windowStart) {
+		return 0, nil
+	}
+	return w.count, nil
+}
+
+func (m *memoryUsageStore) IncrUploads(ctx context.Context, subject string, windowStart time.Time, window time.Duration) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	w, ok := m.uploads[subject]
+	if !ok || !w.start.Equal(windowStart) {
+		w = &uploadWindow{start: windowStart}
+		m.uploads[subject] = w
+	}
+	w.count++
+	return w.count, nil
+}
@@ -0,0 +1,126 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/mcontext"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+func TestControllerUploadPolicy(t *testing.T) {
+	engine := s3test.NewEngine()
+	usage := NewMemoryUsageStore()
+	policy := NewQuotaPolicy(usage, WithUserLimit(UploadLimit{
+		MaxFileSize:    100,
+		MaxStoredBytes: 30,
+		ContentTypes:   []string{"image/*"},
+	}))
+	c := newController(passCache{impl: engine}, engine, WithUploadPolicy(policy))
+	ctx := mcontext.SetOpUserID(context.Background(), "user1")
+	png := WithUploadContentType("image/png")
+
+	if _, err := c.InitiateUpload(ctx, presignedHash([]byte("x")), 101, time.Minute, 0, png); !errs.ErrUploadQuotaExceeded.Is(err) {
+		t.Fatalf("expected ErrUploadQuotaExceeded for size, got %v", err)
+	}
+	if _, err := c.InitiateUpload(ctx, presignedHash([]byte("x")), 1, time.Minute, 0, WithUploadContentType("text/plain")); !errs.ErrNoPermission.Is(err) {
+		t.Fatalf("expected ErrNoPermission for content type, got %v", err)
+	}
+
+	data := bytes.Repeat([]byte{'p'}, 20)
+	res, err := c.InitiateUpload(ctx, presignedHash(data), int64(len(data)), time.Minute, 0, png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := c.parseMultipartUploadID(res.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.ContentType != "image/png" {
+		t.Fatalf("content type not kept in upload id: %+v", upload)
+	}
+	engine.SetObject(upload.Key, data)
+	completed, err := c.CompleteUpload(ctx, res.UploadID, []string{md5Hex(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := usage.StoredBytes(ctx, "user:user1"); stored != int64(len(data)) {
+		t.Fatalf("stored bytes not accounted: %d", stored)
+	}
+
+	other := bytes.Repeat([]byte{'q'}, 20)
+	if _, err := c.InitiateUpload(ctx, presignedHash(other), int64(len(other)), time.Minute, 0, png); !errs.ErrUploadQuotaExceeded.Is(err) {
+		t.Fatalf("expected ErrUploadQuotaExceeded for storage, got %v", err)
+	}
+	if _, err := c.InitiateUpload(mcontext.SetOpUserID(context.Background(), "user2"), presignedHash(other), int64(len(other)), time.Minute, 0, png); err != nil {
+		t.Fatalf("quota of user1 applied to user2: %v", err)
+	}
+
+	// Deleting releases the stored bytes.
+	if err := c.DeleteObject(ctx, completed.Key); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := usage.StoredBytes(ctx, "user:user1"); stored != 0 {
+		t.Fatalf("stored bytes not released: %d", stored)
+	}
+	if _, err := c.InitiateUpload(ctx, presignedHash(other), int64(len(other)), time.Minute, 0, png); err != nil {
+		t.Fatalf("quota still exceeded after delete: %v", err)
+	}
+}
+
+func TestQuotaPolicyRate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tenants := map[string]string{"a": "t1", "b": "t1", "c": "t2"}
+	usage := NewMemoryUsageStore()
+	policy := NewQuotaPolicy(usage,
+		WithUserLimit(UploadLimit{MaxUploads: 2, RateWindow: time.Minute}),
+		WithTenantLimit(func(ctx context.Context, userID string) string { return tenants[userID] },
+			UploadLimit{MaxUploads: 3, RateWindow: time.Minute}),
+		WithQuotaClock(func() time.Time { return now }),
+	)
+	check := func(userID string) error {
+		return policy.CheckInitiate(ctx, &UploadRequest{UserID: userID, Size: 1})
+	}
+	for i := 0; i < 2; i++ {
+		if err := check("a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := check("a"); !errs.ErrUploadQuotaExceeded.Is(err) {
+		t.Fatalf("expected user rate limit, got %v", err)
+	}
+	if err := check("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := check("b"); !errs.ErrUploadQuotaExceeded.Is(err) {
+		t.Fatalf("expected tenant rate limit, got %v", err)
+	}
+	if count, _ := usage.Uploads(ctx, "user:b", now.Truncate(time.Minute)); count != 1 {
+		t.Fatalf("request rejected by the tenant limit counted for the user: %d", count)
+	}
+	if err := check("c"); err != nil {
+		t.Fatalf("other tenant limited: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := check("a"); err != nil {
+		t.Fatalf("rate window did not roll over: %v", err)
+	}
+}
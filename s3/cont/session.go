@@ -0,0 +1,244 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"github.com/amazing-socrates/next-tools/mcontext"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+// UploadSession is the server-side state of an upload started by InitiateUpload.
+type UploadSession struct {
+	UserID     string    `json:"userID"`
+	Hash       string    `json:"hash"`
+	Size       int64     `json:"size"`
+	UploadID   string    `json:"uploadID"`
+	PartSize   int64     `json:"partSize"`
+	CreateTime time.Time `json:"createTime"`
+	// Expire is when the upload ID stops being accepted, zero if it never expires.
+	Expire time.Time `json:"expire"`
+}
+
+// SessionStore keeps one upload session per user and hash.
+// Get returns an error matching errs.ErrRecordNotFound when there is none.
+type SessionStore interface {
+	Get(ctx context.Context, userID string, hash string) (*UploadSession, error)
+	Set(ctx context.Context, session *UploadSession) error
+	Delete(ctx context.Context, userID string, hash string) error
+}
+
+type sessionKey struct {
+	userID string
+	hash   string
+}
+
+// NewMemorySessionStore keeps sessions in process memory, suitable for a single instance and tests.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		sessions: make(map[sessionKey]*UploadSession),
+		now:      time.Now,
+	}
+}
+
+type memorySessionStore struct {
+	lock     sync.Mutex
+	sessions map[sessionKey]*UploadSession
+	now      func() time.Time
+}
+
+func (m *memorySessionStore) Get(ctx context.Context, userID string, hash string) (*UploadSession, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := sessionKey{userID: userID, hash: hash}
+	session, ok := m.sessions[key]
+	if !ok {
+		return nil, errs.ErrRecordNotFound.WrapMsg("upload session not found", "userID", userID, "hash", hash)
+	}
+	if !session.Expire.IsZero() && !m.now().Before(session.Expire) {
+		delete(m.sessions, key)
+		return nil, errs.ErrRecordNotFound.WrapMsg("upload session expired", "userID", userID, "hash", hash)
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (m *memorySessionStore) Set(ctx context.Context, session *UploadSession) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.now()
+	for key, s := range m.sessions {
+		if !s.Expire.IsZero() && !now.Before(s.Expire) {
+			delete(m.sessions, key)
+		}
+	}
+	copied := *session
+	m.sessions[sessionKey{userID: session.UserID, hash: session.Hash}] = &copied
+	return nil
+}
+
+func (m *memorySessionStore) Delete(ctx context.Context, userID string, hash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sessions, sessionKey{userID: userID, hash: hash})
+	return nil
+}
+
+func (c *Controller) saveSession(ctx context.Context, hash string, size int64, res *InitiateUploadResult) {
+	if c.sessions == nil {
+		return
+	}
+	now := c.now()
+	session := &UploadSession{
+		UserID:     mcontext.GetOpUserID(ctx),
+		Hash:       hash,
+		Size:       size,
+		UploadID:   res.UploadID,
+		PartSize:   res.PartSize,
+		CreateTime: now,
+	}
+	if c.uploadIDTTL > 0 {
+		session.Expire = now.Add(c.uploadIDTTL)
+	}
+	// The upload works without a session, it only cannot be resumed.
+	if err := c.sessions.Set(ctx, session); err != nil {
+		log.ZWarn(ctx, "save upload session failed", err, "hash", hash)
+	}
+}
+
+func (c *Controller) deleteSession(ctx context.Context, hash string) {
+	if c.sessions == nil {
+		return
+	}
+	if err := c.sessions.Delete(ctx, mcontext.GetOpUserID(ctx), hash); err != nil {
+		log.ZWarn(ctx, "delete upload session failed", err, "hash", hash)
+	}
+}
+
+// ResumeUpload returns the upload the calling user started for hash and size, with the
+// parts already stored and fresh signatures for the rest. It fails with
+// errs.ErrRecordNotFound when there is no live session and with HashAlreadyExistsError
+// when the content was uploaded in the meantime.
+func (c *Controller) ResumeUpload(ctx context.Context, hash string, size int64) (*ResumeUploadResult, error) {
+	defer log.ZDebug(ctx, "return")
+	if c.sessions == nil {
+		return nil, errs.New("upload session store not configured").Wrap()
+	}
+	session, err := c.sessions.Get(ctx, mcontext.GetOpUserID(ctx), hash)
+	if err != nil {
+		return nil, err
+	}
+	if session.Size != size {
+		return nil, errs.ErrRecordNotFound.WrapMsg("upload session size mismatch", "hash", hash, "size", size, "sessionSize", session.Size)
+	}
+	upload, err := c.parseMultipartUploadID(session.UploadID)
+	if err != nil {
+		if errs.ErrUploadIDExpired.Is(err) {
+			c.deleteSession(ctx, hash)
+			return nil, errs.ErrRecordNotFound.WrapMsg("upload session expired", "hash", hash)
+		}
+		return nil, err
+	}
+	alg, ok := GetHashAlgorithm(upload.Algorithm)
+	if !ok {
+		return nil, errs.ErrArgs.WrapMsg("unsupported hash algorithm", "name", upload.Algorithm)
+	}
+	if info, err := c.StatObject(ctx, c.HashPathFor(alg, hash)); err == nil {
+		c.deleteSession(ctx, hash)
+		return nil, &HashAlreadyExistsError{Object: info}
+	} else if !c.IsNotFound(err) {
+		return nil, err
+	}
+	// Fresh signatures do not outlive the session.
+	expire := time.Hour * 24
+	if !session.Expire.IsZero() {
+		expire = session.Expire.Sub(c.now())
+	}
+	res := &ResumeUploadResult{
+		InitiateUploadResult: InitiateUploadResult{
+			UploadID: session.UploadID,
+			PartSize: session.PartSize,
+		},
+	}
+	switch upload.Type {
+	case UploadTypeMultipart:
+		parts, err := c.listUploadedParts(ctx, upload.ID, upload.Key)
+		if err != nil {
+			if c.impl.IsNotFound(err) {
+				c.deleteSession(ctx, hash)
+				return nil, errs.ErrRecordNotFound.WrapMsg("multipart upload no longer exists", "hash", hash)
+			}
+			return nil, err
+		}
+		res.UploadedParts = parts
+		uploaded := make(map[int]struct{}, len(parts))
+		for _, part := range parts {
+			uploaded[part.PartNumber] = struct{}{}
+		}
+		partNumber := int(size / session.PartSize)
+		if size%session.PartSize > 0 {
+			partNumber++
+		}
+		var missing []int
+		for i := 1; i <= partNumber; i++ {
+			if _, ok := uploaded[i]; !ok {
+				missing = append(missing, i)
+			}
+		}
+		if len(missing) > 0 {
+			res.Sign, err = c.impl.AuthSign(ctx, upload.ID, upload.Key, expire, missing)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case UploadTypePresigned:
+		info, err := c.impl.StatObject(ctx, upload.Key)
+		if err == nil {
+			res.UploadedParts = []s3.UploadedPart{
+				{
+					PartNumber:   1,
+					LastModified: info.LastModified,
+					ETag:         info.ETag,
+					Size:         info.Size,
+				},
+			}
+			break
+		} else if !c.impl.IsNotFound(err) {
+			return nil, err
+		}
+		presigned, err := s3.PresignPut(ctx, c.impl, upload.Key, expire)
+		if err != nil {
+			return nil, err
+		}
+		res.Sign = &s3.AuthSignResult{
+			Parts: []s3.SignPart{
+				{
+					PartNumber: 1,
+					URL:        presigned.URL,
+					Header:     presigned.Header,
+				},
+			},
+		}
+	default:
+		return nil, errors.New("invalid upload id type")
+	}
+	return res, nil
+}
@@ -0,0 +1,85 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultSessionKeyPrefix = "S3_UPLOAD_SESSION:"
+
+// NewRedisSessionStore stores sessions as JSON strings that expire with the upload ID.
+// rdb is typically created by redisutil.NewRedisClient; keyPrefix defaults to "S3_UPLOAD_SESSION:".
+func NewRedisSessionStore(rdb redis.UniversalClient, keyPrefix string) SessionStore {
+	if keyPrefix == "" {
+		keyPrefix = defaultSessionKeyPrefix
+	}
+	return &redisSessionStore{rdb: rdb, prefix: keyPrefix}
+}
+
+type redisSessionStore struct {
+	rdb    redis.UniversalClient
+	prefix string
+}
+
+func (r *redisSessionStore) key(userID string, hash string) string {
+	return r.prefix + userID + ":" + hash
+}
+
+func (r *redisSessionStore) Get(ctx context.Context, userID string, hash string) (*UploadSession, error) {
+	data, err := r.rdb.Get(ctx, r.key(userID, hash)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errs.ErrRecordNotFound.WrapMsg("upload session not found", "userID", userID, "hash", hash)
+		}
+		return nil, errs.WrapMsg(err, "redis get upload session failed", "userID", userID, "hash", hash)
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, errs.WrapMsg(err, "decode upload session failed", "userID", userID, "hash", hash)
+	}
+	return &session, nil
+}
+
+func (r *redisSessionStore) Set(ctx context.Context, session *UploadSession) error {
+	var ttl time.Duration
+	if !session.Expire.IsZero() {
+		ttl = time.Until(session.Expire)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if err := r.rdb.Set(ctx, r.key(session.UserID, session.Hash), data, ttl).Err(); err != nil {
+		return errs.WrapMsg(err, "redis set upload session failed", "userID", session.UserID, "hash", session.Hash)
+	}
+	return nil
+}
+
+func (r *redisSessionStore) Delete(ctx context.Context, userID string, hash string) error {
+	if err := r.rdb.Del(ctx, r.key(userID, hash)).Err(); err != nil {
+		return errs.WrapMsg(err, "redis delete upload session failed", "userID", userID, "hash", hash)
+	}
+	return nil
+}
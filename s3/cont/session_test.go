@@ -0,0 +1,125 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cont
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/mcontext"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+func TestResumeMultipartUpload(t *testing.T) {
+	engine := s3test.NewEngine()
+	c := newController(passCache{impl: engine}, engine, WithSessionStore(NewMemorySessionStore()), WithUploadIDTTL(time.Hour))
+	ctx := mcontext.SetOpUserID(context.Background(), "user1")
+	partSize := c.PartLimit().MinPartSize
+	chunks := [][]byte{bytes.Repeat([]byte{'r'}, int(partSize)), []byte("tail")}
+	partHashs := []string{md5Hex(chunks[0]), md5Hex(chunks[1])}
+	hash := md5Hex([]byte(strings.Join(partHashs, partSeparator)))
+	size := partSize + int64(len(chunks[1]))
+	res, err := c.InitiateUpload(ctx, hash, size, time.Minute, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := c.parseMultipartUploadID(res.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.UploadPart(upload.ID, upload.Key, 1, chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := c.ResumeUpload(ctx, hash, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.UploadID != res.UploadID || resumed.PartSize != partSize {
+		t.Fatalf("unexpected session %+v", resumed.InitiateUploadResult)
+	}
+	if len(resumed.UploadedParts) != 1 || resumed.UploadedParts[0].PartNumber != 1 {
+		t.Fatalf("unexpected uploaded parts %+v", resumed.UploadedParts)
+	}
+	if resumed.Sign == nil || len(resumed.Sign.Parts) != 1 || resumed.Sign.Parts[0].PartNumber != 2 {
+		t.Fatalf("expected a signature for part 2 only, got %+v", resumed.Sign)
+	}
+	if expires, _ := strconv.ParseInt(resumed.Sign.Query.Get("expires"), 10, 64); expires > time.Now().Add(time.Hour).Unix() {
+		t.Fatalf("part signatures outlive the session: %d", expires)
+	}
+	if _, err := c.ResumeUpload(mcontext.SetOpUserID(context.Background(), "user2"), hash, size); !errs.ErrRecordNotFound.Is(err) {
+		t.Fatalf("another user resumed the session: %v", err)
+	}
+	if _, err := c.ResumeUpload(ctx, hash, size+1); !errs.ErrRecordNotFound.Is(err) {
+		t.Fatalf("expected ErrRecordNotFound for another size, got %v", err)
+	}
+
+	if _, err := engine.UploadPart(upload.ID, upload.Key, 2, chunks[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CompleteUpload(ctx, resumed.UploadID, partHashs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ResumeUpload(ctx, hash, size); !errs.ErrRecordNotFound.Is(err) {
+		t.Fatalf("session kept after completion: %v", err)
+	}
+}
+
+func TestResumePresignedUpload(t *testing.T) {
+	engine := s3test.NewEngine()
+	store := NewMemorySessionStore()
+	c := newController(passCache{impl: engine}, engine, WithSessionStore(store))
+	ctx := mcontext.SetOpUserID(context.Background(), "user1")
+	data := []byte("resume presigned")
+	hash := presignedHash(data)
+	_, upload := initiatePresigned(t, c, hash, int64(len(data)))
+
+	resumed, err := c.ResumeUpload(ctx, hash, int64(len(data)))
+	if !errs.ErrRecordNotFound.Is(err) {
+		t.Fatalf("session recorded for the wrong user: %v, %+v", err, resumed)
+	}
+	if _, err := c.InitiateUpload(ctx, hash, int64(len(data)), time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err = c.ResumeUpload(ctx, hash, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Sign == nil || len(resumed.Sign.Parts) != 1 || len(resumed.UploadedParts) != 0 {
+		t.Fatalf("expected a fresh presigned url, got %+v", resumed)
+	}
+	upload, err = c.parseMultipartUploadID(resumed.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.SetObject(upload.Key, data)
+	resumed, err = c.ResumeUpload(ctx, hash, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Sign != nil || len(resumed.UploadedParts) != 1 || resumed.UploadedParts[0].Size != int64(len(data)) {
+		t.Fatalf("expected the uploaded object, got %+v", resumed)
+	}
+
+	store.(*memorySessionStore).now = func() time.Time { return time.Now().Add(defaultUploadIDTTL + time.Minute) }
+	if _, err := c.ResumeUpload(ctx, hash, int64(len(data))); !errs.ErrRecordNotFound.Is(err) {
+		t.Fatalf("expired session resumed: %v", err)
+	}
+}
@@ -32,3 +32,10 @@ type UploadResult struct {
 	Size int64  `json:"size"`
 	Key  string `json:"key"`
 }
+
+type ResumeUploadResult struct {
+	InitiateUploadResult
+
+	// UploadedParts lists the parts the storage already holds; Sign only covers the missing ones.
+	UploadedParts []s3.UploadedPart `json:"uploadedParts"`
+}
@@ -50,6 +50,15 @@ const (
 
 const successCode = http.StatusOK
 
+const metaHeaderPrefix = "X-Cos-Meta-"
+
+var encryptionHeaders = s3.EncryptionHeaders{
+	Prefix:   "x-cos-",
+	SSES3:    "AES256",
+	SSEKMS:   "cos/kms",
+	KMSKeyID: "x-cos-server-side-encryption-cos-kms-key-id",
+}
+
 var _ s3.Interface = (*Cos)(nil)
 
 type Config struct {
@@ -58,9 +67,14 @@ type Config struct {
 	SecretKey    string
 	SessionToken string
 	PublicRead   bool
+	// Encryption enables server-side encryption of uploaded objects.
+	Encryption *s3.Encryption
 }
 
 func NewCos(conf Config) (*Cos, error) {
+	if err := conf.Encryption.Check(); err != nil {
+		return nil, err
+	}
 	u, err := url.Parse(conf.BucketURL)
 	if err != nil {
 		panic(err)
@@ -77,6 +91,7 @@ func NewCos(conf Config) (*Cos, error) {
 		copyURL:    u.Host + "/",
 		client:     client,
 		credential: client.GetCredential(),
+		sse:        conf.Encryption,
 	}, nil
 }
 
@@ -85,6 +100,7 @@ type Cos struct {
 	copyURL    string
 	client     *cos.Client
 	credential *cos.Credential
+	sse        *s3.Encryption
 }
 
 func (c *Cos) Engine() string {
@@ -100,7 +116,9 @@ func (c *Cos) PartLimit() *s3.PartLimit {
 }
 
 func (c *Cos) InitiateMultipartUpload(ctx context.Context, name string) (*s3.InitiateMultipartUploadResult, error) {
-	result, _, err := c.client.Object.InitiateMultipartUpload(ctx, name, nil)
+	result, _, err := c.client.Object.InitiateMultipartUpload(ctx, name, &cos.InitiateMultipartUploadOptions{
+		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{XOptionHeader: c.encryptionHeader()},
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -161,6 +179,10 @@ func (c *Cos) AuthSign(ctx context.Context, uploadID string, name string, expire
 	if err != nil {
 		return nil, err
 	}
+	// SSE-C parts carry the key, so the headers are part of the signature.
+	for key, values := range c.sse.CustomerHeader(encryptionHeaders) {
+		req.Header[key] = values
+	}
 	cos.AddAuthorizationHeader(c.credential.SecretID, c.credential.SecretKey, c.credential.SessionToken, req, cos.NewAuthTime(expire))
 	result.Header = req.Header
 	for i, partNumber := range partNumbers {
@@ -173,11 +195,29 @@ func (c *Cos) AuthSign(ctx context.Context, uploadID string, name string, expire
 }
 
 func (c *Cos) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
-	rawURL, err := c.client.Object.GetPresignedURL(ctx, http.MethodPut, name, c.credential.SecretID, c.credential.SecretKey, expire, nil)
+	res, err := c.PresignedPutObjectHeader(ctx, name, expire)
 	if err != nil {
 		return "", err
 	}
-	return rawURL.String(), nil
+	return res.URL, nil
+}
+
+// PresignedPutObjectHeader signs the encryption headers into the URL; the client must send them.
+func (c *Cos) PresignedPutObjectHeader(ctx context.Context, name string, expire time.Duration) (*s3.PresignedPutObjectResult, error) {
+	var opt *cos.PresignedURLOptions
+	header := c.encryptionHeader()
+	if header != nil {
+		opt = &cos.PresignedURLOptions{Header: header}
+	}
+	rawURL, err := c.client.Object.GetPresignedURL(ctx, http.MethodPut, name, c.credential.SecretID, c.credential.SecretKey, expire, opt)
+	if err != nil {
+		return nil, err
+	}
+	res := &s3.PresignedPutObjectResult{URL: rawURL.String()}
+	if header != nil {
+		res.Header = *header
+	}
+	return res, nil
 }
 
 func (c *Cos) DeleteObject(ctx context.Context, name string) error {
@@ -189,11 +229,15 @@ func (c *Cos) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, erro
 	if name != "" && name[0] == '/' {
 		name = name[1:]
 	}
-	info, err := c.client.Object.Head(ctx, name, nil)
+	info, err := c.client.Object.Head(ctx, name, &cos.ObjectHeadOptions{XOptionHeader: c.customerHeader()})
 	if err != nil {
 		return nil, err
 	}
-	res := &s3.ObjectInfo{Key: name}
+	res := &s3.ObjectInfo{
+		Key:         name,
+		ContentType: info.Header.Get("Content-Type"),
+		Metadata:    s3.MetadataFromHeader(info.Header, metaHeaderPrefix),
+	}
 	if res.ETag = strings.ToLower(strings.ReplaceAll(info.Header.Get("ETag"), `"`, "")); res.ETag == "" {
 		return nil, errors.New("StatObject etag not found")
 	}
@@ -221,7 +265,15 @@ func (c *Cos) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, erro
 
 func (c *Cos) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyObjectInfo, error) {
 	sourceURL := c.copyURL + src
-	result, _, err := c.client.Object.Copy(ctx, dst, sourceURL, nil)
+	var opt *cos.ObjectCopyOptions
+	if c.sse != nil {
+		header := c.sse.Header(encryptionHeaders)
+		for key, values := range c.sse.CopySourceHeader(encryptionHeaders) {
+			header[key] = values
+		}
+		opt = &cos.ObjectCopyOptions{ObjectCopyHeaderOptions: &cos.ObjectCopyHeaderOptions{XOptionHeader: &header}}
+	}
+	result, _, err := c.client.Object.Copy(ctx, dst, sourceURL, opt)
 	if err != nil {
 		return nil, err
 	}
@@ -272,6 +324,36 @@ func (c *Cos) ListUploadedParts(ctx context.Context, uploadID string, name strin
 	return res, nil
 }
 
+func (c *Cos) ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*s3.ListMultipartUploadsResult, error) {
+	result, _, err := c.client.Bucket.ListMultipartUploads(ctx, &cos.ListMultipartUploadsOptions{
+		Prefix:         prefix,
+		KeyMarker:      keyMarker,
+		UploadIDMarker: uploadIDMarker,
+		MaxUploads:     s3.ListLimit(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &s3.ListMultipartUploadsResult{
+		NextKeyMarker:      result.NextKeyMarker,
+		NextUploadIDMarker: result.NextUploadIDMarker,
+		IsTruncated:        result.IsTruncated,
+		Uploads:            make([]s3.MultipartUpload, len(result.Uploads)),
+	}
+	for i, upload := range result.Uploads {
+		res.Uploads[i] = s3.MultipartUpload{
+			Key:      upload.Key,
+			UploadID: upload.UploadID,
+		}
+		if upload.Initiated != "" {
+			if res.Uploads[i].Initiated, err = time.Parse(time.RFC3339, upload.Initiated); err != nil {
+				return nil, fmt.Errorf("ListMultipartUploads initiated parse error: %w", err)
+			}
+		}
+	}
+	return res, nil
+}
+
 func (c *Cos) AccessURL(ctx context.Context, name string, expire time.Duration, opt *s3.AccessURLOption) (string, error) {
 	if opt != nil && opt.Image != nil {
 		opt.Filename = ""
@@ -357,6 +439,10 @@ func (c *Cos) FormData(ctx context.Context, name string, size int64, contentType
 	if contentType != "" {
 		conditions = append(conditions, map[string]string{"Content-Type": contentType})
 	}
+	fields := s3.FormFields(c.sse.Header(encryptionHeaders))
+	for key, value := range fields {
+		conditions = append(conditions, map[string]string{key: value})
+	}
 	policy := map[string]any{
 		"expiration": expiration.Format("2006-01-02T15:04:05.000Z"),
 		"conditions": conditions,
@@ -390,9 +476,30 @@ func (c *Cos) FormData(ctx context.Context, name string, size int64, contentType
 	if c.credential.SessionToken != "" {
 		fd.FormData["x-cos-security-token"] = c.credential.SessionToken
 	}
+	for key, value := range fields {
+		fd.FormData[key] = value
+	}
 	return fd, nil
 }
 
+// encryptionHeader returns the headers of requests creating an object, nil without encryption.
+func (c *Cos) encryptionHeader() *http.Header {
+	if c.sse == nil {
+		return nil
+	}
+	header := c.sse.Header(encryptionHeaders)
+	return &header
+}
+
+// customerHeader returns the SSE-C headers of requests touching the object data, nil otherwise.
+func (c *Cos) customerHeader() *http.Header {
+	if !c.sse.IsCustomerKey() {
+		return nil
+	}
+	header := c.sse.CustomerHeader(encryptionHeaders)
+	return &header
+}
+
 func hmacSha1val(key, msg string) string {
 	v := hmac.New(sha1.New, []byte(key))
 	v.Write([]byte(msg))
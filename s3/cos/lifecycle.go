@@ -0,0 +1,126 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cos
+
+import (
+	"context"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+var _ s3.Lifecycle = (*Cos)(nil)
+
+const (
+	statusEnabled  = "Enabled"
+	statusDisabled = "Disabled"
+)
+
+var storageClasses = s3.StorageClasses{
+	s3.StorageClassInfrequent:  "STANDARD_IA",
+	s3.StorageClassArchive:     "ARCHIVE",
+	s3.StorageClassDeepArchive: "DEEP_ARCHIVE",
+}
+
+func (c *Cos) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	res, _, err := c.client.Bucket.GetLifecycle(ctx)
+	if err != nil {
+		// NoSuchLifecycleConfiguration
+		if cos.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	rules := make([]s3.LifecycleRule, 0, len(res.Rules))
+	for _, r := range res.Rules {
+		rule := s3.LifecycleRule{ID: r.ID, Disabled: r.Status != statusEnabled}
+		if r.Filter != nil {
+			rule.Prefix = r.Filter.Prefix
+		}
+		if r.Expiration != nil {
+			rule.ExpireDays = r.Expiration.Days
+		}
+		for _, t := range r.Transition {
+			rule.Transitions = append(rule.Transitions, s3.LifecycleTransition{Days: t.Days, StorageClass: storageClasses.Class(t.StorageClass)})
+		}
+		if !mappable(r) {
+			rule.Vendor = r
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// mappable reports whether s3.LifecycleRule expresses everything r does.
+func mappable(r cos.BucketLifecycleRule) bool {
+	if r.Filter != nil && (r.Filter.Tag != nil || r.Filter.And != nil) {
+		return false
+	}
+	if r.Expiration != nil && (r.Expiration.Date != "" || r.Expiration.ExpiredObjectDeleteMarker) {
+		return false
+	}
+	for _, t := range r.Transition {
+		if t.Date != "" {
+			return false
+		}
+	}
+	return r.AbortIncompleteMultipartUpload == nil && r.NoncurrentVersionExpiration == nil && len(r.NoncurrentVersionTransition) == 0 &&
+		(r.Status == statusEnabled || r.Status == statusDisabled)
+}
+
+func (c *Cos) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	if len(rules) == 0 {
+		_, err := c.client.Bucket.DeleteLifecycle(ctx)
+		return err
+	}
+	opt := &cos.BucketPutLifecycleOptions{Rules: make([]cos.BucketLifecycleRule, 0, len(rules))}
+	for _, rule := range rules {
+		if rule.Vendor != nil {
+			r, ok := rule.Vendor.(cos.BucketLifecycleRule)
+			if !ok {
+				return errs.ErrArgs.WrapMsg("not a cos lifecycle rule", "id", rule.ID)
+			}
+			opt.Rules = append(opt.Rules, r)
+			continue
+		}
+		r := cos.BucketLifecycleRule{
+			ID:     rule.ID,
+			Status: statusEnabled,
+			Filter: &cos.BucketLifecycleFilter{Prefix: rule.Prefix},
+		}
+		if rule.Disabled {
+			r.Status = statusDisabled
+		}
+		if rule.ExpireDays > 0 {
+			r.Expiration = &cos.BucketLifecycleExpiration{Days: rule.ExpireDays}
+		}
+		for _, t := range rule.Transitions {
+			r.Transition = append(r.Transition, cos.BucketLifecycleTransition{Days: t.Days, StorageClass: storageClasses.Vendor(t.StorageClass)})
+		}
+		opt.Rules = append(opt.Rules, r)
+	}
+	_, err := c.client.Bucket.PutLifecycle(ctx, opt)
+	return err
+}
+
+// SetLegalHold is not supported, COS only locks objects for a retention period.
+func (c *Cos) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	return s3.ErrLegalHoldNotSupported.WrapMsg("cos has no object legal hold", "name", name)
+}
+
+func (c *Cos) LegalHold(ctx context.Context, name string) (bool, error) {
+	return false, s3.ErrLegalHoldNotSupported.WrapMsg("cos has no object legal hold", "name", name)
+}
@@ -0,0 +1,148 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+func (c *Cos) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	return s3.PutStream(ctx, c, objectWriter{c}, name, reader, size, opt)
+}
+
+func (c *Cos) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	resp, err := c.client.Object.Get(ctx, name, &cos.ObjectGetOptions{Range: opt.RangeHeader(), XOptionHeader: c.customerHeader()})
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &s3.ObjectInfo{
+		ETag:        formatETag(resp.Header.Get("ETag")),
+		Key:         name,
+		Size:        s3.ObjectSize(resp.ContentLength, resp.Header.Get("Content-Range")),
+		ContentType: resp.Header.Get("Content-Type"),
+		Metadata:    s3.MetadataFromHeader(resp.Header, metaHeaderPrefix),
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		info.LastModified, _ = time.Parse(http.TimeFormat, lastModified)
+	}
+	return resp.Body, info, nil
+}
+
+func (c *Cos) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	result, _, err := c.client.Bucket.Get(ctx, &cos.BucketGetOptions{
+		Prefix:  prefix,
+		Marker:  marker,
+		MaxKeys: s3.ListLimit(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*s3.ObjectInfo, len(result.Contents))
+	for i, object := range result.Contents {
+		objects[i] = &s3.ObjectInfo{
+			ETag: formatETag(object.ETag),
+			Key:  object.Key,
+			Size: object.Size,
+		}
+		// The listing gives ISO 8601 times such as 2006-01-02T15:04:05.000Z.
+		objects[i].LastModified, _ = time.Parse(time.RFC3339, object.LastModified)
+	}
+	return s3.ListResult(objects, result.IsTruncated), nil
+}
+
+func formatETag(etag string) string {
+	return strings.ToLower(strings.ReplaceAll(etag, `"`, ""))
+}
+
+func (c *Cos) putHeaderOptions(opt *s3.PutObjectOption, size int64) *cos.ObjectPutHeaderOptions {
+	header := &cos.ObjectPutHeaderOptions{ContentLength: size, XOptionHeader: c.encryptionHeader()}
+	if opt != nil {
+		header.ContentType = opt.ContentType
+		if len(opt.Metadata) > 0 {
+			meta := make(http.Header, len(opt.Metadata))
+			for k, v := range opt.Metadata {
+				meta.Set(metaHeaderPrefix+k, v)
+			}
+			header.XCosMetaXXX = &meta
+		}
+	}
+	return header
+}
+
+type objectWriter struct {
+	c *Cos
+}
+
+func (w objectWriter) PutObject(ctx context.Context, name string, data []byte, opt *s3.PutObjectOption) (string, error) {
+	resp, err := w.c.client.Object.Put(ctx, name, bytes.NewReader(data), &cos.ObjectPutOptions{
+		ObjectPutHeaderOptions: w.c.putHeaderOptions(opt, int64(len(data))),
+	})
+	if err != nil {
+		return "", err
+	}
+	etag := formatETag(resp.Header.Get("ETag"))
+	if etag == "" {
+		return "", errors.New("PutObject etag not found")
+	}
+	return etag, nil
+}
+
+func (w objectWriter) InitiateMultipart(ctx context.Context, name string, opt *s3.PutObjectOption) (s3.MultipartWriter, error) {
+	result, _, err := w.c.client.Object.InitiateMultipartUpload(ctx, name, &cos.InitiateMultipartUploadOptions{
+		ObjectPutHeaderOptions: w.c.putHeaderOptions(opt, 0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &multipartWriter{c: w.c, name: name, uploadID: result.UploadID}, nil
+}
+
+type multipartWriter struct {
+	c        *Cos
+	name     string
+	uploadID string
+}
+
+func (w *multipartWriter) UploadPart(ctx context.Context, partNumber int, data []byte) (string, error) {
+	resp, err := w.c.client.Object.UploadPart(ctx, w.name, w.uploadID, partNumber, bytes.NewReader(data), &cos.ObjectUploadPartOptions{
+		ContentLength: int64(len(data)),
+		XOptionHeader: w.c.customerHeader(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+func (w *multipartWriter) Complete(ctx context.Context, parts []s3.Part) (string, error) {
+	res, err := w.c.CompleteMultipartUpload(ctx, w.uploadID, w.name, parts)
+	if err != nil {
+		return "", err
+	}
+	return formatETag(res.ETag), nil
+}
+
+func (w *multipartWriter) Abort(ctx context.Context) error {
+	return w.c.AbortMultipartUpload(ctx, w.uploadID, w.name)
+}
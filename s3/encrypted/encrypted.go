@@ -0,0 +1,371 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encrypted encrypts objects before they reach an s3.Interface. Every object
+// gets its own data key, wrapped by a MasterKey and kept in the object metadata next
+// to the chunk size, so objects can be copied freely and the master key rotated
+// without rewriting data.
+//
+// The Storage only covers the server side of an s3.Interface. Objects are sealed in
+// AES-GCM chunks, so PutObject still streams large objects to the engine in multipart
+// uploads, and GetObject serves ranges by downloading only the chunks they span.
+// StatObject, ListObjects, CopyObject and DeleteObject work as on the wrapped engine.
+//
+// Clients never get direct access to the bucket: they would either upload plaintext or
+// need the data key. InitiateMultipartUpload, CompleteMultipartUpload, AuthSign,
+// PresignedPutObject, AccessURL and FormData therefore fail with ErrClientAccess, and
+// so does the client upload flow of cont.Controller. Content is stored through
+// cont.Controller.UploadObject instead and served by the application from GetObject.
+package encrypted
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+const (
+	// DefaultChunkSize is the plaintext size of every sealed chunk.
+	DefaultChunkSize = 64 * 1024
+
+	algorithm = "aes-256-gcm-chunked"
+)
+
+// Metadata keys of encrypted objects; they are hidden from the ObjectInfo returned by the Storage.
+const (
+	MetaAlgorithm = "enc-alg"
+	MetaKeyID     = "enc-key-id"
+	MetaKey       = "enc-key"
+	MetaChunkSize = "enc-chunk-size"
+)
+
+var (
+	// ErrClientAccess is returned by the methods that would let clients bypass encryption.
+	ErrClientAccess = errs.New("encrypted storage does not support direct client access")
+	// ErrUnknownKey is returned for objects wrapped by a master key the Storage does not have.
+	ErrUnknownKey = errs.New("unknown master key")
+	// ErrCorrupted is returned when an object or its key metadata fails authentication.
+	ErrCorrupted = errs.New("encrypted object corrupted")
+)
+
+type Option func(*Storage)
+
+// WithChunkSize sets the plaintext size of the chunks of new objects, DefaultChunkSize by
+// default. Ranged reads download whole chunks.
+func WithChunkSize(size int) Option {
+	if size <= 0 {
+		panic("invalid chunk size " + strconv.Itoa(size))
+	}
+	return func(s *Storage) {
+		s.chunkSize = size
+	}
+}
+
+// WithDecryptionKeys adds master keys that only unwrap, typically those replaced by a rotation.
+func WithDecryptionKeys(keys ...MasterKey) Option {
+	return func(s *Storage) {
+		for _, key := range keys {
+			s.keys[key.ID()] = key
+		}
+	}
+}
+
+var _ s3.Interface = (*Storage)(nil)
+
+// Storage wraps an s3.Interface, encrypting on writes and decrypting on reads. Objects
+// without encryption metadata are returned as stored, so existing buckets can be wrapped.
+type Storage struct {
+	s3.Interface
+	master    MasterKey
+	keys      map[string]MasterKey
+	chunkSize int
+}
+
+// New wraps impl; master wraps the data keys of new objects.
+func New(impl s3.Interface, master MasterKey, opts ...Option) *Storage {
+	s := &Storage{
+		Interface: impl,
+		master:    master,
+		keys:      map[string]MasterKey{master.ID(): master},
+		chunkSize: DefaultChunkSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.keys[master.ID()] = master
+	return s
+}
+
+// PutObject encrypts reader; a known size is passed on as the encrypted size.
+func (s *Storage) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	wrapped, err := s.master.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	put := s3.PutObjectOption{Metadata: make(map[string]string)}
+	if opt != nil {
+		put.ContentType = opt.ContentType
+		for k, v := range opt.Metadata {
+			put.Metadata[k] = v
+		}
+	}
+	put.Metadata[MetaAlgorithm] = algorithm
+	put.Metadata[MetaKeyID] = s.master.ID()
+	put.Metadata[MetaKey] = base64.StdEncoding.EncodeToString(wrapped)
+	put.Metadata[MetaChunkSize] = strconv.Itoa(s.chunkSize)
+	storedSize := int64(-1)
+	if size >= 0 {
+		reader = io.LimitReader(reader, size)
+		storedSize = encryptedSize(size, int64(s.chunkSize))
+	}
+	info, err := s.Interface.PutObject(ctx, name, newEncryptReader(aead, reader, s.chunkSize), storedSize, &put)
+	if err != nil {
+		return nil, err
+	}
+	plain := *info
+	if plain.Size, err = plainSize(info.Size, int64(s.chunkSize)); err != nil {
+		return nil, err
+	}
+	if opt != nil {
+		plain.Metadata = opt.Metadata
+	}
+	return &plain, nil
+}
+
+func (s *Storage) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, error) {
+	info, err := s.Interface.StatObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.plainInfo(info)
+}
+
+// GetObject decrypts the object; a range only downloads the chunks it overlaps.
+func (s *Storage) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	if opt == nil || (opt.Offset == 0 && opt.Length == 0) {
+		body, info, err := s.Interface.GetObject(ctx, name, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader, plain, err := s.decrypt(ctx, body, info, 0, 0, -1)
+		if err != nil {
+			_ = body.Close()
+			return nil, nil, err
+		}
+		return reader, plain, nil
+	}
+	// The chunk size is in the metadata, so it must be known before the range is.
+	info, err := s.Interface.StatObject(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isEncrypted(info) {
+		return s.Interface.GetObject(ctx, name, opt)
+	}
+	plain, err := s.plainInfo(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opt.Offset < 0 || opt.Offset >= plain.Size {
+		return nil, nil, errs.ErrArgs.WrapMsg("invalid range", "offset", opt.Offset, "size", plain.Size)
+	}
+	end := plain.Size
+	if opt.Length > 0 {
+		end = min(end, opt.Offset+opt.Length)
+	}
+	chunkSize, _ := strconv.ParseInt(info.Metadata[MetaChunkSize], 10, 64)
+	first, last := opt.Offset/chunkSize, (end-1)/chunkSize
+	start := first * (chunkSize + tagSize)
+	stop := min(info.Size, (last+1)*(chunkSize+tagSize))
+	body, _, err := s.Interface.GetObject(ctx, name, &s3.GetObjectOption{Offset: start, Length: stop - start})
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, _, err := s.decrypt(ctx, body, info, first, opt.Offset-first*chunkSize, end-opt.Offset)
+	if err != nil {
+		_ = body.Close()
+		return nil, nil, err
+	}
+	return reader, plain, nil
+}
+
+// decrypt reads body, which starts at chunk first of the object described by info, and
+// returns length plaintext bytes after skipping skip, all of them when length is negative.
+func (s *Storage) decrypt(ctx context.Context, body io.ReadCloser, info *s3.ObjectInfo, first int64, skip int64, length int64) (io.ReadCloser, *s3.ObjectInfo, error) {
+	if !isEncrypted(info) {
+		return body, info, nil
+	}
+	plain, err := s.plainInfo(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := s.dataKey(ctx, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	chunkSize, _ := strconv.ParseInt(info.Metadata[MetaChunkSize], 10, 64)
+	if length < 0 {
+		length = plain.Size
+	}
+	dr := &decryptReader{
+		aead:   aead,
+		src:    body,
+		sealed: make([]byte, chunkSize+tagSize),
+		index:  first,
+		count:  chunkCount(plain.Size, chunkSize),
+		skip:   skip,
+	}
+	return &limitReadCloser{Reader: io.LimitReader(dr, length), Closer: dr}, plain, nil
+}
+
+func (s *Storage) dataKey(ctx context.Context, info *s3.ObjectInfo) (cipher.AEAD, error) {
+	keyID := info.Metadata[MetaKeyID]
+	master, ok := s.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey.WrapMsg("data key wrapped by an unknown master key", "object", info.Key, "keyID", keyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(info.Metadata[MetaKey])
+	if err != nil {
+		return nil, ErrCorrupted.WrapMsg("invalid wrapped key", "object", info.Key)
+	}
+	dataKey, err := master.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(dataKey)
+}
+
+func isEncrypted(info *s3.ObjectInfo) bool {
+	return info.Metadata[MetaAlgorithm] != ""
+}
+
+// plainInfo describes the plaintext of an object and hides the encryption metadata.
+func (s *Storage) plainInfo(info *s3.ObjectInfo) (*s3.ObjectInfo, error) {
+	if !isEncrypted(info) {
+		return info, nil
+	}
+	if alg := info.Metadata[MetaAlgorithm]; alg != algorithm {
+		return nil, ErrCorrupted.WrapMsg("unsupported encryption algorithm", "object", info.Key, "algorithm", alg)
+	}
+	chunkSize, err := strconv.ParseInt(info.Metadata[MetaChunkSize], 10, 64)
+	if err != nil || chunkSize <= 0 {
+		return nil, ErrCorrupted.WrapMsg("invalid chunk size", "object", info.Key, "chunkSize", info.Metadata[MetaChunkSize])
+	}
+	size, err := plainSize(info.Size, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	plain := *info
+	plain.Size = size
+	plain.Metadata = make(map[string]string, len(info.Metadata))
+	for k, v := range info.Metadata {
+		switch k {
+		case MetaAlgorithm, MetaKeyID, MetaKey, MetaChunkSize:
+		default:
+			plain.Metadata[k] = v
+		}
+	}
+	return &plain, nil
+}
+
+func (s *Storage) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	res, err := s.Interface.ListObjects(ctx, prefix, marker, limit)
+	if err != nil {
+		return nil, err
+	}
+	// Telling encrypted objects and their plaintext size apart takes their metadata.
+	if err := s3.StatListed(ctx, s.Interface, res); err != nil {
+		return nil, err
+	}
+	for i, info := range res.Objects {
+		if res.Objects[i], err = s.plainInfo(info); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// InitiateMultipartUpload and the other methods below hand clients direct access to the
+// bucket, which the Storage does not support.
+func (s *Storage) InitiateMultipartUpload(ctx context.Context, name string) (*s3.InitiateMultipartUploadResult, error) {
+	return nil, ErrClientAccess.WrapMsg("InitiateMultipartUpload is not supported", "name", name)
+}
+
+func (s *Storage) CompleteMultipartUpload(ctx context.Context, uploadID string, name string, parts []s3.Part) (*s3.CompleteMultipartUploadResult, error) {
+	return nil, ErrClientAccess.WrapMsg("CompleteMultipartUpload is not supported", "name", name)
+}
+
+func (s *Storage) AuthSign(ctx context.Context, uploadID string, name string, expire time.Duration, partNumbers []int) (*s3.AuthSignResult, error) {
+	return nil, ErrClientAccess.WrapMsg("AuthSign is not supported", "name", name)
+}
+
+func (s *Storage) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
+	return "", ErrClientAccess.WrapMsg("PresignedPutObject is not supported", "name", name)
+}
+
+func (s *Storage) AccessURL(ctx context.Context, name string, expire time.Duration, opt *s3.AccessURLOption) (string, error) {
+	return "", ErrClientAccess.WrapMsg("AccessURL is not supported", "name", name)
+}
+
+func (s *Storage) FormData(ctx context.Context, name string, size int64, contentType string, duration time.Duration) (*s3.FormData, error) {
+	return nil, ErrClientAccess.WrapMsg("FormData is not supported", "name", name)
+}
+
+// GetLifecycle and the other s3.Lifecycle methods forward to the wrapped engine.
+func (s *Storage) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	lc, err := s3.LifecycleOf(s.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return lc.GetLifecycle(ctx)
+}
+
+func (s *Storage) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	lc, err := s3.LifecycleOf(s.Interface)
+	if err != nil {
+		return err
+	}
+	return lc.SetLifecycle(ctx, rules)
+}
+
+func (s *Storage) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	lc, err := s3.LifecycleOf(s.Interface)
+	if err != nil {
+		return err
+	}
+	return lc.SetLegalHold(ctx, name, hold)
+}
+
+func (s *Storage) LegalHold(ctx context.Context, name string) (bool, error) {
+	lc, err := s3.LifecycleOf(s.Interface)
+	if err != nil {
+		return false, err
+	}
+	return lc.LegalHold(ctx, name)
+}
@@ -0,0 +1,293 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+const testChunkSize = 1024
+
+func testKey(t *testing.T, id string) MasterKey {
+	t.Helper()
+	key, err := NewAESKey(id, bytes.Repeat([]byte(id[:1]), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func newTestStorage(t *testing.T, opts ...Option) (*Storage, *s3test.Engine) {
+	engine := s3test.NewEngine()
+	opts = append([]Option{WithChunkSize(testChunkSize)}, opts...)
+	return New(engine, testKey(t, "k1"), opts...), engine
+}
+
+func readAll(t *testing.T, s s3.Interface, name string, opt *s3.GetObjectOption) ([]byte, *s3.ObjectInfo) {
+	t.Helper()
+	reader, info, err := s.GetObject(context.Background(), name, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, info
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s, engine := newTestStorage(t)
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 5*testChunkSize + 7} {
+		data := testData(size)
+		info, err := s.PutObject(ctx, "obj", bytes.NewReader(data), int64(size), &s3.PutObjectOption{Metadata: map[string]string{"a": "b"}})
+		if err != nil {
+			t.Fatal(size, err)
+		}
+		if info.Size != int64(size) {
+			t.Fatalf("size %d: put returned size %d", size, info.Size)
+		}
+		stored, _ := engine.Object("obj")
+		if int64(len(stored)) != encryptedSize(int64(size), testChunkSize) {
+			t.Fatalf("size %d: stored %d bytes", size, len(stored))
+		}
+		if size > 0 && bytes.Contains(stored, data) {
+			t.Fatalf("size %d: plaintext stored", size)
+		}
+		got, info := readAll(t, s, "obj", nil)
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d: content mismatch", size)
+		}
+		if info.Size != int64(size) || info.Metadata["a"] != "b" || info.Metadata[MetaKey] != "" {
+			t.Fatalf("size %d: unexpected info %+v", size, info)
+		}
+	}
+}
+
+func TestUnknownSize(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStorage(t)
+	data := testData(3*testChunkSize + 5)
+	if _, err := s.PutObject(ctx, "obj", bytes.NewReader(data), -1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readAll(t, s, "obj", nil); !bytes.Equal(got, data) {
+		t.Fatal("content mismatch")
+	}
+}
+
+func TestRange(t *testing.T) {
+	ctx := context.Background()
+	s, engine := newTestStorage(t)
+	data := testData(4*testChunkSize + 100)
+	if _, err := s.PutObject(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][2]int64{
+		{0, 1},
+		{10, 0},
+		{testChunkSize - 1, 2},
+		{testChunkSize, testChunkSize},
+		{testChunkSize + 3, 2*testChunkSize + 10},
+		{int64(len(data)) - 1, 0},
+		{100, int64(len(data))},
+	} {
+		engine.ResetCalls()
+		got, info := readAll(t, s, "obj", &s3.GetObjectOption{Offset: r[0], Length: r[1]})
+		end := int64(len(data))
+		if r[1] > 0 {
+			end = min(end, r[0]+r[1])
+		}
+		if !bytes.Equal(got, data[r[0]:end]) {
+			t.Fatalf("range %v: content mismatch, got %d bytes", r, len(got))
+		}
+		if info.Size != int64(len(data)) {
+			t.Fatalf("range %v: size %d", r, info.Size)
+		}
+	}
+	if _, _, err := s.GetObject(ctx, "obj", &s3.GetObjectOption{Offset: int64(len(data))}); err == nil {
+		t.Fatal("expected range error")
+	}
+}
+
+func TestMultipartPut(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	s := New(engine, testKey(t, "k1"))
+	// Larger than the 5MB parts of s3test, so PutObject streams it in parts.
+	data := testData(11 << 20)
+	if _, err := s.PutObject(ctx, "big", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readAll(t, s, "big", nil); !bytes.Equal(got, data) {
+		t.Fatal("content mismatch")
+	}
+	offset := int64(6<<20 + 12345)
+	if got, _ := readAll(t, s, "big", &s3.GetObjectOption{Offset: offset, Length: 1 << 20}); !bytes.Equal(got, data[offset:offset+1<<20]) {
+		t.Fatal("range mismatch")
+	}
+}
+
+func TestTamper(t *testing.T) {
+	ctx := context.Background()
+	s, engine := newTestStorage(t)
+	data := testData(3 * testChunkSize)
+	if _, err := s.PutObject(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := engine.Object("obj")
+	info, err := engine.StatObject(ctx, "obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(data []byte) {
+		if _, err := engine.PutObject(ctx, "obj", bytes.NewReader(data), int64(len(data)), &s3.PutObjectOption{Metadata: info.Metadata}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() error {
+		reader, _, err := s.GetObject(ctx, "obj", nil)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, err = io.ReadAll(reader)
+		return err
+	}
+
+	flipped := bytes.Clone(stored)
+	flipped[testChunkSize+tagSize+5] ^= 1
+	put(flipped)
+	if err := read(); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("flipped byte: expected ErrCorrupted, got %v", err)
+	}
+
+	// Dropping the last chunk leaves a valid size, but the new last chunk is not marked final.
+	put(stored[:2*(testChunkSize+tagSize)])
+	if err := read(); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("truncated: expected ErrCorrupted, got %v", err)
+	}
+
+	swapped := bytes.Clone(stored)
+	copy(swapped, stored[testChunkSize+tagSize:2*(testChunkSize+tagSize)])
+	copy(swapped[testChunkSize+tagSize:], stored[:testChunkSize+tagSize])
+	put(swapped)
+	if err := read(); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("reordered: expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	old := testKey(t, "k1")
+	data := testData(2*testChunkSize + 1)
+	if _, err := New(engine, old).PutObject(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	rotated := New(engine, testKey(t, "k2"))
+	if _, _, err := rotated.GetObject(ctx, "obj", nil); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+	rotated = New(engine, testKey(t, "k2"), WithDecryptionKeys(old))
+	if got, _ := readAll(t, rotated, "obj", nil); !bytes.Equal(got, data) {
+		t.Fatal("content mismatch")
+	}
+	if _, err := rotated.PutObject(ctx, "new", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := engine.StatObject(ctx, "new"); info.Metadata[MetaKeyID] != "k2" {
+		t.Fatalf("new object wrapped by %q", info.Metadata[MetaKeyID])
+	}
+}
+
+func TestPlaintextPassThrough(t *testing.T) {
+	s, engine := newTestStorage(t)
+	data := []byte("stored before encryption")
+	engine.SetObject("plain", data)
+	if got, info := readAll(t, s, "plain", nil); !bytes.Equal(got, data) || info.Size != int64(len(data)) {
+		t.Fatal("plaintext object changed")
+	}
+	if got, _ := readAll(t, s, "plain", &s3.GetObjectOption{Offset: 7, Length: 6}); string(got) != "before" {
+		t.Fatalf("unexpected range %q", got)
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	ctx := context.Background()
+	s, engine := newTestStorage(t)
+	engine.SetObject("dir/plain", []byte("plain"))
+	data := testData(testChunkSize + 1)
+	if _, err := s.PutObject(ctx, "dir/enc", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.ListObjects(ctx, "dir/", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := make(map[string]int64)
+	for _, info := range res.Objects {
+		if info.Metadata[MetaAlgorithm] != "" {
+			t.Fatalf("encryption metadata listed for %s", info.Key)
+		}
+		sizes[info.Key] = info.Size
+	}
+	if sizes["dir/enc"] != int64(len(data)) || sizes["dir/plain"] != 5 {
+		t.Fatalf("unexpected sizes %v", sizes)
+	}
+}
+
+func TestClientAccess(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStorage(t)
+	if _, err := s.PresignedPutObject(ctx, "obj", time.Minute); !errors.Is(err, ErrClientAccess) {
+		t.Fatalf("PresignedPutObject: %v", err)
+	}
+	if _, err := s3.PresignPut(ctx, s, "obj", time.Minute); !errors.Is(err, ErrClientAccess) {
+		t.Fatalf("PresignPut: %v", err)
+	}
+	if _, err := s.InitiateMultipartUpload(ctx, "obj"); !errors.Is(err, ErrClientAccess) {
+		t.Fatalf("InitiateMultipartUpload: %v", err)
+	}
+	if _, err := s.AuthSign(ctx, "upload", "obj", time.Minute, []int{1}); !errors.Is(err, ErrClientAccess) {
+		t.Fatalf("AuthSign: %v", err)
+	}
+	if _, err := s.CompleteMultipartUpload(ctx, "upload", "obj", nil); !errors.Is(err, ErrClientAccess) {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+	if _, err := s.AccessURL(ctx, "obj", time.Minute, nil); !errors.Is(err, ErrClientAccess) {
+		t.Fatalf("AccessURL: %v", err)
+	}
+	if _, err := s.FormData(ctx, "obj", 1, "", time.Minute); !errors.Is(err, ErrClientAccess) {
+		t.Fatalf("FormData: %v", err)
+	}
+}
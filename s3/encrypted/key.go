@@ -0,0 +1,78 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypted
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+// MasterKey wraps the data keys of objects. Implementations may keep the key in a KMS
+// and only send data keys to it; the ID is stored with every object so that the
+// key which wrapped it can be found again after a rotation.
+type MasterKey interface {
+	ID() string
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+type aesKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewAESKey returns a MasterKey wrapping data keys locally with AES-GCM; key is 16, 24 or 32 bytes.
+func NewAESKey(id string, key []byte) (MasterKey, error) {
+	if id == "" {
+		return nil, errs.ErrArgs.WrapMsg("master key id is empty")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errs.ErrArgs.WrapMsg("invalid master key", "id", id, "size", len(key))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return &aesKey{id: id, aead: aead}, nil
+}
+
+func (k *aesKey) ID() string {
+	return k.id
+}
+
+func (k *aesKey) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(dataKey)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return k.aead.Seal(nonce, nonce, dataKey, []byte(k.id)), nil
+}
+
+func (k *aesKey) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < k.aead.NonceSize() {
+		return nil, ErrCorrupted.WrapMsg("wrapped key too short", "id", k.id)
+	}
+	nonce, sealed := wrapped[:k.aead.NonceSize()], wrapped[k.aead.NonceSize():]
+	dataKey, err := k.aead.Open(nil, nonce, sealed, []byte(k.id))
+	if err != nil {
+		return nil, ErrCorrupted.WrapMsg("unwrap data key failed", "id", k.id)
+	}
+	return dataKey, nil
+}
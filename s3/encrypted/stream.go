@@ -0,0 +1,186 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypted
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+// Objects are sealed in chunks of chunkSize bytes with AES-256-GCM under a per-object
+// data key. The nonce is the chunk index, which is unique because the key is, and the
+// additional data marks the last chunk, so dropping or reordering chunks fails to open.
+// An empty object still has one empty chunk, hence every object has at least one tag.
+const tagSize = 16
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// chunkCount returns the number of chunks of a plaintext of size bytes.
+func chunkCount(size int64, chunkSize int64) int64 {
+	return max(1, (size+chunkSize-1)/chunkSize)
+}
+
+// encryptedSize returns the stored size of a plaintext of size bytes.
+func encryptedSize(size int64, chunkSize int64) int64 {
+	return size + chunkCount(size, chunkSize)*tagSize
+}
+
+// plainSize inverts encryptedSize.
+func plainSize(size int64, chunkSize int64) (int64, error) {
+	count := (size + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	plain := size - count*tagSize
+	if count == 0 || plain < 0 {
+		return 0, ErrCorrupted.WrapMsg("invalid encrypted size", "size", size)
+	}
+	return plain, nil
+}
+
+type encryptReader struct {
+	aead   cipher.AEAD
+	src    *bufio.Reader
+	plain  []byte
+	sealed []byte
+	out    []byte
+	index  int64
+	done   bool
+}
+
+func newEncryptReader(aead cipher.AEAD, src io.Reader, chunkSize int) *encryptReader {
+	return &encryptReader{
+		aead:   aead,
+		src:    bufio.NewReader(src),
+		plain:  make([]byte, chunkSize),
+		sealed: make([]byte, 0, chunkSize+tagSize),
+	}
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// seal encrypts the next chunk, peeking one byte ahead to learn whether it is the last.
+func (r *encryptReader) seal() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	final := false
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.src.Peek(1); errors.Is(err, io.EOF) {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	r.out = r.aead.Seal(r.sealed[:0], chunkNonce(r.index), r.plain[:n], chunkAAD(final))
+	r.index++
+	r.done = final
+	return nil
+}
+
+// decryptReader opens the chunks first..count-1 of src, dropping skip bytes of the first.
+type decryptReader struct {
+	aead   cipher.AEAD
+	src    io.ReadCloser
+	sealed []byte
+	out    []byte
+	index  int64
+	count  int64
+	skip   int64
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.index >= r.count {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *decryptReader) open() error {
+	n, err := io.ReadFull(r.src, r.sealed)
+	final := r.index == r.count-1
+	switch {
+	case err == nil:
+	case errors.Is(err, io.ErrUnexpectedEOF) && final:
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrCorrupted.WrapMsg("encrypted object truncated", "index", r.index)
+	default:
+		return errs.Wrap(err)
+	}
+	plain, err := r.aead.Open(r.sealed[:0], chunkNonce(r.index), r.sealed[:n], chunkAAD(final))
+	if err != nil {
+		return ErrCorrupted.WrapMsg("chunk authentication failed", "index", r.index)
+	}
+	r.index++
+	if r.skip > 0 {
+		plain = plain[min(r.skip, int64(len(plain))):]
+		r.skip = 0
+	}
+	r.out = plain
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
+
+type limitReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,192 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+const (
+	// EncryptionSSES3 encrypts objects with keys managed by the storage service.
+	EncryptionSSES3 = "SSE-S3"
+	// EncryptionSSEKMS encrypts objects with a key of the vendor key management service.
+	EncryptionSSEKMS = "SSE-KMS"
+	// EncryptionSSEC encrypts objects with a key supplied on every request.
+	EncryptionSSEC = "SSE-C"
+)
+
+const customerKeySize = 32
+
+// Encryption selects the server-side encryption of uploaded objects. It is set on the
+// engine Config and applies to PutObject, InitiateMultipartUpload, AuthSign,
+// PresignedPutObject and FormData.
+//
+// With EncryptionSSEC the key must accompany every read as well, so the engine passes it
+// to StatObject, GetObject and CopyObject, but AccessURL links cannot be opened by
+// browsers, which do not send the key headers.
+type Encryption struct {
+	// Type is EncryptionSSES3, EncryptionSSEKMS or EncryptionSSEC.
+	Type string
+	// KMSKeyID selects the key of EncryptionSSEKMS, the bucket default key when empty.
+	KMSKeyID string
+	// CustomerKey is the 32 byte AES-256 key of EncryptionSSEC.
+	CustomerKey []byte
+}
+
+// Check validates e; a nil Encryption disables encryption.
+func (e *Encryption) Check() error {
+	if e == nil {
+		return nil
+	}
+	switch e.Type {
+	case EncryptionSSES3, EncryptionSSEKMS:
+	case EncryptionSSEC:
+		if len(e.CustomerKey) != customerKeySize {
+			return errs.ErrArgs.WrapMsg("SSE-C customer key must be 32 bytes", "size", len(e.CustomerKey))
+		}
+	default:
+		return errs.ErrArgs.WrapMsg("unknown encryption type", "type", e.Type)
+	}
+	return nil
+}
+
+// IsCustomerKey reports whether requests reading or writing object data must carry the key.
+func (e *Encryption) IsCustomerKey() bool {
+	return e != nil && e.Type == EncryptionSSEC
+}
+
+// CustomerKeyBase64 returns the SSE-C key as sent in request headers.
+func (e *Encryption) CustomerKeyBase64() string {
+	return base64.StdEncoding.EncodeToString(e.CustomerKey)
+}
+
+// CustomerKeyMD5 returns the base64 MD5 of the SSE-C key the service checks the key against.
+func (e *Encryption) CustomerKeyMD5() string {
+	sum := md5.Sum(e.CustomerKey)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// EncryptionHeaders names the server-side encryption headers of a vendor.
+type EncryptionHeaders struct {
+	// Prefix starts every header, such as "x-amz-".
+	Prefix string
+	// SSES3 and SSEKMS are the values of the server-side-encryption header.
+	SSES3  string
+	SSEKMS string
+	// KMSKeyID is the full name of the header selecting the KMS key.
+	KMSKeyID string
+}
+
+// AmzEncryptionHeaders are the headers of Amazon S3 and compatible services.
+var AmzEncryptionHeaders = EncryptionHeaders{
+	Prefix:   "x-amz-",
+	SSES3:    "AES256",
+	SSEKMS:   "aws:kms",
+	KMSKeyID: "x-amz-server-side-encryption-aws-kms-key-id",
+}
+
+// Header returns the headers of requests creating an object: a single PUT, the
+// initiation of a multipart upload or the fields of a POST form. It is nil without encryption.
+func (e *Encryption) Header(names EncryptionHeaders) http.Header {
+	if e == nil {
+		return nil
+	}
+	header := make(http.Header)
+	switch e.Type {
+	case EncryptionSSES3:
+		header.Set(names.Prefix+"server-side-encryption", names.SSES3)
+	case EncryptionSSEKMS:
+		header.Set(names.Prefix+"server-side-encryption", names.SSEKMS)
+		if e.KMSKeyID != "" {
+			header.Set(names.KMSKeyID, e.KMSKeyID)
+		}
+	case EncryptionSSEC:
+		e.setCustomerHeader(header, names.Prefix+"server-side-encryption-customer-")
+	}
+	return header
+}
+
+// CustomerHeader returns the SSE-C headers that uploading a part and reading the object
+// need. It is nil for the other types, whose settings are fixed when the object is created.
+func (e *Encryption) CustomerHeader(names EncryptionHeaders) http.Header {
+	if !e.IsCustomerKey() {
+		return nil
+	}
+	header := make(http.Header)
+	e.setCustomerHeader(header, names.Prefix+"server-side-encryption-customer-")
+	return header
+}
+
+// CopySourceHeader returns the SSE-C headers that decrypt the source of a copy.
+func (e *Encryption) CopySourceHeader(names EncryptionHeaders) http.Header {
+	if !e.IsCustomerKey() {
+		return nil
+	}
+	header := make(http.Header)
+	e.setCustomerHeader(header, names.Prefix+"copy-source-server-side-encryption-customer-")
+	return header
+}
+
+func (e *Encryption) setCustomerHeader(header http.Header, prefix string) {
+	header.Set(prefix+"algorithm", "AES256")
+	header.Set(prefix+"key", e.CustomerKeyBase64())
+	header.Set(prefix+"key-MD5", e.CustomerKeyMD5())
+}
+
+// FormFields converts upload headers to the lower-case field names of POST forms.
+func FormFields(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(header))
+	for key, values := range header {
+		if len(values) > 0 {
+			fields[strings.ToLower(key)] = values[0]
+		}
+	}
+	return fields
+}
+
+// PresignedPutObjectResult is a presigned PUT together with the headers the request must carry.
+type PresignedPutObjectResult struct {
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+}
+
+// PresignedPutObjectHeaderer is implemented by engines whose presigned PUT signs request
+// headers, such as those of server-side encryption. A URL returned by PresignedPutObject
+// alone is then rejected unless the client sends the same headers.
+type PresignedPutObjectHeaderer interface {
+	PresignedPutObjectHeader(ctx context.Context, name string, expire time.Duration) (*PresignedPutObjectResult, error)
+}
+
+// PresignPut presigns a PUT of name, including the required headers when impl signs any.
+func PresignPut(ctx context.Context, impl Interface, name string, expire time.Duration) (*PresignedPutObjectResult, error) {
+	if p, ok := impl.(PresignedPutObjectHeaderer); ok {
+		return p.PresignedPutObjectHeader(ctx, name, expire)
+	}
+	rawURL, err := impl.PresignedPutObject(ctx, name, expire)
+	if err != nil {
+		return nil, err
+	}
+	return &PresignedPutObjectResult{URL: rawURL}, nil
+}
@@ -0,0 +1,57 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+func TestEncryptionHeader(t *testing.T) {
+	var disabled *Encryption
+	if disabled.Check() != nil || disabled.Header(AmzEncryptionHeaders) != nil || disabled.CustomerHeader(AmzEncryptionHeaders) != nil {
+		t.Fatal("nil encryption must be disabled")
+	}
+	if err := (&Encryption{Type: EncryptionSSEC, CustomerKey: []byte("short")}).Check(); !errs.ErrArgs.Is(err) {
+		t.Fatalf("expected ErrArgs for a short key, got %v", err)
+	}
+
+	kms := &Encryption{Type: EncryptionSSEKMS, KMSKeyID: "key-1"}
+	header := kms.Header(AmzEncryptionHeaders)
+	if header.Get("X-Amz-Server-Side-Encryption") != "aws:kms" || header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "key-1" {
+		t.Fatalf("unexpected kms headers %v", header)
+	}
+	if kms.CustomerHeader(AmzEncryptionHeaders) != nil {
+		t.Fatal("kms parts need no headers")
+	}
+
+	ssec := &Encryption{Type: EncryptionSSEC, CustomerKey: bytes.Repeat([]byte{1}, 32)}
+	if err := ssec.Check(); err != nil {
+		t.Fatal(err)
+	}
+	part := ssec.CustomerHeader(AmzEncryptionHeaders)
+	if part.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "AES256" || part.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") != ssec.CustomerKeyMD5() {
+		t.Fatalf("unexpected SSE-C headers %v", part)
+	}
+	if src := ssec.CopySourceHeader(AmzEncryptionHeaders); src.Get("X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key") != ssec.CustomerKeyBase64() {
+		t.Fatalf("unexpected copy source headers %v", src)
+	}
+	fields := FormFields(ssec.Header(AmzEncryptionHeaders))
+	if fields["x-amz-server-side-encryption-customer-key"] != ssec.CustomerKeyBase64() {
+		t.Fatalf("unexpected form fields %v", fields)
+	}
+}
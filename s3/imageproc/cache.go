@@ -0,0 +1,247 @@
+package imageproc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/db/cacheutil"
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+// ImageInfo is the cached metadata of an object, Etag identifies the content thumbnails were made from.
+type ImageInfo struct {
+	IsImg  bool   `json:"isImg"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	Etag   string `json:"etag"`
+}
+
+// Cache stores image metadata and thumbnail keys in front of a Processor, typically in Redis.
+// The callbacks compute the value on a miss.
+type Cache interface {
+	GetImageObjectKeyInfo(ctx context.Context, key string, fn func(ctx context.Context) (*ImageInfo, error)) (*ImageInfo, error)
+	GetThumbnailKey(ctx context.Context, key string, format string, width int, height int, minioCache func(ctx context.Context) (string, error)) (string, error)
+	DelObjectImageInfoKey(ctx context.Context, keys ...string) error
+	DelImageThumbnailKey(ctx context.Context, key string, format string, width int, height int) error
+}
+
+// NopCache computes every lookup; Processor still finds thumbnails that were already stored.
+func NopCache() Cache {
+	return nopCache{}
+}
+
+type nopCache struct{}
+
+func (nopCache) GetImageObjectKeyInfo(ctx context.Context, key string, fn func(ctx context.Context) (*ImageInfo, error)) (*ImageInfo, error) {
+	return fn(ctx)
+}
+
+func (nopCache) GetThumbnailKey(ctx context.Context, key string, format string, width int, height int, fn func(ctx context.Context) (string, error)) (string, error) {
+	return fn(ctx)
+}
+
+func (nopCache) DelObjectImageInfoKey(ctx context.Context, keys ...string) error {
+	return nil
+}
+
+func (nopCache) DelImageThumbnailKey(ctx context.Context, key string, format string, width int, height int) error {
+	return nil
+}
+
+type thumbnailCacheKey struct {
+	key    string
+	format string
+	width  int
+	height int
+}
+
+// String is the key of the load of k in the thumbnail group.
+func (k thumbnailCacheKey) String() string {
+	return fmt.Sprintf("%s\x00%s\x00%dx%d", k.key, k.format, k.width, k.height)
+}
+
+// Defaults of the CacheOption settings.
+const (
+	DefaultCacheTTL         = time.Hour * 24
+	DefaultCacheNotFoundTTL = time.Second * 30
+	DefaultCacheJitter      = 0.1
+)
+
+type CacheOption func(*cacheConfig)
+
+// WithCacheTTL sets how long image infos and thumbnail keys are cached.
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.ttl = ttl
+	}
+}
+
+// WithCacheNotFound remembers objects missing from the engine for ttl, isNotFound being
+// the IsNotFound of the engine. Lookups of a remembered object fail with errs.ErrRecordNotFound.
+func WithCacheNotFound(ttl time.Duration, isNotFound func(err error) bool) CacheOption {
+	return func(c *cacheConfig) {
+		c.notFoundTTL = ttl
+		c.isNotFound = isNotFound
+	}
+}
+
+// WithCacheJitter moves every TTL randomly by up to fraction of it, so that entries
+// cached together do not expire together.
+func WithCacheJitter(fraction float64) CacheOption {
+	return func(c *cacheConfig) {
+		c.jitter = fraction
+	}
+}
+
+// WithCacheKeyPrefix sets the prefix of the Redis keys, "IMAGE:" by default.
+func WithCacheKeyPrefix(prefix string) CacheOption {
+	return func(c *cacheConfig) {
+		c.keyPrefix = prefix
+	}
+}
+
+type cacheConfig struct {
+	ttl         time.Duration
+	notFoundTTL time.Duration
+	isNotFound  func(err error) bool
+	jitter      float64
+	keyPrefix   string
+}
+
+func newCacheConfig(opts []CacheOption) cacheConfig {
+	conf := cacheConfig{
+		ttl:         DefaultCacheTTL,
+		notFoundTTL: DefaultCacheNotFoundTTL,
+		jitter:      DefaultCacheJitter,
+		keyPrefix:   defaultCacheKeyPrefix,
+	}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	return conf
+}
+
+// imageInfoEntry is a cached image info; NotFound entries are negative.
+type imageInfoEntry struct {
+	Info     *ImageInfo `json:"info,omitempty"`
+	NotFound bool       `json:"notFound,omitempty"`
+}
+
+// loadInfo calls fn. A missing object yields a negative entry along with the error of fn.
+func (c *cacheConfig) loadInfo(ctx context.Context, fn func(ctx context.Context) (*ImageInfo, error)) (*imageInfoEntry, time.Duration, error) {
+	info, err := fn(ctx)
+	if err == nil {
+		copied := *info
+		return &imageInfoEntry{Info: &copied}, cacheutil.Jitter(c.ttl, c.jitter), nil
+	}
+	if c.isNotFound != nil && c.notFoundTTL > 0 && c.isNotFound(err) {
+		return &imageInfoEntry{NotFound: true}, cacheutil.Jitter(c.notFoundTTL, c.jitter), err
+	}
+	return nil, 0, err
+}
+
+func (e *imageInfoEntry) result(key string) (*ImageInfo, error) {
+	if e.NotFound || e.Info == nil {
+		return nil, errs.ErrRecordNotFound.WrapMsg("image object not found", "key", key)
+	}
+	copied := *e.Info
+	return &copied, nil
+}
+
+// NewMemoryCache keeps up to maxEntries image infos and thumbnail keys each in process memory,
+// dropping the least recently used first. Concurrent misses of a key share one computation.
+func NewMemoryCache(maxEntries int, opts ...CacheOption) Cache {
+	return &memoryCache{
+		conf:       newCacheConfig(opts),
+		infos:      cacheutil.NewLRU[string, *imageInfoEntry](maxEntries),
+		thumbnails: cacheutil.NewLRU[thumbnailCacheKey, string](maxEntries),
+	}
+}
+
+type memoryCache struct {
+	conf           cacheConfig
+	infos          *cacheutil.LRU[string, *imageInfoEntry]
+	thumbnails     *cacheutil.LRU[thumbnailCacheKey, string]
+	infoGroup      cacheutil.Group[*imageInfoEntry]
+	thumbnailGroup cacheutil.Group[string]
+	// lock orders stores against deletions, which bump epoch so that a computation
+	// started before a deletion does not cache what it saw.
+	lock  sync.Mutex
+	epoch uint64
+}
+
+// store calls set unless a deletion happened since epoch was read.
+func (m *memoryCache) store(epoch uint64, set func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.epoch == epoch {
+		set()
+	}
+}
+
+func (m *memoryCache) currentEpoch() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.epoch
+}
+
+func (m *memoryCache) GetImageObjectKeyInfo(ctx context.Context, key string, fn func(ctx context.Context) (*ImageInfo, error)) (*ImageInfo, error) {
+	entry, ok := m.infos.Get(key)
+	if !ok {
+		var err error
+		entry, err, _ = m.infoGroup.Do(key, func() (*imageInfoEntry, error) {
+			epoch := m.currentEpoch()
+			entry, ttl, err := m.conf.loadInfo(ctx, fn)
+			if entry != nil {
+				m.store(epoch, func() { m.infos.Set(key, entry, ttl) })
+			}
+			return entry, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entry.result(key)
+}
+
+func (m *memoryCache) GetThumbnailKey(ctx context.Context, key string, format string, width int, height int, fn func(ctx context.Context) (string, error)) (string, error) {
+	cacheKey := thumbnailCacheKey{key: key, format: format, width: width, height: height}
+	if thumbnail, ok := m.thumbnails.Get(cacheKey); ok {
+		return thumbnail, nil
+	}
+	thumbnail, err, _ := m.thumbnailGroup.Do(cacheKey.String(), func() (string, error) {
+		epoch := m.currentEpoch()
+		thumbnail, err := fn(ctx)
+		if err != nil {
+			return "", err
+		}
+		m.store(epoch, func() { m.thumbnails.Set(cacheKey, thumbnail, cacheutil.Jitter(m.conf.ttl, m.conf.jitter)) })
+		return thumbnail, nil
+	})
+	return thumbnail, err
+}
+
+// DelObjectImageInfoKey also drops the thumbnail keys of the objects, which depend on their content.
+func (m *memoryCache) DelObjectImageInfoKey(ctx context.Context, keys ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.epoch++
+	for _, key := range keys {
+		m.infos.Delete(key)
+		m.thumbnails.DeleteFunc(func(cacheKey thumbnailCacheKey, _ string) bool {
+			return cacheKey.key == key
+		})
+	}
+	return nil
+}
+
+func (m *memoryCache) DelImageThumbnailKey(ctx context.Context, key string, format string, width int, height int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.epoch++
+	m.thumbnails.Delete(thumbnailCacheKey{key: key, format: format, width: width, height: height})
+	return nil
+}
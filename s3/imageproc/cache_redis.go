@@ -0,0 +1,136 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imageproc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/amazing-socrates/next-tools/db/cacheutil"
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultCacheKeyPrefix = "IMAGE:"
+
+// NewRedisCache stores image infos as JSON strings and the thumbnail keys of an object
+// in one hash, so that DelObjectImageInfoKey drops them together. Concurrent misses of
+// a key within the process share one computation. When Redis fails, lookups are
+// computed so that the cache never takes the service down.
+func NewRedisCache(rdb redis.UniversalClient, opts ...CacheOption) Cache {
+	return &redisCache{rdb: rdb, conf: newCacheConfig(opts)}
+}
+
+type redisCache struct {
+	rdb            redis.UniversalClient
+	conf           cacheConfig
+	infoGroup      cacheutil.Group[*imageInfoEntry]
+	thumbnailGroup cacheutil.Group[string]
+}
+
+func (r *redisCache) infoKey(key string) string {
+	return r.conf.keyPrefix + "info:" + key
+}
+
+func (r *redisCache) thumbnailKey(key string) string {
+	return r.conf.keyPrefix + "thumbnail:" + key
+}
+
+func thumbnailField(format string, width int, height int) string {
+	return fmt.Sprintf("%s:%dx%d", format, width, height)
+}
+
+func (r *redisCache) GetImageObjectKeyInfo(ctx context.Context, key string, fn func(ctx context.Context) (*ImageInfo, error)) (*ImageInfo, error) {
+	redisKey := r.infoKey(key)
+	data, err := r.rdb.Get(ctx, redisKey).Bytes()
+	if err == nil {
+		var entry imageInfoEntry
+		if err := json.Unmarshal(data, &entry); err == nil {
+			return entry.result(key)
+		}
+		log.ZWarn(ctx, "decode image info cache failed", err, "key", redisKey)
+	} else if !errors.Is(err, redis.Nil) {
+		log.ZWarn(ctx, "redis get image info cache failed", err, "key", redisKey)
+	}
+	entry, err, _ := r.infoGroup.Do(key, func() (*imageInfoEntry, error) {
+		entry, ttl, err := r.conf.loadInfo(ctx, fn)
+		if entry != nil && ttl > 0 {
+			if data, err := json.Marshal(entry); err == nil {
+				if err := r.rdb.Set(ctx, redisKey, data, ttl).Err(); err != nil {
+					log.ZWarn(ctx, "redis set image info cache failed", err, "key", redisKey)
+				}
+			}
+		}
+		return entry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.result(key)
+}
+
+// GetThumbnailKey expires the hash of the object with each new thumbnail key.
+func (r *redisCache) GetThumbnailKey(ctx context.Context, key string, format string, width int, height int, fn func(ctx context.Context) (string, error)) (string, error) {
+	redisKey, field := r.thumbnailKey(key), thumbnailField(format, width, height)
+	thumbnail, err := r.rdb.HGet(ctx, redisKey, field).Result()
+	if err == nil {
+		return thumbnail, nil
+	} else if !errors.Is(err, redis.Nil) {
+		log.ZWarn(ctx, "redis get thumbnail cache failed", err, "key", redisKey, "field", field)
+	}
+	cacheKey := thumbnailCacheKey{key: key, format: format, width: width, height: height}
+	thumbnail, err, _ = r.thumbnailGroup.Do(cacheKey.String(), func() (string, error) {
+		thumbnail, err := fn(ctx)
+		if err != nil {
+			return "", err
+		}
+		pipe := r.rdb.TxPipeline()
+		pipe.HSet(ctx, redisKey, field, thumbnail)
+		if ttl := cacheutil.Jitter(r.conf.ttl, r.conf.jitter); ttl > 0 {
+			pipe.Expire(ctx, redisKey, ttl)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.ZWarn(ctx, "redis set thumbnail cache failed", err, "key", redisKey, "field", field)
+		}
+		return thumbnail, nil
+	})
+	return thumbnail, err
+}
+
+// DelObjectImageInfoKey also drops the thumbnail keys of the objects, which depend on their content.
+func (r *redisCache) DelObjectImageInfoKey(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pipe := r.rdb.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, r.infoKey(key))
+		pipe.Del(ctx, r.thumbnailKey(key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errs.WrapMsg(err, "redis delete image info cache failed", "keys", keys)
+	}
+	return nil
+}
+
+func (r *redisCache) DelImageThumbnailKey(ctx context.Context, key string, format string, width int, height int) error {
+	if err := r.rdb.HDel(ctx, r.thumbnailKey(key), thumbnailField(format, width, height)).Err(); err != nil {
+		return errs.WrapMsg(err, "redis delete thumbnail cache failed", "key", key)
+	}
+	return nil
+}
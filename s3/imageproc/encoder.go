@@ -0,0 +1,103 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imageproc
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Encoder writes thumbnails in the format s3.Image.Format names. The standard library
+// has no WebP or AVIF encoder, so those are registered by the application, usually
+// backed by libwebp or libavif; until then such formats are served as PNG.
+type Encoder interface {
+	Format() string
+	ContentType() string
+	// Encode writes img; quality ranges from 1 to 100 and may be ignored by lossless formats.
+	Encode(w io.Writer, img image.Image, quality int) error
+}
+
+type encoder struct {
+	format      string
+	contentType string
+	encode      func(w io.Writer, img image.Image, quality int) error
+}
+
+func (e encoder) Format() string {
+	return e.format
+}
+
+func (e encoder) ContentType() string {
+	return e.contentType
+}
+
+func (e encoder) Encode(w io.Writer, img image.Image, quality int) error {
+	return e.encode(w, img, quality)
+}
+
+// NewEncoder adapts an encode function to Encoder.
+func NewEncoder(format string, contentType string, fn func(w io.Writer, img image.Image, quality int) error) Encoder {
+	return encoder{format: format, contentType: contentType, encode: fn}
+}
+
+var encoders = struct {
+	lock sync.RWMutex
+	m    map[string]Encoder
+}{
+	m: map[string]Encoder{
+		formatPng: NewEncoder(formatPng, "image/png", func(w io.Writer, img image.Image, quality int) error {
+			return png.Encode(w, img)
+		}),
+		formatJpeg: NewEncoder(formatJpeg, "image/jpeg", func(w io.Writer, img image.Image, quality int) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+		}),
+		formatGif: NewEncoder(formatGif, "image/gif", func(w io.Writer, img image.Image, quality int) error {
+			return gif.Encode(w, img, nil)
+		}),
+	},
+}
+
+// RegisterEncoder makes enc available for its format, replacing any previous encoder.
+func RegisterEncoder(enc Encoder) {
+	format := normalizeFormat(enc.Format())
+	if format == "" || strings.ContainsAny(format, `/\.`) {
+		panic(fmt.Sprintf("invalid image format %q", enc.Format()))
+	}
+	encoders.lock.Lock()
+	defer encoders.lock.Unlock()
+	encoders.m[format] = enc
+}
+
+// GetEncoder looks up the encoder of format, "jpg" being an alias of "jpeg".
+func GetEncoder(format string) (Encoder, bool) {
+	encoders.lock.RLock()
+	defer encoders.lock.RUnlock()
+	enc, ok := encoders.m[normalizeFormat(format)]
+	return enc, ok
+}
+
+func normalizeFormat(format string) string {
+	format = strings.ToLower(format)
+	if format == formatJpg {
+		return formatJpeg
+	}
+	return format
+}
@@ -0,0 +1,61 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imageproc
+
+import (
+	"image"
+	"image/gif"
+
+	"golang.org/x/image/draw"
+)
+
+// resizeGIF resizes every frame of an animation. Frames may only cover part of the
+// canvas, so each one is composed onto the canvas honouring the disposal methods and
+// the full canvas is resized; the output frames are therefore complete images.
+func resizeGIF(g *gif.GIF, maxWidth, maxHeight int, mode string, scaler draw.Scaler) *gif.GIF {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(bounds)
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, 0, len(g.Image)),
+		Delay:     append([]int(nil), g.Delay...),
+		LoopCount: g.LoopCount,
+	}
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		resized := resizeImage(canvas, maxWidth, maxHeight, mode, scaler)
+		paletted := image.NewPaletted(resized.Bounds(), frame.Palette)
+		draw.Draw(paletted, paletted.Bounds(), resized, resized.Bounds().Min, draw.Src)
+		out.Image = append(out.Image, paletted)
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+// Copyright © 2023 OpenIM. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	formatPng  = "png"
+	formatJpeg = "jpeg"
+	formatJpg  = "jpg"
+	formatGif  = "gif"
+	formatWebP = "webp"
+	formatTiff = "tiff"
+	formatBmp  = "bmp"
+
+	formatHeic = "heic"
+	formatHeif = "heif"
+	formatAvif = "avif"
+)
+
+const (
+	FilterNearest    = "nearest"
+	FilterBilinear   = "bilinear"
+	FilterCatmullRom = "catmullrom"
+)
+
+// ImageStat decodes an image and rotates it upright according to its EXIF orientation.
+func ImageStat(reader io.Reader) (image.Image, string, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	return applyOrientation(img, exifOrientation(data)), format, nil
+}
+
+func ImageWidthHeight(img image.Image) (int, int) {
+	bounds := img.Bounds().Max
+	return bounds.X, bounds.Y
+}
+
+// imageScaler maps a filter name to its interpolator, Catmull-Rom by default.
+func imageScaler(filter string) draw.Scaler {
+	switch strings.ToLower(filter) {
+	case FilterNearest:
+		return draw.NearestNeighbor
+	case FilterBilinear:
+		return draw.BiLinear
+	default:
+		return draw.CatmullRom
+	}
+}
+
+// fitSize scales width x height down to fit within maxWidth x maxHeight, a bound of 0 being unset.
+func fitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= 0 || height <= 0 || (maxWidth <= 0 && maxHeight <= 0) {
+		return width, height
+	}
+	scale := float64(maxWidth) / float64(width)
+	if scaleHeight := float64(maxHeight) / float64(height); maxWidth <= 0 || (maxHeight > 0 && scaleHeight < scale) {
+		scale = scaleHeight
+	}
+	return max(int(float64(width)*scale), 1), max(int(float64(height)*scale), 1)
+}
+
+// fillRect returns the centered part of bounds with the aspect ratio of width x height.
+func fillRect(bounds image.Rectangle, width, height int) image.Rectangle {
+	srcWidth, srcHeight := int64(bounds.Dx()), int64(bounds.Dy())
+	if srcWidth*int64(height) > srcHeight*int64(width) {
+		cropWidth := int(srcHeight * int64(width) / int64(height))
+		x := bounds.Min.X + (bounds.Dx()-cropWidth)/2
+		return image.Rect(x, bounds.Min.Y, x+cropWidth, bounds.Max.Y)
+	}
+	cropHeight := int(srcWidth * int64(height) / int64(width))
+	y := bounds.Min.Y + (bounds.Dy()-cropHeight)/2
+	return image.Rect(bounds.Min.X, y, bounds.Max.X, y+cropHeight)
+}
+
+// thumbnailSize returns the output size of resizeImage for an image of width x height.
+func thumbnailSize(width, height, maxWidth, maxHeight int, mode string) (int, int) {
+	if mode == s3.ImageModeFill && maxWidth > 0 && maxHeight > 0 {
+		return maxWidth, maxHeight
+	}
+	return fitSize(width, height, maxWidth, maxHeight)
+}
+
+// resizeImage scales img to fit within maxWidth x maxHeight, or in fill mode to exactly
+// maxWidth x maxHeight cropping around the center. The scaler has fast paths for
+// *image.RGBA and *image.YCbCr sources, which covers decoded PNG and JPEG images.
+func resizeImage(img image.Image, maxWidth, maxHeight int, mode string, scaler draw.Scaler) image.Image {
+	bounds := img.Bounds()
+	width, height := thumbnailSize(bounds.Dx(), bounds.Dy(), maxWidth, maxHeight, mode)
+	src := bounds
+	if mode == s3.ImageModeFill && maxWidth > 0 && maxHeight > 0 {
+		src = fillRect(bounds, width, height)
+	}
+	if src == bounds && width == bounds.Dx() && height == bounds.Dy() {
+		return img
+	}
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaler.Scale(thumbnail, thumbnail.Bounds(), img, src, draw.Src, nil)
+	return thumbnail
+}
+
+func getThumbnailSize(img image.Image) (thumbnailWidth, thumbnailHeight int) {
+	bounds := img.Bounds()
+	imgWidth := bounds.Max.X
+	imgHeight := bounds.Max.Y
+
+	if imgWidth < 640 {
+		thumbnailWidth = imgWidth
+	} else {
+		thumbnailWidth = 640
+	}
+	if imgHeight < 640 {
+		thumbnailHeight = imgHeight
+	} else {
+		thumbnailHeight = 640
+	}
+	return thumbnailWidth, thumbnailHeight
+}
@@ -0,0 +1,99 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+// jpegWithOrientation encodes img as JPEG with an EXIF segment carrying orientation.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("MM\x00*\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), append(app1, segment...)...), data[2:]...)
+}
+
+func TestImageStatOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	data := jpegWithOrientation(t, img, 6)
+	if o := exifOrientation(data); o != 6 {
+		t.Fatalf("expected orientation 6, got %d", o)
+	}
+	decoded, format, err := ImageStat(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := ImageWidthHeight(decoded); format != "jpeg" || w != 20 || h != 40 {
+		t.Fatalf("expected upright 20x40 jpeg, got %dx%d %s", w, h, format)
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	// A 2x1 image with a red pixel on the left.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.SetRGBA(0, 0, red)
+	for orientation, want := range map[int]image.Point{
+		2: {1, 0},
+		3: {1, 0},
+		6: {0, 0},
+		8: {0, 1},
+	} {
+		dst := applyOrientation(src, orientation).(*image.RGBA)
+		if dst.RGBAAt(want.X, want.Y) != red {
+			t.Errorf("orientation %d: red pixel not at %v", orientation, want)
+		}
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	img := image.NewYCbCr(image.Rect(0, 0, 400, 200), image.YCbCrSubsampleRatio420)
+	for _, filter := range []string{FilterNearest, FilterBilinear, FilterCatmullRom} {
+		fit := resizeImage(img, 100, 100, s3.ImageModeFit, imageScaler(filter))
+		if w, h := ImageWidthHeight(fit); w != 100 || h != 50 {
+			t.Fatalf("%s fit: got %dx%d", filter, w, h)
+		}
+	}
+	fill := resizeImage(img, 100, 100, s3.ImageModeFill, imageScaler(""))
+	if w, h := ImageWidthHeight(fill); w != 100 || h != 100 {
+		t.Fatalf("fill: got %dx%d", w, h)
+	}
+	if r := fillRect(img.Bounds(), 100, 100); r != image.Rect(100, 0, 300, 200) {
+		t.Fatalf("fill crop not centered: %v", r)
+	}
+	if same := resizeImage(img, 400, 200, s3.ImageModeFit, imageScaler("")); same != image.Image(img) {
+		t.Fatal("resized an image that already has the requested size")
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package imageproc generates thumbnails for any s3.Interface. A Processor decodes the
+// original, resizes and encodes it, stores the result under a thumbnail prefix of the
+// same storage and serves it through the engine's own AccessURL.
+package imageproc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+const (
+	DefaultThumbnailPrefix = "openim/thumbnail"
+
+	defaultMaxWidth      = 1024
+	defaultMaxHeight     = 1024
+	defaultMaxObjectSize = 1024 * 1024 * 50
+	defaultQuality       = 40
+	defaultPreviewSize   = 1024 * 1024 * 200
+)
+
+type Option func(*Processor)
+
+// WithThumbnailPrefix sets where thumbnails are stored, DefaultThumbnailPrefix by default.
+func WithThumbnailPrefix(prefix string) Option {
+	return func(p *Processor) {
+		p.prefix = prefix
+	}
+}
+
+// WithMaxImageSize sets the largest thumbnail that can be requested; larger requests get the original.
+func WithMaxImageSize(width, height int) Option {
+	return func(p *Processor) {
+		p.maxWidth = width
+		p.maxHeight = height
+	}
+}
+
+// WithMaxObjectSize sets the largest object that is decoded, 50MB by default.
+func WithMaxObjectSize(size int64) Option {
+	return func(p *Processor) {
+		p.maxObjectSize = size
+	}
+}
+
+// WithFilter selects the resampling filter: FilterNearest, FilterBilinear or FilterCatmullRom (the default).
+func WithFilter(filter string) Option {
+	return func(p *Processor) {
+		p.filter = filter
+	}
+}
+
+// WithQuality sets the quality passed to lossy encoders, from 1 to 100, 40 by default.
+func WithQuality(quality int) Option {
+	return func(p *Processor) {
+		p.quality = quality
+	}
+}
+
+// WithMaxPreviewSize sets the largest file handed to a PreviewProvider, 200MB by default.
+func WithMaxPreviewSize(size int64) Option {
+	return func(p *Processor) {
+		p.maxPreviewSize = size
+	}
+}
+
+var _ s3.Interface = (*Processor)(nil)
+
+// Processor wraps an s3.Interface so that AccessURL with an s3.Image option returns a thumbnail
+// and with an s3.Preview option an image rendered by the PreviewProvider of the content type.
+// Writes through the Processor invalidate the cached image metadata.
+type Processor struct {
+	s3.Interface
+	cache          Cache
+	prefix         string
+	maxWidth       int
+	maxHeight      int
+	maxObjectSize  int64
+	filter         string
+	quality        int
+	maxPreviewSize int64
+}
+
+// New wraps impl; a nil cache is NopCache.
+func New(impl s3.Interface, cache Cache, opts ...Option) *Processor {
+	if cache == nil {
+		cache = NopCache()
+	}
+	p := &Processor{
+		Interface:      impl,
+		cache:          cache,
+		prefix:         DefaultThumbnailPrefix,
+		maxWidth:       defaultMaxWidth,
+		maxHeight:      defaultMaxHeight,
+		maxObjectSize:  defaultMaxObjectSize,
+		quality:        defaultQuality,
+		maxPreviewSize: defaultPreviewSize,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Processor) AccessURL(ctx context.Context, name string, expire time.Duration, opt *s3.AccessURLOption) (string, error) {
+	if opt != nil && opt.Preview != nil {
+		return p.previewURL(ctx, name, expire, opt.Preview)
+	}
+	if opt == nil || opt.Image == nil {
+		return p.Interface.AccessURL(ctx, name, expire, opt)
+	}
+	img := *opt.Image
+	if (img.Width < 0 && img.Height < 0 && img.Format == "") || img.Width > p.maxWidth || img.Height > p.maxHeight {
+		return p.Interface.AccessURL(ctx, name, expire, &s3.AccessURLOption{})
+	}
+	return p.thumbnailURL(ctx, name, expire, &img)
+}
+
+func (p *Processor) thumbnailURL(ctx context.Context, name string, expire time.Duration, opt *s3.Image) (string, error) {
+	var img image.Image
+	info, err := p.cache.GetImageObjectKeyInfo(ctx, name, func(ctx context.Context) (info *ImageInfo, err error) {
+		info, img, err = p.objectImageInfo(ctx, name)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	if !info.IsImg {
+		return "", errs.New("object not image").Wrap()
+	}
+	if opt.Width > info.Width || opt.Width <= 0 {
+		opt.Width = info.Width
+	}
+	if opt.Height > info.Height || opt.Height <= 0 {
+		opt.Height = info.Height
+	}
+	// Without a requested format the original one is kept when it can be encoded.
+	opt.Format = normalizeFormat(opt.Format)
+	if opt.Format == "" {
+		opt.Format = formatPng
+		if _, ok := GetEncoder(info.Format); ok {
+			opt.Format = info.Format
+		}
+	}
+	enc, ok := GetEncoder(opt.Format)
+	if !ok {
+		// Formats without a registered encoder, such as WebP by default, fall back to PNG.
+		opt.Format = formatPng
+		enc, _ = GetEncoder(formatPng)
+	}
+	opt.Mode = strings.ToLower(opt.Mode)
+	if opt.Mode != s3.ImageModeFill {
+		opt.Mode = s3.ImageModeFit
+	}
+	// Fit and fill only differ in output size, so the size identifies the thumbnail.
+	width, height := thumbnailSize(info.Width, info.Height, opt.Width, opt.Height, opt.Mode)
+	if width == info.Width && height == info.Height && opt.Format == info.Format {
+		return p.Interface.AccessURL(ctx, name, expire, &s3.AccessURLOption{ContentType: enc.ContentType()})
+	}
+	key, err := p.cache.GetThumbnailKey(ctx, name, opt.Format, width, height, func(ctx context.Context) (string, error) {
+		key := p.thumbnailKey(info.Etag, width, height, opt.Format)
+		// Another instance, or an earlier run without a cache, may have stored it already.
+		if _, err := p.Interface.StatObject(ctx, key); err == nil {
+			return key, nil
+		} else if !p.Interface.IsNotFound(err) {
+			return "", err
+		}
+		buf := bytes.NewBuffer(nil)
+		if info.Format == formatGif && opt.Format == formatGif {
+			if err := p.encodeAnimation(ctx, buf, name, opt); err != nil {
+				return "", err
+			}
+		} else {
+			if img == nil {
+				var err error
+				if img, err = p.decodeObject(ctx, name); err != nil {
+					return "", err
+				}
+			}
+			thumbnail := resizeImage(img, opt.Width, opt.Height, opt.Mode, imageScaler(p.filter))
+			if err := enc.Encode(buf, thumbnail, p.quality); err != nil {
+				return "", errs.WrapMsg(err, "encode failed", "type", opt.Format)
+			}
+		}
+		if _, err := p.Interface.PutObject(ctx, key, buf, int64(buf.Len()), &s3.PutObjectOption{ContentType: enc.ContentType()}); err != nil {
+			return "", err
+		}
+		return key, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return p.Interface.AccessURL(ctx, key, expire, &s3.AccessURLOption{ContentType: enc.ContentType()})
+}
+
+// encodeAnimation resizes a GIF frame by frame, the decoded image of ImageStat only holds the first frame.
+func (p *Processor) encodeAnimation(ctx context.Context, w io.Writer, name string, opt *s3.Image) error {
+	data, _, err := p.readObject(ctx, name)
+	if err != nil {
+		return err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return errs.WrapMsg(err, "decode gif failed", "name", name)
+	}
+	if err := gif.EncodeAll(w, resizeGIF(g, opt.Width, opt.Height, opt.Mode, imageScaler(p.filter))); err != nil {
+		return errs.WrapMsg(err, "encode failed", "type", opt.Format)
+	}
+	return nil
+}
+
+func (p *Processor) thumbnailKey(etag string, width, height int, format string) string {
+	return path.Join(p.prefix, etag, fmt.Sprintf("image_w%d_h%d.%s", width, height, format))
+}
+
+func (p *Processor) readObject(ctx context.Context, name string) ([]byte, *s3.ObjectInfo, error) {
+	reader, info, err := p.Interface.GetObject(ctx, name, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	if info.Size > p.maxObjectSize {
+		return nil, nil, errs.New("file size too large").Wrap()
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, info.Size))
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
+
+func (p *Processor) decodeObject(ctx context.Context, name string) (image.Image, error) {
+	data, _, err := p.readObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := ImageStat(bytes.NewReader(data))
+	return img, err
+}
+
+func (p *Processor) objectImageInfo(ctx context.Context, name string) (*ImageInfo, image.Image, error) {
+	data, fileInfo, err := p.readObject(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var info ImageInfo
+	img, format, err := ImageStat(bytes.NewReader(data))
+	if err == nil {
+		info.IsImg = true
+		info.Format = format
+		info.Width, info.Height = ImageWidthHeight(img)
+	} else {
+		info.IsImg = false
+	}
+	info.Etag = fileInfo.ETag
+	return &info, img, nil
+}
+
+// GetImageThumbnailKey returns the key of the default thumbnail of name, at most 640x640.
+func (p *Processor) GetImageThumbnailKey(ctx context.Context, name string) (string, error) {
+	info, img, err := p.objectImageInfo(ctx, name)
+	if err != nil {
+		return "", errs.Wrap(err)
+	}
+	if !info.IsImg {
+		return "", errs.New("object not image").Wrap()
+	}
+	thumbnailWidth, thumbnailHeight := getThumbnailSize(img)
+	return p.thumbnailKey(info.Etag, thumbnailWidth, thumbnailHeight, info.Format), nil
+}
+
+// DelObjectImageInfo drops the cached metadata of name; objects above the decode limit are never cached.
+func (p *Processor) DelObjectImageInfo(ctx context.Context, name string, size int64) {
+	if size > 0 && size > p.maxObjectSize {
+		return
+	}
+	if err := p.cache.DelObjectImageInfoKey(ctx, name); err != nil {
+		log.ZError(ctx, "DelObjectImageInfoKey failed", err, "key", name)
+	}
+}
+
+func (p *Processor) CompleteMultipartUpload(ctx context.Context, uploadID string, name string, parts []s3.Part) (*s3.CompleteMultipartUploadResult, error) {
+	result, err := p.Interface.CompleteMultipartUpload(ctx, uploadID, name, parts)
+	if err != nil {
+		return nil, err
+	}
+	p.DelObjectImageInfo(ctx, name, 0)
+	return result, nil
+}
+
+func (p *Processor) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	info, err := p.Interface.PutObject(ctx, name, reader, size, opt)
+	if err != nil {
+		return nil, err
+	}
+	p.DelObjectImageInfo(ctx, name, info.Size)
+	return info, nil
+}
+
+func (p *Processor) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyObjectInfo, error) {
+	info, err := p.Interface.CopyObject(ctx, src, dst)
+	if err != nil {
+		return nil, err
+	}
+	p.DelObjectImageInfo(ctx, dst, 0)
+	return info, nil
+}
+
+func (p *Processor) DeleteObject(ctx context.Context, name string) error {
+	if err := p.Interface.DeleteObject(ctx, name); err != nil {
+		return err
+	}
+	p.DelObjectImageInfo(ctx, name, 0)
+	return nil
+}
+
+// PresignedPutObjectHeader keeps the signed headers of the wrapped engine, if any.
+func (p *Processor) PresignedPutObjectHeader(ctx context.Context, name string, expire time.Duration) (*s3.PresignedPutObjectResult, error) {
+	return s3.PresignPut(ctx, p.Interface, name, expire)
+}
+
+// GetLifecycle and the other s3.Lifecycle methods forward to the wrapped engine.
+func (p *Processor) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	lc, err := s3.LifecycleOf(p.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return lc.GetLifecycle(ctx)
+}
+
+func (p *Processor) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	lc, err := s3.LifecycleOf(p.Interface)
+	if err != nil {
+		return err
+	}
+	return lc.SetLifecycle(ctx, rules)
+}
+
+func (p *Processor) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	lc, err := s3.LifecycleOf(p.Interface)
+	if err != nil {
+		return err
+	}
+	return lc.SetLegalHold(ctx, name, hold)
+}
+
+func (p *Processor) LegalHold(ctx context.Context, name string) (bool, error) {
+	lc, err := s3.LifecycleOf(p.Interface)
+	if err != nil {
+		return false, err
+	}
+	return lc.LegalHold(ctx, name)
+}
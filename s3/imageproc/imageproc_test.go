@@ -0,0 +1,208 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imageproc
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// objectKey extracts the object key from an s3test access URL.
+func objectKey(t *testing.T, engine *s3test.Engine, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range engine.Keys() {
+		if strings.HasSuffix(u.Path, "/"+key) {
+			return key
+		}
+	}
+	t.Fatalf("no object behind %s", rawURL)
+	return ""
+}
+
+func TestProcessorThumbnail(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	p := New(engine, NewMemoryCache(16))
+	engine.SetObject("photo.png", encodePNG(t, 400, 200))
+
+	rawURL, err := p.AccessURL(ctx, "photo.png", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 100, Height: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := objectKey(t, engine, rawURL)
+	if !strings.HasPrefix(key, DefaultThumbnailPrefix+"/") {
+		t.Fatalf("thumbnail not under the prefix: %s", key)
+	}
+	data, _ := engine.Object(key)
+	thumbnail, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := ImageWidthHeight(thumbnail); format != formatPng || w != 100 || h != 50 {
+		t.Fatalf("unexpected thumbnail %dx%d %s", w, h, format)
+	}
+	if info, err := engine.StatObject(ctx, key); err != nil || info.ContentType != "image/png" {
+		t.Fatalf("unexpected thumbnail object %+v, %v", info, err)
+	}
+
+	fill, err := p.AccessURL(ctx, "photo.png", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 100, Height: 100, Mode: s3.ImageModeFill}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := objectKey(t, engine, fill); !strings.HasSuffix(key, "image_w100_h100.png") {
+		t.Fatalf("unexpected fill thumbnail %s", key)
+	}
+	// The original is downloaded once per generated thumbnail, cached requests touch nothing.
+	if _, err := p.AccessURL(ctx, "photo.png", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 100, Height: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := engine.CallCount(s3test.OpGetObject); n != 2 {
+		t.Fatalf("original downloaded %d times", n)
+	}
+
+	// A second processor without a cache reuses the stored thumbnail.
+	before := engine.CallCount(s3test.OpPutObject)
+	again, err := New(engine, nil).AccessURL(ctx, "photo.png", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 100, Height: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objectKey(t, engine, again) != key || engine.CallCount(s3test.OpPutObject) != before {
+		t.Fatal("thumbnail generated twice")
+	}
+}
+
+func TestProcessorPassThrough(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	p := New(engine, NewMemoryCache(16))
+	engine.SetObject("photo.png", encodePNG(t, 40, 20))
+	engine.SetObject("notes.txt", []byte("not an image"))
+
+	rawURL, err := p.AccessURL(ctx, "photo.png", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 100, Height: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objectKey(t, engine, rawURL) != "photo.png" {
+		t.Fatalf("expected the original for a smaller image, got %s", rawURL)
+	}
+	if _, err := p.AccessURL(ctx, "notes.txt", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 10}}); err == nil {
+		t.Fatal("expected an error for a non image")
+	}
+	if _, err := p.AccessURL(ctx, "notes.txt", time.Minute, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing the object drops its cached metadata.
+	if _, err := p.PutObject(ctx, "photo.png", bytes.NewReader(encodePNG(t, 400, 400)), -1, nil); err != nil {
+		t.Fatal(err)
+	}
+	rawURL, err = p.AccessURL(ctx, "photo.png", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 100, Height: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := objectKey(t, engine, rawURL); !strings.HasSuffix(key, "image_w100_h100.png") {
+		t.Fatalf("stale metadata used: %s", key)
+	}
+}
+
+func TestProcessorFormats(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	p := New(engine, nil)
+	engine.SetObject("photo.png", encodePNG(t, 400, 200))
+
+	rawURL, err := p.AccessURL(ctx, "photo.png", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 100, Format: "webp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := engine.StatObject(ctx, objectKey(t, engine, rawURL)); err != nil || info.ContentType != "image/png" {
+		t.Fatalf("format without encoder not served as png: %+v, %v", info, err)
+	}
+
+	var quality int
+	RegisterEncoder(NewEncoder("x-test", "image/x-test", func(w io.Writer, img image.Image, q int) error {
+		quality = q
+		return png.Encode(w, img)
+	}))
+	rawURL, err = New(engine, nil, WithQuality(85)).AccessURL(ctx, "photo.png", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 100, Format: "X-Test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := objectKey(t, engine, rawURL)
+	if info, err := engine.StatObject(ctx, key); err != nil || info.ContentType != "image/x-test" || quality != 85 {
+		t.Fatalf("registered encoder not used: %+v, %v, quality %d", info, err, quality)
+	}
+}
+
+func TestProcessorAnimatedGIF(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 3; i++ {
+		// Later frames only cover part of the canvas.
+		frame := image.NewPaletted(image.Rect(i*10, 0, 200, 100), palette)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	engine.SetObject("anim.gif", buf.Bytes())
+
+	rawURL, err := New(engine, nil).AccessURL(ctx, "anim.gif", time.Minute, &s3.AccessURLOption{Image: &s3.Image{Width: 50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := engine.Object(objectKey(t, engine, rawURL))
+	out, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Image) != 3 || len(out.Delay) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(out.Image))
+	}
+	for i, frame := range out.Image {
+		if frame.Bounds() != image.Rect(0, 0, 50, 25) {
+			t.Fatalf("frame %d has bounds %v", i, frame.Bounds())
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imageproc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"io"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+// PreviewProvider renders a still image of a file that is not an image, for instance
+// a video frame or the first page of a document.
+type PreviewProvider interface {
+	// Render returns the preview of the given kind from the file content.
+	Render(ctx context.Context, kind string, contentType string, r io.Reader) (image.Image, error)
+}
+
+// PreviewFunc adapts a function to PreviewProvider.
+type PreviewFunc func(ctx context.Context, kind string, contentType string, r io.Reader) (image.Image, error)
+
+func (f PreviewFunc) Render(ctx context.Context, kind string, contentType string, r io.Reader) (image.Image, error) {
+	return f(ctx, kind, contentType, r)
+}
+
+var previewProviders = struct {
+	lock sync.RWMutex
+	m    map[string]PreviewProvider
+}{
+	m: make(map[string]PreviewProvider),
+}
+
+// RegisterPreviewProvider handles contentType with provider. A content type of the form
+// "video/*" covers a whole family and is used when no exact registration matches.
+func RegisterPreviewProvider(contentType string, provider PreviewProvider) {
+	previewProviders.lock.Lock()
+	defer previewProviders.lock.Unlock()
+	previewProviders.m[strings.ToLower(contentType)] = provider
+}
+
+// GetPreviewProvider returns the provider for contentType, parameters such as charset being ignored.
+func GetPreviewProvider(contentType string) (PreviewProvider, bool) {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	previewProviders.lock.RLock()
+	defer previewProviders.lock.RUnlock()
+	if provider, ok := previewProviders.m[contentType]; ok {
+		return provider, true
+	}
+	if family, _, ok := strings.Cut(contentType, "/"); ok {
+		provider, ok := previewProviders.m[family+"/*"]
+		return provider, ok
+	}
+	return nil, false
+}
+
+func checkPosterKind(kind string) error {
+	if kind != "" && kind != s3.PreviewKindPoster {
+		return errs.ErrArgs.WrapMsg("unsupported preview kind", "kind", kind)
+	}
+	return nil
+}
+
+// NewCommandPreviewProvider runs a local tool for posters. The placeholders {input},
+// {output} and {kind} in args are replaced by the file written to a temporary directory,
+// the image file the tool must create and the requested kind. The tool is killed when
+// the context ends.
+func NewCommandPreviewProvider(path string, args ...string) PreviewProvider {
+	return PreviewFunc(func(ctx context.Context, kind string, contentType string, r io.Reader) (image.Image, error) {
+		if err := checkPosterKind(kind); err != nil {
+			return nil, err
+		}
+		dir, err := os.MkdirTemp("", "preview")
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		defer os.RemoveAll(dir)
+		input := filepath.Join(dir, "input")
+		output := filepath.Join(dir, "output.png")
+		if err := writeFile(input, r); err != nil {
+			return nil, err
+		}
+		replacer := strings.NewReplacer("{input}", input, "{output}", output, "{kind}", s3.PreviewKindPoster)
+		cmdArgs := make([]string, len(args))
+		for i, arg := range args {
+			cmdArgs[i] = replacer.Replace(arg)
+		}
+		if out, err := exec.CommandContext(ctx, path, cmdArgs...).CombinedOutput(); err != nil {
+			return nil, errs.WrapMsg(err, "preview command failed", "path", path, "output", strings.TrimSpace(string(out)))
+		}
+		f, err := os.Open(output)
+		if err != nil {
+			return nil, errs.WrapMsg(err, "preview command wrote no image", "path", path)
+		}
+		defer f.Close()
+		img, _, err := ImageStat(f)
+		if err != nil {
+			return nil, errs.WrapMsg(err, "decode preview failed", "path", path)
+		}
+		return img, nil
+	})
+}
+
+// NewFFmpegPreviewProvider takes the first video frame with ffmpeg, found in PATH when path is empty.
+func NewFFmpegPreviewProvider(path string) PreviewProvider {
+	if path == "" {
+		path = "ffmpeg"
+	}
+	return NewCommandPreviewProvider(path, "-hide_banner", "-loglevel", "error", "-y", "-i", "{input}", "-frames:v", "1", "{output}")
+}
+
+func writeFile(name string, r io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return errs.Wrap(err)
+	}
+	return errs.Wrap(f.Close())
+}
+
+// PDFJPEGPreviewProvider makes posters of PDFs without external tools by returning the
+// first embedded JPEG image of the file. It does not render pages: the poster is the
+// first page only for documents made of page-sized JPEG images, as produced by scanners
+// and phone cameras, and text or vector documents have none. It is therefore not
+// registered by default; register it for such documents, or a command provider for
+// "application/pdf" to render pages.
+func PDFJPEGPreviewProvider() PreviewProvider {
+	return PreviewFunc(func(ctx context.Context, kind string, contentType string, r io.Reader) (image.Image, error) {
+		if err := checkPosterKind(kind); err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		stream, ok := firstPDFJPEG(data)
+		if !ok {
+			return nil, errs.ErrArgs.WrapMsg("pdf has no embedded jpeg image")
+		}
+		img, _, err := ImageStat(bytes.NewReader(stream))
+		if err != nil {
+			return nil, errs.WrapMsg(err, "decode pdf image failed")
+		}
+		return img, nil
+	})
+}
+
+// firstPDFJPEG returns the content of the first DCTDecode stream, followed by the rest of
+// the file; JPEG decoding stops at the end-of-image marker.
+func firstPDFJPEG(data []byte) ([]byte, bool) {
+	i := bytes.Index(data, []byte("/DCTDecode"))
+	if i < 0 {
+		return nil, false
+	}
+	j := bytes.Index(data[i:], []byte("stream"))
+	if j < 0 {
+		return nil, false
+	}
+	start := i + j + len("stream")
+	if bytes.HasPrefix(data[start:], []byte("\r\n")) {
+		start += 2
+	} else if bytes.HasPrefix(data[start:], []byte("\n")) {
+		start++
+	}
+	return data[start:], start < len(data)
+}
+
+func (p *Processor) previewURL(ctx context.Context, name string, expire time.Duration, preview *s3.Preview) (string, error) {
+	kind := strings.ToLower(preview.Kind)
+	if kind == "" {
+		kind = s3.PreviewKindPoster
+	}
+	var opt s3.Image
+	if preview.Image != nil {
+		opt = *preview.Image
+	}
+	opt.Format = normalizeFormat(opt.Format)
+	if opt.Format == "" {
+		opt.Format = formatJpeg
+	}
+	enc, ok := GetEncoder(opt.Format)
+	if !ok {
+		opt.Format = formatPng
+		enc, _ = GetEncoder(formatPng)
+	}
+	opt.Mode = strings.ToLower(opt.Mode)
+	if opt.Mode != s3.ImageModeFill {
+		opt.Mode = s3.ImageModeFit
+	}
+	opt.Width, opt.Height = max(opt.Width, 0), max(opt.Height, 0)
+	if opt.Width > p.maxWidth || opt.Height > p.maxHeight {
+		return "", errs.ErrArgs.WrapMsg("preview size too large", "width", opt.Width, "height", opt.Height)
+	}
+	// Previews share the thumbnail cache; the kind in the format keeps them apart from image thumbnails.
+	key, err := p.cache.GetThumbnailKey(ctx, name, "preview-"+kind+"."+opt.Format, opt.Width, opt.Height, func(ctx context.Context) (string, error) {
+		info, err := p.Interface.StatObject(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		key := path.Join(p.prefix, info.ETag, fmt.Sprintf("preview_%s_w%d_h%d.%s", kind, opt.Width, opt.Height, opt.Format))
+		if _, err := p.Interface.StatObject(ctx, key); err == nil {
+			return key, nil
+		} else if !p.Interface.IsNotFound(err) {
+			return "", err
+		}
+		contentType := info.ContentType
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType = mime.TypeByExtension(path.Ext(name))
+		}
+		provider, ok := GetPreviewProvider(contentType)
+		if !ok {
+			return "", errs.ErrArgs.WrapMsg("no preview provider", "name", name, "contentType", contentType)
+		}
+		if info.Size > p.maxPreviewSize {
+			return "", errs.New("file size too large").Wrap()
+		}
+		reader, _, err := p.Interface.GetObject(ctx, name, nil)
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		img, err := provider.Render(ctx, kind, contentType, io.LimitReader(reader, p.maxPreviewSize))
+		if err != nil {
+			return "", err
+		}
+		width, height := opt.Width, opt.Height
+		if width <= 0 && height <= 0 {
+			width, height = getThumbnailSize(img)
+		}
+		thumbnail := resizeImage(img, width, height, opt.Mode, imageScaler(p.filter))
+		buf := bytes.NewBuffer(nil)
+		if err := enc.Encode(buf, thumbnail, p.quality); err != nil {
+			return "", errs.WrapMsg(err, "encode failed", "type", opt.Format)
+		}
+		if _, err := p.Interface.PutObject(ctx, key, buf, int64(buf.Len()), &s3.PutObjectOption{ContentType: enc.ContentType()}); err != nil {
+			return "", err
+		}
+		return key, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return p.Interface.AccessURL(ctx, key, expire, &s3.AccessURLOption{ContentType: enc.ContentType()})
+}
@@ -0,0 +1,122 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imageproc
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+// jpegPDF builds a minimal PDF whose only page is a JPEG image, as scanners produce.
+func jpegPDF(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /XObject /Subtype /Image /Filter /DCTDecode >>\nstream\n")
+	buf.Write(img.Bytes())
+	buf.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return buf.Bytes()
+}
+
+func TestGetPreviewProvider(t *testing.T) {
+	if _, ok := GetPreviewProvider("application/pdf"); ok {
+		t.Fatal("pdf provider registered by default")
+	}
+	if _, ok := GetPreviewProvider("video/x-test"); ok {
+		t.Fatal("unexpected provider")
+	}
+	RegisterPreviewProvider("video/*", NewFFmpegPreviewProvider(""))
+	if _, ok := GetPreviewProvider("video/x-test; codecs=avc1"); !ok {
+		t.Fatal("family provider not used")
+	}
+}
+
+func TestCommandPreviewProvider(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	provider := NewCommandPreviewProvider("sh", "-c", `cp "$0" "$1"`, "{input}", "{output}")
+	img, err := provider.Render(context.Background(), s3.PreviewKindPoster, "video/mp4", bytes.NewReader(encodePNG(t, 30, 20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := ImageWidthHeight(img); w != 30 || h != 20 {
+		t.Fatalf("unexpected poster %dx%d", w, h)
+	}
+	if _, err := provider.Render(context.Background(), "pages", "video/mp4", strings.NewReader("")); !errs.ErrArgs.Is(err) {
+		t.Fatalf("expected ErrArgs for an unknown kind, got %v", err)
+	}
+}
+
+func TestProcessorPreview(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	RegisterPreviewProvider("application/pdf", PDFJPEGPreviewProvider())
+	t.Cleanup(func() {
+		previewProviders.lock.Lock()
+		defer previewProviders.lock.Unlock()
+		delete(previewProviders.m, "application/pdf")
+	})
+	p := New(engine, NewMemoryCache(16))
+	engine.SetObject("doc.pdf", jpegPDF(t, 400, 200))
+	engine.SetObject("notes.txt", []byte("no preview"))
+
+	rawURL, err := p.AccessURL(ctx, "doc.pdf", time.Minute, &s3.AccessURLOption{Preview: &s3.Preview{Image: &s3.Image{Width: 100}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := objectKey(t, engine, rawURL)
+	if !strings.HasPrefix(key, DefaultThumbnailPrefix+"/") || !strings.HasSuffix(key, "preview_poster_w100_h0.jpeg") {
+		t.Fatalf("unexpected preview key %s", key)
+	}
+	data, _ := engine.Object(key)
+	poster, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := ImageWidthHeight(poster); format != formatJpeg || w != 100 || h != 50 {
+		t.Fatalf("unexpected poster %dx%d %s", w, h, format)
+	}
+	// Cached previews are not rendered again.
+	before := engine.CallCount(s3test.OpGetObject)
+	if _, err := p.AccessURL(ctx, "doc.pdf", time.Minute, &s3.AccessURLOption{Preview: &s3.Preview{Image: &s3.Image{Width: 100}}}); err != nil {
+		t.Fatal(err)
+	}
+	if engine.CallCount(s3test.OpGetObject) != before {
+		t.Fatal("preview rendered twice")
+	}
+
+	if _, err := p.AccessURL(ctx, "notes.txt", time.Minute, &s3.AccessURLOption{Preview: &s3.Preview{}}); !errs.ErrArgs.Is(err) {
+		t.Fatalf("expected ErrArgs without a provider, got %v", err)
+	}
+	before = engine.CallCount(s3test.OpGetObject)
+	huge := &s3.AccessURLOption{Preview: &s3.Preview{Image: &s3.Image{Width: defaultMaxWidth + 1, Height: 10}}}
+	if _, err := p.AccessURL(ctx, "doc.pdf", time.Minute, huge); !errs.ErrArgs.Is(err) || engine.CallCount(s3test.OpGetObject) != before {
+		t.Fatalf("expected ErrArgs for an oversized preview, got %v", err)
+	}
+}
@@ -56,6 +56,9 @@ type Config struct {
 	AccessKeySecret string
 	SessionToken    string
 	PublicRead      bool
+	// Encryption enables server-side encryption of uploaded objects. Kodo only supports
+	// EncryptionSSES3, and its form uploads cannot request it.
+	Encryption *s3.Encryption
 }
 
 type Kodo struct {
@@ -68,9 +71,16 @@ type Kodo struct {
 	Auth          *auth.Credentials
 	Client        *awss3.Client
 	PresignClient *awss3.PresignClient
+	Encryption    *s3.Encryption
 }
 
 func NewKodo(conf Config) (*Kodo, error) {
+	if err := conf.Encryption.Check(); err != nil {
+		return nil, err
+	}
+	if conf.Encryption != nil && conf.Encryption.Type != s3.EncryptionSSES3 {
+		return nil, errs.ErrArgs.WrapMsg("kodo only supports SSE-S3", "type", conf.Encryption.Type)
+	}
 	//init client
 	cfg, err := awss3config.LoadDefaultConfig(context.TODO(),
 		awss3config.WithRegion(conf.Bucket),
@@ -98,6 +108,7 @@ func NewKodo(conf Config) (*Kodo, error) {
 		Auth:          auth.New(conf.AccessKeyID, conf.AccessKeySecret),
 		Client:        client,
 		PresignClient: presignClient,
+		Encryption:    conf.Encryption,
 	}, nil
 }
 
@@ -115,8 +126,9 @@ func (k *Kodo) PartLimit() *s3.PartLimit {
 
 func (k *Kodo) InitiateMultipartUpload(ctx context.Context, name string) (*s3.InitiateMultipartUploadResult, error) {
 	result, err := k.Client.CreateMultipartUpload(ctx, &awss3.CreateMultipartUploadInput{
-		Bucket: aws.String(k.Region),
-		Key:    aws.String(name),
+		Bucket:               aws.String(k.Region),
+		Key:                  aws.String(name),
+		ServerSideEncryption: k.serverSide(),
 	})
 	if err != nil {
 		return nil, err
@@ -170,6 +182,38 @@ func (k *Kodo) PartSize(ctx context.Context, size int64) (int64, error) {
 	return partSize, nil
 }
 
+func (k *Kodo) ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*s3.ListMultipartUploadsResult, error) {
+	params := &awss3.ListMultipartUploadsInput{
+		Bucket:     aws.String(k.Region),
+		Prefix:     aws.String(prefix),
+		MaxUploads: aws.Int32(int32(s3.ListLimit(limit))),
+	}
+	if keyMarker != "" {
+		params.KeyMarker = aws.String(keyMarker)
+	}
+	if uploadIDMarker != "" {
+		params.UploadIdMarker = aws.String(uploadIDMarker)
+	}
+	result, err := k.Client.ListMultipartUploads(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	res := &s3.ListMultipartUploadsResult{
+		NextKeyMarker:      aws.ToString(result.NextKeyMarker),
+		NextUploadIDMarker: aws.ToString(result.NextUploadIdMarker),
+		IsTruncated:        aws.ToBool(result.IsTruncated),
+		Uploads:            make([]s3.MultipartUpload, len(result.Uploads)),
+	}
+	for i, upload := range result.Uploads {
+		res.Uploads[i] = s3.MultipartUpload{
+			Key:       aws.ToString(upload.Key),
+			UploadID:  aws.ToString(upload.UploadId),
+			Initiated: aws.ToTime(upload.Initiated),
+		}
+	}
+	return res, nil
+}
+
 func (k *Kodo) AuthSign(ctx context.Context, uploadID string, name string, expire time.Duration, partNumbers []int) (*s3.AuthSignResult, error) {
 	result := s3.AuthSignResult{
 		URL:    k.BucketURL + "/" + name,
@@ -195,16 +239,26 @@ func (k *Kodo) AuthSign(ctx context.Context, uploadID string, name string, expir
 }
 
 func (k *Kodo) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
-	object, err := k.PresignClient.PresignPutObject(ctx, &awss3.PutObjectInput{
-		Bucket: aws.String(k.Region),
-		Key:    aws.String(name),
-	}, awss3.WithPresignExpires(expire), withDisableHTTPPresignerHeaderV4(nil))
+	object, err := k.PresignedPutObjectHeader(ctx, name, expire)
 	if err != nil {
 		return "", err
 	}
 	return object.URL, nil
 }
 
+// PresignedPutObjectHeader also returns the signed headers, which include those of the encryption.
+func (k *Kodo) PresignedPutObjectHeader(ctx context.Context, name string, expire time.Duration) (*s3.PresignedPutObjectResult, error) {
+	object, err := k.PresignClient.PresignPutObject(ctx, &awss3.PutObjectInput{
+		Bucket:               aws.String(k.Region),
+		Key:                  aws.String(name),
+		ServerSideEncryption: k.serverSide(),
+	}, awss3.WithPresignExpires(expire), withDisableHTTPPresignerHeaderV4(nil))
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PresignedPutObjectResult{URL: object.URL, Header: object.SignedHeader}, nil
+}
+
 func (k *Kodo) DeleteObject(ctx context.Context, name string) error {
 	_, err := k.Client.DeleteObject(ctx, &awss3.DeleteObjectInput{
 		Bucket: aws.String(k.Region),
@@ -215,9 +269,10 @@ func (k *Kodo) DeleteObject(ctx context.Context, name string) error {
 
 func (k *Kodo) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyObjectInfo, error) {
 	result, err := k.Client.CopyObject(ctx, &awss3.CopyObjectInput{
-		Bucket:     aws.String(k.Region),
-		CopySource: aws.String(k.Region + "/" + src),
-		Key:        aws.String(dst),
+		Bucket:               aws.String(k.Region),
+		CopySource:           aws.String(k.Region + "/" + src),
+		Key:                  aws.String(dst),
+		ServerSideEncryption: k.serverSide(),
 	})
 	if err != nil {
 		return nil, err
@@ -239,6 +294,9 @@ func (k *Kodo) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, err
 	res := &s3.ObjectInfo{Key: name}
 	res.Size = aws.ToInt64(info.ContentLength)
 	res.ETag = strings.ToLower(strings.ReplaceAll(aws.ToString(info.ETag), `"`, ``))
+	res.LastModified = aws.ToTime(info.LastModified)
+	res.ContentType = aws.ToString(info.ContentType)
+	res.Metadata = s3.LowerMetadata(info.Metadata)
 	return res, nil
 }
 
@@ -344,6 +402,9 @@ func (k *Kodo) SetObjectContentType(ctx context.Context, name string, contentTyp
 }
 func (k *Kodo) FormData(ctx context.Context, name string, size int64, contentType string, duration time.Duration) (*s3.FormData, error) {
 	// https://developer.qiniu.com/kodo/1312/upload
+	if k.Encryption != nil {
+		return nil, errs.ErrArgs.WrapMsg("kodo form uploads do not support server-side encryption")
+	}
 	now := time.Now()
 	expiration := now.Add(duration)
 	resourceKey := k.Region + ":" + name
@@ -382,6 +443,13 @@ func (k *Kodo) FormData(ctx context.Context, name string, size int64, contentTyp
 	return fd, nil
 }
 
+func (k *Kodo) serverSide() awss3types.ServerSideEncryption {
+	if k.Encryption == nil {
+		return ""
+	}
+	return awss3types.ServerSideEncryptionAes256
+}
+
 func withDisableHTTPPresignerHeaderV4(opt *s3.AccessURLOption) func(options *awss3.PresignOptions) {
 	return func(options *awss3.PresignOptions) {
 		options.Presigner = &disableHTTPPresignerHeaderV4{
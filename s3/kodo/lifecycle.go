@@ -0,0 +1,125 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kodo
+
+import (
+	"context"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/qiniu/go-sdk/v7/storage"
+)
+
+var _ s3.Lifecycle = (*Kodo)(nil)
+
+// Kodo rules are managed one by one through the native bucket API, which has a day field
+// per storage class instead of a list of transitions.
+func (k *Kodo) bucketManager() *storage.BucketManager {
+	return storage.NewBucketManager(k.Auth, &storage.Config{UseHTTPS: true})
+}
+
+func (k *Kodo) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	kodoRules, err := k.bucketManager().GetBucketLifeCycleRule(k.Region)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]s3.LifecycleRule, 0, len(kodoRules))
+	for _, r := range kodoRules {
+		rule := s3.LifecycleRule{ID: r.Name, Prefix: r.Prefix, ExpireDays: r.DeleteAfterDays}
+		for _, t := range []s3.LifecycleTransition{
+			{Days: r.ToLineAfterDays, StorageClass: s3.StorageClassInfrequent},
+			{Days: r.ToArchiveAfterDays, StorageClass: s3.StorageClassArchive},
+			{Days: r.ToDeepArchiveAfterDays, StorageClass: s3.StorageClassDeepArchive},
+		} {
+			if t.Days > 0 {
+				rule.Transitions = append(rule.Transitions, t)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func kodoLifecycleRule(rule s3.LifecycleRule) (*storage.BucketLifeCycleRule, error) {
+	if rule.Vendor != nil {
+		return nil, errs.ErrArgs.WrapMsg("not a kodo lifecycle rule", "id", rule.ID)
+	}
+	if rule.Disabled {
+		return nil, errs.ErrArgs.WrapMsg("kodo lifecycle rules cannot be disabled", "id", rule.ID)
+	}
+	r := &storage.BucketLifeCycleRule{Name: rule.ID, Prefix: rule.Prefix, DeleteAfterDays: rule.ExpireDays}
+	for _, t := range rule.Transitions {
+		switch t.StorageClass {
+		case s3.StorageClassInfrequent:
+			r.ToLineAfterDays = t.Days
+		case s3.StorageClassArchive:
+			r.ToArchiveAfterDays = t.Days
+		case s3.StorageClassDeepArchive:
+			r.ToDeepArchiveAfterDays = t.Days
+		default:
+			return nil, errs.ErrArgs.WrapMsg("unsupported kodo storage class", "id", rule.ID, "storageClass", t.StorageClass)
+		}
+	}
+	return r, nil
+}
+
+// SetLifecycle adds, updates and deletes rules until the bucket has exactly rules.
+func (k *Kodo) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	desired := make([]*storage.BucketLifeCycleRule, 0, len(rules))
+	for _, rule := range rules {
+		r, err := kodoLifecycleRule(rule)
+		if err != nil {
+			return err
+		}
+		desired = append(desired, r)
+	}
+	manager := k.bucketManager()
+	current, err := manager.GetBucketLifeCycleRule(k.Region)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]storage.BucketLifeCycleRule, len(current))
+	for _, r := range current {
+		existing[r.Name] = r
+	}
+	for _, r := range desired {
+		old, ok := existing[r.Name]
+		delete(existing, r.Name)
+		switch {
+		case !ok:
+			err = manager.AddBucketLifeCycleRule(k.Region, r)
+		case old != *r:
+			err = manager.UpdateBucketLifeCycleRule(k.Region, r)
+		}
+		if err != nil {
+			return errs.WrapMsg(err, "set kodo lifecycle rule failed", "name", r.Name)
+		}
+	}
+	for name := range existing {
+		if err := manager.DelBucketLifeCycleRule(k.Region, name); err != nil {
+			return errs.WrapMsg(err, "delete kodo lifecycle rule failed", "name", name)
+		}
+	}
+	return nil
+}
+
+// SetLegalHold is not supported, Kodo has no object lock.
+func (k *Kodo) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	return s3.ErrLegalHoldNotSupported.WrapMsg("kodo has no object legal hold", "name", name)
+}
+
+func (k *Kodo) LegalHold(ctx context.Context, name string) (bool, error) {
+	return false, s3.ErrLegalHoldNotSupported.WrapMsg("kodo has no object legal hold", "name", name)
+}
@@ -0,0 +1,159 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kodo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func (k *Kodo) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	return s3.PutStream(ctx, k, objectWriter{k}, name, reader, size, opt)
+}
+
+func (k *Kodo) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	params := &awss3.GetObjectInput{
+		Bucket: aws.String(k.Region),
+		Key:    aws.String(name),
+	}
+	if rangeHeader := opt.RangeHeader(); rangeHeader != "" {
+		params.Range = aws.String(rangeHeader)
+	}
+	res, err := k.Client.GetObject(ctx, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &s3.ObjectInfo{
+		ETag:         formatETag(aws.ToString(res.ETag)),
+		Key:          name,
+		Size:         s3.ObjectSize(aws.ToInt64(res.ContentLength), aws.ToString(res.ContentRange)),
+		LastModified: aws.ToTime(res.LastModified),
+		ContentType:  aws.ToString(res.ContentType),
+		Metadata:     s3.LowerMetadata(res.Metadata),
+	}
+	return res.Body, info, nil
+}
+
+func formatETag(etag string) string {
+	return strings.ToLower(strings.ReplaceAll(etag, `"`, ``))
+}
+
+func (k *Kodo) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	params := &awss3.ListObjectsV2Input{
+		Bucket:  aws.String(k.Region),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(s3.ListLimit(limit))),
+	}
+	if marker != "" {
+		params.StartAfter = aws.String(marker)
+	}
+	res, err := k.Client.ListObjectsV2(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*s3.ObjectInfo, 0, len(res.Contents))
+	for _, object := range res.Contents {
+		objects = append(objects, &s3.ObjectInfo{
+			ETag:         formatETag(aws.ToString(object.ETag)),
+			Key:          aws.ToString(object.Key),
+			Size:         aws.ToInt64(object.Size),
+			LastModified: aws.ToTime(object.LastModified),
+		})
+	}
+	return s3.ListResult(objects, aws.ToBool(res.IsTruncated)), nil
+}
+
+type objectWriter struct {
+	k *Kodo
+}
+
+func (w objectWriter) PutObject(ctx context.Context, name string, data []byte, opt *s3.PutObjectOption) (string, error) {
+	params := &awss3.PutObjectInput{
+		Bucket:               aws.String(w.k.Region),
+		Key:                  aws.String(name),
+		Body:                 bytes.NewReader(data),
+		ContentLength:        aws.Int64(int64(len(data))),
+		ServerSideEncryption: w.k.serverSide(),
+	}
+	if opt != nil {
+		if opt.ContentType != "" {
+			params.ContentType = aws.String(opt.ContentType)
+		}
+		params.Metadata = opt.Metadata
+	}
+	res, err := w.k.Client.PutObject(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return formatETag(aws.ToString(res.ETag)), nil
+}
+
+func (w objectWriter) InitiateMultipart(ctx context.Context, name string, opt *s3.PutObjectOption) (s3.MultipartWriter, error) {
+	params := &awss3.CreateMultipartUploadInput{
+		Bucket:               aws.String(w.k.Region),
+		Key:                  aws.String(name),
+		ServerSideEncryption: w.k.serverSide(),
+	}
+	if opt != nil {
+		if opt.ContentType != "" {
+			params.ContentType = aws.String(opt.ContentType)
+		}
+		params.Metadata = opt.Metadata
+	}
+	res, err := w.k.Client.CreateMultipartUpload(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return &multipartWriter{k: w.k, name: name, uploadID: aws.ToString(res.UploadId)}, nil
+}
+
+type multipartWriter struct {
+	k        *Kodo
+	name     string
+	uploadID string
+}
+
+func (w *multipartWriter) UploadPart(ctx context.Context, partNumber int, data []byte) (string, error) {
+	res, err := w.k.Client.UploadPart(ctx, &awss3.UploadPartInput{
+		Bucket:        aws.String(w.k.Region),
+		Key:           aws.String(w.name),
+		UploadId:      aws.String(w.uploadID),
+		PartNumber:    aws.Int32(int32(partNumber)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(res.ETag), nil
+}
+
+func (w *multipartWriter) Complete(ctx context.Context, parts []s3.Part) (string, error) {
+	res, err := w.k.CompleteMultipartUpload(ctx, w.uploadID, w.name, parts)
+	if err != nil {
+		return "", err
+	}
+	return res.ETag, nil
+}
+
+func (w *multipartWriter) Abort(ctx context.Context) error {
+	return w.k.AbortMultipartUpload(ctx, w.uploadID, w.name)
+}
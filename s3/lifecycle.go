@@ -0,0 +1,232 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"slices"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+const (
+	// StorageClassInfrequent is the infrequent access class, such as STANDARD_IA or IA.
+	StorageClassInfrequent = "infrequent"
+	// StorageClassArchive is the archive class, such as GLACIER or Archive.
+	StorageClassArchive = "archive"
+	// StorageClassDeepArchive is the deep archive class, such as DEEP_ARCHIVE or ColdArchive.
+	StorageClassDeepArchive = "deep-archive"
+)
+
+var (
+	// ErrLifecycleNotSupported is returned for engines that cannot manage lifecycle rules.
+	ErrLifecycleNotSupported = errs.New("s3 lifecycle not supported")
+	// ErrLegalHoldNotSupported is returned by engines whose vendor has no object legal hold.
+	ErrLegalHoldNotSupported = errs.New("s3 legal hold not supported")
+)
+
+// LifecycleTransition moves objects to StorageClass Days days after they were written.
+type LifecycleTransition struct {
+	Days int `json:"days"`
+	// StorageClass is one of the StorageClass constants, or a vendor class name passed through as is.
+	StorageClass string `json:"storageClass"`
+}
+
+// LifecycleRule applies to the objects under Prefix, all of them when it is empty.
+type LifecycleRule struct {
+	// ID names the rule; reconciling matches rules by ID.
+	ID     string `json:"id"`
+	Prefix string `json:"prefix"`
+	// ExpireDays deletes objects that many days after they were written, never when zero.
+	ExpireDays  int                   `json:"expireDays"`
+	Transitions []LifecycleTransition `json:"transitions"`
+	// Disabled rules stay in the configuration without taking effect.
+	Disabled bool `json:"disabled"`
+	// Vendor is set by GetLifecycle to the engine rule when it uses features LifecycleRule
+	// cannot express, such as tag or size filters, noncurrent version actions or aborting
+	// multipart uploads; the other fields then only describe part of it. SetLifecycle writes
+	// such a rule back untouched.
+	Vendor any `json:"-"`
+}
+
+// Check validates r.
+func (r *LifecycleRule) Check() error {
+	if r.ID == "" {
+		return errs.ErrArgs.WrapMsg("lifecycle rule id is empty")
+	}
+	if r.ExpireDays < 0 {
+		return errs.ErrArgs.WrapMsg("invalid lifecycle expire days", "id", r.ID, "days", r.ExpireDays)
+	}
+	if r.ExpireDays == 0 && len(r.Transitions) == 0 {
+		return errs.ErrArgs.WrapMsg("lifecycle rule has no action", "id", r.ID)
+	}
+	for _, t := range r.Transitions {
+		if t.Days <= 0 || t.StorageClass == "" {
+			return errs.ErrArgs.WrapMsg("invalid lifecycle transition", "id", r.ID, "days", t.Days, "storageClass", t.StorageClass)
+		}
+		if r.ExpireDays > 0 && t.Days >= r.ExpireDays {
+			return errs.ErrArgs.WrapMsg("lifecycle transition after expiration", "id", r.ID, "days", t.Days, "expireDays", r.ExpireDays)
+		}
+	}
+	return nil
+}
+
+// Equal reports whether r and o have the same effect, whatever the order of their transitions.
+func (r *LifecycleRule) Equal(o *LifecycleRule) bool {
+	if r.ID != o.ID || r.Prefix != o.Prefix || r.ExpireDays != o.ExpireDays || r.Disabled != o.Disabled || len(r.Transitions) != len(o.Transitions) {
+		return false
+	}
+	sorted := func(ts []LifecycleTransition) []LifecycleTransition {
+		ts = slices.Clone(ts)
+		slices.SortFunc(ts, func(a, b LifecycleTransition) int {
+			return a.Days - b.Days
+		})
+		return ts
+	}
+	return slices.Equal(sorted(r.Transitions), sorted(o.Transitions))
+}
+
+// Lifecycle is implemented by engines that manage bucket lifecycle rules and object
+// legal holds. Rules take effect in the background, usually within a day.
+type Lifecycle interface {
+	// GetLifecycle returns the rules of the bucket, none when it has no configuration.
+	GetLifecycle(ctx context.Context) ([]LifecycleRule, error)
+	// SetLifecycle replaces every rule of the bucket; no rules removes the configuration.
+	SetLifecycle(ctx context.Context, rules []LifecycleRule) error
+	// SetLegalHold places or releases a legal hold, which keeps the object from being
+	// deleted or overwritten. The bucket must have object lock enabled.
+	SetLegalHold(ctx context.Context, name string, hold bool) error
+	// LegalHold reports whether the object is under a legal hold.
+	LegalHold(ctx context.Context, name string) (bool, error)
+}
+
+// LifecycleOf returns impl as a Lifecycle, or ErrLifecycleNotSupported. Wrappers of an
+// Interface forward their Lifecycle methods through it.
+func LifecycleOf(impl Interface) (Lifecycle, error) {
+	lc, ok := impl.(Lifecycle)
+	if !ok {
+		return nil, ErrLifecycleNotSupported.WrapMsg("engine does not implement s3.Lifecycle", "engine", impl.Engine())
+	}
+	return lc, nil
+}
+
+// StorageClasses maps the StorageClass constants to the class names of a vendor.
+type StorageClasses map[string]string
+
+// AmzStorageClasses are the classes of Amazon S3 and compatible services.
+var AmzStorageClasses = StorageClasses{
+	StorageClassInfrequent:  "STANDARD_IA",
+	StorageClassArchive:     "GLACIER",
+	StorageClassDeepArchive: "DEEP_ARCHIVE",
+}
+
+// Vendor returns the vendor name of class; unknown classes are returned unchanged.
+func (s StorageClasses) Vendor(class string) string {
+	if name, ok := s[class]; ok {
+		return name
+	}
+	return class
+}
+
+// Class returns the StorageClass constant of a vendor class name, or the name itself.
+func (s StorageClasses) Class(name string) string {
+	for class, vendor := range s {
+		if vendor == name {
+			return class
+		}
+	}
+	return name
+}
+
+// LifecycleChanges lists the rule IDs ReconcileLifecycle changed.
+type LifecycleChanges struct {
+	Added   []string `json:"added"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+	// Kept lists the vendor rules of the bucket that were left untouched.
+	Kept []string `json:"kept"`
+}
+
+// Empty reports whether the bucket already had the desired rules.
+func (c *LifecycleChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+// ReconcileLifecycle makes desired the rules of the bucket of impl, which must implement
+// Lifecycle. Rules are matched by ID; rules of the bucket not in desired are removed,
+// and nothing is written when the bucket already has the desired rules. It is meant to
+// run at startup, so that the rules live in code instead of in each vendor console.
+//
+// Rules of the bucket that LifecycleRule cannot express, those with Vendor set, are
+// written back untouched, and desired may not replace them: reconciling fails with
+// errs.ErrArgs until they are removed by hand.
+func ReconcileLifecycle(ctx context.Context, impl Interface, desired []LifecycleRule) (*LifecycleChanges, error) {
+	lc, err := LifecycleOf(impl)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]struct{}, len(desired))
+	for i := range desired {
+		if err := desired[i].Check(); err != nil {
+			return nil, err
+		}
+		if _, ok := ids[desired[i].ID]; ok {
+			return nil, errs.ErrArgs.WrapMsg("duplicate lifecycle rule id", "id", desired[i].ID)
+		}
+		if desired[i].Vendor != nil {
+			return nil, errs.ErrArgs.WrapMsg("vendor lifecycle rules cannot be reconciled", "id", desired[i].ID)
+		}
+		ids[desired[i].ID] = struct{}{}
+	}
+	current, err := lc.GetLifecycle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*LifecycleRule, len(current))
+	for i := range current {
+		existing[current[i].ID] = &current[i]
+	}
+	var changes LifecycleChanges
+	for i := range desired {
+		rule, ok := existing[desired[i].ID]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, desired[i].ID)
+		case rule.Vendor != nil:
+			return nil, errs.ErrArgs.WrapMsg("lifecycle rule of the bucket uses features that cannot be reconciled", "id", rule.ID)
+		case !rule.Equal(&desired[i]):
+			changes.Updated = append(changes.Updated, desired[i].ID)
+		}
+	}
+	rules := slices.Clone(desired)
+	for _, rule := range current {
+		if _, ok := ids[rule.ID]; ok {
+			continue
+		}
+		if rule.Vendor != nil {
+			changes.Kept = append(changes.Kept, rule.ID)
+			rules = append(rules, rule)
+			continue
+		}
+		changes.Removed = append(changes.Removed, rule.ID)
+	}
+	if changes.Empty() {
+		return &changes, nil
+	}
+	if err := lc.SetLifecycle(ctx, rules); err != nil {
+		return nil, err
+	}
+	return &changes, nil
+}
@@ -0,0 +1,183 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+type noLifecycle struct {
+	s3.Interface
+}
+
+func TestReconcileLifecycle(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	desired := []s3.LifecycleRule{
+		{ID: "temp", Prefix: "temp/", ExpireDays: 1},
+		{ID: "logs", Prefix: "logs/", ExpireDays: 365, Transitions: []s3.LifecycleTransition{
+			{Days: 90, StorageClass: s3.StorageClassArchive},
+			{Days: 30, StorageClass: s3.StorageClassInfrequent},
+		}},
+	}
+	changes, err := s3.ReconcileLifecycle(ctx, engine, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changes.Added, []string{"temp", "logs"}) || len(changes.Updated) != 0 || len(changes.Removed) != 0 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+
+	// Transitions compare regardless of order, so the same rules reconcile to nothing.
+	slices.Reverse(desired[1].Transitions)
+	engine.ResetCalls()
+	if changes, err = s3.ReconcileLifecycle(ctx, engine, desired); err != nil {
+		t.Fatal(err)
+	}
+	if !changes.Empty() || engine.CallCount(s3test.OpSetLifecycle) != 0 {
+		t.Fatalf("unchanged rules rewritten: %+v", changes)
+	}
+
+	desired = []s3.LifecycleRule{
+		{ID: "logs", Prefix: "logs/", ExpireDays: 180},
+		{ID: "uploads", Prefix: "uploads/", Transitions: []s3.LifecycleTransition{{Days: 30, StorageClass: s3.StorageClassDeepArchive}}},
+	}
+	if changes, err = s3.ReconcileLifecycle(ctx, engine, desired); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changes.Added, []string{"uploads"}) || !slices.Equal(changes.Updated, []string{"logs"}) || !slices.Equal(changes.Removed, []string{"temp"}) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	rules, err := engine.GetLifecycle(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || !rules[0].Equal(&desired[0]) || !rules[1].Equal(&desired[1]) {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+
+	if changes, err = s3.ReconcileLifecycle(ctx, engine, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Removed) != 2 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	if rules, _ := engine.GetLifecycle(ctx); len(rules) != 0 {
+		t.Fatalf("rules left behind: %+v", rules)
+	}
+}
+
+func TestReconcileLifecycleKeepsVendorRules(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	// A tag filtered rule only reads partially as a LifecycleRule, and an operator disabled "old".
+	tagged := s3.LifecycleRule{ID: "tagged", ExpireDays: 30, Vendor: "tag filter"}
+	if err := engine.SetLifecycle(ctx, []s3.LifecycleRule{tagged, {ID: "old", Prefix: "old/", ExpireDays: 7, Disabled: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := []s3.LifecycleRule{{ID: "old", Prefix: "old/", ExpireDays: 7}}
+	changes, err := s3.ReconcileLifecycle(ctx, engine, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changes.Updated, []string{"old"}) || !slices.Equal(changes.Kept, []string{"tagged"}) || len(changes.Added) != 0 || len(changes.Removed) != 0 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	rules, err := engine.GetLifecycle(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || !rules[0].Equal(&desired[0]) || rules[1].ID != "tagged" || rules[1].Vendor != tagged.Vendor {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+
+	// Disabling is a change like any other.
+	desired[0].Disabled = true
+	if changes, err = s3.ReconcileLifecycle(ctx, engine, desired); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changes.Updated, []string{"old"}) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	engine.ResetCalls()
+	if changes, err = s3.ReconcileLifecycle(ctx, engine, desired); err != nil {
+		t.Fatal(err)
+	}
+	if !changes.Empty() || engine.CallCount(s3test.OpSetLifecycle) != 0 {
+		t.Fatalf("unchanged rules rewritten: %+v", changes)
+	}
+
+	// A vendor rule cannot be replaced, only kept.
+	engine.ResetCalls()
+	if _, err := s3.ReconcileLifecycle(ctx, engine, []s3.LifecycleRule{{ID: "tagged", ExpireDays: 30}}); !errs.ErrArgs.Is(err) {
+		t.Fatalf("expected ErrArgs, got %v", err)
+	}
+	if engine.CallCount(s3test.OpSetLifecycle) != 0 {
+		t.Fatal("vendor rule replaced")
+	}
+	if changes, err = s3.ReconcileLifecycle(ctx, engine, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changes.Removed, []string{"old"}) || !slices.Equal(changes.Kept, []string{"tagged"}) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	if rules, _ := engine.GetLifecycle(ctx); len(rules) != 1 || rules[0].Vendor != tagged.Vendor {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+}
+
+func TestReconcileLifecycleInvalid(t *testing.T) {
+	ctx := context.Background()
+	engine := s3test.NewEngine()
+	for _, rules := range [][]s3.LifecycleRule{
+		{{ID: "", ExpireDays: 1}},
+		{{ID: "none"}},
+		{{ID: "negative", ExpireDays: -1}},
+		{{ID: "late", ExpireDays: 30, Transitions: []s3.LifecycleTransition{{Days: 30, StorageClass: s3.StorageClassArchive}}}},
+		{{ID: "class", Transitions: []s3.LifecycleTransition{{Days: 30}}}},
+		{{ID: "dup", ExpireDays: 1}, {ID: "dup", ExpireDays: 2}},
+	} {
+		if _, err := s3.ReconcileLifecycle(ctx, engine, rules); !errs.ErrArgs.Is(err) {
+			t.Fatalf("%+v: expected ErrArgs, got %v", rules, err)
+		}
+	}
+	if n := engine.CallCount(s3test.OpGetLifecycle); n != 0 {
+		t.Fatalf("invalid rules reached the engine %d times", n)
+	}
+	_, err := s3.ReconcileLifecycle(ctx, noLifecycle{engine}, []s3.LifecycleRule{{ID: "temp", ExpireDays: 1}})
+	if !errors.Is(err, s3.ErrLifecycleNotSupported) {
+		t.Fatalf("expected ErrLifecycleNotSupported, got %v", err)
+	}
+}
+
+func TestStorageClasses(t *testing.T) {
+	if name := s3.AmzStorageClasses.Vendor(s3.StorageClassArchive); name != "GLACIER" {
+		t.Fatalf("unexpected vendor class %s", name)
+	}
+	if class := s3.AmzStorageClasses.Class("DEEP_ARCHIVE"); class != s3.StorageClassDeepArchive {
+		t.Fatalf("unexpected class %s", class)
+	}
+	if name := s3.AmzStorageClasses.Vendor("INTELLIGENT_TIERING"); name != "INTELLIGENT_TIERING" {
+		t.Fatalf("vendor class not passed through: %s", name)
+	}
+}
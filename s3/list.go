@@ -0,0 +1,150 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// MaxListLimit is the largest page every engine returns from ListObjects.
+const MaxListLimit = 1000
+
+// ListLimit clamps a requested page size to (0, MaxListLimit].
+func ListLimit(limit int) int {
+	if limit <= 0 || limit > MaxListLimit {
+		return MaxListLimit
+	}
+	return limit
+}
+
+// ListResult builds the page of a listing from the objects the vendor listing returned.
+func ListResult(objects []*ObjectInfo, truncated bool) *ListObjectsResult {
+	res := &ListObjectsResult{
+		Objects:     objects,
+		IsTruncated: truncated,
+	}
+	if truncated && len(objects) > 0 {
+		res.NextMarker = objects[len(objects)-1].Key
+	}
+	return res
+}
+
+// StatListed fills in the content type and user metadata of a listing, which vendor list
+// APIs do not return, by stating every listed object: it costs one request per object, so
+// only callers that need those fields should use it. Objects deleted since the listing
+// are dropped.
+func StatListed(ctx context.Context, impl Interface, res *ListObjectsResult) error {
+	objects := make([]*ObjectInfo, 0, len(res.Objects))
+	for _, listed := range res.Objects {
+		info, err := impl.StatObject(ctx, listed.Key)
+		if err != nil {
+			if impl.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		objects = append(objects, info)
+	}
+	res.Objects = objects
+	return nil
+}
+
+// MetadataFromHeader collects the user metadata carried in header under prefix, such as "X-Amz-Meta-".
+func MetadataFromHeader(header http.Header, prefix string) map[string]string {
+	var metadata map[string]string
+	for key, values := range header {
+		if len(key) <= len(prefix) || !strings.EqualFold(key[:len(prefix)], prefix) || len(values) == 0 {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[strings.ToLower(key[len(prefix):])] = values[0]
+	}
+	return metadata
+}
+
+// LowerMetadata returns metadata with lower-cased keys, as reported by every engine.
+func LowerMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		res[strings.ToLower(k)] = v
+	}
+	return res
+}
+
+// ObjectIterator walks every object under a prefix, fetching ListObjects pages on demand.
+//
+//	it := s3.NewObjectIterator(impl, "openim/temp/", 0)
+//	for it.Next(ctx) {
+//		fmt.Println(it.Object().Key)
+//	}
+//	return it.Err()
+type ObjectIterator struct {
+	impl    Interface
+	prefix  string
+	marker  string
+	limit   int
+	page    []*ObjectInfo
+	index   int
+	current *ObjectInfo
+	done    bool
+	err     error
+}
+
+func NewObjectIterator(impl Interface, prefix string, limit int) *ObjectIterator {
+	return &ObjectIterator{
+		impl:   impl,
+		prefix: prefix,
+		limit:  limit,
+	}
+}
+
+// Next advances to the next object, reporting false once the listing is exhausted or fails.
+func (it *ObjectIterator) Next(ctx context.Context) bool {
+	for it.index >= len(it.page) {
+		if it.done || it.err != nil {
+			it.current = nil
+			return false
+		}
+		res, err := it.impl.ListObjects(ctx, it.prefix, it.marker, it.limit)
+		if err != nil {
+			it.err = err
+			it.current = nil
+			return false
+		}
+		it.page, it.index = res.Objects, 0
+		it.done = !res.IsTruncated || res.NextMarker == ""
+		it.marker = res.NextMarker
+	}
+	it.current = it.page[it.index]
+	it.index++
+	return true
+}
+
+// Object returns the object Next advanced to.
+func (it *ObjectIterator) Object() *ObjectInfo {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ObjectIterator) Err() error {
+	return it.err
+}
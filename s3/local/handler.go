@@ -0,0 +1,230 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+// maxFormValue bounds the form fields sent before the file of a form upload.
+const maxFormValue = 64 * 1024
+
+// Handler serves the URLs produced by PresignedPutObject, AuthSign, AccessURL and FormData.
+// It must be mounted so that it receives the full request path of Config.Endpoint.
+func (l *Local) Handler() http.Handler {
+	return http.HandlerFunc(l.serveHTTP)
+}
+
+func (l *Local) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, l.prefix+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, l.prefix+"/")
+	switch r.Method {
+	case http.MethodPut:
+		l.servePut(w, r, name)
+	case http.MethodGet, http.MethodHead:
+		l.serveGet(w, r, name)
+	case http.MethodPost:
+		l.servePost(w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (l *Local) servePut(w http.ResponseWriter, r *http.Request, name string) {
+	name, err := cleanKey(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	if err := l.verify(http.MethodPut, name, query); err != nil {
+		writeError(w, err)
+		return
+	}
+	var etag string
+	if uploadID := query.Get(queryUploadID); uploadID != "" {
+		partNumber, err := strconv.Atoi(query.Get(queryPartNumber))
+		if err != nil {
+			writeError(w, errs.ErrArgs.WrapMsg("invalid part number", "partNumber", query.Get(queryPartNumber)))
+			return
+		}
+		etag, err = l.putPart(uploadID, name, partNumber, r.Body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	} else {
+		meta, err := l.putObject(name, r.Body, -1, objectMeta{ContentType: r.Header.Get("Content-Type")})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		etag = meta.ETag
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(successCode)
+}
+
+func (l *Local) serveGet(w http.ResponseWriter, r *http.Request, name string) {
+	name, err := cleanKey(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	if err := l.verify(http.MethodGet, name, query); err != nil {
+		writeError(w, err)
+		return
+	}
+	l.lock.RLock()
+	info, meta, err := l.stat(name)
+	var file *os.File
+	if err == nil {
+		file, err = os.Open(l.objectPath(name))
+	}
+	l.lock.RUnlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer file.Close()
+	header := w.Header()
+	header.Set("ETag", `"`+info.ETag+`"`)
+	if contentType := query.Get(queryResponseContentType); contentType != "" {
+		header.Set("Content-Type", contentType)
+	} else if meta.ContentType != "" {
+		header.Set("Content-Type", meta.ContentType)
+	}
+	if disposition := query.Get(queryResponseContentDisposition); disposition != "" {
+		header.Set("Content-Disposition", disposition)
+	}
+	http.ServeContent(w, r, "", info.LastModified, file)
+}
+
+// servePost handles form uploads. As with S3 the file must be the last field, so the
+// policy is checked before the file is read and an oversized file is cut off while it streams.
+func (l *Local) servePost(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, errs.ErrArgs.WrapMsg("parse multipart form failed", "err", err))
+		return
+	}
+	values := make(url.Values)
+	var file *multipart.Part
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			writeError(w, errs.ErrArgs.WrapMsg("form file not found"))
+			return
+		} else if err != nil {
+			writeError(w, errs.ErrArgs.WrapMsg("parse multipart form failed", "err", err))
+			return
+		}
+		if part.FormName() == "file" {
+			file = part
+			break
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxFormValue+1))
+		if err != nil {
+			writeError(w, errs.ErrArgs.WrapMsg("read form field failed", "err", err))
+			return
+		}
+		if len(value) > maxFormValue {
+			writeError(w, errs.ErrArgs.WrapMsg("form field too large", "field", part.FormName()))
+			return
+		}
+		values.Add(part.FormName(), string(value))
+	}
+	defer file.Close()
+	name, err := cleanKey(values.Get("key"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	policyStr := values.Get("policy")
+	signature := l.signature(http.MethodPost, name, url.Values{"policy": {policyStr}})
+	if !hmac.Equal([]byte(signature), []byte(values.Get("signature"))) {
+		writeError(w, ErrSignatureMismatch.Wrap())
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(policyStr)
+	if err != nil {
+		writeError(w, errs.ErrArgs.WrapMsg("invalid policy"))
+		return
+	}
+	var policy formPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		writeError(w, errs.ErrArgs.WrapMsg("invalid policy"))
+		return
+	}
+	if policy.Key != name {
+		writeError(w, ErrSignatureMismatch.WrapMsg("policy key mismatching", "key", name))
+		return
+	}
+	if time.Now().Unix() > policy.Expires {
+		writeError(w, ErrSignatureExpired.Wrap())
+		return
+	}
+	contentType := values.Get("Content-Type")
+	if policy.ContentType != "" && contentType != policy.ContentType {
+		writeError(w, errs.ErrArgs.WrapMsg("content type mismatching", "contentType", contentType))
+		return
+	}
+	var body io.Reader = file
+	if policy.MaxSize > 0 {
+		body = http.MaxBytesReader(w, file, policy.MaxSize)
+	}
+	meta, err := l.putObject(name, body, -1, objectMeta{ContentType: contentType})
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = errs.ErrArgs.WrapMsg("file too large", "maxSize", policy.MaxSize)
+		}
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+meta.ETag+`"`)
+	w.WriteHeader(successCode)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		code = http.StatusNotFound
+	case ErrSignatureMismatch.Is(errs.Unwrap(err)), ErrSignatureExpired.Is(errs.Unwrap(err)):
+		code = http.StatusForbidden
+	case ErrInvalidKey.Is(errs.Unwrap(err)), errs.ErrArgs.Is(err):
+		code = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), code)
+}
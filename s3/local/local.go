@@ -0,0 +1,841 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/google/uuid"
+)
+
+const (
+	minPartSize int64 = 1024 * 1024 * 5        // 5MB
+	maxPartSize int64 = 1024 * 1024 * 1024 * 5 // 5GB
+	maxNumSize  int64 = 10000
+)
+
+const (
+	objectDir = "objects"
+	metaDir   = "meta"
+	uploadDir = "uploads"
+	tempDir   = "tmp"
+
+	metaSuffix = ".json"
+	uploadMeta = "upload.json"
+)
+
+const successCode = http.StatusOK
+
+var _ s3.Interface = (*Local)(nil)
+
+var ErrInvalidKey = errs.New("invalid object key")
+
+type Config struct {
+	// Root is the directory holding objects, metadata and in-progress multipart uploads.
+	Root string
+	// Endpoint is the public base URL the Handler is served under, e.g. http://127.0.0.1:10002/object.
+	Endpoint string
+	// SecretKey signs the URLs handed out to clients and must be shared by every process serving the Handler.
+	SecretKey string
+}
+
+type objectMeta struct {
+	ETag        string            `json:"etag"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+type uploadInfo struct {
+	Key       string    `json:"key"`
+	Initiated time.Time `json:"initiated"`
+}
+
+type partMeta struct {
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+func NewLocal(conf Config) (*Local, error) {
+	if conf.Root == "" {
+		return nil, errs.New("local root is empty").Wrap()
+	}
+	if conf.SecretKey == "" {
+		return nil, errs.New("local secret key is empty").Wrap()
+	}
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, errs.WrapMsg(err, "parse local endpoint failed", "endpoint", conf.Endpoint)
+	}
+	root, err := filepath.Abs(conf.Root)
+	if err != nil {
+		return nil, errs.WrapMsg(err, "local root abs failed", "root", conf.Root)
+	}
+	for _, dir := range []string{objectDir, metaDir, uploadDir, tempDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			return nil, errs.WrapMsg(err, "create local dir failed", "dir", dir)
+		}
+	}
+	return &Local{
+		root:     root,
+		endpoint: strings.TrimSuffix(u.String(), "/"),
+		prefix:   strings.TrimSuffix(u.Path, "/"),
+		secret:   []byte(conf.SecretKey),
+		lock:     &sync.RWMutex{},
+	}, nil
+}
+
+type Local struct {
+	root     string
+	endpoint string
+	prefix   string
+	secret   []byte
+	lock     *sync.RWMutex
+}
+
+func (l *Local) Engine() string {
+	return "local"
+}
+
+func (l *Local) PartLimit() *s3.PartLimit {
+	return &s3.PartLimit{
+		MinPartSize: minPartSize,
+		MaxPartSize: maxPartSize,
+		MaxNumSize:  maxNumSize,
+	}
+}
+
+func (l *Local) PartSize(ctx context.Context, size int64) (int64, error) {
+	if size <= 0 {
+		return 0, errors.New("size must be greater than 0")
+	}
+	if size > maxPartSize*maxNumSize {
+		return 0, fmt.Errorf("LOCAL size must be less than the maximum allowed limit")
+	}
+	if size <= minPartSize*maxNumSize {
+		return minPartSize, nil
+	}
+	partSize := size / maxNumSize
+	if size%maxNumSize != 0 {
+		partSize++
+	}
+	return partSize, nil
+}
+
+func (l *Local) InitiateMultipartUpload(ctx context.Context, name string) (*s3.InitiateMultipartUploadResult, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return nil, err
+	}
+	id := uuid.New()
+	uploadID := hex.EncodeToString(id[:])
+	dir := l.uploadPath(uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errs.WrapMsg(err, "create upload dir failed", "uploadID", uploadID)
+	}
+	if err := l.writeJSON(filepath.Join(dir, uploadMeta), uploadInfo{Key: name, Initiated: time.Now()}); err != nil {
+		return nil, err
+	}
+	return &s3.InitiateMultipartUploadResult{
+		Bucket:   l.Engine(),
+		Key:      name,
+		UploadID: uploadID,
+	}, nil
+}
+
+func (l *Local) CompleteMultipartUpload(ctx context.Context, uploadID string, name string, parts []s3.Part) (*s3.CompleteMultipartUploadResult, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := l.getUpload(uploadID, name); err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, errs.ErrArgs.WrapMsg("complete multipart upload without parts")
+	}
+	dir := l.uploadPath(uploadID)
+	tmp, err := os.CreateTemp(filepath.Join(l.root, tempDir), "complete-*")
+	if err != nil {
+		return nil, errs.WrapMsg(err, "create temp file failed")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	sums := md5.New()
+	for i, part := range parts {
+		if i > 0 && parts[i-1].PartNumber >= part.PartNumber {
+			return nil, errs.ErrArgs.WrapMsg("parts must be in ascending order", "partNumber", part.PartNumber)
+		}
+		var meta partMeta
+		if err := readJSON(filepath.Join(dir, partMetaName(part.PartNumber)), &meta); err != nil {
+			return nil, errs.WrapMsg(err, "part not uploaded", "partNumber", part.PartNumber)
+		}
+		if formatETag(part.ETag) != meta.ETag {
+			return nil, errs.ErrArgs.WrapMsg("part etag mismatching", "partNumber", part.PartNumber, "etag", part.ETag)
+		}
+		raw, err := hex.DecodeString(meta.ETag)
+		if err != nil {
+			return nil, errs.WrapMsg(err, "invalid part etag", "partNumber", part.PartNumber)
+		}
+		sums.Write(raw)
+		if err := appendFile(tmp, filepath.Join(dir, partDataName(part.PartNumber))); err != nil {
+			return nil, err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, errs.WrapMsg(err, "close temp file failed")
+	}
+	etag := hex.EncodeToString(sums.Sum(nil)) + "-" + strconv.Itoa(len(parts))
+	if err := l.commit(tmp.Name(), name, objectMeta{ETag: etag}); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, errs.WrapMsg(err, "remove upload dir failed", "uploadID", uploadID)
+	}
+	return &s3.CompleteMultipartUploadResult{
+		Location: l.objectURL(name),
+		Bucket:   l.Engine(),
+		Key:      name,
+		ETag:     etag,
+	}, nil
+}
+
+func (l *Local) AuthSign(ctx context.Context, uploadID string, name string, expire time.Duration, partNumbers []int) (*s3.AuthSignResult, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := l.getUpload(uploadID, name); err != nil {
+		return nil, err
+	}
+	expires := strconv.FormatInt(time.Now().Add(expire).Unix(), 10)
+	result := s3.AuthSignResult{
+		URL:   l.objectURL(name),
+		Query: url.Values{queryUploadID: {uploadID}, queryExpires: {expires}},
+		Parts: make([]s3.SignPart, len(partNumbers)),
+	}
+	for i, partNumber := range partNumbers {
+		query := url.Values{
+			queryUploadID:   {uploadID},
+			queryExpires:    {expires},
+			queryPartNumber: {strconv.Itoa(partNumber)},
+		}
+		result.Parts[i] = s3.SignPart{
+			PartNumber: partNumber,
+			Query: url.Values{
+				queryPartNumber: {strconv.Itoa(partNumber)},
+				querySignature:  {l.signature(http.MethodPut, name, query)},
+			},
+		}
+	}
+	return &result, nil
+}
+
+func (l *Local) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return "", err
+	}
+	return l.objectURL(name) + "?" + l.sign(http.MethodPut, name, expire, nil).Encode(), nil
+}
+
+func (l *Local) DeleteObject(ctx context.Context, name string) error {
+	name, err := cleanKey(name)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := os.Remove(l.objectPath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errs.WrapMsg(err, "remove object failed", "key", name)
+	}
+	if err := os.Remove(l.metaPath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errs.WrapMsg(err, "remove object meta failed", "key", name)
+	}
+	return nil
+}
+
+func (l *Local) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyObjectInfo, error) {
+	src, err := cleanKey(src)
+	if err != nil {
+		return nil, err
+	}
+	dst, err = cleanKey(dst)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(l.root, tempDir), "copy-*")
+	if err != nil {
+		return nil, errs.WrapMsg(err, "create temp file failed")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	l.lock.RLock()
+	meta, err := l.readMeta(src)
+	if err == nil {
+		err = appendFile(tmp, l.objectPath(src))
+	}
+	l.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, errs.WrapMsg(err, "close temp file failed")
+	}
+	if err := l.commit(tmp.Name(), dst, *meta); err != nil {
+		return nil, err
+	}
+	return &s3.CopyObjectInfo{
+		Key:  dst,
+		ETag: meta.ETag,
+	}, nil
+}
+
+func (l *Local) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return nil, err
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	info, _, err := l.stat(name)
+	return info, err
+}
+
+// PutObject writes reader straight to disk; a local object never needs multipart.
+func (l *Local) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return nil, err
+	}
+	var meta objectMeta
+	if opt != nil {
+		meta.ContentType = opt.ContentType
+		meta.Metadata = s3.LowerMetadata(opt.Metadata)
+	}
+	if _, err := l.putObject(name, reader, size, meta); err != nil {
+		return nil, err
+	}
+	return l.StatObject(ctx, name)
+}
+
+func (l *Local) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	limit = s3.ListLimit(limit)
+	base := filepath.Join(l.root, objectDir)
+	start := base
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		dir, err := cleanKey(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		start = filepath.Join(base, filepath.FromSlash(dir))
+	}
+	var keys []string
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errs.WrapMsg(err, "list objects failed", "prefix", prefix)
+	}
+	sort.Strings(keys)
+	truncated := len(keys) > limit
+	if truncated {
+		keys = keys[:limit]
+	}
+	// The meta files are local, so the listing reports everything StatObject does.
+	objects := make([]*s3.ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		info, _, err := l.stat(key)
+		if err != nil {
+			if l.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		objects = append(objects, info)
+	}
+	return s3.ListResult(objects, truncated), nil
+}
+
+func (l *Local) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.lock.RLock()
+	info, _, err := l.stat(name)
+	var file *os.File
+	if err == nil {
+		file, err = os.Open(l.objectPath(name))
+	}
+	l.lock.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	if opt == nil || (opt.Offset <= 0 && opt.Length <= 0) {
+		return file, info, nil
+	}
+	if opt.Offset < 0 || opt.Offset >= info.Size {
+		_ = file.Close()
+		return nil, nil, errs.ErrArgs.WrapMsg("invalid range", "offset", opt.Offset, "size", info.Size)
+	}
+	length := info.Size - opt.Offset
+	if opt.Length > 0 && opt.Length < length {
+		length = opt.Length
+	}
+	return &sectionReadCloser{
+		SectionReader: io.NewSectionReader(file, opt.Offset, length),
+		Closer:        file,
+	}, info, nil
+}
+
+func (l *Local) IsNotFound(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+func (l *Local) AbortMultipartUpload(ctx context.Context, uploadID string, name string) error {
+	name, err := cleanKey(name)
+	if err != nil {
+		return err
+	}
+	if _, err := l.getUpload(uploadID, name); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(l.uploadPath(uploadID)); err != nil {
+		return errs.WrapMsg(err, "remove upload dir failed", "uploadID", uploadID)
+	}
+	return nil
+}
+
+func (l *Local) ListUploadedParts(ctx context.Context, uploadID string, name string, partNumberMarker int, maxParts int) (*s3.ListUploadedPartsResult, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := l.getUpload(uploadID, name); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(l.uploadPath(uploadID))
+	if err != nil {
+		return nil, errs.WrapMsg(err, "read upload dir failed", "uploadID", uploadID)
+	}
+	var partNumbers []int
+	for _, entry := range entries {
+		partNumber, ok := parsePartMetaName(entry.Name())
+		if ok && partNumber > partNumberMarker {
+			partNumbers = append(partNumbers, partNumber)
+		}
+	}
+	sort.Ints(partNumbers)
+	if maxParts > 0 && len(partNumbers) > maxParts {
+		partNumbers = partNumbers[:maxParts]
+	}
+	res := &s3.ListUploadedPartsResult{
+		Key:           name,
+		UploadID:      uploadID,
+		MaxParts:      maxParts,
+		UploadedParts: make([]s3.UploadedPart, 0, len(partNumbers)),
+	}
+	for _, partNumber := range partNumbers {
+		var meta partMeta
+		if err := readJSON(filepath.Join(l.uploadPath(uploadID), partMetaName(partNumber)), &meta); err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(filepath.Join(l.uploadPath(uploadID), partDataName(partNumber)))
+		if err != nil {
+			return nil, errs.WrapMsg(err, "stat part failed", "partNumber", partNumber)
+		}
+		res.UploadedParts = append(res.UploadedParts, s3.UploadedPart{
+			PartNumber:   partNumber,
+			LastModified: fi.ModTime(),
+			ETag:         meta.ETag,
+			Size:         meta.Size,
+		})
+		res.NextPartNumberMarker = partNumber
+	}
+	return res, nil
+}
+
+func (l *Local) ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*s3.ListMultipartUploadsResult, error) {
+	limit = s3.ListLimit(limit)
+	entries, err := os.ReadDir(filepath.Join(l.root, uploadDir))
+	if err != nil {
+		return nil, errs.WrapMsg(err, "read upload dir failed")
+	}
+	uploads := make([]s3.MultipartUpload, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		var info uploadInfo
+		if err := readJSON(filepath.Join(l.uploadPath(entry.Name()), uploadMeta), &info); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, errs.WrapMsg(err, "read upload meta failed", "uploadID", entry.Name())
+		}
+		if !strings.HasPrefix(info.Key, prefix) {
+			continue
+		}
+		if info.Key < keyMarker || (info.Key == keyMarker && entry.Name() <= uploadIDMarker) {
+			continue
+		}
+		uploads = append(uploads, s3.MultipartUpload{Key: info.Key, UploadID: entry.Name(), Initiated: info.Initiated})
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+	res := &s3.ListMultipartUploadsResult{IsTruncated: len(uploads) > limit}
+	if res.IsTruncated {
+		uploads = uploads[:limit]
+		res.NextKeyMarker = uploads[limit-1].Key
+		res.NextUploadIDMarker = uploads[limit-1].UploadID
+	}
+	res.Uploads = uploads
+	return res, nil
+}
+
+func (l *Local) AccessURL(ctx context.Context, name string, expire time.Duration, opt *s3.AccessURLOption) (string, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return "", err
+	}
+	if expire <= 0 {
+		expire = time.Hour * 24 * 365 * 99 // 99 years
+	} else if expire < time.Second {
+		expire = time.Second
+	}
+	query := make(url.Values)
+	if opt != nil {
+		if opt.ContentType != "" {
+			query.Set(queryResponseContentType, opt.ContentType)
+		}
+		if opt.Filename != "" {
+			query.Set(queryResponseContentDisposition, `attachment; filename*=UTF-8''`+url.PathEscape(opt.Filename))
+		}
+	}
+	return l.objectURL(name) + "?" + l.sign(http.MethodGet, name, expire, query).Encode(), nil
+}
+
+func (l *Local) FormData(ctx context.Context, name string, size int64, contentType string, duration time.Duration) (*s3.FormData, error) {
+	name, err := cleanKey(name)
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(duration)
+	data, err := json.Marshal(formPolicy{
+		Key:         name,
+		Expires:     expires.Unix(),
+		MaxSize:     size,
+		ContentType: contentType,
+	})
+	if err != nil {
+		return nil, errs.WrapMsg(err, "Marshal json error")
+	}
+	policy := base64.StdEncoding.EncodeToString(data)
+	fd := &s3.FormData{
+		URL:     l.endpoint + "/",
+		File:    "file",
+		Expires: expires,
+		FormData: map[string]string{
+			"key":       name,
+			"policy":    policy,
+			"signature": l.signature(http.MethodPost, name, url.Values{"policy": {policy}}),
+		},
+		SuccessCodes: []int{successCode},
+	}
+	if contentType != "" {
+		fd.FormData["Content-Type"] = contentType
+	}
+	return fd, nil
+}
+
+type formPolicy struct {
+	Key         string `json:"key"`
+	Expires     int64  `json:"expires"`
+	MaxSize     int64  `json:"maxSize,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// putObject stores reader under name; a non-negative size must match the number of bytes read.
+func (l *Local) putObject(name string, reader io.Reader, size int64, meta objectMeta) (*objectMeta, error) {
+	tmp, err := os.CreateTemp(filepath.Join(l.root, tempDir), "put-*")
+	if err != nil {
+		return nil, errs.WrapMsg(err, "create temp file failed")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := md5.New()
+	if size >= 0 {
+		reader = io.LimitReader(reader, size)
+	}
+	written, err := io.Copy(io.MultiWriter(tmp, h), reader)
+	if err != nil {
+		return nil, errs.WrapMsg(err, "write object failed", "key", name)
+	}
+	if size >= 0 && written != size {
+		return nil, s3.ErrSizeMismatch.WrapMsg("short read", "key", name, "size", size, "read", written)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, errs.WrapMsg(err, "close temp file failed")
+	}
+	meta.ETag = hex.EncodeToString(h.Sum(nil))
+	if err := l.commit(tmp.Name(), name, meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// putPart stores one part of a multipart upload and returns its etag.
+func (l *Local) putPart(uploadID string, name string, partNumber int, reader io.Reader) (string, error) {
+	if partNumber < 1 || int64(partNumber) > maxNumSize {
+		return "", errs.ErrArgs.WrapMsg("invalid part number", "partNumber", partNumber)
+	}
+	if _, err := l.getUpload(uploadID, name); err != nil {
+		return "", err
+	}
+	dir := l.uploadPath(uploadID)
+	tmp, err := os.CreateTemp(dir, "part-*")
+	if err != nil {
+		return "", errs.WrapMsg(err, "create temp part failed")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), reader)
+	if err != nil {
+		return "", errs.WrapMsg(err, "write part failed", "partNumber", partNumber)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", errs.WrapMsg(err, "close temp part failed")
+	}
+	etag := hex.EncodeToString(h.Sum(nil))
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, partDataName(partNumber))); err != nil {
+		return "", errs.WrapMsg(err, "rename part failed", "partNumber", partNumber)
+	}
+	if err := l.writeJSON(filepath.Join(dir, partMetaName(partNumber)), partMeta{ETag: etag, Size: size}); err != nil {
+		return "", err
+	}
+	return etag, nil
+}
+
+// commit moves a fully written temp file into place together with its meta.
+func (l *Local) commit(tmp string, name string, meta objectMeta) error {
+	objectPath := l.objectPath(name)
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0o755); err != nil {
+		return errs.WrapMsg(err, "create object dir failed", "key", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.metaPath(name)), 0o755); err != nil {
+		return errs.WrapMsg(err, "create meta dir failed", "key", name)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := os.Rename(tmp, objectPath); err != nil {
+		return errs.WrapMsg(err, "rename object failed", "key", name)
+	}
+	return l.writeJSON(l.metaPath(name), meta)
+}
+
+// stat must be called with l.lock held.
+func (l *Local) stat(name string) (*s3.ObjectInfo, *objectMeta, error) {
+	fi, err := os.Stat(l.objectPath(name))
+	if err != nil {
+		return nil, nil, errs.WrapMsg(err, "stat object failed", "key", name)
+	}
+	if fi.IsDir() {
+		return nil, nil, errs.WrapMsg(fs.ErrNotExist, "stat object failed", "key", name)
+	}
+	meta, err := l.readMeta(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &s3.ObjectInfo{
+		ETag:         meta.ETag,
+		Key:          name,
+		Size:         fi.Size(),
+		LastModified: fi.ModTime(),
+		ContentType:  meta.ContentType,
+		Metadata:     meta.Metadata,
+	}, meta, nil
+}
+
+func (l *Local) readMeta(name string) (*objectMeta, error) {
+	var meta objectMeta
+	if err := readJSON(l.metaPath(name), &meta); err != nil {
+		return nil, errs.WrapMsg(err, "read object meta failed", "key", name)
+	}
+	return &meta, nil
+}
+
+func (l *Local) getUpload(uploadID string, name string) (*uploadInfo, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\.`) {
+		return nil, errs.ErrArgs.WrapMsg("invalid upload id", "uploadID", uploadID)
+	}
+	var info uploadInfo
+	if err := readJSON(filepath.Join(l.uploadPath(uploadID), uploadMeta), &info); err != nil {
+		return nil, errs.WrapMsg(err, "no such upload", "uploadID", uploadID)
+	}
+	if info.Key != name {
+		return nil, errs.ErrArgs.WrapMsg("upload key mismatching", "uploadID", uploadID, "key", name)
+	}
+	return &info, nil
+}
+
+func (l *Local) writeJSON(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errs.WrapMsg(err, "Marshal json error")
+	}
+	tmp, err := os.CreateTemp(filepath.Join(l.root, tempDir), "meta-*")
+	if err != nil {
+		return errs.WrapMsg(err, "create temp file failed")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errs.WrapMsg(err, "write temp file failed")
+	}
+	if err := tmp.Close(); err != nil {
+		return errs.WrapMsg(err, "close temp file failed")
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return errs.WrapMsg(err, "rename meta failed", "name", name)
+	}
+	return nil
+}
+
+func (l *Local) objectURL(name string) string {
+	return l.endpoint + "/" + escapeKey(name)
+}
+
+func (l *Local) objectPath(name string) string {
+	return filepath.Join(l.root, objectDir, filepath.FromSlash(name))
+}
+
+func (l *Local) metaPath(name string) string {
+	return filepath.Join(l.root, metaDir, filepath.FromSlash(name)+metaSuffix)
+}
+
+func (l *Local) uploadPath(uploadID string) string {
+	return filepath.Join(l.root, uploadDir, uploadID)
+}
+
+func readJSON(name string, v any) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errs.WrapMsg(err, "Unmarshal json error", "name", name)
+	}
+	return nil
+}
+
+func appendFile(dst *os.File, name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return errs.WrapMsg(err, "open file failed", "name", name)
+	}
+	defer src.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return errs.WrapMsg(err, "copy file failed", "name", name)
+	}
+	return nil
+}
+
+// cleanKey rejects keys that would escape the root directory once mapped onto the filesystem.
+func cleanKey(name string) (string, error) {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || strings.HasSuffix(name, "/") || path.Clean("/"+name) != "/"+name {
+		return "", ErrInvalidKey.WrapMsg("key", name)
+	}
+	return name, nil
+}
+
+func escapeKey(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func formatETag(etag string) string {
+	return strings.ToLower(strings.Trim(etag, `"`))
+}
+
+func partDataName(partNumber int) string {
+	return fmt.Sprintf("%05d.part", partNumber)
+}
+
+func partMetaName(partNumber int) string {
+	return fmt.Sprintf("%05d%s", partNumber, metaSuffix)
+}
+
+func parsePartMetaName(name string) (int, bool) {
+	if !strings.HasSuffix(name, metaSuffix) || name == uploadMeta {
+		return 0, false
+	}
+	partNumber, err := strconv.Atoi(strings.TrimSuffix(name, metaSuffix))
+	if err != nil {
+		return 0, false
+	}
+	return partNumber, true
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
@@ -0,0 +1,238 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+func newTestLocal(t *testing.T) *Local {
+	t.Helper()
+	var l *Local
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	var err error
+	l, err = NewLocal(Config{
+		Root:      t.TempDir(),
+		Endpoint:  server.URL + "/object",
+		SecretKey: "test-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func doRequest(t *testing.T, method string, rawURL string, header http.Header, body []byte) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPresignedPutAndAccess(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLocal(t)
+	data := []byte("hello local object storage")
+	rawURL, err := l.PresignedPutObject(ctx, "openim/temp/a b.txt", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := doRequest(t, http.MethodPut, rawURL, nil, data); resp.StatusCode != http.StatusOK {
+		t.Fatalf("put status %d", resp.StatusCode)
+	}
+	info, err := l.StatObject(ctx, "openim/temp/a b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(data)) || info.ETag != md5Hex(data) {
+		t.Fatalf("unexpected stat %+v", info)
+	}
+	accessURL, err := l.AccessURL(ctx, "openim/temp/a b.txt", time.Minute, &s3.AccessURLOption{ContentType: "text/plain", Filename: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := doRequest(t, http.MethodGet, accessURL, http.Header{"Range": {"bytes=6-10"}}, nil)
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("get status %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "local" {
+		t.Fatalf("unexpected range body %q", body)
+	}
+	if resp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestSignatureRejected(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLocal(t)
+	rawURL, err := l.PresignedPutObject(ctx, "openim/temp/x", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(rawURL)
+	u.Path = strings.Replace(u.Path, "/x", "/y", 1)
+	if resp := doRequest(t, http.MethodPut, u.String(), nil, []byte("x")); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("retargeted url status %d", resp.StatusCode)
+	}
+	expired, err := l.PresignedPutObject(ctx, "openim/temp/x", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := doRequest(t, http.MethodPut, expired, nil, []byte("x")); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expired url status %d", resp.StatusCode)
+	}
+	if _, err := l.StatObject(ctx, "openim/temp/y"); !l.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := l.StatObject(ctx, "../escape"); err == nil {
+		t.Fatal("expected invalid key error")
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLocal(t)
+	name := "openim/data/hash/multipart"
+	upload, err := l.InitiateMultipartUpload(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := l.AuthSign(ctx, upload.UploadID, name, time.Minute, []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := [][]byte{[]byte("first part "), []byte("second part")}
+	parts := make([]s3.Part, len(chunks))
+	for i, part := range sign.Parts {
+		u, _ := url.Parse(sign.URL)
+		query := u.Query()
+		for k, v := range sign.Query {
+			query[k] = v
+		}
+		for k, v := range part.Query {
+			query[k] = v
+		}
+		u.RawQuery = query.Encode()
+		resp := doRequest(t, http.MethodPut, u.String(), part.Header, chunks[i])
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("put part %d status %d", part.PartNumber, resp.StatusCode)
+		}
+		parts[i] = s3.Part{PartNumber: part.PartNumber, ETag: resp.Header.Get("ETag")}
+	}
+	listed, err := l.ListUploadedParts(ctx, upload.UploadID, name, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.UploadedParts) != 2 || listed.UploadedParts[1].ETag != md5Hex(chunks[1]) {
+		t.Fatalf("unexpected parts %+v", listed.UploadedParts)
+	}
+	result, err := l.CompleteMultipartUpload(ctx, upload.UploadID, name, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(result.ETag, "-2") {
+		t.Fatalf("unexpected multipart etag %s", result.ETag)
+	}
+	copyInfo, err := l.CopyObject(ctx, name, name+".copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := l.StatObject(ctx, copyInfo.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ETag != result.ETag || info.Size != int64(len(chunks[0])+len(chunks[1])) {
+		t.Fatalf("unexpected copy stat %+v", info)
+	}
+	if _, err := l.ListUploadedParts(ctx, upload.UploadID, name, 0, 10); !l.IsNotFound(err) {
+		t.Fatalf("expected completed upload to be gone, got %v", err)
+	}
+}
+
+func TestFormData(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLocal(t)
+	fd, err := l.FormData(ctx, "openim/direct/form", 16, "text/plain", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func(data []byte) int {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range fd.FormData {
+			_ = mw.WriteField(k, v)
+		}
+		fw, _ := mw.CreateFormFile(fd.File, "form.txt")
+		_, _ = fw.Write(data)
+		_ = mw.Close()
+		resp := doRequest(t, http.MethodPost, fd.URL, http.Header{"Content-Type": {mw.FormDataContentType()}}, buf.Bytes())
+		return resp.StatusCode
+	}
+	if code := upload([]byte("this body is far too large")); code != http.StatusBadRequest {
+		t.Fatalf("oversized form status %d", code)
+	}
+	if _, err := l.StatObject(ctx, "openim/direct/form"); !l.IsNotFound(err) {
+		t.Fatalf("oversized form stored: %v", err)
+	}
+	if code := upload([]byte("form body")); code != http.StatusOK {
+		t.Fatalf("form status %d", code)
+	}
+	if err := l.DeleteObject(ctx, "openim/direct/form"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.StatObject(ctx, "openim/direct/form"); !l.IsNotFound(err) {
+		t.Fatalf("expected not found after delete, got %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	s3test.RunConformance(t, func(t *testing.T) *s3test.Harness {
+		return &s3test.Harness{Impl: newTestLocal(t)}
+	})
+}
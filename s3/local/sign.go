@@ -0,0 +1,85 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+const (
+	queryExpires    = "expires"
+	querySignature  = "signature"
+	queryUploadID   = "uploadId"
+	queryPartNumber = "partNumber"
+
+	queryResponseContentType        = "response-content-type"
+	queryResponseContentDisposition = "response-content-disposition"
+)
+
+var (
+	ErrSignatureMismatch = errs.New("local signature mismatch")
+	ErrSignatureExpired  = errs.New("local signature expired")
+)
+
+// signature computes the HMAC over the method, the object key and every query
+// parameter except the signature itself, so a client can neither retarget a
+// URL nor change what it is allowed to do with it.
+func (l *Local) signature(method string, name string, query url.Values) string {
+	values := make(url.Values, len(query))
+	for k, v := range query {
+		if k == querySignature {
+			continue
+		}
+		values[k] = v
+	}
+	h := hmac.New(sha256.New, l.secret)
+	h.Write([]byte(method))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(name))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(values.Encode()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (l *Local) sign(method string, name string, expire time.Duration, query url.Values) url.Values {
+	if query == nil {
+		query = make(url.Values)
+	}
+	query.Set(queryExpires, strconv.FormatInt(time.Now().Add(expire).Unix(), 10))
+	query.Set(querySignature, l.signature(method, name, query))
+	return query
+}
+
+func (l *Local) verify(method string, name string, query url.Values) error {
+	expires, err := strconv.ParseInt(query.Get(queryExpires), 10, 64)
+	if err != nil {
+		return errs.WrapMsg(ErrSignatureMismatch, "invalid expires", "expires", query.Get(queryExpires))
+	}
+	if time.Now().Unix() > expires {
+		return ErrSignatureExpired.Wrap()
+	}
+	expected := l.signature(method, name, query)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(querySignature))) {
+		return ErrSignatureMismatch.Wrap()
+	}
+	return nil
+}
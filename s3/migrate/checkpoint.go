@@ -0,0 +1,81 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+// checkpoint records the listing marker every object before which has been handled,
+// and the keys among them that failed.
+type checkpoint struct {
+	Prefix     string    `json:"prefix"`
+	Marker     string    `json:"marker"`
+	Failed     []string  `json:"failed,omitempty"`
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+// loadCheckpoint returns nil when path does not exist yet.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errs.WrapMsg(err, "read checkpoint failed", "path", path)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, errs.WrapMsg(err, "invalid checkpoint", "path", path)
+	}
+	return &cp, nil
+}
+
+// saveCheckpoint replaces path atomically, so an interrupted write keeps the previous one.
+func saveCheckpoint(path string, cp *checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errs.WrapMsg(err, "create checkpoint failed", "path", path)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errs.WrapMsg(err, "write checkpoint failed", "path", path)
+	}
+	if err := tmp.Close(); err != nil {
+		return errs.WrapMsg(err, "write checkpoint failed", "path", path)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errs.WrapMsg(err, "save checkpoint failed", "path", path)
+	}
+	return nil
+}
+
+func removeCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errs.WrapMsg(err, "remove checkpoint failed", "path", path)
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package migrate copies objects between two s3.Interface engines, such as from Kodo
+// to MinIO. Objects are streamed without buffering them whole, verified against their
+// MD5 and skipped when the destination already has them, so a run can be repeated.
+package migrate
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/log"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+// MetaMD5 is the metadata key the hex MD5 of copied objects is stored under. Multipart
+// ETags are not content MD5s, so it is what later runs compare objects with.
+const MetaMD5 = "migrate-md5"
+
+// Defaults of the options of New.
+const (
+	DefaultConcurrency = 8
+	DefaultRetries     = 3
+	DefaultRetryDelay  = time.Second
+)
+
+// ErrVerify is returned when a copied object does not match its source.
+var ErrVerify = errs.New("s3 migrate verification failed")
+
+type Option func(*Migrator)
+
+// WithConcurrency sets how many objects are copied at once.
+func WithConcurrency(n int) Option {
+	return func(m *Migrator) {
+		m.concurrency = max(1, n)
+	}
+}
+
+// WithRetries sets how many times a failed object is retried, waiting delay before the
+// first retry and doubling it after each one.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(m *Migrator) {
+		m.retries = max(0, retries)
+		m.retryDelay = delay
+	}
+}
+
+// WithCheckpoint saves progress to path after every listed page and resumes from it.
+// The keys that failed before the saved page are kept with it and retried first on
+// resume. The file is removed once a run completes.
+func WithCheckpoint(path string) Option {
+	return func(m *Migrator) {
+		m.checkpoint = path
+	}
+}
+
+// WithDryRun compares the engines without copying anything; the report lists the
+// objects that are missing from or different in the destination.
+func WithDryRun(dryRun bool) Option {
+	return func(m *Migrator) {
+		m.dryRun = dryRun
+	}
+}
+
+// WithPageSize sets how many objects are requested per listing call.
+func WithPageSize(size int) Option {
+	return func(m *Migrator) {
+		m.pageSize = size
+	}
+}
+
+// Migrator copies the objects under a prefix from src to dst.
+type Migrator struct {
+	src         s3.Interface
+	dst         s3.Interface
+	concurrency int
+	retries     int
+	retryDelay  time.Duration
+	checkpoint  string
+	dryRun      bool
+	pageSize    int
+}
+
+func New(src s3.Interface, dst s3.Interface, opts ...Option) *Migrator {
+	m := &Migrator{
+		src:         src,
+		dst:         dst,
+		concurrency: DefaultConcurrency,
+		retries:     DefaultRetries,
+		retryDelay:  DefaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Failure is one object that could not be copied.
+type Failure struct {
+	Key string `json:"key"`
+	Err error  `json:"-"`
+}
+
+// Report describes one Run. Missing and Different are only filled in dry-run mode,
+// where they list what a real run would copy.
+type Report struct {
+	DryRun      bool      `json:"dryRun"`
+	Prefix      string    `json:"prefix"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	Resumed     string    `json:"resumed,omitempty"`
+	Scanned     int       `json:"scanned"`
+	Copied      int       `json:"copied"`
+	CopiedBytes int64     `json:"copiedBytes"`
+	Skipped     int       `json:"skipped"`
+	Missing     []string  `json:"missing"`
+	Different   []string  `json:"different"`
+	Failures    []Failure `json:"failures"`
+}
+
+// Run copies every object under prefix. Failures of single objects are recorded in the
+// report; a listing or checkpoint error stops the run and is returned with the partial report.
+func (m *Migrator) Run(ctx context.Context, prefix string) (*Report, error) {
+	report := &Report{DryRun: m.dryRun, Prefix: prefix, StartTime: time.Now()}
+	defer func() {
+		report.EndTime = time.Now()
+		slices.Sort(report.Missing)
+		slices.Sort(report.Different)
+		slices.SortFunc(report.Failures, func(a, b Failure) int {
+			return strings.Compare(a.Key, b.Key)
+		})
+		log.ZInfo(ctx, "s3 migrate run", "dryRun", report.DryRun, "prefix", prefix, "scanned", report.Scanned,
+			"copied", report.Copied, "copiedBytes", report.CopiedBytes, "skipped", report.Skipped,
+			"missing", len(report.Missing), "different", len(report.Different), "failures", len(report.Failures))
+	}()
+	var (
+		marker string
+		failed []string
+	)
+	if m.checkpoint != "" && !m.dryRun {
+		cp, err := loadCheckpoint(m.checkpoint)
+		if err != nil {
+			return report, err
+		}
+		if cp != nil {
+			if cp.Prefix != prefix {
+				return report, errs.ErrArgs.WrapMsg("checkpoint belongs to another prefix", "path", m.checkpoint, "prefix", cp.Prefix)
+			}
+			marker = cp.Marker
+			failed = cp.Failed
+			report.Resumed = marker
+		}
+	}
+	if len(failed) > 0 {
+		if err := m.retryFailed(ctx, failed, report); err != nil {
+			return report, err
+		}
+	}
+	for {
+		res, err := m.src.ListObjects(ctx, prefix, marker, m.pageSize)
+		if err != nil {
+			return report, err
+		}
+		m.migratePage(ctx, res.Objects, report)
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if !res.IsTruncated || res.NextMarker == "" {
+			if m.checkpoint != "" && !m.dryRun {
+				return report, removeCheckpoint(m.checkpoint)
+			}
+			return report, nil
+		}
+		marker = res.NextMarker
+		if m.checkpoint != "" && !m.dryRun {
+			cp := &checkpoint{Prefix: prefix, Marker: marker, Failed: failedKeys(report), UpdateTime: time.Now()}
+			if err := saveCheckpoint(m.checkpoint, cp); err != nil {
+				return report, err
+			}
+		}
+	}
+}
+
+// retryFailed migrates again the keys a checkpoint recorded as failed. Keys the source
+// no longer has are dropped.
+func (m *Migrator) retryFailed(ctx context.Context, keys []string, report *Report) error {
+	objects := make([]*s3.ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		info, err := m.src.StatObject(ctx, key)
+		if err != nil {
+			if m.src.IsNotFound(err) {
+				continue
+			}
+			report.Failures = append(report.Failures, Failure{Key: key, Err: err})
+			continue
+		}
+		objects = append(objects, info)
+	}
+	m.migratePage(ctx, objects, report)
+	return ctx.Err()
+}
+
+// failedKeys returns the keys of the failures recorded so far.
+func failedKeys(report *Report) []string {
+	if len(report.Failures) == 0 {
+		return nil
+	}
+	keys := make([]string, len(report.Failures))
+	for i, failure := range report.Failures {
+		keys[i] = failure.Key
+	}
+	return keys
+}
+
+// migratePage handles the objects of one listing with up to m.concurrency workers.
+func (m *Migrator) migratePage(ctx context.Context, objects []*s3.ObjectInfo, report *Report) {
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	sem := make(chan struct{}, m.concurrency)
+	for _, info := range objects {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(info *s3.ObjectInfo) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			copied, err := m.migrate(ctx, info)
+			lock.Lock()
+			defer lock.Unlock()
+			report.Scanned++
+			switch {
+			case err != nil:
+				log.ZWarn(ctx, "s3 migrate object failed", err, "key", info.Key)
+				report.Failures = append(report.Failures, Failure{Key: info.Key, Err: err})
+			case copied == nil:
+				report.Skipped++
+			case m.dryRun && copied.missing:
+				report.Missing = append(report.Missing, info.Key)
+			case m.dryRun:
+				report.Different = append(report.Different, info.Key)
+			default:
+				report.Copied++
+				report.CopiedBytes += copied.size
+			}
+		}(info)
+	}
+	wg.Wait()
+}
+
+type result struct {
+	missing bool
+	size    int64
+}
+
+// migrate copies one object with retries; it returns nil when dst already has it.
+func (m *Migrator) migrate(ctx context.Context, info *s3.ObjectInfo) (*result, error) {
+	delay := m.retryDelay
+	for attempt := 0; ; attempt++ {
+		res, err := m.migrateOnce(ctx, info)
+		if err == nil || attempt >= m.retries || ctx.Err() != nil {
+			return res, err
+		}
+		log.ZDebug(ctx, "s3 migrate retry", "key", info.Key, "attempt", attempt+1, "err", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, context.Cause(ctx)
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func (m *Migrator) migrateOnce(ctx context.Context, info *s3.ObjectInfo) (*result, error) {
+	dstInfo, err := m.dst.StatObject(ctx, info.Key)
+	if err == nil {
+		if sameObject(info, dstInfo) {
+			return nil, nil
+		}
+		// Listings carry no metadata, so an MD5 an earlier migration recorded on the
+		// source takes a stat to read.
+		if contentMD5(info) == "" && contentMD5(dstInfo) != "" {
+			srcInfo, err := m.src.StatObject(ctx, info.Key)
+			if err != nil {
+				return nil, err
+			}
+			if sameObject(srcInfo, dstInfo) {
+				return nil, nil
+			}
+		}
+	} else if !m.dst.IsNotFound(err) {
+		return nil, err
+	}
+	res := &result{missing: err != nil, size: info.Size}
+	if m.dryRun {
+		return res, nil
+	}
+	reader, srcInfo, err := m.src.GetObject(ctx, info.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	want := contentMD5(srcInfo)
+	metadata := maps.Clone(srcInfo.Metadata)
+	if want != "" {
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[MetaMD5] = want
+	}
+	hash := md5.New()
+	counter := &countWriter{w: hash}
+	putInfo, err := m.dst.PutObject(ctx, info.Key, io.TeeReader(reader, counter), srcInfo.Size, &s3.PutObjectOption{
+		ContentType: srcInfo.ContentType,
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	switch {
+	case counter.n != srcInfo.Size:
+		err = ErrVerify.WrapMsg("size mismatch", "key", info.Key, "size", srcInfo.Size, "read", counter.n)
+	case want != "" && want != sum:
+		err = ErrVerify.WrapMsg("source md5 mismatch", "key", info.Key, "md5", sum, "want", want)
+	case plainMD5(putInfo.ETag) != "" && plainMD5(putInfo.ETag) != sum:
+		err = ErrVerify.WrapMsg("destination md5 mismatch", "key", info.Key, "md5", sum, "etag", putInfo.ETag)
+	}
+	if err != nil {
+		// A later run must not take the bad copy for a good one.
+		_ = m.dst.DeleteObject(ctx, info.Key)
+		return nil, err
+	}
+	res.size = srcInfo.Size
+	return res, nil
+}
+
+// plainMD5 returns etag when it is the hex MD5 of the content, which multipart and
+// encrypted uploads do not have.
+func plainMD5(etag string) string {
+	etag = strings.ToLower(strings.Trim(etag, `"`))
+	if len(etag) != hex.EncodedLen(md5.Size) {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return etag
+}
+
+// contentMD5 returns the MD5 of an object, empty when neither its ETag nor a previous
+// migration tells it.
+func contentMD5(info *s3.ObjectInfo) string {
+	if sum := plainMD5(info.Metadata[MetaMD5]); sum != "" {
+		return sum
+	}
+	return plainMD5(info.ETag)
+}
+
+// sameObject reports whether dst already holds the content of src. Without a known
+// MD5 on both sides the ETags must match, so such objects are copied again.
+func sameObject(src *s3.ObjectInfo, dst *s3.ObjectInfo) bool {
+	if src.Size != dst.Size {
+		return false
+	}
+	if sum := contentMD5(src); sum != "" {
+		return sum == contentMD5(dst)
+	}
+	return strings.Trim(src.ETag, `"`) == strings.Trim(dst.ETag, `"`)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,215 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/s3test"
+)
+
+func newEngines(n int) (*s3test.Engine, *s3test.Engine) {
+	src, dst := s3test.NewEngine(), s3test.NewEngine()
+	for i := 0; i < n; i++ {
+		src.SetObject(fmt.Sprintf("data/%02d", i), []byte(fmt.Sprintf("object %d", i)))
+	}
+	src.SetObject("other/x", []byte("not migrated"))
+	return src, dst
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newEngines(10)
+	m := New(src, dst, WithConcurrency(3), WithPageSize(4), WithRetries(0, 0))
+	report, err := m.Run(ctx, "data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Scanned != 10 || report.Copied != 10 || report.Skipped != 0 || len(report.Failures) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("data/%02d", i)
+		data, ok := dst.Object(key)
+		if !ok || !bytes.Equal(data, []byte(fmt.Sprintf("object %d", i))) {
+			t.Fatalf("%s not copied", key)
+		}
+		info, err := dst.StatObject(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Metadata[MetaMD5] != plainMD5(info.ETag) {
+			t.Fatalf("%s: md5 metadata %q", key, info.Metadata[MetaMD5])
+		}
+	}
+	if _, ok := dst.Object("other/x"); ok {
+		t.Fatal("object outside the prefix copied")
+	}
+
+	dst.ResetCalls()
+	if report, err = m.Run(ctx, "data/"); err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 10 || report.Copied != 0 || dst.CallCount(s3test.OpPutObject) != 0 {
+		t.Fatalf("second run copied again: %+v", report)
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newEngines(3)
+	dst.SetObject("data/00", []byte("object 0"))
+	dst.SetObject("data/01", []byte("changed"))
+	report, err := New(src, dst, WithDryRun(true)).Run(ctx, "data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 1 || !slices.Equal(report.Different, []string{"data/01"}) || !slices.Equal(report.Missing, []string{"data/02"}) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if n := dst.CallCount(s3test.OpPutObject); n != 0 {
+		t.Fatalf("dry run wrote %d objects", n)
+	}
+	if data, _ := dst.Object("data/01"); string(data) != "changed" {
+		t.Fatal("dry run overwrote an object")
+	}
+}
+
+func TestMigrateRetry(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newEngines(1)
+	injected := errors.New("injected")
+	dst.SetFault(s3test.OpPutObject, s3test.Fault{Err: injected, Times: 1})
+	report, err := New(src, dst, WithRetries(0, 0)).Run(ctx, "data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failures) != 1 || !errors.Is(report.Failures[0].Err, injected) {
+		t.Fatalf("expected a failure, got %+v", report)
+	}
+	dst.SetFault(s3test.OpPutObject, s3test.Fault{Err: injected, Times: 2})
+	report, err = New(src, dst, WithRetries(2, time.Millisecond)).Run(ctx, "data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 1 || len(report.Failures) != 0 {
+		t.Fatalf("retries did not recover: %+v", report)
+	}
+}
+
+func TestMigrateVerify(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newEngines(1)
+	// The source reports an ETag that is not the MD5 of what it returns.
+	src.SetFault(s3test.OpGetObject, s3test.Fault{ETagDrift: true})
+	report, err := New(src, dst, WithRetries(0, 0)).Run(ctx, "data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failures) != 1 || !errors.Is(report.Failures[0].Err, ErrVerify) {
+		t.Fatalf("expected a verification failure, got %+v", report)
+	}
+	if keys := dst.Keys(); len(keys) != 0 {
+		t.Fatalf("unverified copy left behind: %v", keys)
+	}
+}
+
+func TestMigrateCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newEngines(6)
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	if err := saveCheckpoint(path, &checkpoint{Prefix: "data/", Marker: "data/03"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(src, dst, WithCheckpoint(path)).Run(ctx, "other/"); !errs.ErrArgs.Is(err) {
+		t.Fatalf("expected ErrArgs for another prefix, got %v", err)
+	}
+	report, err := New(src, dst, WithCheckpoint(path), WithPageSize(2)).Run(ctx, "data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Resumed != "data/03" || report.Copied != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if keys := dst.Keys(); !slices.Equal(keys, []string{"data/04", "data/05"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("checkpoint not removed: %v", err)
+	}
+
+	// A run stopped by a listing error keeps the checkpoint of the last completed page.
+	src, dst = newEngines(6)
+	listErr := errors.New("list failed")
+	report, err = New(&failingList{Interface: src, after: 1, err: listErr}, dst, WithCheckpoint(path), WithPageSize(2)).Run(ctx, "data/")
+	if !errors.Is(err, listErr) || report.Copied != 2 {
+		t.Fatalf("unexpected run %+v: %v", report, err)
+	}
+	if cp, err := loadCheckpoint(path); err != nil || cp == nil || cp.Marker != "data/01" {
+		t.Fatalf("unexpected checkpoint %+v: %v", cp, err)
+	}
+	if report, err = New(src, dst, WithCheckpoint(path), WithPageSize(2)).Run(ctx, "data/"); err != nil {
+		t.Fatal(err)
+	}
+	if report.Resumed != "data/01" || report.Scanned != 4 || report.Copied != 4 {
+		t.Fatalf("unexpected resumed report %+v", report)
+	}
+
+	// Keys that failed before the saved page are kept with it and retried on resume.
+	src, dst = newEngines(6)
+	dst.SetFault(s3test.OpPutObject, s3test.Fault{Err: errors.New("injected"), Times: 1})
+	failing := &failingList{Interface: src, after: 1, err: listErr}
+	report, err = New(failing, dst, WithCheckpoint(path), WithPageSize(2), WithConcurrency(1), WithRetries(0, 0)).Run(ctx, "data/")
+	if !errors.Is(err, listErr) || report.Copied != 1 || len(report.Failures) != 1 {
+		t.Fatalf("unexpected run %+v: %v", report, err)
+	}
+	if cp, err := loadCheckpoint(path); err != nil || cp == nil || cp.Marker != "data/01" || !slices.Equal(cp.Failed, []string{"data/00"}) {
+		t.Fatalf("unexpected checkpoint %+v: %v", cp, err)
+	}
+	if report, err = New(src, dst, WithCheckpoint(path), WithPageSize(2)).Run(ctx, "data/"); err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 5 || len(report.Failures) != 0 {
+		t.Fatalf("unexpected resumed report %+v", report)
+	}
+	if keys := dst.Keys(); len(keys) != 6 {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+// failingList fails every ListObjects call after the first after ones.
+type failingList struct {
+	s3.Interface
+	after int
+	err   error
+}
+
+func (f *failingList) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	if f.after == 0 {
+		return nil, f.err
+	}
+	f.after--
+	return f.Interface.ListObjects(ctx, prefix, marker, limit)
+}
@@ -0,0 +1,134 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package minio
+
+import (
+	"context"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+var _ s3.Lifecycle = (*Minio)(nil)
+
+const (
+	statusEnabled  = "Enabled"
+	statusDisabled = "Disabled"
+)
+
+// GetLifecycle returns the rules of the bucket. MinIO transitions objects to remote tiers,
+// so storage classes are the tier names configured on the server.
+func (m *Minio) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	if err := m.initMinio(ctx); err != nil {
+		return nil, err
+	}
+	config, err := m.core.Client.GetBucketLifecycle(ctx, m.bucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	rules := make([]s3.LifecycleRule, 0, len(config.Rules))
+	for _, r := range config.Rules {
+		rule := s3.LifecycleRule{
+			ID:         r.ID,
+			Prefix:     r.RuleFilter.Prefix,
+			ExpireDays: int(r.Expiration.Days),
+			Disabled:   r.Status != statusEnabled,
+		}
+		if rule.Prefix == "" {
+			rule.Prefix = r.Prefix
+		}
+		if !r.Transition.IsNull() {
+			rule.Transitions = []s3.LifecycleTransition{{Days: int(r.Transition.Days), StorageClass: r.Transition.StorageClass}}
+		}
+		if !mappable(r) {
+			rule.Vendor = r
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// mappable reports whether s3.LifecycleRule expresses everything r does.
+func mappable(r lifecycle.Rule) bool {
+	return r.RuleFilter.Tag.IsEmpty() && r.RuleFilter.And.IsEmpty() &&
+		r.RuleFilter.ObjectSizeLessThan == 0 && r.RuleFilter.ObjectSizeGreaterThan == 0 &&
+		r.Expiration.IsDateNull() && !r.Expiration.IsDeleteMarkerExpirationEnabled() && !r.Expiration.DeleteAll.IsEnabled() &&
+		r.Transition.IsDateNull() && r.AbortIncompleteMultipartUpload.IsDaysNull() &&
+		r.NoncurrentVersionExpiration.IsDaysNull() && r.NoncurrentVersionExpiration.NewerNoncurrentVersions == 0 &&
+		r.NoncurrentVersionTransition.IsStorageClassEmpty() &&
+		(r.Status == statusEnabled || r.Status == statusDisabled)
+}
+
+// SetLifecycle replaces the rules of the bucket; MinIO allows one transition per rule.
+func (m *Minio) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	if err := m.initMinio(ctx); err != nil {
+		return err
+	}
+	config := lifecycle.NewConfiguration()
+	for _, rule := range rules {
+		if rule.Vendor != nil {
+			r, ok := rule.Vendor.(lifecycle.Rule)
+			if !ok {
+				return errs.ErrArgs.WrapMsg("not a minio lifecycle rule", "id", rule.ID)
+			}
+			config.Rules = append(config.Rules, r)
+			continue
+		}
+		if len(rule.Transitions) > 1 {
+			return errs.ErrArgs.WrapMsg("minio supports one transition per lifecycle rule", "id", rule.ID)
+		}
+		r := lifecycle.Rule{
+			ID:         rule.ID,
+			Status:     statusEnabled,
+			RuleFilter: lifecycle.Filter{Prefix: rule.Prefix},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(rule.ExpireDays)},
+		}
+		if rule.Disabled {
+			r.Status = statusDisabled
+		}
+		for _, t := range rule.Transitions {
+			r.Transition = lifecycle.Transition{Days: lifecycle.ExpirationDays(t.Days), StorageClass: t.StorageClass}
+		}
+		config.Rules = append(config.Rules, r)
+	}
+	return m.core.Client.SetBucketLifecycle(ctx, m.bucket, config)
+}
+
+func (m *Minio) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	if err := m.initMinio(ctx); err != nil {
+		return err
+	}
+	status := minio.LegalHoldDisabled
+	if hold {
+		status = minio.LegalHoldEnabled
+	}
+	return m.core.Client.PutObjectLegalHold(ctx, m.bucket, name, minio.PutObjectLegalHoldOptions{Status: &status})
+}
+
+func (m *Minio) LegalHold(ctx context.Context, name string) (bool, error) {
+	if err := m.initMinio(ctx); err != nil {
+		return false, err
+	}
+	status, err := m.core.Client.GetObjectLegalHold(ctx, m.bucket, name, minio.GetObjectLegalHoldOptions{})
+	if err != nil {
+		return false, err
+	}
+	return status != nil && *status == minio.LegalHoldEnabled, nil
+}
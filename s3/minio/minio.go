@@ -18,11 +18,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"path"
-	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -33,8 +31,10 @@ import (
 	"github.com/amazing-socrates/next-tools/errs"
 	"github.com/amazing-socrates/next-tools/log"
 	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/amazing-socrates/next-tools/s3/imageproc"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/minio/minio-go/v7/pkg/signer"
 )
 
@@ -67,6 +67,12 @@ type Config struct {
 	SessionToken    string
 	SignEndpoint    string
 	PublicRead      bool
+	// ThumbnailFilter is FilterNearest, FilterBilinear or FilterCatmullRom (the default).
+	ThumbnailFilter string
+	// ThumbnailQuality is the quality of lossy thumbnails from 1 to 100, 40 when unset.
+	ThumbnailQuality int
+	// Encryption enables server-side encryption of uploaded objects.
+	Encryption *s3.Encryption
 }
 
 func NewMinio(ctx context.Context, cache Cache, conf Config) (*Minio, error) {
@@ -74,6 +80,10 @@ func NewMinio(ctx context.Context, cache Cache, conf Config) (*Minio, error) {
 	if err != nil {
 		return nil, err
 	}
+	sse, err := serverSide(conf.Encryption)
+	if err != nil {
+		return nil, err
+	}
 	opts := &minio.Options{
 		Creds:  credentials.NewStaticV4(conf.AccessKeyID, conf.SecretAccessKey, conf.SessionToken),
 		Secure: u.Scheme == "https",
@@ -86,10 +96,20 @@ func NewMinio(ctx context.Context, cache Cache, conf Config) (*Minio, error) {
 		conf:   conf,
 		bucket: conf.Bucket,
 		core:   &minio.Core{Client: client},
+		sse:    sse,
 		lock:   &sync.Mutex{},
 		init:   false,
-		cache:  cache,
 	}
+	imageOpts := []imageproc.Option{
+		imageproc.WithThumbnailPrefix(imageThumbnailPath),
+		imageproc.WithMaxImageSize(maxImageWidth, maxImageHeight),
+		imageproc.WithMaxObjectSize(maxImageSize),
+		imageproc.WithFilter(conf.ThumbnailFilter),
+	}
+	if conf.ThumbnailQuality > 0 {
+		imageOpts = append(imageOpts, imageproc.WithQuality(conf.ThumbnailQuality))
+	}
+	m.images = imageproc.New(m, cache, imageOpts...)
 	if conf.SignEndpoint == "" || conf.SignEndpoint == conf.Endpoint {
 		m.opts = opts
 		m.sign = m.core.Client
@@ -129,10 +149,11 @@ type Minio struct {
 	opts         *minio.Options
 	core         *minio.Core
 	sign         *minio.Client
+	sse          encrypt.ServerSide
 	lock         sync.Locker
 	init         bool
 	prefix       string
-	cache        Cache
+	images       *imageproc.Processor
 }
 
 func (m *Minio) initMinio(ctx context.Context) error {
@@ -209,7 +230,7 @@ func (m *Minio) InitiateMultipartUpload(ctx context.Context, name string) (*s3.I
 	if err := m.initMinio(ctx); err != nil {
 		return nil, err
 	}
-	uploadID, err := m.core.NewMultipartUpload(ctx, m.bucket, name, minio.PutObjectOptions{})
+	uploadID, err := m.core.NewMultipartUpload(ctx, m.bucket, name, minio.PutObjectOptions{ServerSideEncryption: m.sse})
 	if err != nil {
 		return nil, err
 	}
@@ -235,7 +256,7 @@ func (m *Minio) CompleteMultipartUpload(ctx context.Context, uploadID string, na
 	if err != nil {
 		return nil, err
 	}
-	m.delObjectImageInfoKey(ctx, name, upload.Size)
+	m.images.DelObjectImageInfo(ctx, name, upload.Size)
 	return &s3.CompleteMultipartUploadResult{
 		Location: upload.Location,
 		Bucket:   upload.Bucket,
@@ -281,6 +302,10 @@ func (m *Minio) AuthSign(ctx context.Context, uploadID string, name string, expi
 			return nil, err
 		}
 		request.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+		// Parts only carry SSE-C headers; the S3 and KMS ones are rejected on UploadPart.
+		if m.sse != nil && m.sse.Type() == encrypt.SSEC {
+			encrypt.SSE(m.sse).Marshal(request.Header)
+		}
 		request = signer.SignV4Trailer(*request, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, m.location, nil)
 		result.Parts[i] = s3.SignPart{
 			PartNumber: partNumber,
@@ -295,17 +320,31 @@ func (m *Minio) AuthSign(ctx context.Context, uploadID string, name string, expi
 }
 
 func (m *Minio) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
-	if err := m.initMinio(ctx); err != nil {
+	res, err := m.PresignedPutObjectHeader(ctx, name, expire)
+	if err != nil {
 		return "", err
 	}
-	rawURL, err := m.sign.PresignedPutObject(ctx, m.bucket, name, expire)
+	return res.URL, nil
+}
+
+// PresignedPutObjectHeader signs the encryption headers into the URL; the client must send them.
+func (m *Minio) PresignedPutObjectHeader(ctx context.Context, name string, expire time.Duration) (*s3.PresignedPutObjectResult, error) {
+	if err := m.initMinio(ctx); err != nil {
+		return nil, err
+	}
+	var header http.Header
+	if m.sse != nil {
+		header = make(http.Header)
+		m.sse.Marshal(header)
+	}
+	rawURL, err := m.sign.PresignHeader(ctx, http.MethodPut, m.bucket, name, expire, nil, header)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if m.prefix != "" {
 		rawURL.Path = path.Join(m.prefix, rawURL.Path)
 	}
-	return rawURL.String(), nil
+	return &s3.PresignedPutObjectResult{URL: rawURL.String(), Header: header}, nil
 }
 
 func (m *Minio) DeleteObject(ctx context.Context, name string) error {
@@ -319,7 +358,7 @@ func (m *Minio) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, er
 	if err := m.initMinio(ctx); err != nil {
 		return nil, err
 	}
-	info, err := m.core.Client.StatObject(ctx, m.bucket, name, minio.StatObjectOptions{})
+	info, err := m.core.Client.StatObject(ctx, m.bucket, name, minio.StatObjectOptions{ServerSideEncryption: encrypt.SSE(m.sse)})
 	if err != nil {
 		return nil, err
 	}
@@ -328,6 +367,8 @@ func (m *Minio) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, er
 		Key:          info.Key,
 		Size:         info.Size,
 		LastModified: info.LastModified,
+		ContentType:  info.ContentType,
+		Metadata:     s3.LowerMetadata(info.UserMetadata),
 	}, nil
 }
 
@@ -336,11 +377,13 @@ func (m *Minio) CopyObject(ctx context.Context, src string, dst string) (*s3.Cop
 		return nil, err
 	}
 	result, err := m.core.Client.CopyObject(ctx, minio.CopyDestOptions{
-		Bucket: m.bucket,
-		Object: dst,
+		Bucket:     m.bucket,
+		Object:     dst,
+		Encryption: m.sse,
 	}, minio.CopySrcOptions{
-		Bucket: m.bucket,
-		Object: src,
+		Bucket:     m.bucket,
+		Object:     src,
+		Encryption: encrypt.SSECopy(m.sse),
 	})
 	if err != nil {
 		return nil, err
@@ -395,6 +438,30 @@ func (m *Minio) ListUploadedParts(ctx context.Context, uploadID string, name str
 	return res, nil
 }
 
+func (m *Minio) ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*s3.ListMultipartUploadsResult, error) {
+	if err := m.initMinio(ctx); err != nil {
+		return nil, err
+	}
+	result, err := m.core.ListMultipartUploads(ctx, m.bucket, prefix, keyMarker, uploadIDMarker, "", s3.ListLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	res := &s3.ListMultipartUploadsResult{
+		NextKeyMarker:      result.NextKeyMarker,
+		NextUploadIDMarker: result.NextUploadIDMarker,
+		IsTruncated:        result.IsTruncated,
+		Uploads:            make([]s3.MultipartUpload, len(result.Uploads)),
+	}
+	for i, upload := range result.Uploads {
+		res.Uploads[i] = s3.MultipartUpload{
+			Key:       upload.Key,
+			UploadID:  upload.UploadID,
+			Initiated: upload.Initiated,
+		}
+	}
+	return res, nil
+}
+
 func (m *Minio) PresignedGetObject(ctx context.Context, name string, expire time.Duration, query url.Values) (string, error) {
 	if expire <= 0 {
 		expire = time.Hour * 24 * 365 * 99 // 99 years
@@ -420,9 +487,8 @@ func (m *Minio) PresignedGetObject(ctx context.Context, name string, expire time
 }
 
 func (m *Minio) AccessURL(ctx context.Context, name string, expire time.Duration, opt *s3.AccessURLOption) (string, error) {
-	if opt != nil && opt.Image != nil {
-		opt.Filename = ""
-		opt.ContentType = ""
+	if opt != nil && (opt.Image != nil || opt.Preview != nil) {
+		return m.images.AccessURL(ctx, name, expire, opt)
 	}
 	if err := m.initMinio(ctx); err != nil {
 		return "", err
@@ -436,22 +502,7 @@ func (m *Minio) AccessURL(ctx context.Context, name string, expire time.Duration
 			reqParams.Set("response-content-disposition", `attachment; filename*=UTF-8''`+url.PathEscape(opt.Filename))
 		}
 	}
-	if opt.Image == nil || (opt.Image.Width < 0 && opt.Image.Height < 0 && opt.Image.Format == "") || (opt.Image.Width > maxImageWidth || opt.Image.Height > maxImageHeight) {
-		return m.PresignedGetObject(ctx, name, expire, reqParams)
-	}
-	return m.getImageThumbnailURL(ctx, name, expire, opt.Image)
-}
-
-func (m *Minio) getObjectData(ctx context.Context, name string, limit int64) ([]byte, error) {
-	object, err := m.core.Client.GetObject(ctx, m.bucket, name, minio.GetObjectOptions{})
-	if err != nil {
-		return nil, err
-	}
-	defer object.Close()
-	if limit < 0 {
-		return io.ReadAll(object)
-	}
-	return io.ReadAll(io.LimitReader(object, limit))
+	return m.PresignedGetObject(ctx, name, expire, reqParams)
 }
 
 func (m *Minio) FormData(ctx context.Context, name string, size int64, contentType string, duration time.Duration) (*s3.FormData, error) {
@@ -482,6 +533,9 @@ func (m *Minio) FormData(ctx context.Context, name string, size int64, contentTy
 	if err := policy.SetBucket(m.bucket); err != nil {
 		return nil, err
 	}
+	if m.sse != nil {
+		policy.SetEncryption(m.sse)
+	}
 	u, fd, err := m.core.PresignedPostPolicy(ctx, policy)
 	if err != nil {
 		return nil, err
@@ -503,15 +557,23 @@ func (m *Minio) FormData(ctx context.Context, name string, size int64, contentTy
 }
 
 func (m *Minio) GetImageThumbnailKey(ctx context.Context, name string) (string, error) {
-	info, img, err := m.getObjectImageInfo(ctx, name)
-	if err != nil {
-		return "", errs.Wrap(err)
+	return m.images.GetImageThumbnailKey(ctx, name)
+}
+
+// serverSide converts the encryption config to the minio client setting, nil when disabled.
+func serverSide(enc *s3.Encryption) (encrypt.ServerSide, error) {
+	if err := enc.Check(); err != nil {
+		return nil, err
 	}
-	if !info.IsImg {
-		return "", errs.New("object not image").Wrap()
+	if enc == nil {
+		return nil, nil
+	}
+	switch enc.Type {
+	case s3.EncryptionSSEKMS:
+		return encrypt.NewSSEKMS(enc.KMSKeyID, nil)
+	case s3.EncryptionSSEC:
+		return encrypt.NewSSEC(enc.CustomerKey)
+	default:
+		return encrypt.NewSSE(), nil
 	}
-	thumbnailWidth, thumbnailHeight := getThumbnailSize(img)
-
-	cacheKey := filepath.Join(imageThumbnailPath, info.Etag, fmt.Sprintf("image_w%d_h%d.%s", thumbnailWidth, thumbnailHeight, info.Format))
-	return cacheKey, nil
 }
@@ -0,0 +1,87 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// newSignMinio returns a Minio that can sign requests without reaching a server.
+func newSignMinio(t *testing.T, enc *s3.Encryption) *Minio {
+	t.Helper()
+	sse, err := serverSide(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Minio{
+		bucket:       "bucket",
+		signEndpoint: "http://127.0.0.1:9000",
+		location:     "us-east-1",
+		opts:         &minio.Options{Creds: credentials.NewStaticV4("access", "secret", "")},
+		sse:          sse,
+		lock:         &sync.Mutex{},
+		init:         true,
+	}
+}
+
+func TestAuthSignEncryption(t *testing.T) {
+	cases := []struct {
+		name string
+		enc  *s3.Encryption
+		ssec bool
+	}{
+		{name: "none"},
+		{name: "sse-s3", enc: &s3.Encryption{Type: s3.EncryptionSSES3}},
+		{name: "sse-kms", enc: &s3.Encryption{Type: s3.EncryptionSSEKMS, KMSKeyID: "key"}},
+		{name: "sse-c", enc: &s3.Encryption{Type: s3.EncryptionSSEC, CustomerKey: bytes.Repeat([]byte{1}, 32)}, ssec: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newSignMinio(t, c.enc)
+			res, err := m.AuthSign(context.Background(), "upload", "object", time.Hour, []int{1, 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, part := range res.Parts {
+				var customer bool
+				for key := range part.Header {
+					key = strings.ToLower(key)
+					if !strings.HasPrefix(key, "x-amz-server-side-encryption") {
+						continue
+					}
+					if !strings.HasPrefix(key, "x-amz-server-side-encryption-customer-") {
+						t.Fatalf("part %d signs %s", part.PartNumber, key)
+					}
+					customer = true
+				}
+				if customer != c.ssec {
+					t.Fatalf("part %d customer key headers %v, want %v", part.PartNumber, customer, c.ssec)
+				}
+				if signed := part.Header.Get("Authorization"); c.ssec && !strings.Contains(signed, "x-amz-server-side-encryption-customer-key") {
+					t.Fatalf("part %d does not sign the customer key: %s", part.PartNumber, signed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/amazing-socrates/next-tools/s3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+func (m *Minio) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	if err := m.initMinio(ctx); err != nil {
+		return nil, err
+	}
+	info, err := s3.PutStream(ctx, m, objectWriter{m}, name, reader, size, opt)
+	if err != nil {
+		return nil, err
+	}
+	m.images.DelObjectImageInfo(ctx, name, info.Size)
+	return info, nil
+}
+
+func (m *Minio) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	if err := m.initMinio(ctx); err != nil {
+		return nil, nil, err
+	}
+	opts := minio.GetObjectOptions{ServerSideEncryption: encrypt.SSE(m.sse)}
+	if rangeHeader := opt.RangeHeader(); rangeHeader != "" {
+		opts.Set("Range", rangeHeader)
+	}
+	body, info, header, err := m.core.GetObject(ctx, m.bucket, name, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, &s3.ObjectInfo{
+		ETag:         strings.ToLower(strings.Trim(info.ETag, `"`)),
+		Key:          name,
+		Size:         s3.ObjectSize(info.Size, header.Get("Content-Range")),
+		LastModified: info.LastModified,
+		ContentType:  info.ContentType,
+		Metadata:     s3.LowerMetadata(info.UserMetadata),
+	}, nil
+}
+
+func (m *Minio) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	if err := m.initMinio(ctx); err != nil {
+		return nil, err
+	}
+	result, err := m.core.ListObjectsV2(m.bucket, prefix, marker, "", "", s3.ListLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*s3.ObjectInfo, len(result.Contents))
+	for i, object := range result.Contents {
+		objects[i] = &s3.ObjectInfo{
+			ETag:         strings.ToLower(strings.Trim(object.ETag, `"`)),
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		}
+	}
+	return s3.ListResult(objects, result.IsTruncated), nil
+}
+
+type objectWriter struct {
+	m *Minio
+}
+
+func (w objectWriter) PutObject(ctx context.Context, name string, data []byte, opt *s3.PutObjectOption) (string, error) {
+	info, err := w.m.core.PutObject(ctx, w.m.bucket, name, bytes.NewReader(data), int64(len(data)), "", "", w.m.putObjectOptions(opt))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(info.ETag), nil
+}
+
+func (w objectWriter) InitiateMultipart(ctx context.Context, name string, opt *s3.PutObjectOption) (s3.MultipartWriter, error) {
+	uploadID, err := w.m.core.NewMultipartUpload(ctx, w.m.bucket, name, w.m.putObjectOptions(opt))
+	if err != nil {
+		return nil, err
+	}
+	return &multipartWriter{m: w.m, name: name, uploadID: uploadID}, nil
+}
+
+type multipartWriter struct {
+	m        *Minio
+	name     string
+	uploadID string
+}
+
+func (w *multipartWriter) UploadPart(ctx context.Context, partNumber int, data []byte) (string, error) {
+	part, err := w.m.core.PutObjectPart(ctx, w.m.bucket, w.name, w.uploadID, partNumber, bytes.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{SSE: encrypt.SSE(w.m.sse)})
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+func (w *multipartWriter) Complete(ctx context.Context, parts []s3.Part) (string, error) {
+	res, err := w.m.CompleteMultipartUpload(ctx, w.uploadID, w.name, parts)
+	if err != nil {
+		return "", err
+	}
+	return res.ETag, nil
+}
+
+func (w *multipartWriter) Abort(ctx context.Context) error {
+	return w.m.core.AbortMultipartUpload(ctx, w.m.bucket, w.name, w.uploadID)
+}
+
+func (m *Minio) putObjectOptions(opt *s3.PutObjectOption) minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{ServerSideEncryption: m.sse}
+	if opt != nil {
+		opts.ContentType = opt.ContentType
+		opts.UserMetadata = opt.Metadata
+	}
+	return opts
+}
@@ -0,0 +1,119 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oss
+
+import (
+	"context"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+var _ s3.Lifecycle = (*OSS)(nil)
+
+const (
+	statusEnabled  = "Enabled"
+	statusDisabled = "Disabled"
+)
+
+var storageClasses = s3.StorageClasses{
+	s3.StorageClassInfrequent:  string(oss.StorageIA),
+	s3.StorageClassArchive:     string(oss.StorageArchive),
+	s3.StorageClassDeepArchive: string(oss.StorageColdArchive),
+}
+
+func (o *OSS) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	res, err := o.bucket.Client.GetBucketLifecycle(o.bucket.BucketName)
+	if err != nil {
+		// NoSuchLifecycle
+		if o.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	rules := make([]s3.LifecycleRule, 0, len(res.Rules))
+	for _, r := range res.Rules {
+		rule := s3.LifecycleRule{ID: r.ID, Prefix: r.Prefix, Disabled: r.Status != statusEnabled}
+		if r.Expiration != nil {
+			rule.ExpireDays = r.Expiration.Days
+		}
+		for _, t := range r.Transitions {
+			rule.Transitions = append(rule.Transitions, s3.LifecycleTransition{Days: t.Days, StorageClass: storageClasses.Class(string(t.StorageClass))})
+		}
+		if !mappable(r) {
+			rule.Vendor = r
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// mappable reports whether s3.LifecycleRule expresses everything r does.
+func mappable(r oss.LifecycleRule) bool {
+	if r.Expiration != nil && (r.Expiration.Date != "" || r.Expiration.CreatedBeforeDate != "" || r.Expiration.ExpiredObjectDeleteMarker != nil) {
+		return false
+	}
+	for _, t := range r.Transitions {
+		if t.CreatedBeforeDate != "" || t.IsAccessTime != nil || t.ReturnToStdWhenVisit != nil || t.AllowSmallFile != nil {
+			return false
+		}
+	}
+	return len(r.Tags) == 0 && r.Filter == nil && r.AbortMultipartUpload == nil && r.NonVersionExpiration == nil &&
+		r.NonVersionTransition == nil && len(r.NonVersionTransitions) == 0 &&
+		(r.Status == statusEnabled || r.Status == statusDisabled)
+}
+
+func (o *OSS) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	if len(rules) == 0 {
+		return o.bucket.Client.DeleteBucketLifecycle(o.bucket.BucketName)
+	}
+	ossRules := make([]oss.LifecycleRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Vendor != nil {
+			r, ok := rule.Vendor.(oss.LifecycleRule)
+			if !ok {
+				return errs.ErrArgs.WrapMsg("not an oss lifecycle rule", "id", rule.ID)
+			}
+			ossRules = append(ossRules, r)
+			continue
+		}
+		r := oss.LifecycleRule{
+			ID:     rule.ID,
+			Prefix: rule.Prefix,
+			Status: statusEnabled,
+		}
+		if rule.Disabled {
+			r.Status = statusDisabled
+		}
+		if rule.ExpireDays > 0 {
+			r.Expiration = &oss.LifecycleExpiration{Days: rule.ExpireDays}
+		}
+		for _, t := range rule.Transitions {
+			r.Transitions = append(r.Transitions, oss.LifecycleTransition{Days: t.Days, StorageClass: oss.StorageClassType(storageClasses.Vendor(t.StorageClass))})
+		}
+		ossRules = append(ossRules, r)
+	}
+	return o.bucket.Client.SetBucketLifecycle(o.bucket.BucketName, ossRules)
+}
+
+// SetLegalHold is not supported, OSS only locks whole buckets with retention policies.
+func (o *OSS) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	return s3.ErrLegalHoldNotSupported.WrapMsg("oss has no object legal hold", "name", name)
+}
+
+func (o *OSS) LegalHold(ctx context.Context, name string) (bool, error) {
+	return false, s3.ErrLegalHoldNotSupported.WrapMsg("oss has no object legal hold", "name", name)
+}
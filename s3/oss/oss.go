@@ -51,6 +51,13 @@ const (
 
 const successCode = http.StatusOK
 
+var encryptionHeaders = s3.EncryptionHeaders{
+	Prefix:   "x-oss-",
+	SSES3:    "AES256",
+	SSEKMS:   "KMS",
+	KMSKeyID: oss.HTTPHeaderOssServerSideEncryptionKeyID,
+}
+
 var _ s3.Interface = (*OSS)(nil)
 
 type Config struct {
@@ -61,12 +68,21 @@ type Config struct {
 	AccessKeySecret string
 	SessionToken    string
 	PublicRead      bool
+	// Encryption enables server-side encryption of uploaded objects. OSS does not
+	// support EncryptionSSEC.
+	Encryption *s3.Encryption
 }
 
 func NewOSS(conf Config) (*OSS, error) {
 	if conf.BucketURL == "" {
 		return nil, errs.Wrap(errors.New("bucket url is empty"))
 	}
+	if err := conf.Encryption.Check(); err != nil {
+		return nil, err
+	}
+	if conf.Encryption.IsCustomerKey() {
+		return nil, errs.ErrArgs.WrapMsg("ali-oss does not support SSE-C")
+	}
 	client, err := oss.New(conf.Endpoint, conf.AccessKeyID, conf.AccessKeySecret)
 	if err != nil {
 		return nil, err
@@ -84,6 +100,7 @@ func NewOSS(conf Config) (*OSS, error) {
 		credentials: client.Config.GetCredentials(),
 		um:          *(*urlMaker)(reflect.ValueOf(bucket.Client.Conn).Elem().FieldByName("url").UnsafePointer()),
 		publicRead:  conf.PublicRead,
+		encryption:  conf.Encryption.Header(encryptionHeaders),
 	}, nil
 }
 
@@ -93,6 +110,7 @@ type OSS struct {
 	credentials oss.Credentials
 	um          urlMaker
 	publicRead  bool
+	encryption  http.Header
 }
 
 func (o *OSS) Engine() string {
@@ -108,7 +126,7 @@ func (o *OSS) PartLimit() *s3.PartLimit {
 }
 
 func (o *OSS) InitiateMultipartUpload(ctx context.Context, name string) (*s3.InitiateMultipartUploadResult, error) {
-	result, err := o.bucket.InitiateMultipartUpload(name)
+	result, err := o.bucket.InitiateMultipartUpload(name, o.encryptionOptions()...)
 	if err != nil {
 		return nil, err
 	}
@@ -193,7 +211,16 @@ func (o *OSS) AuthSign(ctx context.Context, uploadID string, name string, expire
 }
 
 func (o *OSS) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
-	return o.bucket.SignURL(name, http.MethodPut, int64(expire/time.Second))
+	return o.bucket.SignURL(name, http.MethodPut, int64(expire/time.Second), o.encryptionOptions()...)
+}
+
+// PresignedPutObjectHeader returns the encryption headers signed into the URL; the client must send them.
+func (o *OSS) PresignedPutObjectHeader(ctx context.Context, name string, expire time.Duration) (*s3.PresignedPutObjectResult, error) {
+	rawURL, err := o.PresignedPutObject(ctx, name, expire)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PresignedPutObjectResult{URL: rawURL, Header: o.encryption.Clone()}, nil
 }
 
 func (o *OSS) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, error) {
@@ -201,7 +228,11 @@ func (o *OSS) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, erro
 	if err != nil {
 		return nil, err
 	}
-	res := &s3.ObjectInfo{Key: name}
+	res := &s3.ObjectInfo{
+		Key:         name,
+		ContentType: header.Get("Content-Type"),
+		Metadata:    s3.MetadataFromHeader(header, oss.HTTPHeaderOssMetaPrefix),
+	}
 	if res.ETag = strings.ToLower(strings.ReplaceAll(header.Get("ETag"), `"`, ``)); res.ETag == "" {
 		return nil, errs.Wrap(errors.New("StatObject etag not found"))
 	}
@@ -232,7 +263,7 @@ func (o *OSS) DeleteObject(ctx context.Context, name string) error {
 }
 
 func (o *OSS) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyObjectInfo, error) {
-	result, err := o.bucket.CopyObject(src, dst)
+	result, err := o.bucket.CopyObject(src, dst, o.encryptionOptions()...)
 	if err != nil {
 		return nil, errs.WrapMsg(err, "CopyObject error")
 	}
@@ -288,6 +319,33 @@ func (o *OSS) ListUploadedParts(ctx context.Context, uploadID string, name strin
 	return res, nil
 }
 
+func (o *OSS) ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*s3.ListMultipartUploadsResult, error) {
+	result, err := o.bucket.ListMultipartUploads(
+		oss.WithContext(ctx),
+		oss.Prefix(prefix),
+		oss.KeyMarker(keyMarker),
+		oss.UploadIDMarker(uploadIDMarker),
+		oss.MaxUploads(s3.ListLimit(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	res := &s3.ListMultipartUploadsResult{
+		NextKeyMarker:      result.NextKeyMarker,
+		NextUploadIDMarker: result.NextUploadIDMarker,
+		IsTruncated:        result.IsTruncated,
+		Uploads:            make([]s3.MultipartUpload, len(result.Uploads)),
+	}
+	for i, upload := range result.Uploads {
+		res.Uploads[i] = s3.MultipartUpload{
+			Key:       upload.Key,
+			UploadID:  upload.UploadID,
+			Initiated: upload.Initiated,
+		}
+	}
+	return res, nil
+}
+
 func (o *OSS) AccessURL(ctx context.Context, name string, expire time.Duration, opt *s3.AccessURLOption) (string, error) {
 	if opt != nil && opt.Image != nil {
 		opt.Filename = ""
@@ -355,6 +413,10 @@ func (o *OSS) FormData(ctx context.Context, name string, size int64, contentType
 	if size > 0 {
 		conditions = append(conditions, []any{"content-length-range", 0, size})
 	}
+	fields := s3.FormFields(o.encryption)
+	for key, value := range fields {
+		conditions = append(conditions, map[string]string{key: value})
+	}
 	policy := map[string]any{
 		"expiration": expires.Format("2006-01-02T15:04:05.000Z"),
 		"conditions": conditions,
@@ -384,5 +446,16 @@ func (o *OSS) FormData(ctx context.Context, name string, size int64, contentType
 	if contentType != "" {
 		fd.FormData["x-oss-content-type"] = contentType
 	}
+	for key, value := range fields {
+		fd.FormData[key] = value
+	}
 	return fd, nil
 }
+
+func (o *OSS) encryptionOptions() []oss.Option {
+	options := make([]oss.Option, 0, len(o.encryption))
+	for key := range o.encryption {
+		options = append(options, oss.SetHeader(key, o.encryption.Get(key)))
+	}
+	return options
+}
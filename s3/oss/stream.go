@@ -0,0 +1,142 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oss
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+func (o *OSS) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	return s3.PutStream(ctx, o, objectWriter{o}, name, reader, size, opt)
+}
+
+func (o *OSS) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	var header http.Header
+	options := []oss.Option{oss.WithContext(ctx), oss.GetResponseHeader(&header)}
+	if rangeHeader := opt.RangeHeader(); rangeHeader != "" {
+		options = append(options, oss.NormalizedRange(strings.TrimPrefix(rangeHeader, "bytes=")))
+	}
+	body, err := o.bucket.GetObject(name, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	contentLength, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	info := &s3.ObjectInfo{
+		ETag:        formatETag(header.Get("ETag")),
+		Key:         name,
+		Size:        s3.ObjectSize(contentLength, header.Get("Content-Range")),
+		ContentType: header.Get("Content-Type"),
+		Metadata:    s3.MetadataFromHeader(header, oss.HTTPHeaderOssMetaPrefix),
+	}
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		info.LastModified, _ = time.Parse(http.TimeFormat, lastModified)
+	}
+	return body, info, nil
+}
+
+func (o *OSS) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	result, err := o.bucket.ListObjects(oss.WithContext(ctx), oss.Prefix(prefix), oss.Marker(marker), oss.MaxKeys(s3.ListLimit(limit)))
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*s3.ObjectInfo, len(result.Objects))
+	for i, object := range result.Objects {
+		objects[i] = &s3.ObjectInfo{
+			ETag:         formatETag(object.ETag),
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		}
+	}
+	return s3.ListResult(objects, result.IsTruncated), nil
+}
+
+func formatETag(etag string) string {
+	return strings.ToLower(strings.ReplaceAll(etag, `"`, ``))
+}
+
+func (o *OSS) putOptions(ctx context.Context, opt *s3.PutObjectOption) []oss.Option {
+	options := append([]oss.Option{oss.WithContext(ctx)}, o.encryptionOptions()...)
+	if opt != nil {
+		if opt.ContentType != "" {
+			options = append(options, oss.ContentType(opt.ContentType))
+		}
+		for k, v := range opt.Metadata {
+			options = append(options, oss.Meta(k, v))
+		}
+	}
+	return options
+}
+
+type objectWriter struct {
+	o *OSS
+}
+
+func (w objectWriter) PutObject(ctx context.Context, name string, data []byte, opt *s3.PutObjectOption) (string, error) {
+	var header http.Header
+	options := append(w.o.putOptions(ctx, opt), oss.GetResponseHeader(&header))
+	if err := w.o.bucket.PutObject(name, bytes.NewReader(data), options...); err != nil {
+		return "", err
+	}
+	etag := formatETag(header.Get("ETag"))
+	if etag == "" {
+		return "", errs.Wrap(errors.New("PutObject etag not found"))
+	}
+	return etag, nil
+}
+
+func (w objectWriter) InitiateMultipart(ctx context.Context, name string, opt *s3.PutObjectOption) (s3.MultipartWriter, error) {
+	imur, err := w.o.bucket.InitiateMultipartUpload(name, w.o.putOptions(ctx, opt)...)
+	if err != nil {
+		return nil, err
+	}
+	return &multipartWriter{o: w.o, imur: imur}, nil
+}
+
+type multipartWriter struct {
+	o    *OSS
+	imur oss.InitiateMultipartUploadResult
+}
+
+func (w *multipartWriter) UploadPart(ctx context.Context, partNumber int, data []byte) (string, error) {
+	part, err := w.o.bucket.UploadPart(w.imur, bytes.NewReader(data), int64(len(data)), partNumber, oss.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+func (w *multipartWriter) Complete(ctx context.Context, parts []s3.Part) (string, error) {
+	res, err := w.o.CompleteMultipartUpload(ctx, w.imur.UploadID, w.imur.Key, parts)
+	if err != nil {
+		return "", err
+	}
+	return formatETag(res.ETag), nil
+}
+
+func (w *multipartWriter) Abort(ctx context.Context) error {
+	return w.o.AbortMultipartUpload(ctx, w.imur.UploadID, w.imur.Key)
+}
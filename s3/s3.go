@@ -16,6 +16,7 @@ package s3
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/url"
 	"time"
@@ -63,10 +64,12 @@ type SignResult struct {
 }
 
 type ObjectInfo struct {
-	ETag         string    `json:"etag"`
-	Key          string    `json:"name"`
-	Size         int64     `json:"size"`
-	LastModified time.Time `json:"lastModified"`
+	ETag         string            `json:"etag"`
+	Key          string            `json:"name"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"lastModified"`
+	ContentType  string            `json:"contentType"`
+	Metadata     map[string]string `json:"metadata"`
 }
 
 type CopyObjectInfo struct {
@@ -125,16 +128,68 @@ type ListUploadedPartsResult struct {
 	UploadedParts        []UploadedPart `xml:"Part"`
 }
 
+const (
+	// ImageModeFit scales the image to fit within Width x Height, keeping its aspect ratio.
+	ImageModeFit = "fit"
+	// ImageModeFill scales the image to cover Width x Height and crops the overflow around the center.
+	ImageModeFill = "fill"
+)
+
 type Image struct {
 	Format string `json:"format"`
 	Width  int    `json:"width"`
 	Height int    `json:"height"`
+	// Mode is ImageModeFit or ImageModeFill, fit when empty.
+	Mode string `json:"mode,omitempty"`
+}
+
+// PreviewKindPoster is a still image standing for the whole file, such as a video frame or a first page.
+const PreviewKindPoster = "poster"
+
+// Preview asks for an image rendered from a file that is not an image itself.
+type Preview struct {
+	// Kind is PreviewKindPoster when empty.
+	Kind string `json:"kind"`
+	// Image sizes and encodes the rendered preview like an image thumbnail.
+	Image *Image `json:"image,omitempty"`
 }
 
 type AccessURLOption struct {
+	ContentType string   `json:"contentType"`
+	Filename    string   `json:"filename"`
+	Image       *Image   `json:"image"`
+	Preview     *Preview `json:"preview,omitempty"`
+}
+
+type PutObjectOption struct {
 	ContentType string `json:"contentType"`
-	Filename    string `json:"filename"`
-	Image       *Image `json:"image"`
+	// Metadata is stored as user metadata; keys are returned lower-cased.
+	Metadata map[string]string `json:"metadata"`
+}
+
+// GetObjectOption selects the byte range returned by GetObject; a zero Length reads to the end.
+type GetObjectOption struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+type ListObjectsResult struct {
+	Objects     []*ObjectInfo `json:"objects"`
+	NextMarker  string        `json:"nextMarker"`
+	IsTruncated bool          `json:"isTruncated"`
+}
+
+type MultipartUpload struct {
+	Key       string    `json:"key"`
+	UploadID  string    `json:"uploadID"`
+	Initiated time.Time `json:"initiated"`
+}
+
+type ListMultipartUploadsResult struct {
+	Uploads            []MultipartUpload `json:"uploads"`
+	NextKeyMarker      string            `json:"nextKeyMarker"`
+	NextUploadIDMarker string            `json:"nextUploadIDMarker"`
+	IsTruncated        bool              `json:"isTruncated"`
 }
 
 type Interface interface {
@@ -155,10 +210,23 @@ type Interface interface {
 
 	StatObject(ctx context.Context, name string) (*ObjectInfo, error)
 
+	// PutObject uploads size bytes from reader, switching to multipart when they do not fit in one part.
+	// A negative size means the length is unknown.
+	PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *PutObjectOption) (*ObjectInfo, error)
+	// ListObjects returns up to limit objects under prefix whose keys sort after marker.
+	// Listed objects carry their key, size, ETag and modification time; ContentType and
+	// Metadata may be empty, see StatListed.
+	ListObjects(ctx context.Context, prefix string, marker string, limit int) (*ListObjectsResult, error)
+
+	// GetObject streams the object content; the returned ObjectInfo describes the whole object.
+	GetObject(ctx context.Context, name string, opt *GetObjectOption) (io.ReadCloser, *ObjectInfo, error)
+
 	IsNotFound(err error) bool
 
 	AbortMultipartUpload(ctx context.Context, uploadID string, name string) error
 	ListUploadedParts(ctx context.Context, uploadID string, name string, partNumberMarker int, maxParts int) (*ListUploadedPartsResult, error)
+	// ListMultipartUploads returns in-progress multipart uploads under prefix, ordered by key and upload ID.
+	ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*ListMultipartUploadsResult, error)
 
 	AccessURL(ctx context.Context, name string, expire time.Duration, opt *AccessURLOption) (string, error)
 
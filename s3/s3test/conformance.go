@@ -0,0 +1,484 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+// Harness describes an s3.Interface under conformance test and how data reaches it.
+type Harness struct {
+	// Impl is the implementation under test.
+	Impl s3.Interface
+	// Put stores data under name. Defaults to an HTTP PUT to the PresignedPutObject URL.
+	Put func(ctx context.Context, name string, data []byte) error
+	// UploadPart uploads one part and returns its ETag. Defaults to an HTTP PUT to the AuthSign URL.
+	UploadPart func(ctx context.Context, uploadID string, name string, partNumber int, data []byte) (string, error)
+	// Prefix is prepended to every key the suite touches.
+	Prefix string
+}
+
+// RunConformance checks that an s3.Interface implementation behaves the way cont.Controller
+// relies on. newHarness is called once per subtest so implementations can start from a clean state.
+func RunConformance(t *testing.T, newHarness func(t *testing.T) *Harness) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, h *Harness)
+	}{
+		{"PartSize", testPartSize},
+		{"StatNotFound", testStatNotFound},
+		{"PutStatCopyDelete", testPutStatCopyDelete},
+		{"MultipartUpload", testMultipartUpload},
+		{"AbortMultipartUpload", testAbortMultipartUpload},
+		{"ListMultipartUploads", testListMultipartUploads},
+		{"PutGetObject", testPutGetObject},
+		{"PutObjectMultipart", testPutObjectMultipart},
+		{"ListObjects", testListObjects},
+		{"AccessURL", testAccessURL},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			if h.Put == nil {
+				h.Put = h.httpPut
+			}
+			if h.UploadPart == nil {
+				h.UploadPart = h.httpUploadPart
+			}
+			test.fn(t, h)
+		})
+	}
+}
+
+func (h *Harness) key(name string) string {
+	return h.Prefix + name
+}
+
+func (h *Harness) httpPut(ctx context.Context, name string, data []byte) error {
+	rawURL, err := h.Impl.PresignedPutObject(ctx, name, time.Minute)
+	if err != nil {
+		return err
+	}
+	_, err = doPut(ctx, rawURL, nil, data)
+	return err
+}
+
+func (h *Harness) httpUploadPart(ctx context.Context, uploadID string, name string, partNumber int, data []byte) (string, error) {
+	sign, err := h.Impl.AuthSign(ctx, uploadID, name, time.Minute, []int{partNumber})
+	if err != nil {
+		return "", err
+	}
+	if len(sign.Parts) != 1 {
+		return "", fmt.Errorf("auth sign returned %d parts", len(sign.Parts))
+	}
+	part := sign.Parts[0]
+	rawURL := part.URL
+	if rawURL == "" {
+		rawURL = sign.URL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for k, v := range sign.Query {
+		query[k] = v
+	}
+	for k, v := range part.Query {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	header := make(http.Header)
+	for k, v := range sign.Header {
+		header[k] = v
+	}
+	for k, v := range part.Header {
+		header[k] = v
+	}
+	return doPut(ctx, u.String(), header, data)
+}
+
+func doPut(ctx context.Context, rawURL string, header http.Header, data []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, rawURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("put %s: status %d: %s", rawURL, resp.StatusCode, body)
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+func normalizeETag(etag string) string {
+	return strings.ToLower(strings.Trim(etag, `"`))
+}
+
+func testPartSize(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	limit := h.Impl.PartLimit()
+	if limit == nil || limit.MinPartSize <= 0 || limit.MaxPartSize < limit.MinPartSize || limit.MaxNumSize <= 0 {
+		t.Fatalf("invalid part limit %+v", limit)
+	}
+	if _, err := h.Impl.PartSize(ctx, 0); err == nil {
+		t.Error("PartSize(0) should fail")
+	}
+	if _, err := h.Impl.PartSize(ctx, limit.MaxPartSize*limit.MaxNumSize+1); err == nil {
+		t.Error("PartSize above the maximum object size should fail")
+	}
+	for _, size := range []int64{1, limit.MinPartSize * limit.MaxNumSize, limit.MinPartSize*limit.MaxNumSize + 1} {
+		partSize, err := h.Impl.PartSize(ctx, size)
+		if err != nil {
+			t.Fatalf("PartSize(%d): %v", size, err)
+		}
+		if partSize < limit.MinPartSize || partSize > limit.MaxPartSize {
+			t.Errorf("PartSize(%d) = %d outside %+v", size, partSize, limit)
+		}
+		if num := (size + partSize - 1) / partSize; num > limit.MaxNumSize {
+			t.Errorf("PartSize(%d) = %d needs %d parts", size, partSize, num)
+		}
+	}
+}
+
+func testStatNotFound(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	_, err := h.Impl.StatObject(ctx, h.key("s3test/missing"))
+	if err == nil {
+		t.Fatal("stat of a missing object should fail")
+	}
+	if !h.Impl.IsNotFound(err) {
+		t.Fatalf("IsNotFound(%v) = false", err)
+	}
+}
+
+func testPutStatCopyDelete(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	name := h.key("s3test/object")
+	data := []byte("s3test conformance object")
+	if err := h.Put(ctx, name, data); err != nil {
+		t.Fatal(err)
+	}
+	info, err := h.Impl.StatObject(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != name || info.Size != int64(len(data)) || normalizeETag(info.ETag) != md5Hex(data) {
+		t.Fatalf("unexpected stat %+v", info)
+	}
+	dst := name + ".copy"
+	copyInfo, err := h.Impl.CopyObject(ctx, name, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copyInfo.Key != dst || normalizeETag(copyInfo.ETag) != normalizeETag(info.ETag) {
+		t.Fatalf("unexpected copy %+v", copyInfo)
+	}
+	for _, key := range []string{name, dst} {
+		if err := h.Impl.DeleteObject(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.Impl.StatObject(ctx, key); !h.Impl.IsNotFound(err) {
+			t.Fatalf("stat after delete of %s: %v", key, err)
+		}
+	}
+	if _, err := h.Impl.CopyObject(ctx, name, dst); err == nil {
+		t.Fatal("copy of a deleted object should fail")
+	}
+}
+
+func testMultipartUpload(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	name := h.key("s3test/multipart")
+	upload, err := h.Impl.InitiateMultipartUpload(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.UploadID == "" || upload.Key != name {
+		t.Fatalf("unexpected initiate result %+v", upload)
+	}
+	chunks := [][]byte{
+		bytes.Repeat([]byte{'a'}, int(h.Impl.PartLimit().MinPartSize)),
+		[]byte("last part"),
+	}
+	parts := make([]s3.Part, len(chunks))
+	for i, chunk := range chunks {
+		etag, err := h.UploadPart(ctx, upload.UploadID, name, i+1, chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if normalizeETag(etag) != md5Hex(chunk) {
+			t.Fatalf("part %d etag %s", i+1, etag)
+		}
+		parts[i] = s3.Part{PartNumber: i + 1, ETag: etag}
+	}
+	listed, err := h.Impl.ListUploadedParts(ctx, upload.UploadID, name, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.UploadedParts) != len(chunks) {
+		t.Fatalf("listed %d parts", len(listed.UploadedParts))
+	}
+	for i, part := range listed.UploadedParts {
+		if part.PartNumber != i+1 || part.Size != int64(len(chunks[i])) || normalizeETag(part.ETag) != md5Hex(chunks[i]) {
+			t.Fatalf("unexpected listed part %+v", part)
+		}
+	}
+	if _, err := h.Impl.StatObject(ctx, name); !h.Impl.IsNotFound(err) {
+		t.Fatalf("object visible before complete: %v", err)
+	}
+	if _, err := h.Impl.CompleteMultipartUpload(ctx, upload.UploadID, name, parts); err != nil {
+		t.Fatal(err)
+	}
+	info, err := h.Impl.StatObject(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(chunks[0])+len(chunks[1])) {
+		t.Fatalf("unexpected completed size %d", info.Size)
+	}
+	if err := h.Impl.DeleteObject(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testAbortMultipartUpload(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	name := h.key("s3test/aborted")
+	upload, err := h.Impl.InitiateMultipartUpload(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.UploadPart(ctx, upload.UploadID, name, 1, []byte("aborted part")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Impl.AbortMultipartUpload(ctx, upload.UploadID, name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Impl.ListUploadedParts(ctx, upload.UploadID, name, 0, 10); err == nil {
+		t.Fatal("list parts of an aborted upload should fail")
+	}
+	if _, err := h.Impl.StatObject(ctx, name); !h.Impl.IsNotFound(err) {
+		t.Fatalf("aborted upload produced an object: %v", err)
+	}
+}
+
+func readObject(t *testing.T, impl s3.Interface, name string, opt *s3.GetObjectOption) ([]byte, *s3.ObjectInfo) {
+	t.Helper()
+	body, info, err := impl.GetObject(context.Background(), name, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, info
+}
+
+func testListMultipartUploads(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	prefix := h.key("s3test/uploads/")
+	keys := []string{prefix + "a", prefix + "b", prefix + "c"}
+	uploads := make(map[string]string)
+	for _, key := range keys {
+		upload, err := h.Impl.InitiateMultipartUpload(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploads[key] = upload.UploadID
+		defer h.Impl.AbortMultipartUpload(ctx, upload.UploadID, key)
+	}
+	first, err := h.Impl.ListMultipartUploads(ctx, prefix, "", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Uploads) != 2 || !first.IsTruncated || first.Uploads[0].Key != keys[0] || first.Uploads[0].UploadID != uploads[keys[0]] {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+	if first.Uploads[0].Initiated.IsZero() {
+		t.Fatal("upload initiation time missing")
+	}
+	rest, err := h.Impl.ListMultipartUploads(ctx, prefix, first.NextKeyMarker, first.NextUploadIDMarker, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest.Uploads) != 1 || rest.IsTruncated || rest.Uploads[0].Key != keys[2] {
+		t.Fatalf("unexpected last page %+v", rest)
+	}
+	if err := h.Impl.AbortMultipartUpload(ctx, uploads[keys[1]], keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	all, err := h.Impl.ListMultipartUploads(ctx, prefix, "", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Uploads) != 2 {
+		t.Fatalf("aborted upload still listed: %+v", all.Uploads)
+	}
+}
+
+func testPutGetObject(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	name := h.key("s3test/stream")
+	data := []byte("server side streaming upload")
+	info, err := h.Impl.PutObject(ctx, name, bytes.NewReader(data), int64(len(data)), &s3.PutObjectOption{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Impl.DeleteObject(ctx, name)
+	if info.Size != int64(len(data)) || normalizeETag(info.ETag) != md5Hex(data) {
+		t.Fatalf("unexpected put result %+v", info)
+	}
+	got, getInfo := readObject(t, h.Impl, name, nil)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if getInfo.Size != int64(len(data)) || getInfo.ContentType != "text/plain" {
+		t.Fatalf("unexpected get info %+v", getInfo)
+	}
+	got, getInfo = readObject(t, h.Impl, name, &s3.GetObjectOption{Offset: 7, Length: 4})
+	if string(got) != "side" || getInfo.Size != int64(len(data)) {
+		t.Fatalf("unexpected range %q %+v", got, getInfo)
+	}
+	if got, _ = readObject(t, h.Impl, name, &s3.GetObjectOption{Offset: 22}); string(got) != "upload" {
+		t.Fatalf("unexpected open range %q", got)
+	}
+	if _, _, err := h.Impl.GetObject(ctx, h.key("s3test/missing"), nil); !h.Impl.IsNotFound(err) {
+		t.Fatalf("get of a missing object: %v", err)
+	}
+	short := h.key("s3test/short")
+	if _, err := h.Impl.PutObject(ctx, short, bytes.NewReader(data), int64(len(data))+1, nil); err == nil {
+		t.Fatal("put with a short reader should fail")
+	}
+	if _, err := h.Impl.StatObject(ctx, short); !h.Impl.IsNotFound(err) {
+		t.Fatalf("short put left an object behind: %v", err)
+	}
+}
+
+func testPutObjectMultipart(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	name := h.key("s3test/stream-multipart")
+	data := bytes.Repeat([]byte("0123456789"), int(h.Impl.PartLimit().MinPartSize/10)+1)
+	info, err := h.Impl.PutObject(ctx, name, io.MultiReader(bytes.NewReader(data)), -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Impl.DeleteObject(ctx, name)
+	if info.Size != int64(len(data)) {
+		t.Fatalf("unexpected put size %d", info.Size)
+	}
+	stat, err := h.Impl.StatObject(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size != int64(len(data)) || normalizeETag(stat.ETag) != normalizeETag(info.ETag) {
+		t.Fatalf("stat %+v does not match put %+v", stat, info)
+	}
+	if got, _ := readObject(t, h.Impl, name, nil); !bytes.Equal(got, data) {
+		t.Fatal("multipart content mismatch")
+	}
+}
+
+func testListObjects(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	prefix := h.key("s3test/list/")
+	names := []string{prefix + "a", prefix + "b/c", prefix + "b/d", prefix + "e", h.key("s3test/listx")}
+	opt := &s3.PutObjectOption{ContentType: "text/plain", Metadata: map[string]string{"Origin": "s3test"}}
+	for _, name := range names {
+		if _, err := h.Impl.PutObject(ctx, name, strings.NewReader(name), int64(len(name)), opt); err != nil {
+			t.Fatal(err)
+		}
+		defer h.Impl.DeleteObject(ctx, name)
+	}
+	first, err := h.Impl.ListObjects(ctx, prefix, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Objects) != 2 || !first.IsTruncated || first.NextMarker != prefix+"b/c" {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+	if obj := first.Objects[0]; obj.Key != prefix+"a" || obj.Size != int64(len(obj.Key)) || normalizeETag(obj.ETag) != md5Hex([]byte(obj.Key)) || obj.LastModified.IsZero() {
+		t.Fatalf("unexpected listed object %+v", obj)
+	}
+	if err := s3.StatListed(ctx, h.Impl, first); err != nil {
+		t.Fatal(err)
+	}
+	if obj := first.Objects[0]; obj.ContentType != "text/plain" || obj.Metadata["origin"] != "s3test" {
+		t.Fatalf("unexpected stated object %+v", obj)
+	}
+	rest, err := h.Impl.ListObjects(ctx, prefix, first.NextMarker, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest.Objects) != 2 || rest.IsTruncated || rest.Objects[0].Key != prefix+"b/d" {
+		t.Fatalf("unexpected last page %+v", rest)
+	}
+	var keys []string
+	it := s3.NewObjectIterator(h.Impl, prefix, 1)
+	for it.Next(ctx) {
+		keys = append(keys, it.Object().Key)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != strings.Join(names[:4], ",") {
+		t.Fatalf("iterator returned %v", keys)
+	}
+	if empty, err := h.Impl.ListObjects(ctx, h.key("s3test/none/"), "", 10); err != nil || len(empty.Objects) != 0 || empty.IsTruncated {
+		t.Fatalf("unexpected empty listing %+v %v", empty, err)
+	}
+}
+
+func testAccessURL(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	name := h.key("s3test/access")
+	if err := h.Put(ctx, name, []byte("access")); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Impl.DeleteObject(ctx, name)
+	rawURL, err := h.Impl.AccessURL(ctx, name, time.Minute, &s3.AccessURLOption{ContentType: "text/plain", Filename: "access.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := url.Parse(rawURL); err != nil || rawURL == "" {
+		t.Fatalf("invalid access url %q: %v", rawURL, err)
+	}
+	fd, err := h.Impl.FormData(ctx, name, 6, "text/plain", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.URL == "" || fd.File == "" || len(fd.SuccessCodes) == 0 {
+		t.Fatalf("incomplete form data %+v", fd)
+	}
+}
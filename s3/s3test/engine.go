@@ -0,0 +1,752 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3test provides an in-memory s3.Interface with call recording and
+// fault injection, and a conformance suite for s3.Interface implementations.
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+const (
+	minPartSize int64 = 1024 * 1024 * 5        // 5MB
+	maxPartSize int64 = 1024 * 1024 * 1024 * 5 // 5GB
+	maxNumSize  int64 = 10000
+)
+
+const engineName = "s3test"
+
+// Op names an s3.Interface method for call recording and fault injection.
+type Op string
+
+const (
+	OpInitiateMultipartUpload Op = "InitiateMultipartUpload"
+	OpCompleteMultipartUpload Op = "CompleteMultipartUpload"
+	OpPartSize                Op = "PartSize"
+	OpAuthSign                Op = "AuthSign"
+	OpPresignedPutObject      Op = "PresignedPutObject"
+	OpDeleteObject            Op = "DeleteObject"
+	OpCopyObject              Op = "CopyObject"
+	OpStatObject              Op = "StatObject"
+	OpAbortMultipartUpload    Op = "AbortMultipartUpload"
+	OpListUploadedParts       Op = "ListUploadedParts"
+	OpAccessURL               Op = "AccessURL"
+	OpFormData                Op = "FormData"
+	OpPutObject               Op = "PutObject"
+	OpGetObject               Op = "GetObject"
+	OpListObjects             Op = "ListObjects"
+	OpListMultipartUploads    Op = "ListMultipartUploads"
+	OpGetLifecycle            Op = "GetLifecycle"
+	OpSetLifecycle            Op = "SetLifecycle"
+	OpSetLegalHold            Op = "SetLegalHold"
+	OpLegalHold               Op = "LegalHold"
+)
+
+var (
+	ErrNotFound       = errs.New("s3test: object not found")
+	ErrUploadNotFound = errs.New("s3test: upload not found")
+	ErrLegalHold      = errs.New("s3test: object under legal hold")
+)
+
+var _ s3.Interface = (*Engine)(nil)
+
+// Call is one recorded invocation of the engine.
+type Call struct {
+	Op       Op
+	Key      string
+	UploadID string
+	Err      error
+}
+
+// Fault describes what goes wrong when an operation is invoked.
+type Fault struct {
+	// Err is returned instead of performing the operation.
+	Err error
+	// Latency delays the operation, honouring context cancellation.
+	Latency time.Duration
+	// ETagDrift makes the operation succeed but report a different ETag than the one stored.
+	ETagDrift bool
+	// Times limits how many invocations the fault applies to; zero means every invocation.
+	Times int
+}
+
+type object struct {
+	data         []byte
+	etag         string
+	contentType  string
+	metadata     map[string]string
+	lastModified time.Time
+}
+
+type part struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+}
+
+type upload struct {
+	key       string
+	parts     map[int]*part
+	initiated time.Time
+}
+
+// Engine is an in-memory s3.Interface. The zero value is not usable; call NewEngine.
+type Engine struct {
+	lock      sync.Mutex
+	objects   map[string]*object
+	uploads   map[string]*upload
+	faults    map[Op]*Fault
+	calls     []Call
+	seq       int
+	now       func() time.Time
+	lifecycle []s3.LifecycleRule
+	holds     map[string]bool
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		objects: make(map[string]*object),
+		uploads: make(map[string]*upload),
+		faults:  make(map[Op]*Fault),
+		now:     time.Now,
+		holds:   make(map[string]bool),
+	}
+}
+
+// SetClock replaces the time source used for LastModified and upload initiation times.
+func (e *Engine) SetClock(now func() time.Time) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.now = now
+}
+
+// SetFault installs a fault for op, replacing any previous one.
+func (e *Engine) SetFault(op Op, fault Fault) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.faults[op] = &fault
+}
+
+// ClearFaults removes every installed fault.
+func (e *Engine) ClearFaults() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.faults = make(map[Op]*Fault)
+}
+
+// Calls returns a copy of every recorded call in invocation order.
+func (e *Engine) Calls() []Call {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]Call(nil), e.calls...)
+}
+
+// CallCount returns how many times op has been invoked.
+func (e *Engine) CallCount(op Op) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var n int
+	for _, call := range e.calls {
+		if call.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+// ResetCalls forgets every recorded call.
+func (e *Engine) ResetCalls() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.calls = nil
+}
+
+// SetObject stores data under key as if a client had uploaded it, bypassing faults and call recording.
+func (e *Engine) SetObject(key string, data []byte) *s3.ObjectInfo {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	obj := &object{data: append([]byte(nil), data...), etag: md5Hex(data), lastModified: e.now()}
+	e.objects[key] = obj
+	return obj.info(key)
+}
+
+// Object returns the content stored under key.
+func (e *Engine) Object(key string) ([]byte, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	obj, ok := e.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// Keys returns every stored object key in lexical order.
+func (e *Engine) Keys() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	keys := make([]string, 0, len(e.objects))
+	for key := range e.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UploadPart stores one part of a multipart upload as if a client had sent it to an AuthSign URL.
+func (e *Engine) UploadPart(uploadID string, key string, partNumber int, data []byte) (string, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	up, err := e.getUpload(uploadID, key)
+	if err != nil {
+		return "", err
+	}
+	p := &part{data: append([]byte(nil), data...), etag: md5Hex(data), lastModified: e.now()}
+	up.parts[partNumber] = p
+	return p.etag, nil
+}
+
+// Harness returns a conformance harness that uploads through SetObject and UploadPart.
+func (e *Engine) Harness() *Harness {
+	return &Harness{
+		Impl: e,
+		Put: func(ctx context.Context, name string, data []byte) error {
+			e.SetObject(name, data)
+			return nil
+		},
+		UploadPart: func(ctx context.Context, uploadID string, name string, partNumber int, data []byte) (string, error) {
+			return e.UploadPart(uploadID, name, partNumber, data)
+		},
+	}
+}
+
+// begin records the call and applies the installed fault. It returns with e.lock held
+// unless an error is returned, so the caller must unlock through end.
+func (e *Engine) begin(ctx context.Context, op Op, key string, uploadID string) (bool, error) {
+	e.lock.Lock()
+	fault := e.faults[op]
+	var drift bool
+	var latency time.Duration
+	var err error
+	if fault != nil {
+		drift, latency, err = fault.ETagDrift, fault.Latency, fault.Err
+		if fault.Times > 0 {
+			if fault.Times--; fault.Times == 0 {
+				delete(e.faults, op)
+			}
+		}
+	}
+	e.lock.Unlock()
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = context.Cause(ctx)
+		case <-timer.C:
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	e.lock.Lock()
+	e.calls = append(e.calls, Call{Op: op, Key: key, UploadID: uploadID, Err: err})
+	if err != nil {
+		e.lock.Unlock()
+		return false, err
+	}
+	return drift, nil
+}
+
+func (e *Engine) end() {
+	e.lock.Unlock()
+}
+
+func (e *Engine) Engine() string {
+	return engineName
+}
+
+func (e *Engine) PartLimit() *s3.PartLimit {
+	return &s3.PartLimit{
+		MinPartSize: minPartSize,
+		MaxPartSize: maxPartSize,
+		MaxNumSize:  maxNumSize,
+	}
+}
+
+func (e *Engine) InitiateMultipartUpload(ctx context.Context, name string) (*s3.InitiateMultipartUploadResult, error) {
+	if _, err := e.begin(ctx, OpInitiateMultipartUpload, name, ""); err != nil {
+		return nil, err
+	}
+	defer e.end()
+	e.seq++
+	uploadID := "upload-" + strconv.Itoa(e.seq)
+	e.uploads[uploadID] = &upload{key: name, parts: make(map[int]*part), initiated: e.now()}
+	return &s3.InitiateMultipartUploadResult{
+		Bucket:   engineName,
+		Key:      name,
+		UploadID: uploadID,
+	}, nil
+}
+
+func (e *Engine) CompleteMultipartUpload(ctx context.Context, uploadID string, name string, parts []s3.Part) (*s3.CompleteMultipartUploadResult, error) {
+	drift, err := e.begin(ctx, OpCompleteMultipartUpload, name, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer e.end()
+	up, err := e.getUpload(uploadID, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, errs.ErrArgs.WrapMsg("complete multipart upload without parts")
+	}
+	var data []byte
+	sums := md5.New()
+	for i, p := range parts {
+		if i > 0 && parts[i-1].PartNumber >= p.PartNumber {
+			return nil, errs.ErrArgs.WrapMsg("parts must be in ascending order", "partNumber", p.PartNumber)
+		}
+		uploaded, ok := up.parts[p.PartNumber]
+		if !ok {
+			return nil, errs.ErrArgs.WrapMsg("part not uploaded", "partNumber", p.PartNumber)
+		}
+		if strings.ToLower(strings.Trim(p.ETag, `"`)) != uploaded.etag {
+			return nil, errs.ErrArgs.WrapMsg("part etag mismatching", "partNumber", p.PartNumber, "etag", p.ETag)
+		}
+		raw, _ := hex.DecodeString(uploaded.etag)
+		sums.Write(raw)
+		data = append(data, uploaded.data...)
+	}
+	obj := &object{
+		data:         data,
+		etag:         hex.EncodeToString(sums.Sum(nil)) + "-" + strconv.Itoa(len(parts)),
+		lastModified: e.now(),
+	}
+	e.objects[name] = obj
+	delete(e.uploads, uploadID)
+	return &s3.CompleteMultipartUploadResult{
+		Location: e.url(name),
+		Bucket:   engineName,
+		Key:      name,
+		ETag:     driftETag(obj.etag, drift),
+	}, nil
+}
+
+func (e *Engine) PartSize(ctx context.Context, size int64) (int64, error) {
+	if _, err := e.begin(ctx, OpPartSize, "", ""); err != nil {
+		return 0, err
+	}
+	defer e.end()
+	if size <= 0 {
+		return 0, errors.New("size must be greater than 0")
+	}
+	if size > maxPartSize*maxNumSize {
+		return 0, fmt.Errorf("S3TEST size must be less than the maximum allowed limit")
+	}
+	if size <= minPartSize*maxNumSize {
+		return minPartSize, nil
+	}
+	partSize := size / maxNumSize
+	if size%maxNumSize != 0 {
+		partSize++
+	}
+	return partSize, nil
+}
+
+func (e *Engine) AuthSign(ctx context.Context, uploadID string, name string, expire time.Duration, partNumbers []int) (*s3.AuthSignResult, error) {
+	if _, err := e.begin(ctx, OpAuthSign, name, uploadID); err != nil {
+		return nil, err
+	}
+	defer e.end()
+	if _, err := e.getUpload(uploadID, name); err != nil {
+		return nil, err
+	}
+	result := &s3.AuthSignResult{
+		URL: e.url(name),
+		Query: url.Values{
+			"uploadId": {uploadID},
+			"expires":  {strconv.FormatInt(time.Now().Add(expire).Unix(), 10)},
+		},
+		Parts: make([]s3.SignPart, len(partNumbers)),
+	}
+	for i, partNumber := range partNumbers {
+		result.Parts[i] = s3.SignPart{
+			PartNumber: partNumber,
+			Query:      url.Values{"partNumber": {strconv.Itoa(partNumber)}},
+		}
+	}
+	return result, nil
+}
+
+func (e *Engine) PresignedPutObject(ctx context.Context, name string, expire time.Duration) (string, error) {
+	if _, err := e.begin(ctx, OpPresignedPutObject, name, ""); err != nil {
+		return "", err
+	}
+	defer e.end()
+	return e.url(name) + "?expires=" + strconv.FormatInt(time.Now().Add(expire).Unix(), 10), nil
+}
+
+func (e *Engine) DeleteObject(ctx context.Context, name string) error {
+	if _, err := e.begin(ctx, OpDeleteObject, name, ""); err != nil {
+		return err
+	}
+	defer e.end()
+	if e.holds[name] {
+		return ErrLegalHold.WrapMsg("key", name)
+	}
+	delete(e.objects, name)
+	return nil
+}
+
+func (e *Engine) CopyObject(ctx context.Context, src string, dst string) (*s3.CopyObjectInfo, error) {
+	drift, err := e.begin(ctx, OpCopyObject, src, "")
+	if err != nil {
+		return nil, err
+	}
+	defer e.end()
+	obj, ok := e.objects[src]
+	if !ok {
+		return nil, ErrNotFound.WrapMsg("key", src)
+	}
+	cp := *obj
+	cp.lastModified = e.now()
+	e.objects[dst] = &cp
+	return &s3.CopyObjectInfo{
+		Key:  dst,
+		ETag: driftETag(cp.etag, drift),
+	}, nil
+}
+
+func (e *Engine) StatObject(ctx context.Context, name string) (*s3.ObjectInfo, error) {
+	drift, err := e.begin(ctx, OpStatObject, name, "")
+	if err != nil {
+		return nil, err
+	}
+	defer e.end()
+	obj, ok := e.objects[name]
+	if !ok {
+		return nil, ErrNotFound.WrapMsg("key", name)
+	}
+	info := obj.info(name)
+	info.ETag = driftETag(info.ETag, drift)
+	return info, nil
+}
+
+// PutObject splits large readers through s3.PutStream, so the stored ETag of a
+// multipart object carries the part count just as on a real engine.
+func (e *Engine) PutObject(ctx context.Context, name string, reader io.Reader, size int64, opt *s3.PutObjectOption) (*s3.ObjectInfo, error) {
+	drift, err := e.begin(ctx, OpPutObject, name, "")
+	if err != nil {
+		return nil, err
+	}
+	e.end()
+	info, err := s3.PutStream(ctx, e, objectWriter{e}, name, reader, size, opt)
+	if err != nil {
+		return nil, err
+	}
+	info.ETag = driftETag(info.ETag, drift)
+	return info, nil
+}
+
+func (e *Engine) ListObjects(ctx context.Context, prefix string, marker string, limit int) (*s3.ListObjectsResult, error) {
+	if _, err := e.begin(ctx, OpListObjects, prefix, ""); err != nil {
+		return nil, err
+	}
+	defer e.end()
+	limit = s3.ListLimit(limit)
+	keys := make([]string, 0)
+	for key := range e.objects {
+		if strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	res := &s3.ListObjectsResult{IsTruncated: len(keys) > limit}
+	if res.IsTruncated {
+		keys = keys[:limit]
+		res.NextMarker = keys[len(keys)-1]
+	}
+	res.Objects = make([]*s3.ObjectInfo, len(keys))
+	for i, key := range keys {
+		// Like the vendor listings, no content type or metadata.
+		info := e.objects[key].info(key)
+		info.ContentType, info.Metadata = "", nil
+		res.Objects[i] = info
+	}
+	return res, nil
+}
+
+func (e *Engine) GetObject(ctx context.Context, name string, opt *s3.GetObjectOption) (io.ReadCloser, *s3.ObjectInfo, error) {
+	drift, err := e.begin(ctx, OpGetObject, name, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer e.end()
+	obj, ok := e.objects[name]
+	if !ok {
+		return nil, nil, ErrNotFound.WrapMsg("key", name)
+	}
+	info := obj.info(name)
+	info.ETag = driftETag(info.ETag, drift)
+	data := obj.data
+	if opt != nil && (opt.Offset > 0 || opt.Length > 0) {
+		if opt.Offset < 0 || opt.Offset >= int64(len(data)) {
+			return nil, nil, errs.ErrArgs.WrapMsg("invalid range", "offset", opt.Offset, "size", len(data))
+		}
+		data = data[opt.Offset:]
+		if opt.Length > 0 && opt.Length < int64(len(data)) {
+			data = data[:opt.Length]
+		}
+	}
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+func (e *Engine) IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrUploadNotFound)
+}
+
+func (e *Engine) AbortMultipartUpload(ctx context.Context, uploadID string, name string) error {
+	if _, err := e.begin(ctx, OpAbortMultipartUpload, name, uploadID); err != nil {
+		return err
+	}
+	defer e.end()
+	if _, err := e.getUpload(uploadID, name); err != nil {
+		return err
+	}
+	delete(e.uploads, uploadID)
+	return nil
+}
+
+func (e *Engine) ListUploadedParts(ctx context.Context, uploadID string, name string, partNumberMarker int, maxParts int) (*s3.ListUploadedPartsResult, error) {
+	drift, err := e.begin(ctx, OpListUploadedParts, name, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer e.end()
+	up, err := e.getUpload(uploadID, name)
+	if err != nil {
+		return nil, err
+	}
+	partNumbers := make([]int, 0, len(up.parts))
+	for partNumber := range up.parts {
+		if partNumber > partNumberMarker {
+			partNumbers = append(partNumbers, partNumber)
+		}
+	}
+	sort.Ints(partNumbers)
+	if maxParts > 0 && len(partNumbers) > maxParts {
+		partNumbers = partNumbers[:maxParts]
+	}
+	res := &s3.ListUploadedPartsResult{
+		Key:           name,
+		UploadID:      uploadID,
+		MaxParts:      maxParts,
+		UploadedParts: make([]s3.UploadedPart, len(partNumbers)),
+	}
+	for i, partNumber := range partNumbers {
+		p := up.parts[partNumber]
+		res.UploadedParts[i] = s3.UploadedPart{
+			PartNumber:   partNumber,
+			LastModified: p.lastModified,
+			ETag:         driftETag(p.etag, drift),
+			Size:         int64(len(p.data)),
+		}
+		res.NextPartNumberMarker = partNumber
+	}
+	return res, nil
+}
+
+func (e *Engine) ListMultipartUploads(ctx context.Context, prefix string, keyMarker string, uploadIDMarker string, limit int) (*s3.ListMultipartUploadsResult, error) {
+	if _, err := e.begin(ctx, OpListMultipartUploads, prefix, ""); err != nil {
+		return nil, err
+	}
+	defer e.end()
+	limit = s3.ListLimit(limit)
+	uploads := make([]s3.MultipartUpload, 0, len(e.uploads))
+	for uploadID, up := range e.uploads {
+		if !strings.HasPrefix(up.key, prefix) {
+			continue
+		}
+		if up.key < keyMarker || (up.key == keyMarker && uploadID <= uploadIDMarker) {
+			continue
+		}
+		uploads = append(uploads, s3.MultipartUpload{Key: up.key, UploadID: uploadID, Initiated: up.initiated})
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+	res := &s3.ListMultipartUploadsResult{IsTruncated: len(uploads) > limit}
+	if res.IsTruncated {
+		uploads = uploads[:limit]
+		res.NextKeyMarker = uploads[limit-1].Key
+		res.NextUploadIDMarker = uploads[limit-1].UploadID
+	}
+	res.Uploads = uploads
+	return res, nil
+}
+
+func (e *Engine) AccessURL(ctx context.Context, name string, expire time.Duration, opt *s3.AccessURLOption) (string, error) {
+	if _, err := e.begin(ctx, OpAccessURL, name, ""); err != nil {
+		return "", err
+	}
+	defer e.end()
+	query := make(url.Values)
+	if opt != nil {
+		if opt.ContentType != "" {
+			query.Set("response-content-type", opt.ContentType)
+		}
+		if opt.Filename != "" {
+			query.Set("response-content-disposition", `attachment; filename*=UTF-8''`+url.PathEscape(opt.Filename))
+		}
+	}
+	query.Set("expires", strconv.FormatInt(time.Now().Add(expire).Unix(), 10))
+	return e.url(name) + "?" + query.Encode(), nil
+}
+
+func (e *Engine) FormData(ctx context.Context, name string, size int64, contentType string, duration time.Duration) (*s3.FormData, error) {
+	if _, err := e.begin(ctx, OpFormData, name, ""); err != nil {
+		return nil, err
+	}
+	defer e.end()
+	fd := &s3.FormData{
+		URL:          e.url(""),
+		File:         "file",
+		Expires:      time.Now().Add(duration),
+		FormData:     map[string]string{"key": name},
+		SuccessCodes: []int{200},
+	}
+	if contentType != "" {
+		fd.FormData["Content-Type"] = contentType
+	}
+	return fd, nil
+}
+
+// getUpload must be called with e.lock held.
+func (e *Engine) getUpload(uploadID string, key string) (*upload, error) {
+	up, ok := e.uploads[uploadID]
+	if !ok {
+		return nil, ErrUploadNotFound.WrapMsg("uploadID", uploadID)
+	}
+	if up.key != key {
+		return nil, errs.ErrArgs.WrapMsg("upload key mismatching", "uploadID", uploadID, "key", key)
+	}
+	return up, nil
+}
+
+func (e *Engine) url(name string) string {
+	return "s3test://" + engineName + "/" + name
+}
+
+func (o *object) info(key string) *s3.ObjectInfo {
+	return &s3.ObjectInfo{
+		ETag:         o.etag,
+		Key:          key,
+		Size:         int64(len(o.data)),
+		LastModified: o.lastModified,
+		ContentType:  o.contentType,
+		Metadata:     o.metadata,
+	}
+}
+
+type objectWriter struct {
+	e *Engine
+}
+
+func (w objectWriter) PutObject(ctx context.Context, name string, data []byte, opt *s3.PutObjectOption) (string, error) {
+	w.e.lock.Lock()
+	defer w.e.lock.Unlock()
+	obj := &object{data: append([]byte(nil), data...), etag: md5Hex(data), lastModified: w.e.now()}
+	if opt != nil {
+		obj.contentType = opt.ContentType
+		obj.metadata = s3.LowerMetadata(opt.Metadata)
+	}
+	w.e.objects[name] = obj
+	return obj.etag, nil
+}
+
+func (w objectWriter) InitiateMultipart(ctx context.Context, name string, opt *s3.PutObjectOption) (s3.MultipartWriter, error) {
+	w.e.lock.Lock()
+	defer w.e.lock.Unlock()
+	w.e.seq++
+	mw := &multipartWriter{e: w.e, name: name, uploadID: "upload-" + strconv.Itoa(w.e.seq)}
+	if opt != nil {
+		mw.contentType = opt.ContentType
+		mw.metadata = s3.LowerMetadata(opt.Metadata)
+	}
+	w.e.uploads[mw.uploadID] = &upload{key: name, parts: make(map[int]*part), initiated: w.e.now()}
+	return mw, nil
+}
+
+type multipartWriter struct {
+	e           *Engine
+	name        string
+	uploadID    string
+	contentType string
+	metadata    map[string]string
+}
+
+func (w *multipartWriter) UploadPart(ctx context.Context, partNumber int, data []byte) (string, error) {
+	return w.e.UploadPart(w.uploadID, w.name, partNumber, data)
+}
+
+func (w *multipartWriter) Complete(ctx context.Context, parts []s3.Part) (string, error) {
+	res, err := w.e.CompleteMultipartUpload(ctx, w.uploadID, w.name, parts)
+	if err != nil {
+		return "", err
+	}
+	w.e.lock.Lock()
+	defer w.e.lock.Unlock()
+	if obj, ok := w.e.objects[w.name]; ok {
+		obj.contentType = w.contentType
+		obj.metadata = w.metadata
+	}
+	return res.ETag, nil
+}
+
+func (w *multipartWriter) Abort(ctx context.Context) error {
+	return w.e.AbortMultipartUpload(ctx, w.uploadID, w.name)
+}
+
+func driftETag(etag string, drift bool) string {
+	if !drift {
+		return etag
+	}
+	return md5Hex([]byte("drift:" + etag))
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
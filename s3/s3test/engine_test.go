@@ -0,0 +1,124 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+func TestEngineConformance(t *testing.T) {
+	RunConformance(t, func(t *testing.T) *Harness {
+		return NewEngine().Harness()
+	})
+}
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	e := NewEngine()
+	info := e.SetObject("a", []byte("a"))
+	injected := errors.New("injected")
+	e.SetFault(OpStatObject, Fault{Err: injected, Times: 1})
+	if _, err := e.StatObject(ctx, "a"); !errors.Is(err, injected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if _, err := e.StatObject(ctx, "a"); err != nil {
+		t.Fatalf("fault should apply once, got %v", err)
+	}
+	e.SetFault(OpCopyObject, Fault{ETagDrift: true})
+	copyInfo, err := e.CopyObject(ctx, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copyInfo.ETag == info.ETag {
+		t.Fatal("expected drifted etag")
+	}
+	if stat, err := e.StatObject(ctx, "b"); err != nil || stat.ETag != info.ETag {
+		t.Fatalf("drift must not change the stored object: %+v %v", stat, err)
+	}
+	e.SetFault(OpDeleteObject, Fault{Latency: time.Second})
+	timeout, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+	if err := e.DeleteObject(timeout, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if _, ok := e.Object("a"); !ok {
+		t.Fatal("failed delete removed the object")
+	}
+	if n := e.CallCount(OpStatObject); n != 3 {
+		t.Fatalf("expected 3 stat calls, got %d", n)
+	}
+	calls := e.Calls()
+	if last := calls[len(calls)-1]; last.Op != OpDeleteObject || last.Err == nil {
+		t.Fatalf("unexpected last call %+v", last)
+	}
+}
+
+func TestPutObjectMultipart(t *testing.T) {
+	ctx := context.Background()
+	e := NewEngine()
+	data := bytes.Repeat([]byte{'x'}, int(minPartSize)*2+1)
+	info, err := e.PutObject(ctx, "big", bytes.NewReader(data), int64(len(data)), &s3.PutObjectOption{ContentType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(info.ETag, "-3") {
+		t.Fatalf("expected a three part etag, got %s", info.ETag)
+	}
+	if stat, err := e.StatObject(ctx, "big"); err != nil || stat.ContentType != "video/mp4" {
+		t.Fatalf("unexpected stat %+v %v", stat, err)
+	}
+	injected := errors.New("part rejected")
+	e.SetFault(OpCompleteMultipartUpload, Fault{Err: injected, Times: 1})
+	if _, err := e.PutObject(ctx, "failed", bytes.NewReader(data), -1, nil); !errors.Is(err, injected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if n := e.CallCount(OpAbortMultipartUpload); n != 1 {
+		t.Fatalf("failed upload aborted %d times", n)
+	}
+	if _, ok := e.Object("failed"); ok {
+		t.Fatal("failed upload stored an object")
+	}
+}
+
+func TestLegalHold(t *testing.T) {
+	ctx := context.Background()
+	e := NewEngine()
+	e.SetObject("held", []byte("held"))
+	if err := e.SetLegalHold(ctx, "held", true); err != nil {
+		t.Fatal(err)
+	}
+	if hold, err := e.LegalHold(ctx, "held"); err != nil || !hold {
+		t.Fatalf("expected hold, got %v %v", hold, err)
+	}
+	if err := e.DeleteObject(ctx, "held"); !errors.Is(err, ErrLegalHold) {
+		t.Fatalf("expected ErrLegalHold, got %v", err)
+	}
+	if err := e.SetLegalHold(ctx, "held", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DeleteObject(ctx, "held"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetLegalHold(ctx, "held", true); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
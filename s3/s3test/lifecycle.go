@@ -0,0 +1,81 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3test
+
+import (
+	"context"
+	"slices"
+
+	"github.com/amazing-socrates/next-tools/s3"
+)
+
+var _ s3.Lifecycle = (*Engine)(nil)
+
+// GetLifecycle returns the rules last set; they are recorded but never applied.
+func (e *Engine) GetLifecycle(ctx context.Context) ([]s3.LifecycleRule, error) {
+	if _, err := e.begin(ctx, OpGetLifecycle, "", ""); err != nil {
+		return nil, err
+	}
+	defer e.end()
+	return cloneRules(e.lifecycle), nil
+}
+
+func (e *Engine) SetLifecycle(ctx context.Context, rules []s3.LifecycleRule) error {
+	if _, err := e.begin(ctx, OpSetLifecycle, "", ""); err != nil {
+		return err
+	}
+	defer e.end()
+	e.lifecycle = cloneRules(rules)
+	return nil
+}
+
+// SetLegalHold places or releases a hold; DeleteObject fails with ErrLegalHold on held objects.
+func (e *Engine) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	if _, err := e.begin(ctx, OpSetLegalHold, name, ""); err != nil {
+		return err
+	}
+	defer e.end()
+	if _, ok := e.objects[name]; !ok {
+		return ErrNotFound.WrapMsg("key", name)
+	}
+	if hold {
+		e.holds[name] = true
+	} else {
+		delete(e.holds, name)
+	}
+	return nil
+}
+
+func (e *Engine) LegalHold(ctx context.Context, name string) (bool, error) {
+	if _, err := e.begin(ctx, OpLegalHold, name, ""); err != nil {
+		return false, err
+	}
+	defer e.end()
+	if _, ok := e.objects[name]; !ok {
+		return false, ErrNotFound.WrapMsg("key", name)
+	}
+	return e.holds[name], nil
+}
+
+func cloneRules(rules []s3.LifecycleRule) []s3.LifecycleRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	cloned := slices.Clone(rules)
+	for i := range cloned {
+		cloned[i].Transitions = slices.Clone(cloned[i].Transitions)
+	}
+	return cloned
+}
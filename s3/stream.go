@@ -0,0 +1,162 @@
+// Copyright © 2024 OpenIM open source community. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amazing-socrates/next-tools/errs"
+)
+
+var ErrSizeMismatch = errs.New("s3 object size mismatch")
+
+// ObjectWriter is the engine side of PutStream.
+type ObjectWriter interface {
+	// PutObject uploads data as a whole object in a single request and returns its ETag.
+	PutObject(ctx context.Context, name string, data []byte, opt *PutObjectOption) (string, error)
+	// InitiateMultipart starts a multipart upload carrying the object options of opt.
+	InitiateMultipart(ctx context.Context, name string, opt *PutObjectOption) (MultipartWriter, error)
+}
+
+// MultipartWriter is one multipart upload in progress.
+type MultipartWriter interface {
+	UploadPart(ctx context.Context, partNumber int, data []byte) (string, error)
+	Complete(ctx context.Context, parts []Part) (string, error)
+	Abort(ctx context.Context) error
+}
+
+// PutStream reads an object of size bytes from reader and uploads it through w.
+// A negative size means the length is unknown. Objects that do not fit in one
+// part of impl's PartSize are uploaded as multipart, and a failed multipart
+// upload is aborted before returning.
+func PutStream(ctx context.Context, impl Interface, w ObjectWriter, name string, reader io.Reader, size int64, opt *PutObjectOption) (*ObjectInfo, error) {
+	var partSize int64
+	if size > 0 {
+		var err error
+		partSize, err = impl.PartSize(ctx, size)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		partSize = impl.PartLimit().MinPartSize
+	}
+	if size >= 0 {
+		reader = io.LimitReader(reader, size)
+	}
+	first, err := readPart(reader, partSize)
+	if err != nil {
+		return nil, err
+	}
+	info := &ObjectInfo{Key: name}
+	if opt != nil {
+		info.ContentType = opt.ContentType
+	}
+	if int64(len(first)) < partSize || (size >= 0 && int64(len(first)) == size) {
+		if size >= 0 && int64(len(first)) != size {
+			return nil, ErrSizeMismatch.WrapMsg("short read", "key", name, "size", size, "read", len(first))
+		}
+		if info.ETag, err = w.PutObject(ctx, name, first, opt); err != nil {
+			return nil, err
+		}
+		info.Size = int64(len(first))
+		info.LastModified = time.Now()
+		return info, nil
+	}
+	mw, err := w.InitiateMultipart(ctx, name, opt)
+	if err != nil {
+		return nil, err
+	}
+	info.ETag, info.Size, err = writeParts(ctx, mw, reader, first, partSize, impl.PartLimit().MaxNumSize)
+	if err == nil && size >= 0 && info.Size != size {
+		err = ErrSizeMismatch.WrapMsg("short read", "key", name, "size", size, "read", info.Size)
+	}
+	if err != nil {
+		if abortErr := mw.Abort(context.WithoutCancel(ctx)); abortErr != nil {
+			return nil, errors.Join(err, abortErr)
+		}
+		return nil, err
+	}
+	info.LastModified = time.Now()
+	return info, nil
+}
+
+func writeParts(ctx context.Context, mw MultipartWriter, reader io.Reader, data []byte, partSize int64, maxNum int64) (string, int64, error) {
+	var (
+		parts []Part
+		total int64
+	)
+	for len(data) > 0 {
+		partNumber := len(parts) + 1
+		if int64(partNumber) > maxNum {
+			return "", 0, errs.ErrArgs.WrapMsg("too many parts", "maxNumSize", maxNum)
+		}
+		etag, err := mw.UploadPart(ctx, partNumber, data)
+		if err != nil {
+			return "", 0, errs.WrapMsg(err, "upload part failed", "partNumber", partNumber)
+		}
+		parts = append(parts, Part{PartNumber: partNumber, ETag: etag})
+		total += int64(len(data))
+		if int64(len(data)) < partSize {
+			break
+		}
+		if data, err = readPart(reader, partSize); err != nil {
+			return "", 0, err
+		}
+	}
+	etag, err := mw.Complete(ctx, parts)
+	if err != nil {
+		return "", 0, err
+	}
+	return etag, total, nil
+}
+
+// readPart reads up to size bytes, returning fewer only at the end of reader.
+func readPart(reader io.Reader, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := io.ReadFull(reader, buf)
+	switch {
+	case err == nil, errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return buf[:n], nil
+	default:
+		return nil, err
+	}
+}
+
+// RangeHeader returns the HTTP Range header value selected by opt, or "" for the whole object.
+func (o *GetObjectOption) RangeHeader() string {
+	if o == nil || (o.Offset <= 0 && o.Length <= 0) {
+		return ""
+	}
+	if o.Length <= 0 {
+		return "bytes=" + strconv.FormatInt(o.Offset, 10) + "-"
+	}
+	return "bytes=" + strconv.FormatInt(o.Offset, 10) + "-" + strconv.FormatInt(o.Offset+o.Length-1, 10)
+}
+
+// ObjectSize returns the full object size from a GET response, which for a range
+// request is carried by the total in Content-Range rather than by Content-Length.
+func ObjectSize(contentLength int64, contentRange string) int64 {
+	if i := strings.LastIndexByte(contentRange, '/'); i >= 0 {
+		if total, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+			return total
+		}
+	}
+	return contentLength
+}
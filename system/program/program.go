@@ -15,10 +15,15 @@
 package program
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func ExitWithError(err error) {
@@ -32,6 +37,32 @@ func SIGTERMExit() {
 	fmt.Fprintf(os.Stderr, "Warning %s receive process terminal SIGTERM exit 0\n", progName)
 }
 
+// WaitSIGTERM blocks until the process receives SIGTERM or SIGINT, then runs hooks in order,
+// such as discovery.Drain followed by the graceful stop of the server, and reports the exit
+// like SIGTERMExit. The hooks share a context that expires after timeout, and every hook runs
+// even when an earlier one fails; their errors are joined. When ctx is done before a signal
+// arrives no hook runs and ctx.Err() is returned.
+func WaitSIGTERM(ctx context.Context, timeout time.Duration, hooks ...func(ctx context.Context) error) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+	select {
+	case <-sigs:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	hookCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	var errList []error
+	for _, hook := range hooks {
+		if err := hook(hookCtx); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	SIGTERMExit()
+	return errors.Join(errList...)
+}
+
 // GetProcessName retrieves the name of the currently running process.
 // It achieves this by parsing os.Args[0], which typically contains the full path to the program.
 // If os.Args[0] is empty or unset for some reason, the function returns an empty string.
@@ -15,8 +15,13 @@
 package program
 
 import (
+	"context"
+	"errors"
 	"os"
+	"os/signal"
+	"syscall"
 	"testing"
+	"time"
 )
 
 // TestGetProcessName tests the GetProcessName function to ensure it returns the expected process name.
@@ -32,3 +37,52 @@ func TestGetProcessName(t *testing.T) {
 		t.Errorf("GetProcessName() = %q, want %q", got, expected)
 	}
 }
+
+func TestWaitSIGTERM(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	if err := WaitSIGTERM(ctx, time.Second, func(context.Context) error { called = true; return nil }); !errors.Is(err, context.Canceled) || called {
+		t.Fatalf("hook run without a signal: %v", err)
+	}
+
+	// Keep the signal from ending the test process before WaitSIGTERM listens.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	hookErr := errors.New("hook failed")
+	var order []int
+	done := make(chan error, 1)
+	go func() {
+		done <- WaitSIGTERM(context.Background(), time.Second,
+			func(context.Context) error { order = append(order, 1); return hookErr },
+			func(ctx context.Context) error {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("hook context has no deadline")
+				}
+				order = append(order, 2)
+				return nil
+			})
+	}()
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if err := self.Signal(syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-done:
+			if !errors.Is(err, hookErr) || len(order) != 2 || order[0] != 1 {
+				t.Fatalf("unexpected hooks run %v: %v", order, err)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("WaitSIGTERM did not return")
+		}
+	}
+}